@@ -0,0 +1,44 @@
+package raknet
+
+import (
+	"net"
+	"time"
+)
+
+// AckEvent is an acknowledgement (ACK) or negative acknowledgement (NACK) sent or received by a connection.
+// ACKs confirm that datagrams arrived, while NACKs request datagrams that were found missing to be resent.
+type AckEvent struct {
+	// Direction is Inbound for acknowledgements received from the other end of the connection and Outbound
+	// for those sent to it.
+	Direction Direction
+	// NACK is true if the event is a negative acknowledgement.
+	NACK bool
+	// Time is the time at which the acknowledgement was sent or received.
+	Time time.Time
+	// Addr is the address of the other end of the connection.
+	Addr net.Addr
+	// Ranges holds the ranges of datagram sequence numbers acknowledged, in the order in which they were
+	// encoded in the acknowledgement.
+	Ranges []SequenceRange
+}
+
+// SequenceRange is an inclusive range of datagram sequence numbers. First and Last are equal if the range
+// holds a single sequence number.
+type SequenceRange struct {
+	First, Last uint32
+}
+
+// emitAck calls the OnAck function of the connection, if set, with an AckEvent for the sequence numbers
+// passed.
+func (conn *Conn) emitAck(direction Direction, nack bool, packets []uint24) {
+	if conn.onAck == nil || len(packets) == 0 {
+		return
+	}
+	event := AckEvent{Direction: direction, NACK: nack, Time: conn.clock.Now(), Addr: conn.addr}
+	for rest := packets; len(rest) > 0; {
+		var record ackRecord
+		record, rest = nextAckRecord(rest)
+		event.Ranges = append(event.Ranges, SequenceRange{First: uint32(record.first), Last: uint32(record.last)})
+	}
+	conn.onAck(event)
+}
@@ -0,0 +1,58 @@
+package raknet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnAck(t *testing.T) {
+	events := make(chan AckEvent, 64)
+	onAck := func(event AckEvent) {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	l, err := ListenConfig{OnAck: onAck}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			b := make([]byte, 1500)
+			for {
+				if _, err := c.Read(b); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte{0xfe, 1}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+
+	var sent, received bool
+	timeout := time.After(time.Second * 5)
+	for !sent || !received {
+		select {
+		case e := <-events:
+			if e.NACK || len(e.Ranges) == 0 || e.Addr == nil {
+				t.Fatalf("unexpected event %+v", e)
+			}
+			for _, r := range e.Ranges {
+				if r.First > r.Last {
+					t.Fatalf("invalid range %+v", r)
+				}
+			}
+			sent, received = sent || e.Direction == Outbound, received || e.Direction == Inbound
+		case <-timeout:
+			t.Fatalf("expected both sent and received ACKs (sent=%v, received=%v)", sent, received)
+		}
+	}
+}
@@ -40,7 +40,7 @@ func (addr *rakAddr) UnmarshalBinary(b []byte) error {
 			(-ipBytes[2]-1)&0xff,
 			(-ipBytes[3]-1)&0xff,
 		)
-		var port int16
+		var port uint16
 		if err := binary.Read(buffer, binary.BigEndian, &port); err != nil {
 			return fmt.Errorf("error reading raknet address port: %v", err)
 		}
@@ -48,7 +48,7 @@ func (addr *rakAddr) UnmarshalBinary(b []byte) error {
 	} else {
 		// Pass the first short, we don't care about it.
 		buffer.Next(2)
-		var port int16
+		var port uint16
 		if err := binary.Read(buffer, binary.BigEndian, &port); err != nil {
 			return fmt.Errorf("error reading raknet address port: %v", err)
 		}
@@ -92,18 +92,18 @@ func (addr *rakAddr) MarshalBinary() (b []byte, err error) {
 		ipBytes := addr.IP.To4()
 
 		// If the IP is an IPv4 IP, we write all 4 bytes individually.
-		if _, err := buffer.Write([]byte{ipBytes[0], ^ipBytes[1], ^ipBytes[2], ^ipBytes[3]}); err != nil {
+		if _, err := buffer.Write([]byte{^ipBytes[0], ^ipBytes[1], ^ipBytes[2], ^ipBytes[3]}); err != nil {
 			return nil, fmt.Errorf("error writing raknet address ipv4 bytes: %v", err)
 		}
 		// Finally write the port.
-		if err := binary.Write(buffer, binary.BigEndian, int16(addr.Port)); err != nil {
+		if err := binary.Write(buffer, binary.BigEndian, uint16(addr.Port)); err != nil {
 			return nil, fmt.Errorf("error writing raknet address port: %v", err)
 		}
 	} else {
 		if err := binary.Write(buffer, binary.LittleEndian, int16(23)); err != nil {
 			return nil, fmt.Errorf("error writing raknet address port: %v", err)
 		}
-		if err := binary.Write(buffer, binary.BigEndian, int16(addr.Port)); err != nil {
+		if err := binary.Write(buffer, binary.BigEndian, uint16(addr.Port)); err != nil {
 			return nil, fmt.Errorf("error writing raknet address port: %v", err)
 		}
 		// The IPv6 address is enclosed in two 0 integers, which we represent with an empty 4 length byte
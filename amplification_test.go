@@ -0,0 +1,52 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMaxPongAmplification(t *testing.T) {
+	rejections := make(chan Rejection, 4)
+	l, err := ListenConfig{MaxPongAmplification: 2, OnReject: func(r Rejection) { rejections <- r }}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	data := bytes.Repeat([]byte{'a'}, 100)
+	l.PongData(data)
+
+	// The pong is 35 bytes bigger than the pong data, so a ping of 33 bytes is too small.
+	if _, err := (Dialer{}).Ping(l.Addr().String()); err == nil {
+		t.Fatalf("expected ping without padding to be dropped")
+	}
+	select {
+	case r := <-rejections:
+		if r.Reason != RejectAmplification {
+			t.Fatalf("expected amplification rejection, got %v: %v", r.Reason, r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnReject not called")
+	}
+	pong, err := (Dialer{PingPadding: 35}).Ping(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error pinging with padding: %v", err)
+	}
+	if !bytes.Equal(pong, data) {
+		t.Fatalf("expected pong data %q, got %q", data, pong)
+	}
+}
+
+func TestMinPingSize(t *testing.T) {
+	l, err := ListenConfig{MinPingSize: 64}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	if _, err := (Dialer{PingPadding: 30}).Ping(l.Addr().String()); err == nil {
+		t.Fatalf("expected ping of 63 bytes to be dropped")
+	}
+	if _, err := (Dialer{PingPadding: 31}).Ping(l.Addr().String()); err != nil {
+		t.Fatalf("error pinging with 64 bytes: %v", err)
+	}
+}
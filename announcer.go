@@ -0,0 +1,103 @@
+package raknet
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// AnnouncerConfig may be used to pass additional configuration to an Announcer. The zero value of
+// AnnouncerConfig is valid and is used by the Announce function.
+type AnnouncerConfig struct {
+	// Interval is the interval at which the pong data of the Listener is announced.
+	// Interval is 1.5 seconds by default.
+	Interval time.Duration
+	// Addrs are the addresses that announcements are sent to.
+	// Addrs is nil by default, meaning announcements are broadcast to ports 19132 and 19133 of the local
+	// network, which are the ports that Minecraft clients discover LAN servers on.
+	Addrs []*net.UDPAddr
+	// Clock is the Clock used to time the announcements.
+	// Clock is nil by default, meaning SystemClock is used.
+	Clock Clock
+}
+
+// Announcer periodically broadcasts the pong data of a Listener on the local network, so that the server
+// shows up in the LAN tab of Minecraft clients. The pong data, set using Listener.PongData, must be that of a
+// Minecraft server, starting with "MCPE;". Announcements are unconnected pongs sent from the socket of the
+// Listener, so that clients connect to the address they were announced from.
+type Announcer struct {
+	listener *Listener
+	config   AnnouncerConfig
+
+	once   sync.Once
+	closed chan struct{}
+	done   chan struct{}
+}
+
+// Announce starts announcing the Listener passed on the local network. If not successful, an error is
+// returned.
+func Announce(listener *Listener) (*Announcer, error) {
+	return AnnouncerConfig{}.Announce(listener)
+}
+
+// Announce starts announcing the Listener passed on the local network. If not successful, an error is
+// returned.
+// Announce fills out any values of the AnnouncerConfig left as their empty values with the default values of
+// those fields.
+func (config AnnouncerConfig) Announce(listener *Listener) (*Announcer, error) {
+	if config.Interval <= 0 {
+		config.Interval = time.Second * 3 / 2
+	}
+	if config.Addrs == nil {
+		config.Addrs = []*net.UDPAddr{
+			{IP: net.IPv4bcast, Port: 19132},
+			{IP: net.IPv4bcast, Port: 19133},
+		}
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	if err := setBroadcast(listener.conn); err != nil {
+		return nil, fmt.Errorf("error enabling broadcast: %v", err)
+	}
+	a := &Announcer{listener: listener, config: config, closed: make(chan struct{}), done: make(chan struct{})}
+	go a.announce()
+	return a, nil
+}
+
+// Close stops the announcements of the Announcer. The Listener is left open.
+func (a *Announcer) Close() error {
+	a.once.Do(func() {
+		close(a.closed)
+	})
+	<-a.done
+	return nil
+}
+
+// announce announces the Listener every interval until the Announcer or the Listener is closed.
+func (a *Announcer) announce() {
+	defer close(a.done)
+	ticker := a.config.Clock.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	b := bytes.NewBuffer(nil)
+	for {
+		b.Reset()
+		if err := a.listener.writePong(b, a.config.Clock.Now().UnixNano()/int64(time.Millisecond)); err == nil {
+			for _, addr := range a.config.Addrs {
+				// Announcements to addresses that are unreachable, for example because the host is not
+				// connected to a network, are simply skipped.
+				_, _ = a.listener.conn.WriteTo(b.Bytes(), addr)
+			}
+		}
+		select {
+		case <-ticker.C():
+		case <-a.closed:
+			return
+		case <-a.listener.closeCtx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package raknet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAnnouncer(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	data := []byte("MCPE;Test;390;1.14.60;0;10;0;Test;Survival;1;19132;19133;")
+	l.PongData(data)
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening for announcements: %v", err)
+	}
+	defer client.Close()
+	a, err := AnnouncerConfig{Interval: time.Millisecond * 50, Addrs: []*net.UDPAddr{client.LocalAddr().(*net.UDPAddr)}}.Announce(l)
+	if err != nil {
+		t.Fatalf("error announcing: %v", err)
+	}
+	defer a.Close()
+
+	for i := 0; i < 2; i++ {
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 1500)
+		n, addr, err := client.ReadFrom(b)
+		if err != nil {
+			t.Fatalf("error reading announcement: %v", err)
+		}
+		if addr.String() != l.Addr().String() {
+			t.Fatalf("expected announcement from %v, got one from %v", l.Addr(), addr)
+		}
+		if b[0] != idUnconnectedPong || !bytes.HasSuffix(b[:n], data) {
+			t.Fatalf("expected unconnected pong holding pong data, got %x", b[:n])
+		}
+	}
+}
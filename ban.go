@@ -0,0 +1,234 @@
+package raknet
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ban is a ban of an IP address or a client GUID held by a Listener. Datagrams from a banned IP address are
+// dropped before they are processed in any way, and clients with a banned GUID are refused when they
+// attempt to connect.
+type Ban struct {
+	// IP is the IP address banned. It is nil if the ban is of a GUID.
+	IP net.IP
+	// GUID is the client GUID banned if IP is nil.
+	GUID int64
+	// Expiry is the time at which the ban expires. A zero Expiry means the ban never expires.
+	Expiry time.Time
+	// Reason is the reason of the ban, for the server software to show. It is not sent to the client.
+	Reason string
+}
+
+// expired checks if the ban is expired at the time passed.
+func (ban Ban) expired(now time.Time) bool {
+	return !ban.Expiry.IsZero() && !now.Before(ban.Expiry)
+}
+
+// banList holds the bans of a Listener.
+type banList struct {
+	mu   sync.RWMutex
+	ips  map[string]Ban
+	guid map[int64]Ban
+	// n is the amount of bans held, so that checking datagrams when no bans are held takes no lock.
+	n int32
+	// swept is the time, in Unix nanoseconds, at which expired bans were last removed. Sweeps happen at most
+	// once per second while bans are held, so that n drops back to 0 once all bans expired.
+	swept int64
+}
+
+// ipBan returns the ban of the IP address of the address passed, if it is banned at the current time of the
+// Clock passed.
+func (l *banList) ipBan(addr net.Addr, clock Clock) (Ban, bool) {
+	if atomic.LoadInt32(&l.n) == 0 {
+		return Ban{}, false
+	}
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return Ban{}, false
+	}
+	now := clock.Now()
+	l.sweep(now)
+	l.mu.RLock()
+	ban, ok := l.ips[udp.IP.String()]
+	l.mu.RUnlock()
+	return ban, ok && !ban.expired(now)
+}
+
+// guidBan returns the ban of the GUID passed, if it is banned at the time passed.
+func (l *banList) guidBan(guid int64, now time.Time) (Ban, bool) {
+	if atomic.LoadInt32(&l.n) == 0 {
+		return Ban{}, false
+	}
+	l.sweep(now)
+	l.mu.RLock()
+	ban, ok := l.guid[guid]
+	l.mu.RUnlock()
+	return ban, ok && !ban.expired(now)
+}
+
+// sweep removes the bans expired at the time passed, unless the last sweep was less than a second ago.
+func (l *banList) sweep(now time.Time) {
+	last := atomic.LoadInt64(&l.swept)
+	if now.UnixNano()-last < int64(time.Second) || !atomic.CompareAndSwapInt64(&l.swept, last, now.UnixNano()) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeExpired(now)
+}
+
+// removeExpired removes the bans expired at the time passed.
+// removeExpired must be called while holding the lock.
+func (l *banList) removeExpired(now time.Time) {
+	for k, ban := range l.ips {
+		if ban.expired(now) {
+			delete(l.ips, k)
+		}
+	}
+	for k, ban := range l.guid {
+		if ban.expired(now) {
+			delete(l.guid, k)
+		}
+	}
+	atomic.StoreInt32(&l.n, int32(len(l.ips)+len(l.guid)))
+}
+
+// put adds or replaces the ban passed.
+func (l *banList) put(ban Ban) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ips == nil {
+		l.ips, l.guid = make(map[string]Ban), make(map[int64]Ban)
+	}
+	if ban.IP != nil {
+		l.ips[ban.IP.String()] = ban
+	} else {
+		l.guid[ban.GUID] = ban
+	}
+	atomic.StoreInt32(&l.n, int32(len(l.ips)+len(l.guid)))
+}
+
+// remove removes the ban of the IP address or GUID passed. It returns false if there was none.
+func (l *banList) remove(ip net.IP, guid int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ok bool
+	if ip != nil {
+		_, ok = l.ips[ip.String()]
+		delete(l.ips, ip.String())
+	} else {
+		_, ok = l.guid[guid]
+		delete(l.guid, guid)
+	}
+	atomic.StoreInt32(&l.n, int32(len(l.ips)+len(l.guid)))
+	return ok
+}
+
+// all removes the bans expired at the time passed and returns the remaining ones, ordered by expiry.
+// Permanent bans come last.
+func (l *banList) all(now time.Time) []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeExpired(now)
+	bans := make([]Ban, 0, len(l.ips)+len(l.guid))
+	for _, ban := range l.ips {
+		bans = append(bans, ban)
+	}
+	for _, ban := range l.guid {
+		bans = append(bans, ban)
+	}
+	sort.SliceStable(bans, func(i, j int) bool {
+		if bans[i].Expiry.IsZero() || bans[j].Expiry.IsZero() {
+			return !bans[i].Expiry.IsZero()
+		}
+		return bans[i].Expiry.Before(bans[j].Expiry)
+	})
+	return bans
+}
+
+// BanIP bans the IP address passed for the duration passed, or permanently if the duration is 0. Open
+// connections from the IP address are closed, and datagrams it sends are dropped until the ban expires.
+func (listener *Listener) BanIP(ip net.IP, d time.Duration, reason string) {
+	listener.ban(Ban{IP: ip, Reason: reason}, d)
+}
+
+// BanGUID bans the client GUID passed for the duration passed, or permanently if the duration is 0. Open
+// connections of the GUID are closed, and clients attempting to connect with it are refused until the ban
+// expires.
+func (listener *Listener) BanGUID(guid int64, d time.Duration, reason string) {
+	listener.ban(Ban{GUID: guid, Reason: reason}, d)
+}
+
+// UnbanIP lifts the ban of the IP address passed. It returns false if the IP address was not banned.
+func (listener *Listener) UnbanIP(ip net.IP) bool {
+	if !listener.bans.remove(ip, 0) {
+		return false
+	}
+	listener.saveBans()
+	return true
+}
+
+// UnbanGUID lifts the ban of the client GUID passed. It returns false if the GUID was not banned.
+func (listener *Listener) UnbanGUID(guid int64) bool {
+	if !listener.bans.remove(nil, guid) {
+		return false
+	}
+	listener.saveBans()
+	return true
+}
+
+// Bans returns the bans currently held by the listener that have not expired, ordered by expiry.
+func (listener *Listener) Bans() []Ban {
+	return listener.bans.all(listener.config.clock.Now())
+}
+
+// ban adds the ban passed with an expiry after the duration passed, closes the connections it applies to and
+// saves the bans.
+func (listener *Listener) ban(ban Ban, d time.Duration) {
+	if d > 0 {
+		ban.Expiry = listener.config.clock.Now().Add(d)
+	}
+	listener.bans.put(ban)
+	listener.connections.Range(func(key, value interface{}) bool {
+		conn := value.(*Conn)
+		udp, ok := conn.addr.(*net.UDPAddr)
+		if (ban.IP != nil && ok && udp.IP.Equal(ban.IP)) || (ban.IP == nil && conn.id == ban.GUID) {
+			_ = conn.Close()
+			listener.connections.Delete(key)
+		}
+		return true
+	})
+	listener.saveBans()
+}
+
+// loadBans loads the bans returned by ListenConfig.LoadBans, if set. Bans that expired are skipped.
+func (listener *Listener) loadBans() error {
+	if listener.listenConfig.LoadBans == nil {
+		return nil
+	}
+	bans, err := listener.listenConfig.LoadBans()
+	if err != nil {
+		return fmt.Errorf("error loading bans: %v", err)
+	}
+	now := listener.config.clock.Now()
+	for _, ban := range bans {
+		if !ban.expired(now) {
+			listener.bans.put(ban)
+		}
+	}
+	return nil
+}
+
+// saveBans passes the bans of the listener to ListenConfig.SaveBans, if set. Errors returned are logged.
+func (listener *Listener) saveBans() {
+	if listener.listenConfig.SaveBans == nil {
+		return
+	}
+	if err := listener.listenConfig.SaveBans(listener.Bans()); err != nil {
+		listener.logger().Error("error saving bans", "error", err)
+	}
+}
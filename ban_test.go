@@ -0,0 +1,116 @@
+package raknet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBanIP(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	var saved []Ban
+	l, err := ListenConfig{Clock: clock, SaveBans: func(bans []Ban) error { saved = bans; return nil }}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	l.BanIP(net.IPv4(127, 0, 0, 1), time.Minute, "flooding")
+	if len(saved) != 1 || saved[0].Reason != "flooding" {
+		t.Fatalf("expected the ban to be saved, got %v", saved)
+	}
+	udp, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing UDP: %v", err)
+	}
+	defer udp.Close()
+	ping := append([]byte{idUnconnectedPing, 0, 0, 0, 0, 0, 0, 0, 1}, append(magic[:], 0, 0, 0, 0, 0, 0, 0, 1)...)
+	if _, err := udp.Write(ping); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	_ = udp.SetReadDeadline(time.Now().Add(time.Millisecond * 300))
+	if _, err := udp.Read(make([]byte, 1500)); err == nil {
+		t.Fatalf("expected ping from banned address to be dropped")
+	}
+	clock.Advance(time.Minute)
+	if _, err := (Dialer{}).Ping(l.Addr().String()); err != nil {
+		t.Fatalf("expected ping after ban expired to succeed: %v", err)
+	}
+	if bans := l.Bans(); len(bans) != 0 {
+		t.Fatalf("expected expired ban to be removed, got %v", bans)
+	}
+}
+
+func TestBanGUID(t *testing.T) {
+	l, err := ListenConfig{LoadBans: func() ([]Ban, error) {
+		return []Ban{{GUID: 1, Expiry: time.Now().Add(-time.Second)}}, nil
+	}}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	if bans := l.Bans(); len(bans) != 0 {
+		t.Fatalf("expected expired ban not to be loaded, got %v", bans)
+	}
+
+	dial := func() (*Conn, error) {
+		return Dialer{Rand: rand.New(rand.NewSource(1))}.Dial(l.Addr().String())
+	}
+	conn, err := dial()
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer conn.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	l.BanGUID(accepted.(*Conn).id, 0, "cheating")
+	select {
+	case <-accepted.(*Conn).closeCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected connection of banned GUID to be closed")
+	}
+
+	if _, err := dial(); !errors.Is(err, ErrBanned) {
+		t.Fatalf("expected ErrBanned dialing with a banned GUID, got %v", err)
+	}
+	if !l.UnbanGUID(accepted.(*Conn).id) || l.UnbanGUID(accepted.(*Conn).id) {
+		t.Fatalf("expected GUID to be unbanned once")
+	}
+}
+
+func TestBanExpirySweep(t *testing.T) {
+	now := time.Now()
+	l := &banList{}
+	l.put(Ban{IP: net.IPv4(10, 0, 0, 1), Expiry: now.Add(time.Second)})
+	l.put(Ban{GUID: 1, Expiry: now.Add(time.Millisecond * 1500)})
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 19132}
+	bans := func() int32 {
+		return atomic.LoadInt32(&l.n)
+	}
+
+	// Lookups sweep the expired bans, also those of other addresses, but at most once per second.
+	clock := NewManualClock(now)
+	l.ipBan(addr, clock)
+	clock.Advance(time.Second)
+	l.ipBan(addr, clock)
+	if n := bans(); n != 1 {
+		t.Fatalf("expected the expired IP ban to be removed, got %v bans", n)
+	}
+	clock.Advance(time.Millisecond * 500)
+	if _, ok := l.guidBan(1, clock.Now()); ok {
+		t.Fatalf("expected expired GUID ban not to apply")
+	}
+	if n := bans(); n != 1 {
+		t.Fatalf("expected no sweep within a second of the last, got %v bans", n)
+	}
+	clock.Advance(time.Millisecond * 500)
+	l.ipBan(addr, clock)
+	if n := bans(); n != 0 {
+		t.Fatalf("expected all bans to be removed once expired, got %v bans", n)
+	}
+}
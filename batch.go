@@ -0,0 +1,72 @@
+package raknet
+
+import (
+	"net"
+	"runtime/pprof"
+)
+
+// maxBatchSize is the maximum amount of datagrams held in a write batch. Once a batch holds this many
+// datagrams, it is written immediately.
+const maxBatchSize = 64
+
+// queueBatch adds a datagram to the write batch of the connection. The batch is written once it is full, or
+// once the write batch window of the connection has passed.
+// queueBatch must be called while holding the write lock.
+func (conn *Conn) queueBatch(b []byte) error {
+	buf := getBuffer(len(b))
+	trackedBuffers.create(conn.tracked, 1)
+	copy(buf, b)
+	conn.batch = append(conn.batch, buf)
+	if len(conn.batch) >= maxBatchSize {
+		return conn.writeBatch()
+	}
+	if !conn.batchScheduled {
+		conn.batchScheduled = true
+		conn.goroutines.Add(1)
+		trackedTimers.create(conn.tracked, 1)
+		if conn.batchTimer == nil {
+			conn.batchTimer = conn.clock.AfterFunc(conn.batchWindow, conn.scheduledWriteBatch)
+		} else {
+			conn.batchTimer.Reset(conn.batchWindow)
+		}
+	}
+	return nil
+}
+
+// scheduledWriteBatch writes the write batch of the connection. It is called by the batch timer.
+func (conn *Conn) scheduledWriteBatch() {
+	defer conn.goroutines.Done()
+	defer trackedTimers.release(conn.tracked, 1)
+	pprof.SetGoroutineLabels(conn.labels)
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	conn.batchScheduled = false
+	_ = conn.writeBatch()
+}
+
+// writeBatch writes all datagrams in the write batch of the connection at once, using a single system call
+// where the platform supports it.
+// writeBatch must be called while holding the write lock.
+func (conn *Conn) writeBatch() error {
+	if len(conn.batch) == 0 {
+		return nil
+	}
+	err := writeDatagrams(conn.conn, conn.addr, conn.batch)
+	for i, b := range conn.batch {
+		putBuffer(b)
+		conn.batch[i] = nil
+	}
+	trackedBuffers.release(conn.tracked, len(conn.batch))
+	conn.batch = conn.batch[:0]
+	return err
+}
+
+// writeDatagramsSequentially writes all datagrams passed to the address passed one by one.
+func writeDatagramsSequentially(conn net.PacketConn, addr net.Addr, datagrams [][]byte) error {
+	for _, b := range datagrams {
+		if _, err := conn.WriteTo(b, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package raknet
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is the message header used by the sendmmsg system call.
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+	_   [4]byte
+}
+
+// writeDatagrams writes all datagrams passed to the address passed. The datagrams are written using a single
+// sendmmsg system call where possible, falling back to writing them one by one if the connection passed is
+// not a UDP connection.
+func writeDatagrams(conn net.PacketConn, addr net.Addr, datagrams [][]byte) error {
+	connected := false
+	if wrapped, ok := conn.(*wrappedConn); ok {
+		// The connection of a client is already connected to the address, so no address is passed to
+		// sendmmsg.
+		conn, connected = wrapped.PacketConn, true
+	}
+	if traced, ok := conn.(*traceConn); ok {
+		// The datagrams are traced here, as they are written to the underlying connection directly.
+		for _, b := range datagrams {
+			traced.trace(Outbound, addr, b)
+		}
+		conn = traced.UDPConn
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	udpAddr, addrOK := addr.(*net.UDPAddr)
+	if !ok || (!connected && !addrOK) {
+		return writeDatagramsSequentially(conn, addr, datagrams)
+	}
+	raw, err := udpConn.SyscallConn()
+	if err != nil {
+		return writeDatagramsSequentially(conn, addr, datagrams)
+	}
+
+	var sockaddr4 syscall.RawSockaddrInet4
+	var sockaddr6 syscall.RawSockaddrInet6
+	var name *byte
+	var nameLen uint32
+	if !connected {
+		var family syscall.Sockaddr
+		if err := raw.Control(func(fd uintptr) {
+			family, _ = syscall.Getsockname(int(fd))
+		}); err != nil {
+			return err
+		}
+		port := uint16(udpAddr.Port)
+		if _, ok := family.(*syscall.SockaddrInet4); ok {
+			ip := udpAddr.IP.To4()
+			if ip == nil {
+				return writeDatagramsSequentially(conn, addr, datagrams)
+			}
+			sockaddr4.Family = syscall.AF_INET
+			sockaddr4.Port = port<<8 | port>>8
+			copy(sockaddr4.Addr[:], ip)
+			name, nameLen = (*byte)(unsafe.Pointer(&sockaddr4)), syscall.SizeofSockaddrInet4
+		} else {
+			sockaddr6.Family = syscall.AF_INET6
+			sockaddr6.Port = port<<8 | port>>8
+			copy(sockaddr6.Addr[:], udpAddr.IP.To16())
+			name, nameLen = (*byte)(unsafe.Pointer(&sockaddr6)), syscall.SizeofSockaddrInet6
+		}
+	}
+
+	iovecs := make([]syscall.Iovec, len(datagrams))
+	messages := make([]mmsghdr, len(datagrams))
+	for i, b := range datagrams {
+		iovecs[i].Base = &b[0]
+		iovecs[i].SetLen(len(b))
+		messages[i].hdr.Name = name
+		messages[i].hdr.Namelen = nameLen
+		messages[i].hdr.Iov = &iovecs[i]
+		messages[i].hdr.Iovlen = 1
+	}
+	sent := 0
+	var errno syscall.Errno
+	if err := raw.Write(func(fd uintptr) bool {
+		for sent < len(messages) {
+			n, _, e := syscall.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&messages[sent])), uintptr(len(messages)-sent), 0, 0, 0)
+			if e == syscall.EAGAIN {
+				// The socket is not writable right now. Returning false waits until it is.
+				return false
+			}
+			if e != 0 {
+				errno = e
+				return true
+			}
+			sent += int(n)
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return &net.OpError{Op: "write", Net: "udp", Addr: addr, Err: errno}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package raknet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteDatagramsIPv4(t *testing.T) {
+	receiver := listenUDP(t, "udp4", "127.0.0.1:0")
+	sender := listenUDP(t, "udp4", "127.0.0.1:0")
+	testWriteDatagrams(t, sender, receiver.LocalAddr(), receiver)
+}
+
+func TestWriteDatagramsIPv6(t *testing.T) {
+	receiver := listenUDP(t, "udp6", "[::1]:0")
+	sender := listenUDP(t, "udp6", "[::1]:0")
+	testWriteDatagrams(t, sender, receiver.LocalAddr(), receiver)
+}
+
+func TestWriteDatagramsDualStack(t *testing.T) {
+	// A socket bound to all addresses is an IPv6 socket that IPv4 addresses are written to as IPv4-mapped
+	// IPv6 addresses.
+	receiver := listenUDP(t, "udp4", "127.0.0.1:0")
+	sender := listenUDP(t, "udp", ":0")
+	testWriteDatagrams(t, sender, receiver.LocalAddr(), receiver)
+}
+
+func TestWriteDatagramsConnected(t *testing.T) {
+	receiver := listenUDP(t, "udp4", "127.0.0.1:0")
+	sender, err := net.DialUDP("udp", nil, receiver.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer sender.Close()
+	// The connection of a client is wrapped like this by Dialer.
+	testWriteDatagrams(t, &wrappedConn{PacketConn: sender}, receiver.LocalAddr(), receiver)
+}
+
+// listenUDP listens on the address passed, skipping the test if the network is not available. The connection
+// is closed once the test finishes.
+func listenUDP(t *testing.T, network, address string) *net.UDPConn {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		t.Skipf("network %v not available: %v", network, err)
+	}
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		t.Skipf("network %v not available: %v", network, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// testWriteDatagrams writes more datagrams than fit in a single batch from sender to addr and checks that
+// every one of them is received by receiver unchanged and in order.
+func testWriteDatagrams(t *testing.T, sender net.PacketConn, addr net.Addr, receiver *net.UDPConn) {
+	datagrams := make([][]byte, maxBatchSize*2+5)
+	for i := range datagrams {
+		datagrams[i] = make([]byte, 1+i*7%1200)
+		for j := range datagrams[i] {
+			datagrams[i][j] = byte(i + j)
+		}
+	}
+	if err := writeDatagrams(sender, addr, datagrams); err != nil {
+		t.Fatalf("error writing datagrams: %v", err)
+	}
+
+	_ = receiver.SetReadDeadline(time.Now().Add(time.Second * 5))
+	b := make([]byte, 1500)
+	for i, expected := range datagrams {
+		n, _, err := receiver.ReadFrom(b)
+		if err != nil {
+			t.Fatalf("error reading datagram %v: %v", i, err)
+		}
+		if !bytes.Equal(b[:n], expected) {
+			t.Fatalf("datagram %v: expected %v bytes %x, got %v bytes %x", i, len(expected), expected, n, b[:n])
+		}
+	}
+}
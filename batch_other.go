@@ -0,0 +1,14 @@
+//go:build !linux || (!amd64 && !arm64)
+// +build !linux !amd64,!arm64
+
+package raknet
+
+import (
+	"net"
+)
+
+// writeDatagrams writes all datagrams passed to the address passed. On this platform, the datagrams are
+// written one by one.
+func writeDatagrams(conn net.PacketConn, addr net.Addr, datagrams [][]byte) error {
+	return writeDatagramsSequentially(conn, addr, datagrams)
+}
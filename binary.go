@@ -3,6 +3,7 @@ package raknet
 import (
 	"bytes"
 	"fmt"
+	"io"
 )
 
 // uint24 represents an integer existing out of 3 bytes. It is actually a uint32, but is an alias for the
@@ -12,22 +13,69 @@ type uint24 uint32
 // readUint24 reads 3 bytes from the buffer passed and combines it into a uint24. If there were no 3 bytes to
 // read, an error is returned.
 func readUint24(b *bytes.Buffer) (uint24, error) {
-	data := make([]byte, 3)
-	if _, err := b.Read(data); err != nil {
-		return 0, fmt.Errorf("error reading uint24: %v", err)
+	data := b.Next(3)
+	if len(data) != 3 {
+		return 0, fmt.Errorf("error reading uint24: %v", io.ErrUnexpectedEOF)
 	}
 	return uint24(data[0]) | (uint24(data[1]) << 8) | (uint24(data[2]) << 16), nil
 }
 
 // writeUint24 writes a uint24 to the buffer passed as 3 bytes. If not successful, an error is returned.
 func writeUint24(b *bytes.Buffer, value uint24) error {
-	data := []byte{
+	data := [3]byte{
 		byte(value),
 		byte(value >> 8),
 		byte(value >> 16),
 	}
-	if _, err := b.Write(data); err != nil {
+	if _, err := b.Write(data[:]); err != nil {
 		return fmt.Errorf("error writing uint24: %v", err)
 	}
 	return nil
 }
+
+// uint24After checks if the uint24 a comes after b, taking into account that uint24s wrap around once they
+// exceed 24 bits.
+func uint24After(a, b uint24) bool {
+	diff := (a - b) & 0xffffff
+	return diff != 0 && diff < 0x800000
+}
+
+// readUint16 reads a big endian uint16 from the buffer passed. If there were no 2 bytes to read, an error is
+// returned.
+func readUint16(b *bytes.Buffer) (uint16, error) {
+	data := b.Next(2)
+	if len(data) != 2 {
+		return 0, fmt.Errorf("error reading uint16: %v", io.ErrUnexpectedEOF)
+	}
+	return uint16(data[0])<<8 | uint16(data[1]), nil
+}
+
+// writeUint16 writes a uint16 to the buffer passed in big endian byte order. If not successful, an error is
+// returned.
+func writeUint16(b *bytes.Buffer, value uint16) error {
+	data := [2]byte{byte(value >> 8), byte(value)}
+	if _, err := b.Write(data[:]); err != nil {
+		return fmt.Errorf("error writing uint16: %v", err)
+	}
+	return nil
+}
+
+// readUint32 reads a big endian uint32 from the buffer passed. If there were no 4 bytes to read, an error is
+// returned.
+func readUint32(b *bytes.Buffer) (uint32, error) {
+	data := b.Next(4)
+	if len(data) != 4 {
+		return 0, fmt.Errorf("error reading uint32: %v", io.ErrUnexpectedEOF)
+	}
+	return uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3]), nil
+}
+
+// writeUint32 writes a uint32 to the buffer passed in big endian byte order. If not successful, an error is
+// returned.
+func writeUint32(b *bytes.Buffer, value uint32) error {
+	data := [4]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+	if _, err := b.Write(data[:]); err != nil {
+		return fmt.Errorf("error writing uint32: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package raknet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBlackholeSources is the maximum amount of addresses of which a Listener counts rejected packets or that
+// it holds blackholed at once. Addresses beyond it are not blackholed.
+const maxBlackholeSources = 65536
+
+// Blackhole configures the blackholing of abusive addresses by a Listener. An address from which Threshold
+// packets were rejected within Window is blackholed for Duration: All datagrams it sends are dropped before
+// they are processed in any way, without calling OnReject or logging, so that scanners and attack traffic
+// cost as little as possible. Dropped datagrams are counted in ListenerStats.BlackholeDrops.
+// Packets rejected because the listener is full, because the client is already connected or banned, or
+// because the connection sequence timed out are not counted, as honest clients run into those too.
+type Blackhole struct {
+	// Threshold is the amount of rejected packets after which an address is blackholed. If 0, addresses are
+	// never blackholed.
+	Threshold int
+	// Window is the duration within which Threshold packets must be rejected. Window is 10 seconds if 0.
+	Window time.Duration
+	// Duration is the duration for which an address is blackholed. Duration is 1 minute if 0.
+	Duration time.Duration
+}
+
+// window returns the Window of the Blackhole, or its default if empty.
+func (b Blackhole) window() time.Duration {
+	if b.Window <= 0 {
+		return time.Second * 10
+	}
+	return b.Window
+}
+
+// duration returns the Duration of the Blackhole, or its default if empty.
+func (b Blackhole) duration() time.Duration {
+	if b.Duration <= 0 {
+		return time.Minute
+	}
+	return b.Duration
+}
+
+// abusive checks if packets rejected for the reason passed count towards blackholing the address they were
+// sent from.
+func (reason RejectReason) abusive() bool {
+	switch reason {
+	case RejectServerFull, RejectAlreadyConnected, RejectBanned, RejectHandshakeTimeout, RejectMemoryLimit:
+		return false
+	}
+	return true
+}
+
+// blackholes holds the rejected packets counted per address and the addresses blackholed.
+type blackholes struct {
+	config Blackhole
+
+	mu      sync.Mutex
+	sources map[string]*blackholeSource
+	// n is the amount of addresses blackholed, so that checking datagrams when none are takes no lock.
+	n int32
+	// swept is the last time sources were swept to make room. Sweeps happen at most once per second, so that
+	// a flood from many addresses does not make every rejected packet scan all sources.
+	swept time.Time
+}
+
+// blackholeSource is the state of a single address.
+type blackholeSource struct {
+	// strikes is the amount of packets rejected since start.
+	strikes int
+	start   time.Time
+	// until is the time until which the address is blackholed. It is zero if it is not.
+	until time.Time
+}
+
+// blackholed checks if the address passed is blackholed at the current time of the Clock passed.
+func (b *blackholes) blackholed(addr string, clock Clock) bool {
+	if atomic.LoadInt32(&b.n) == 0 {
+		return false
+	}
+	now := clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sources[addr]
+	if !ok || s.until.IsZero() {
+		return false
+	}
+	if now.Before(s.until) {
+		return true
+	}
+	delete(b.sources, addr)
+	atomic.AddInt32(&b.n, -1)
+	return false
+}
+
+// strike counts a packet rejected from the address passed at the time passed, blackholing the address once
+// the Threshold is reached.
+func (b *blackholes) strike(addr string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sources[addr]
+	if !ok {
+		if len(b.sources) >= maxBlackholeSources && now.Sub(b.swept) >= time.Second {
+			b.swept = now
+			b.sweep(now)
+		}
+		if len(b.sources) >= maxBlackholeSources {
+			return
+		}
+		s = &blackholeSource{start: now}
+		b.sources[addr] = s
+	}
+	if !s.until.IsZero() {
+		return
+	}
+	if now.Sub(s.start) >= b.config.window() {
+		s.strikes, s.start = 0, now
+	}
+	if s.strikes++; s.strikes >= b.config.Threshold {
+		s.until = now.Add(b.config.duration())
+		atomic.AddInt32(&b.n, 1)
+	}
+}
+
+// sweep removes the addresses of which the window or blackholing ended at the time passed.
+func (b *blackholes) sweep(now time.Time) {
+	for k, s := range b.sources {
+		if s.until.IsZero() && now.Sub(s.start) >= b.config.window() {
+			delete(b.sources, k)
+		} else if !s.until.IsZero() && !now.Before(s.until) {
+			delete(b.sources, k)
+			atomic.AddInt32(&b.n, -1)
+		}
+	}
+}
+
+// blackholed checks if datagrams from the address passed are to be dropped because the address is
+// blackholed. If so, the drop is counted.
+func (listener *Listener) blackholed(addr net.Addr) bool {
+	if listener.blackholes.config.Threshold <= 0 || !listener.blackholes.blackholed(addr.String(), listener.config.clock) {
+		return false
+	}
+	atomic.AddUint64(&listener.counters.blackholeDrops, 1)
+	return true
+}
+
+// strike counts a packet from the address passed that was rejected for the reason passed towards
+// blackholing the address.
+func (listener *Listener) strike(addr net.Addr, reason RejectReason) {
+	if listener.blackholes.config.Threshold <= 0 || !reason.abusive() {
+		return
+	}
+	listener.blackholes.strike(addr.String(), listener.config.clock.Now())
+}
@@ -0,0 +1,82 @@
+package raknet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBlackhole(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	rejections := make(chan Rejection, 16)
+	l, err := ListenConfig{
+		Clock:     clock,
+		Blackhole: Blackhole{Threshold: 3, Duration: time.Minute},
+		OnReject:  func(r Rejection) { rejections <- r },
+	}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing UDP: %v", err)
+	}
+	defer conn.Close()
+	ping := append([]byte{idUnconnectedPing, 0, 0, 0, 0, 0, 0, 0, 1}, append(magic[:], 0, 0, 0, 0, 0, 0, 0, 1)...)
+	pong := func() bool {
+		if _, err := conn.Write(ping); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 300))
+		_, err := conn.Read(make([]byte, 1500))
+		return err == nil
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := conn.Write([]byte{0x7f, 1, 2, 3}); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	if pong() {
+		t.Fatalf("expected ping from blackholed address to be dropped")
+	}
+	if n := len(rejections); n != 3 {
+		t.Fatalf("expected 3 rejections before blackholing, got %v", n)
+	}
+	if drops := l.Stats().BlackholeDrops; drops != 2 {
+		t.Fatalf("expected 2 datagrams dropped, got %v", drops)
+	}
+	clock.Advance(time.Minute)
+	if !pong() {
+		t.Fatalf("expected ping to be answered once the blackholing ended")
+	}
+}
+
+func TestBlackholeSweepThrottled(t *testing.T) {
+	b := &blackholes{config: Blackhole{Threshold: 3}, sources: make(map[string]*blackholeSource)}
+	now := time.Now()
+	for i := 0; i < maxBlackholeSources; i++ {
+		b.strike(fmt.Sprint(i), now)
+	}
+	// The window of all sources ended, so the first strike from a new address sweeps them. The sweep is
+	// not repeated for the strikes that follow within a second, even though the sources fill up again.
+	now = now.Add(Blackhole{}.window())
+	b.strike("new", now)
+	if len(b.sources) != 1 {
+		t.Fatalf("expected sources to be swept, got %v", len(b.sources))
+	}
+	for i := 0; i < maxBlackholeSources; i++ {
+		b.strike(fmt.Sprint(i), now.Add(-Blackhole{}.window()))
+	}
+	b.strike("other", now.Add(time.Millisecond*500))
+	if _, ok := b.sources["other"]; ok || len(b.sources) != maxBlackholeSources {
+		t.Fatalf("expected no sweep within a second of the last")
+	}
+	b.strike("other", now.Add(time.Second))
+	if _, ok := b.sources["other"]; !ok {
+		t.Fatalf("expected sweep a second after the last")
+	}
+}
@@ -0,0 +1,25 @@
+package raknet
+
+import (
+	"net"
+	"syscall"
+)
+
+// setBroadcast allows datagrams to be sent to broadcast addresses over the connection passed.
+func setBroadcast(conn net.PacketConn) error {
+	c, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package raknet
+
+import (
+	"net"
+)
+
+// setBroadcast is a no-op on platforms other than Linux. Sending datagrams to broadcast addresses may fail
+// on these platforms, in which case an Announcer only reaches the other addresses it announces to.
+func setBroadcast(net.PacketConn) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package raknet
+
+import (
+	"sync"
+)
+
+const (
+	// minBufferClass and maxBufferClass are the base-2 logarithms of the smallest and the biggest size class
+	// of buffers that are pooled. Buffers bigger than 1 << maxBufferClass are not pooled.
+	minBufferClass = 9
+	maxBufferClass = 24
+)
+
+// bufferPools holds a sync.Pool for each buffer size class. The buffers in a pool all have a capacity of
+// exactly the size of the class. They are used to hold split packets while they are being reassembled, so
+// that receiving large packets does not lead to many allocations.
+var bufferPools [maxBufferClass - minBufferClass + 1]sync.Pool
+
+// bufferClass returns the index of the smallest size class that can hold n bytes, or -1 if n is bigger than
+// the biggest size class.
+func bufferClass(n int) int {
+	for class := range bufferPools {
+		if n <= 1<<uint(class+minBufferClass) {
+			return class
+		}
+	}
+	return -1
+}
+
+// getBuffer returns a byte slice with a length of n. It is taken from the pool of the smallest size class
+// that can hold n bytes, if there is one. The content of the byte slice returned is undefined.
+func getBuffer(n int) []byte {
+	class := bufferClass(n)
+	if class == -1 {
+		return make([]byte, n)
+	}
+	if b, ok := bufferPools[class].Get().([]byte); ok {
+		return b[:n]
+	}
+	return make([]byte, n, 1<<uint(class+minBufferClass))
+}
+
+// putBuffer returns a byte slice to the pool of its size class, so that it may be re-used by a later call to
+// getBuffer. Byte slices with a capacity that is not exactly that of a size class are not pooled. The byte
+// slice passed must not be used after calling putBuffer.
+func putBuffer(b []byte) {
+	class := bufferClass(cap(b))
+	if class == -1 || cap(b) != 1<<uint(class+minBufferClass) {
+		return
+	}
+	bufferPools[class].Put(b[:0])
+}
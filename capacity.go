@@ -0,0 +1,60 @@
+package raknet
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+)
+
+// Admission describes a client attempting to connect to a Listener, which is passed to
+// ListenConfig.Admit to decide if the client may connect.
+type Admission struct {
+	// Addr is the address of the client.
+	Addr net.Addr
+	// GUID is the GUID of the client.
+	GUID int64
+	// Connections is the amount of connections the Listener currently holds, including those that were not
+	// yet accepted.
+	Connections int
+	// MaxConnections is the current Limits.MaxConnections of the Listener. It is 0 if no limit is set.
+	MaxConnections int
+}
+
+// Full checks if the Listener holds its maximum amount of connections, which is the default policy for
+// refusing clients.
+func (a Admission) Full() bool {
+	return a.MaxConnections > 0 && a.Connections >= a.MaxConnections
+}
+
+// admit checks if the client with the address and GUID passed may connect to the listener.
+func (listener *Listener) admit(addr net.Addr, guid int64) bool {
+	max := listener.Limits().MaxConnections
+	if max <= 0 && listener.listenConfig.Admit == nil {
+		// Counting the connections is not needed without a maximum or a policy of the user.
+		return true
+	}
+	a := Admission{Addr: addr, GUID: guid, Connections: listener.connectionCount(), MaxConnections: max}
+	if listener.listenConfig.Admit != nil {
+		return listener.listenConfig.Admit(a)
+	}
+	return !a.Full()
+}
+
+// advertiseCapacity replaces the player count and maximum player count of the Minecraft pong data passed with
+// the amount of connections of the listener and its maximum amount of connections. Pong data of other games,
+// or pong data when no maximum is set, is returned as is.
+func (listener *Listener) advertiseCapacity(data []byte) []byte {
+	max := listener.Limits().MaxConnections
+	if max <= 0 || !bytes.HasPrefix(data, []byte("MCPE;")) {
+		return data
+	}
+	// The fields of Minecraft pong data are, in order: The edition, the MOTD, the protocol version, the game
+	// version, the player count and the maximum player count, followed by others that are left as they are.
+	fragments := bytes.Split(data, []byte{';'})
+	if len(fragments) < 6 {
+		return data
+	}
+	fragments[4] = []byte(strconv.Itoa(listener.connectionCount()))
+	fragments[5] = []byte(strconv.Itoa(max))
+	return bytes.Join(fragments, []byte{';'})
+}
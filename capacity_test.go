@@ -0,0 +1,42 @@
+package raknet
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCapacity(t *testing.T) {
+	var operators int32
+	l, err := ListenConfig{MaxConnections: 1, AdvertiseCapacity: true, Admit: func(a Admission) bool {
+		// Operators may connect beyond the maximum.
+		return !a.Full() || atomic.LoadInt32(&operators) == 1
+	}}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	l.PongData([]byte("MCPE;Test;390;1.14.60;0;10;0;Test;Survival;1;19132;19133;"))
+
+	if data, err := Ping(l.Addr().String()); err != nil || string(data) != "MCPE;Test;390;1.14.60;0;1;0;Test;Survival;1;19132;19133;" {
+		t.Fatalf("expected pong data advertising capacity of 1, got %q (%v)", data, err)
+	}
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	if data, err := Ping(l.Addr().String()); err != nil || string(data) != "MCPE;Test;390;1.14.60;1;1;0;Test;Survival;1;19132;19133;" {
+		t.Fatalf("expected pong data advertising 1 of 1 players, got %q (%v)", data, err)
+	}
+
+	if _, err := Dial(l.Addr().String()); !errors.Is(err, ErrServerFull) {
+		t.Fatalf("expected dialing full listener to fail with ErrServerFull, got %v", err)
+	}
+	atomic.StoreInt32(&operators, 1)
+	operator, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing as operator: %v", err)
+	}
+	_ = operator.Close()
+}
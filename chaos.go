@@ -0,0 +1,112 @@
+package raknet
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Chaos injects misbehaviour of the transport into the connections it is set on using the Chaos field of a
+// ListenConfig or Dialer, on demand, so that applications may verify their reconnect and error-handling
+// paths. Unlike SimulateNetwork, which alters raw datagrams, Chaos acts on established connections: It drops
+// them, corrupts packets of specific types and stalls acknowledgements.
+// Methods may be called on Chaos from multiple goroutines simultaneously. A nil *Chaos injects nothing.
+type Chaos struct {
+	mu      sync.Mutex
+	rand    *rand.Rand
+	conns   map[*Conn]struct{}
+	corrupt map[byte]float64
+	stalled bool
+}
+
+// NewChaos returns a Chaos that injects nothing until instructed to. The seed passed is used for all random
+// decisions it makes, so that a run may be reproduced.
+func NewChaos(seed int64) *Chaos {
+	return &Chaos{rand: rand.New(rand.NewSource(seed)), conns: make(map[*Conn]struct{}), corrupt: make(map[byte]float64)}
+}
+
+// DropConnections drops every open connection of the Chaos with the probability passed, ranging from 0 to 1.
+// Dropped connections are closed immediately without flushing the data they still had to send, as if the
+// transport failed, so that reads and writes on them return errors. The number of connections dropped is
+// returned.
+func (chaos *Chaos) DropConnections(probability float64) int {
+	chaos.mu.Lock()
+	var dropped []*Conn
+	for conn := range chaos.conns {
+		if conn.closed() {
+			delete(chaos.conns, conn)
+			continue
+		}
+		if chaos.rand.Float64() < probability {
+			delete(chaos.conns, conn)
+			dropped = append(dropped, conn)
+		}
+	}
+	chaos.mu.Unlock()
+
+	for _, conn := range dropped {
+		conn.close()
+	}
+	return len(dropped)
+}
+
+// CorruptPackets makes connections corrupt received packets with the ID passed with the probability passed,
+// ranging from 0 to 1, by replacing a random byte after the ID. A probability of 0 stops corrupting packets
+// with the ID.
+func (chaos *Chaos) CorruptPackets(id byte, probability float64) {
+	chaos.mu.Lock()
+	defer chaos.mu.Unlock()
+	if probability <= 0 {
+		delete(chaos.corrupt, id)
+		return
+	}
+	chaos.corrupt[id] = probability
+}
+
+// StallACKs stops connections from sending ACKs if stall is true, so that the other end resends its
+// datagrams and eventually times out. Once StallACKs is called with false, the datagrams received in the
+// meantime are acknowledged on the next tick.
+func (chaos *Chaos) StallACKs(stall bool) {
+	chaos.mu.Lock()
+	defer chaos.mu.Unlock()
+	chaos.stalled = stall
+}
+
+// add adds a connection to the Chaos, so that it may be dropped.
+func (chaos *Chaos) add(conn *Conn) {
+	if chaos == nil {
+		return
+	}
+	chaos.mu.Lock()
+	defer chaos.mu.Unlock()
+	for c := range chaos.conns {
+		if c.closed() {
+			delete(chaos.conns, c)
+		}
+	}
+	chaos.conns[conn] = struct{}{}
+}
+
+// acksStalled checks if connections should currently refrain from sending ACKs.
+func (chaos *Chaos) acksStalled() bool {
+	if chaos == nil {
+		return false
+	}
+	chaos.mu.Lock()
+	defer chaos.mu.Unlock()
+	return chaos.stalled
+}
+
+// corruptPacket corrupts the packet passed in place if packets with its ID are to be corrupted.
+func (chaos *Chaos) corruptPacket(b []byte) {
+	if chaos == nil || len(b) < 2 {
+		return
+	}
+	chaos.mu.Lock()
+	defer chaos.mu.Unlock()
+	probability, ok := chaos.corrupt[b[0]]
+	if !ok || chaos.rand.Float64() >= probability {
+		return
+	}
+	i := 1 + chaos.rand.Intn(len(b)-1)
+	b[i] ^= byte(1 + chaos.rand.Intn(255))
+}
@@ -0,0 +1,57 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestChaos(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	a, b := p.A(), p.B()
+	chaos := NewChaos(1)
+	for _, conn := range []*Conn{a, b} {
+		conn.chaos = chaos
+		chaos.add(conn)
+	}
+
+	chaos.StallACKs(true)
+	if _, err := a.Write([]byte{0xfe, 1}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	p.Advance(tickInterval * 5)
+	if a.recoveryQueue.Len() == 0 {
+		t.Fatalf("expected datagram to remain unacknowledged while ACKs are stalled")
+	}
+	chaos.StallACKs(false)
+	p.Advance(tickInterval)
+	if n := a.recoveryQueue.Len(); n != 0 {
+		t.Fatalf("expected all datagrams to be acknowledged after ACKs were resumed, %v remain", n)
+	}
+	if _, err := b.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+
+	chaos.CorruptPackets(0xfe, 1)
+	payload := []byte{0xfe, 1, 2, 3}
+	if _, err := a.Write(payload); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	p.Step()
+	buf := make([]byte, 10)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if buf[0] != 0xfe || bytes.Equal(buf[:n], payload) {
+		t.Fatalf("expected packet to be corrupted after its ID, got %x", buf[:n])
+	}
+
+	if n := chaos.DropConnections(1); n != 2 {
+		t.Fatalf("expected 2 connections to be dropped, got %v", n)
+	}
+	if _, err := a.Write([]byte{0xfe}); !ErrConnectionClosed(err) {
+		t.Fatalf("expected closed error after dropping connection, got %v", err)
+	}
+}
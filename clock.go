@@ -0,0 +1,357 @@
+package raknet
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a source of time used by Listeners and connections for their timers: Retransmission of lost
+// datagrams, connection timeouts, pings and the flushing of datagrams are all driven by the Clock. A Clock
+// other than the system clock may be passed to a ListenConfig or Dialer, so that tests may drive this logic
+// deterministically without sleeping.
+// Note that deadlines set on the underlying net.PacketConn are not affected by the Clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTicker returns a new Ticker that sends the current time on its channel after every period d.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f in its own goroutine after the duration d has passed. The Timer returned may be used
+	// to stop or reset the timer.
+	AfterFunc(d time.Duration, f func()) Timer
+	// After returns a channel that receives the current time after the duration d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker is a ticker created by a Clock, like a time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker. No more ticks are sent after Stop returns.
+	Stop()
+}
+
+// Timer is a timer created using Clock.AfterFunc, like a time.Timer. A *time.Timer satisfies this interface.
+type Timer interface {
+	// Reset changes the timer to expire after the duration d. It returns true if the timer had been active.
+	Reset(d time.Duration) bool
+	// Stop prevents the timer from firing. It returns true if the timer had been active.
+	Stop() bool
+}
+
+// SystemClock is the Clock used by default. It uses the time package directly.
+var SystemClock Clock = systemClock{}
+
+// systemClock implements Clock using the time package.
+type systemClock struct{}
+
+// Now ...
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker ...
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{Ticker: time.NewTicker(d)}
+}
+
+// AfterFunc ...
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// After ...
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// systemTicker wraps around a time.Ticker to implement Ticker.
+type systemTicker struct {
+	*time.Ticker
+}
+
+// C ...
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// ManualClock is a Clock of which the time only changes when Advance is called. Timers and tickers created
+// by it fire while advancing the clock, in the order of their expiry, so that time-based logic may be tested
+// deterministically.
+// Methods may be called on ManualClock from multiple goroutines simultaneously.
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManualClock returns a ManualClock of which the current time is t.
+func NewManualClock(t time.Time) *ManualClock {
+	return &ManualClock{now: t}
+}
+
+// Now returns the current time of the clock.
+func (clock *ManualClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+// Advance moves the time of the clock forward by d. Every timer and ticker that expires in that period is
+// fired, with the time of the clock set to the time of expiry while doing so. Like a time.Ticker, a ticker
+// that is not read from drops ticks rather than queueing them: Only the latest tick is kept.
+func (clock *ManualClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	target := clock.now.Add(d)
+	for {
+		t := clock.next(target)
+		if t == nil {
+			break
+		}
+		clock.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			t.active = false
+		}
+		now := clock.now
+		clock.mu.Unlock()
+		t.fire(now)
+		clock.mu.Lock()
+	}
+	clock.now = target
+	clock.mu.Unlock()
+}
+
+// next returns the active timer that expires first, if it expires before or at the time passed. Timers
+// that are no longer active are removed.
+// next must be called while holding the lock of the clock.
+func (clock *ManualClock) next(target time.Time) *manualTimer {
+	active := clock.timers[:0]
+	for _, t := range clock.timers {
+		if t.active {
+			active = append(active, t)
+		} else {
+			t.listed = false
+		}
+	}
+	for i := len(active); i < len(clock.timers); i++ {
+		clock.timers[i] = nil
+	}
+	clock.timers = active
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i].when.Before(active[j].when)
+	})
+	if len(active) == 0 || active[0].when.After(target) {
+		return nil
+	}
+	return active[0]
+}
+
+// NewTicker returns a Ticker that ticks every period d as the clock is advanced.
+func (clock *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for ManualClock.NewTicker")
+	}
+	t := &manualTimer{clock: clock, period: d, c: make(chan time.Time, 1)}
+	clock.schedule(t, d)
+	return manualTicker{t}
+}
+
+// AfterFunc returns a Timer that calls f in its own goroutine once the clock is advanced by d.
+func (clock *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &manualTimer{clock: clock, f: f}
+	clock.schedule(t, d)
+	return t
+}
+
+// After returns a channel that receives the time of the clock once it is advanced by d.
+func (clock *ManualClock) After(d time.Duration) <-chan time.Time {
+	t := &manualTimer{clock: clock, c: make(chan time.Time, 1)}
+	clock.schedule(t, d)
+	return t.c
+}
+
+// schedule makes the timer passed expire after d. It returns true if the timer was already active.
+func (clock *ManualClock) schedule(t *manualTimer, d time.Duration) bool {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	wasActive := t.active
+	t.when, t.active = clock.now.Add(d), true
+	if !t.listed {
+		t.listed = true
+		clock.timers = append(clock.timers, t)
+	}
+	return wasActive
+}
+
+// manualTimer is a timer or ticker created by a ManualClock.
+type manualTimer struct {
+	clock *ManualClock
+	// when is the time at which the timer next expires. period is the interval of a ticker, or 0 if the
+	// timer only expires once.
+	when   time.Time
+	period time.Duration
+	// active specifies if the timer is still to expire. listed specifies if the timer is in the timers of
+	// the clock.
+	active, listed bool
+
+	f func()
+	c chan time.Time
+}
+
+// fire calls the function of the timer in a new goroutine, or sends the time passed over its channel if it
+// has one, replacing a time that was not yet received.
+func (t *manualTimer) fire(now time.Time) {
+	if t.f != nil {
+		go t.f()
+		return
+	}
+	for {
+		select {
+		case t.c <- now:
+			return
+		default:
+			// The channel is full, so we drop the stale time in it in favour of the new one.
+			select {
+			case <-t.c:
+			default:
+			}
+		}
+	}
+}
+
+// Reset ...
+func (t *manualTimer) Reset(d time.Duration) bool {
+	return t.clock.schedule(t, d)
+}
+
+// Stop ...
+func (t *manualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+// manualTicker is a ticker created by a ManualClock.
+type manualTicker struct {
+	t *manualTimer
+}
+
+// C ...
+func (t manualTicker) C() <-chan time.Time {
+	return t.t.c
+}
+
+// Stop ...
+func (t manualTicker) Stop() {
+	t.t.Stop()
+}
+
+// SkewClock returns a Clock that runs relative to the Clock passed, offset by a fixed duration and drifting
+// away from it over time. A drift of 0.01 makes the Clock run 1% fast, so that it gains 10 milliseconds
+// every second, while a negative drift makes it run slow. Timers and tickers of the Clock returned expire
+// according to its own, skewed time. SkewClock may be used to give the ends of a Pipe clocks that differ,
+// like those of real clients, so that timestamp-based logic is tested against skew:
+//
+//	a, b := raknet.PipeConfig{ClockB: raknet.SkewClock(raknet.SystemClock, time.Hour, 0.01)}.Pipe()
+//
+// SkewClock panics if drift is -1 or lower.
+func SkewClock(clock Clock, offset time.Duration, drift float64) Clock {
+	if drift <= -1 {
+		panic("drift of SkewClock must be above -1")
+	}
+	return skewedClock{clock: clock, start: clock.Now(), offset: offset, rate: 1 + drift}
+}
+
+// skewedClock is a Clock that is offset from and runs at a different rate than another Clock.
+type skewedClock struct {
+	clock  Clock
+	start  time.Time
+	offset time.Duration
+	rate   float64
+}
+
+// Now ...
+func (clock skewedClock) Now() time.Time {
+	return clock.skew(clock.clock.Now())
+}
+
+// NewTicker ...
+func (clock skewedClock) NewTicker(d time.Duration) Ticker {
+	t := &skewedTicker{ticker: clock.clock.NewTicker(clock.real(d)), c: make(chan time.Time, 1), stop: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case now := <-t.ticker.C():
+				select {
+				case t.c <- clock.skew(now):
+				default:
+					// Like a time.Ticker, ticks are dropped if they are not received.
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// AfterFunc ...
+func (clock skewedClock) AfterFunc(d time.Duration, f func()) Timer {
+	return skewedTimer{Timer: clock.clock.AfterFunc(clock.real(d), f), clock: clock}
+}
+
+// After ...
+func (clock skewedClock) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	after := clock.clock.After(clock.real(d))
+	go func() {
+		c <- clock.skew(<-after)
+	}()
+	return c
+}
+
+// skew converts a time of the underlying Clock to the time of the skewedClock.
+func (clock skewedClock) skew(t time.Time) time.Time {
+	return clock.start.Add(clock.offset + time.Duration(float64(t.Sub(clock.start))*clock.rate))
+}
+
+// real converts a duration of the skewedClock to a duration of the underlying Clock.
+func (clock skewedClock) real(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / clock.rate)
+}
+
+// skewedTicker is a Ticker created by a skewedClock.
+type skewedTicker struct {
+	ticker Ticker
+	c      chan time.Time
+	once   sync.Once
+	stop   chan struct{}
+}
+
+// C ...
+func (t *skewedTicker) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop ...
+func (t *skewedTicker) Stop() {
+	t.once.Do(func() {
+		t.ticker.Stop()
+		close(t.stop)
+	})
+}
+
+// skewedTimer is a Timer created by a skewedClock.
+type skewedTimer struct {
+	Timer
+	clock skewedClock
+}
+
+// Reset ...
+func (t skewedTimer) Reset(d time.Duration) bool {
+	return t.Timer.Reset(t.clock.real(d))
+}
@@ -0,0 +1,95 @@
+package raknet
+
+import (
+	"sync"
+	"time"
+)
+
+// clockSampleCount is the amount of the most recent clock samples held by a connection. RakNet holds the
+// same amount of ping times to calculate its clock differential.
+const clockSampleCount = 5
+
+// ClockSample is the result of a single exchange of a connected ping and pong, sent by a connection to
+// measure its latency. Connected pings are sent every few seconds, and each pong holds the timestamp of the
+// other end at the time it sent the pong, so that the clocks of both ends may be synchronised.
+type ClockSample struct {
+	// PingTimestamp is the local timestamp in milliseconds at which the connected ping was sent.
+	PingTimestamp int64
+	// PongTimestamp is the timestamp in milliseconds at which the other end sent the connected pong,
+	// according to the clock of the other end.
+	PongTimestamp int64
+	// ReceiveTimestamp is the local timestamp in milliseconds at which the connected pong was received.
+	ReceiveTimestamp int64
+}
+
+// RTT returns the round-trip time of the exchange of the sample.
+func (sample ClockSample) RTT() time.Duration {
+	return time.Duration(sample.ReceiveTimestamp-sample.PingTimestamp) * time.Millisecond
+}
+
+// Differential returns the difference between the clock of the other end and the local clock, assuming
+// that the other end sent its pong halfway through the round trip. Adding it to a local time results in the
+// time of the other end.
+func (sample ClockSample) Differential() time.Duration {
+	return time.Duration(sample.PongTimestamp-(sample.PingTimestamp+sample.ReceiveTimestamp)/2) * time.Millisecond
+}
+
+// clockSamples holds the most recent clock samples of a connection.
+type clockSamples struct {
+	mu      sync.Mutex
+	samples [clockSampleCount]ClockSample
+	n, next int
+}
+
+// add adds a sample, replacing the oldest sample held if clockSampleCount samples are already held.
+func (s *clockSamples) add(sample ClockSample) {
+	s.mu.Lock()
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % clockSampleCount
+	if s.n < clockSampleCount {
+		s.n++
+	}
+	s.mu.Unlock()
+}
+
+// all returns the samples held, from the oldest to the most recent.
+func (s *clockSamples) all() []ClockSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	samples := make([]ClockSample, 0, s.n)
+	for i := 0; i < s.n; i++ {
+		samples = append(samples, s.samples[(s.next-s.n+i+clockSampleCount)%clockSampleCount])
+	}
+	return samples
+}
+
+// differential returns the differential of the sample with the lowest round-trip time, which is the least
+// affected by an asymmetric delay, like RakNet's GetClockDifferential. If no samples are held, false is
+// returned.
+func (s *clockSamples) differential() (time.Duration, bool) {
+	samples := s.all()
+	if len(samples) == 0 {
+		return 0, false
+	}
+	best := samples[0]
+	for _, sample := range samples[1:] {
+		if sample.RTT() <= best.RTT() {
+			best = sample
+		}
+	}
+	return best.Differential(), true
+}
+
+// ClockSamples returns the clock samples of the most recent connected ping and pong exchanges of the
+// connection, from the oldest to the most recent. At most 5 samples are returned.
+func (conn *Conn) ClockSamples() []ClockSample {
+	return conn.clockSamples.all()
+}
+
+// ClockDifferential returns the estimated difference between the clock of the other end of the connection
+// and the local clock, like RakNet's GetClockDifferential. Adding it to a local time results in the time of
+// the other end. The estimate is taken from the most recent clock sample with the lowest round-trip time. If
+// no connected pong was received yet, false is returned.
+func (conn *Conn) ClockDifferential() (time.Duration, bool) {
+	return conn.clockSamples.differential()
+}
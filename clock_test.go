@@ -0,0 +1,154 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewManualClock(start)
+
+	fired := make(chan struct{})
+	clock.AfterFunc(time.Second, func() { close(fired) })
+	after := clock.After(time.Second * 3)
+	ticker := clock.NewTicker(time.Second * 2)
+	defer ticker.Stop()
+
+	clock.Advance(time.Second * 2)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatalf("AfterFunc did not fire")
+	}
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Second * 2)) {
+		t.Errorf("ticker ticked at %v, expected %v", got, start.Add(time.Second*2))
+	}
+	select {
+	case <-after:
+		t.Fatalf("After fired before its duration passed")
+	default:
+	}
+	clock.Advance(time.Second * 5)
+	if got := <-after; !got.Equal(start.Add(time.Second * 3)) {
+		t.Errorf("After fired at %v, expected %v", got, start.Add(time.Second*3))
+	}
+	// Only the latest tick is kept if the ticker is not read from.
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Second * 6)) {
+		t.Errorf("ticker ticked at %v, expected %v", got, start.Add(time.Second*6))
+	}
+	if now := clock.Now(); !now.Equal(start.Add(time.Second * 7)) {
+		t.Errorf("clock is at %v, expected %v", now, start.Add(time.Second*7))
+	}
+}
+
+func TestConnTimeoutManualClock(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer pc.Close()
+
+	clock := NewManualClock(time.Now())
+	conn := newConn(pc, pc.LocalAddr(), 1400, 1, connConfig{clock: clock})
+	defer conn.Close()
+
+	clock.Advance(connTimeout - tickInterval)
+	select {
+	case <-conn.closeCtx.Done():
+		t.Fatalf("connection timed out before the timeout passed")
+	case <-time.After(time.Millisecond * 50):
+	}
+	clock.Advance(tickInterval * 2)
+	select {
+	case <-conn.closeCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("connection did not time out after the timeout passed")
+	}
+}
+
+func TestSkewClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	base := NewManualClock(start)
+	// The skewed clock is an hour ahead and runs twice as fast.
+	clock := SkewClock(base, time.Hour, 1)
+	skewed := start.Add(time.Hour)
+
+	fired := make(chan struct{})
+	clock.AfterFunc(time.Second*4, func() { close(fired) })
+	ticker := clock.NewTicker(time.Second * 2)
+	defer ticker.Stop()
+
+	base.Advance(time.Second)
+	if got := clock.Now(); !got.Equal(skewed.Add(time.Second * 2)) {
+		t.Errorf("skewed clock is at %v, expected %v", got, skewed.Add(time.Second*2))
+	}
+	select {
+	case got := <-ticker.C():
+		if !got.Equal(skewed.Add(time.Second * 2)) {
+			t.Errorf("ticker ticked at %v, expected %v", got, skewed.Add(time.Second*2))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ticker did not tick")
+	}
+	select {
+	case <-fired:
+		t.Fatalf("AfterFunc fired before its duration passed")
+	default:
+	}
+	base.Advance(time.Second)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatalf("AfterFunc did not fire")
+	}
+}
+
+func TestClockDifferential(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer pc.Close()
+
+	clock := NewManualClock(time.Unix(1000, 0))
+	conn := newConn(pc, pc.LocalAddr(), 1400, 1, connConfig{clock: clock})
+	defer conn.Close()
+
+	if _, ok := conn.ClockDifferential(); ok {
+		t.Fatalf("expected no clock differential before a pong was received")
+	}
+	// The clock of the other end runs 5 seconds ahead. The first pong is delayed on its way back, so that
+	// its sample has a higher round-trip time.
+	now := conn.timestamp()
+	for i, pong := range []connectedPong{
+		{PingTimestamp: now - 300, PongTimestamp: now - 200 + 5000},
+		{PingTimestamp: now - 40, PongTimestamp: now - 20 + 5000},
+	} {
+		b := bytes.NewBuffer(nil)
+		_ = binary.Write(b, binary.BigEndian, pong)
+		if err := conn.handleConnectedPong(b); err != nil {
+			t.Fatalf("error handling pong %v: %v", i, err)
+		}
+	}
+	if samples := conn.ClockSamples(); len(samples) != 2 || samples[1].RTT() != time.Millisecond*40 {
+		t.Fatalf("expected 2 clock samples, the last with an RTT of 40ms, got %+v", samples)
+	}
+	if d, ok := conn.ClockDifferential(); !ok || d != time.Second*5 {
+		t.Fatalf("expected clock differential of 5s, got %v", d)
+	}
+}
+
+func TestClockSamples(t *testing.T) {
+	var s clockSamples
+	for i := int64(0); i < clockSampleCount+2; i++ {
+		s.add(ClockSample{PingTimestamp: i})
+	}
+	samples := s.all()
+	if len(samples) != clockSampleCount || samples[0].PingTimestamp != 2 || samples[clockSampleCount-1].PingTimestamp != clockSampleCount+1 {
+		t.Fatalf("expected the %v most recent samples in order, got %+v", clockSampleCount, samples)
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,13 +32,47 @@ const (
 	// resendRequestThreshold is the amount of datagrams that must be received before datagrams that were
 	// missing earlier will be requested to be resent.
 	resendRequestThreshold = 10
+	// receiveWindow is the amount of sequence numbers, starting at the lowest one of which the datagram was
+	// not yet received, that datagrams are accepted with. Datagrams with sequence numbers outside of it are
+	// replays or duplicates, or are too far ahead, and are dropped.
+	receiveWindow uint24 = 1 << 14
 	// tickInterval is the interval at which the connection sends an ACK containing the packets which were
 	// received or a NACK for missing packets.
 	tickInterval = time.Second / 100
 	// pingInterval is the interval in seconds at which a ping is sent to the other end of the connection.
 	pingInterval = time.Second * 4
+	// defaultMaxDatagramSize is the default maximum size of a datagram, which is also the maximum MTU size
+	// negotiated with the other end of a connection.
+	defaultMaxDatagramSize = 1500
+	// maxMTUSize is the maximum MTU size that may be negotiated. The length of the content of a packet is
+	// encoded in bits in a 16-bit integer, so a packet can never hold more than 8191 bytes of content.
+	maxMTUSize = 8192
 )
 
+// maxDatagramSize returns the maximum datagram size passed, or the default maximum datagram size if 0 was
+// passed. The size returned never exceeds maxMTUSize.
+func maxDatagramSize(size int) int {
+	if size <= 0 {
+		return defaultMaxDatagramSize
+	}
+	if size > maxMTUSize {
+		return maxMTUSize
+	}
+	return size
+}
+
+// maxMTU returns the maximum MTU size passed, clamped between minMTUSize and the maximum datagram size
+// passed. If 0, the maximum datagram size is returned.
+func maxMTU(size, maxDatagramSize int) int {
+	if size <= 0 || size > maxDatagramSize {
+		return maxDatagramSize
+	}
+	if size < minMTUSize {
+		return minMTUSize
+	}
+	return size
+}
+
 var (
 	errConnectionClosed = "error reading from conn: connection closed"
 	errUseOfClosed      = "use of closed network connection"
@@ -42,11 +80,14 @@ var (
 )
 
 // ErrConnectionClosed checks if the error passed was an error caused by reading from a Conn of which the
-// connection was closed.
+// connection was closed. It is equivalent to errors.Is(err, ErrClosed) for errors returned by a Conn.
 func ErrConnectionClosed(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, net.ErrClosed) {
+		return true
+	}
 	return strings.Contains(err.Error(), errConnectionClosed) || strings.Contains(err.Error(), errUseOfClosed)
 }
 
@@ -55,39 +96,129 @@ func ErrReadTimeout(err error) bool {
 	if err == nil {
 		return false
 	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "read" && errors.Is(err, ErrTimeout) {
+		return true
+	}
 	return strings.Contains(err.Error(), errReadTimeout)
 }
 
+// errMessageTooLarge is the error returned by Conn.Read if a packet does not fit in the buffer passed.
+var errMessageTooLarge = errors.New("A message sent on a RakNet socket was larger than the buffer used to receive the message into")
+
 // Conn represents a connection to a specific client. It is not a real connection, as UDP is connectionless,
 // but rather a connection emulated using RakNet.
 // Methods may be called on Conn from multiple goroutines simultaneously.
 type Conn struct {
+	// memUsage is the amount of bytes held by the connection for packets in the recovery queue, split
+	// packets being reassembled and packets awaiting ordering. It is accessed atomically and is placed first
+	// in the struct to guarantee 64-bit alignment.
+	memUsage int64
+	// counters holds the statistics of the connection. It is updated atomically and follows memUsage so that
+	// it is 64-bit aligned. listenerCounters are the counters of the Listener that created the connection,
+	// if any.
+	counters         counters
+	listenerCounters *counters
+
 	conn net.PacketConn
 	addr net.Addr
 
 	writeLock   sync.Mutex
 	writeBuffer *bytes.Buffer
 
-	readPacket *packet
+	// sendWindow is the maximum amount of datagrams that may be awaiting acknowledgement in the recovery
+	// queue. Datagrams flushed while the window is full are held in windowQueue until datagrams are
+	// acknowledged. If 0, there is no maximum.
+	sendWindow  int
+	windowQueue []*datagram
+
+	// readPacket is the packet that packets in received datagrams are decoded into. It is re-used for every
+	// packet, so that no new packet needs to be allocated for each of them.
+	readPacket packet
 
 	sendSequenceNumber uint24
 	sendOrderIndex     uint24
 	sendMessageIndex   uint24
+	sendSequenceIndex  uint24
 	sendSplitID        uint32
 
+	// receiveSequenceIndex is the highest sequence index of sequenced packets received so far. Sequenced
+	// packets with a lower index arrived too late and are dropped. receivedSequenced specifies if any
+	// sequenced packet was received yet.
+	receiveSequenceIndex uint24
+	receivedSequenced    bool
+
+	// sendDatagram is the datagram currently being built by writes. It is flushed once it is full, or once
+	// the flush timer fires.
+	sendDatagram   *datagram
+	flushTimer     Timer
+	flushScheduled bool
+
+	// batchWindow is the time datagrams are held in batch before they are written, so that they may be
+	// written using a single system call. If 0, datagrams are written immediately.
+	batchWindow    time.Duration
+	batch          [][]byte
+	batchTimer     Timer
+	batchScheduled bool
+
 	// completingSequence is a Context which is completed once the RakNet connection sequence is completed.
 	completingSequence context.Context
 	finishSequence     context.CancelFunc
 
 	// id is the random client GUID of the client. It is different each time a client connects to to a server.
 	id int64
+	// clock is the Clock that drives the timers of the connection, such as those for resending datagrams,
+	// pinging and timing out. rand is the source of randomness of the connection, or nil if math/rand is used.
+	clock Clock
+	rand  io.Reader
+	// onAck is called for every ACK and NACK sent or received, if set.
+	onAck func(event AckEvent)
+	// synchronous specifies if the connection is driven by a SyncPipe. If so, it starts no goroutines and
+	// datagrams are only flushed and ticks only performed when the SyncPipe is stepped.
+	synchronous bool
+	// chaos is the Chaos used to inject misbehaviour into the connection, if non-nil.
+	chaos *Chaos
+	// protocol is the RakNet protocol version negotiated during the connection sequence. framing holds the
+	// framing of messages for that version, as decided on by the Profile of the connection.
+	protocol byte
+	framing  protocolFraming
+	// session encrypts and decrypts the datagrams of the connection if it is secured. It is nil otherwise.
+	session *securitySession
+	// tracked specifies if resource tracking was enabled when the connection was created, in which case the
+	// resources it creates and releases are counted until it is closed.
+	tracked bool
+	// labels is a context holding the pprof labels of the connection. Goroutines doing work for the
+	// connection are tagged with these labels, so that CPU profiles attribute time to specific connections.
+	labels context.Context
+
+	// tracer is the Tracer used to start spans for the connection. traceCtx holds the span of the connection
+	// sequence, of which spans started later are children. connectSpan is the span of the connection sequence
+	// started by a Listener, which is ended once the connection is accepted.
+	tracer      Tracer
+	traceCtx    context.Context
+	connectSpan Span
 	// mtuSize is the MTU size of the connection. Packets longer than this size must be split into fragments
 	// for them to arrive at the client without losing bytes.
 	mtuSize int16
+	// pathMTU is the MTU size currently used for datagrams sent. It is equal to mtuSize, unless path MTU
+	// discovery found a different MTU size. pathMTU and pmtu are guarded by the write lock.
+	pathMTU int16
+	pmtu    pmtuState
+
+	// loss estimates the packet loss of the connection in both directions.
+	loss lossEstimator
+	// meter counts the metrics returned by RakNetStatistics.
+	meter *metricMeter
+	// resends holds the sequence numbers of datagrams that were resent, to detect spurious resends. It is
+	// guarded by the write lock.
+	resends resendHistory
 
 	// latency is the last measured latency between both ends of the connection. Note that this latency is
 	// not the round-trip time, but half of that.
 	latency atomic.Value
+	// clockSamples holds the timestamps of the most recent connected ping and pong exchanges, used to
+	// estimate the difference between the clocks of both ends.
+	clockSamples clockSamples
 	// packetLossChance is a percentage from 0-1 that specifies the chance that a packet read or written may
 	// be lost.
 	packetLossChance atomic.Value
@@ -97,6 +228,16 @@ type Conn struct {
 	// splits is a map of slices indexed by split IDs. The length of each of the slices is equal to the split
 	// count, and packets are positioned in that slice indexed by the split index.
 	splits map[uint16][][]byte
+	// splitMemory is the amount of bytes held for split packets that are being reassembled, which may be no
+	// more than maxSplitMemory. maxSplitCount is the maximum amount of fragments of a single split packet.
+	splitMemory    int
+	maxSplitMemory int
+	maxSplitCount  int
+	// recoverPanics specifies if panics in the handling of packets are recovered.
+	recoverPanics bool
+	// ackLimit limits the ACKs and NACKs processed, using ackBucket.
+	ackLimit  RateLimit
+	ackBucket tokenBucket
 
 	// datagramRecvQueue is an ordered queue used to track which datagrams were received and which datagrams
 	// were missing, so that we can send NACKs to request missing datagrams.
@@ -110,93 +251,264 @@ type Conn struct {
 	// packetQueue is an ordered queue containing packets indexed by their order index.
 	packetQueue *orderedQueue
 	// packetChan is a channel containing content of packets that were fully processed. Calling Conn.Read()
-	// consumes a value from this channel.
-	packetChan chan *bytes.Buffer
+	// consumes a value from this channel. Its buffer is the read queue of the connection.
+	packetChan chan receivedPacket
+	// slowConsumerPolicy is applied when the read queue is full. slowConsumer is true while the read queue
+	// has been full since it was last empty, and is only accessed by the goroutine receiving packets.
+	slowConsumerPolicy SlowConsumerPolicy
+	slowConsumer       bool
+	// events is the event bus of the Listener that created the connection, if any.
+	events *eventBus
+	// goroutines tracks the goroutines started by the connection, which include the timer goroutine and
+	// those of scheduled flushes. It is shared with the Listener that created the connection, if any.
+	goroutines *sync.WaitGroup
+	// directReads is a channel through which a blocking call to Conn.Read() offers its buffer if it is at
+	// least MTU-sized, so that packets may be copied into it directly. The amount of bytes copied is sent
+	// back over directN with the encapsulation of the packet, or -1 if the buffer was too small to hold the
+	// packet.
+	directReads chan []byte
+	directN     chan readResult
+	// readable receives a value when a packet is added to the read queue or when the connection is closed,
+	// so that callers of TryRead know when to poll again. It has a buffer of one, so values are coalesced.
+	readable chan struct{}
 	// lastPacketTime is the last time a packet was received. It is used to measure the time until the
-	// connection times out.
+	// connection times out. idleTimeout holds the time.Duration after which it times out.
 	lastPacketTime atomic.Value
+	idleTimeout    atomic.Value
+	// lastDetectTime is the last time a detect lost connections packet was sent. It is only used by tick.
+	lastDetectTime time.Time
+
+	// values holds the values attached to the connection using SetValue, keyed by their keys.
+	values sync.Map
 
 	// recoveryQueue is a queue filled with packets that were sent with a given datagram sequence number.
 	recoveryQueue *orderedQueue
 
 	closeCtx context.Context
 	close    context.CancelFunc
+	// disconnect holds the *DisconnectError of the connection if it was closed by a disconnect notification of
+	// the other end. disconnectSent is set to 1 once a disconnect notification was sent to the other end.
+	disconnect     atomic.Value
+	disconnectSent int32
+
+	// pcap holds the *PcapWriter set using SetPcapWriter, which may be nil.
+	pcap atomic.Value
 
 	// readDeadline is a channel that receives a time.Time after a specific time. It is used to listen for
 	// timeouts in Read after calling SetReadDeadline.
 	readDeadline <-chan time.Time
 }
 
+// connConfig holds the configuration of a Conn. It is derived from the ListenConfig or Dialer that created
+// the Conn.
+type connConfig struct {
+	// sendWindow is the maximum amount of datagrams that may be awaiting acknowledgement at the same time.
+	// If 0, there is no maximum.
+	sendWindow int
+	// delayRecordCount is the amount of delays recorded to calculate the average delay of datagrams.
+	delayRecordCount int
+	// idleTimeout is the time after which the connection is closed if no packets were received. If 0, the
+	// default of 7 seconds is used.
+	idleTimeout time.Duration
+	// readQueueSize is the amount of packets that may be waiting to be read. slowConsumerPolicy is applied
+	// once the read queue is full.
+	readQueueSize      int
+	slowConsumerPolicy SlowConsumerPolicy
+	// maxDatagramSize is the maximum size of datagrams read by the connection, and the maximum MTU size that
+	// path MTU discovery raises the MTU size of the connection to.
+	maxDatagramSize int
+	// maxSplitCount and maxSplitMemory are the maximum amount of fragments of a split packet and the maximum
+	// amount of bytes held for split packets being reassembled. If 0, the defaults are used.
+	maxSplitCount, maxSplitMemory int
+	// recoverPanics specifies if panics in the handling of packets received are recovered, closing the
+	// connection instead.
+	recoverPanics bool
+	// ackLimit limits the rate at which ACKs and NACKs are processed. If its Rate is 0, no limit is enforced.
+	ackLimit RateLimit
+	// pathMTUDiscovery specifies if the connection should discover the path MTU once it is established.
+	pathMTUDiscovery bool
+	// writeBatchWindow is the time datagrams are held before they are written in a single batch. If 0,
+	// datagrams are not batched.
+	writeBatchWindow time.Duration
+	// counters are the counters of the Listener that created the connection. They are updated with those of
+	// the connection. If nil, the connection was not created by a Listener.
+	counters *counters
+	// events is the event bus of the Listener that created the connection, if any.
+	events *eventBus
+	// goroutines tracks the goroutines started by the connection. If nil, a new WaitGroup is used.
+	goroutines *sync.WaitGroup
+	// tracer is the Tracer used to start spans for the connection. traceCtx holds the span of the connection
+	// sequence of the connection, of which spans started later are children.
+	tracer   Tracer
+	traceCtx context.Context
+	// clock is the Clock used for the timers of the connection. If nil, SystemClock is used.
+	clock Clock
+	// rand is the source of randomness of the connection. If nil, the global source of math/rand is used.
+	rand io.Reader
+	// onAck is called for every ACK and NACK sent or received by the connection, if set.
+	onAck func(event AckEvent)
+	// synchronous specifies if the connection is driven by a SyncPipe rather than by its own goroutines.
+	synchronous bool
+	// chaos injects misbehaviour into the connection. It is nil if no misbehaviour is to be injected.
+	chaos *Chaos
+	// protocol is the RakNet protocol version negotiated for the connection. If 0, the default protocol of
+	// the profile is used.
+	protocol byte
+	// profile is the Profile of the Listener or Dialer that created the connection.
+	profile Profile
+	// quirks are the Quirks of the Listener or Dialer that created the connection.
+	quirks Quirks
+	// session is the session used to encrypt the datagrams of the connection if it is secured, or nil if it
+	// is not.
+	session *securitySession
+}
+
+const (
+	// lowFootprintBacklog is the accept backlog of a Listener in low footprint mode.
+	lowFootprintBacklog = 16
+	// lowFootprintSendWindow is the send window of a Conn in low footprint mode.
+	lowFootprintSendWindow = 64
+	// lowFootprintDelayRecordCount is the amount of delays recorded by a Conn in low footprint mode.
+	lowFootprintDelayRecordCount = 8
+)
+
+// lowFootprint fills out the values of the connConfig left empty with the values used in low footprint mode.
+func (config connConfig) lowFootprint() connConfig {
+	if config.sendWindow == 0 {
+		config.sendWindow = lowFootprintSendWindow
+	}
+	if config.delayRecordCount == 0 {
+		config.delayRecordCount = lowFootprintDelayRecordCount
+	}
+	if config.readQueueSize == 0 {
+		config.readQueueSize = lowFootprintReadQueueSize
+	}
+	return config
+}
+
 // newConn constructs a new connection specifically dedicated to the address passed.
-func newConn(conn net.PacketConn, addr net.Addr, mtuSize int16, id int64) *Conn {
+func newConn(conn net.PacketConn, addr net.Addr, mtuSize int16, id int64, config connConfig) *Conn {
 	if mtuSize < 500 {
 		mtuSize = 500
 	}
+	if config.delayRecordCount == 0 {
+		config.delayRecordCount = DelayRecordCount
+	}
+	if config.readQueueSize == 0 {
+		config.readQueueSize = defaultReadQueueSize
+	}
+	if config.idleTimeout <= 0 {
+		config.idleTimeout = connTimeout
+	}
+	if config.goroutines == nil {
+		config.goroutines = &sync.WaitGroup{}
+	}
+	if config.tracer == nil {
+		config.tracer = nopTracer{}
+	}
+	if config.traceCtx == nil {
+		config.traceCtx = context.Background()
+	}
+	if config.clock == nil {
+		config.clock = SystemClock
+	}
+	if config.protocol == 0 {
+		config.protocol = config.profile.defaultProtocol()
+	}
+	if config.session != nil {
+		conn = &secureConn{PacketConn: conn, session: config.session}
+	}
 	ctx, cancel := context.WithCancel(context.Background())
+	var closeOnce sync.Once
+	readable := make(chan struct{}, 1)
+	maxSplits, maxSplitMemory := config.splitLimits()
+	tracked := resourcesTracked()
 	sequenceCtx, sequenceComplete := context.WithCancel(context.Background())
 	c := &Conn{
 		addr:               addr,
 		conn:               conn,
 		mtuSize:            mtuSize,
+		pathMTU:            mtuSize,
 		id:                 id,
+		clock:              config.clock,
+		rand:               config.rand,
+		onAck:              config.onAck,
+		synchronous:        config.synchronous,
+		chaos:              config.chaos,
+		tracked:            tracked,
+		protocol:           config.protocol,
+		framing:            config.quirks.apply(config.profile.framing(config.protocol)),
+		session:            config.session,
+		loss:               lossEstimator{clock: config.clock},
+		meter:              newMetricMeter(config.clock),
+		labels:             pprof.WithLabels(context.Background(), pprof.Labels("raknet.addr", addr.String(), "raknet.id", strconv.FormatInt(id, 10))),
 		completingSequence: sequenceCtx,
 		finishSequence:     sequenceComplete,
 		splits:             make(map[uint16][][]byte),
-		datagramRecvQueue:  newOrderedQueue(),
-		packetQueue:        newOrderedQueue(),
-		recoveryQueue:      newOrderedQueue(),
-		close:              cancel,
+		maxSplitCount:      maxSplits,
+		maxSplitMemory:     maxSplitMemory,
+		recoverPanics:      config.recoverPanics,
+		ackLimit:           config.ackLimit,
+		datagramRecvQueue:  newOrderedQueue(config.delayRecordCount, config.clock),
+		packetQueue:        newOrderedQueue(config.delayRecordCount, config.clock),
+		recoveryQueue:      newOrderedQueue(config.delayRecordCount, config.clock),
+		sendWindow:         config.sendWindow,
+		batchWindow:        config.writeBatchWindow,
+		listenerCounters:   config.counters,
+		tracer:             config.tracer,
+		traceCtx:           config.traceCtx,
+		connectSpan:        nopSpan{},
+		close: func() {
+			closeOnce.Do(func() {
+				cancel()
+				notify(readable)
+				trackedConns.release(tracked, 1)
+			})
+		},
 		closeCtx:           ctx,
-		packetChan:         make(chan *bytes.Buffer),
+		packetChan:         make(chan receivedPacket, config.readQueueSize),
+		slowConsumerPolicy: config.slowConsumerPolicy,
+		events:             config.events,
+		goroutines:         config.goroutines,
+		directReads:        make(chan []byte),
+		directN:            make(chan readResult),
+		readable:           readable,
 		writeBuffer:        bytes.NewBuffer(nil),
-		readPacket:         &packet{},
+		sendDatagram:       datagramPool.Get().(*datagram),
+	}
+	if config.pathMTUDiscovery {
+		c.pmtu = pmtuState{enabled: true, max: maxDatagramSize(config.maxDatagramSize), nextProbe: config.clock.Now().Add(pmtuSearchInterval)}
+		c.pmtu.ceiling = c.pmtu.max
 	}
 	c.latency.Store(10)
 	c.packetLossChance.Store(0.0)
-	c.lastPacketTime.Store(time.Now())
+	c.lastPacketTime.Store(config.clock.Now())
+	c.idleTimeout.Store(config.idleTimeout)
 	c.datagramsReceived.Store([]uint24{})
+	c.chaos.add(c)
+	trackedConns.create(tracked, 1)
+	if c.synchronous {
+		// The ticks of a synchronous connection are driven by a SyncPipe instead.
+		return c
+	}
+	c.goroutines.Add(1)
 	go func() {
-		ticker := time.NewTicker(tickInterval)
-		pingTicker := time.NewTicker(pingInterval)
+		defer c.goroutines.Done()
+		pprof.SetGoroutineLabels(c.labels)
+		ticker := c.clock.NewTicker(tickInterval)
+		pingTicker := c.clock.NewTicker(pingInterval)
 		defer ticker.Stop()
 		defer pingTicker.Stop()
 		for {
 			select {
-			case <-pingTicker.C:
+			case <-pingTicker.C():
 				// We send a connected ping to calculate the latency and let the other side know we haven't
 				// timed out.
 				c.Ping()
-			case t := <-ticker.C:
-				// We first check if the other end has actually timed out. If so, we closeCtx the conn, as it is
-				// likely the client was disconnected.
-				if t.Sub(c.lastPacketTime.Load().(time.Time)) > connTimeout {
-					// If the timeout was long enough, we closeCtx the conn.
-					_ = c.Close()
+			case t := <-ticker.C():
+				if !c.tick(t) {
 					return
 				}
-				received := c.datagramsReceived.Load().([]uint24)
-				if len(received) > 0 {
-					// Write an ACK packet to the connection containing all datagram sequence numbers that we
-					// received since the last tick.
-					if err := c.sendACK(received...); err != nil {
-						return
-					}
-					c.datagramsReceived.Store(received[:0])
-				}
-				c.writeLock.Lock()
-				var resendSeqNums []uint24
-				// Allow the average delay with a deviation of 200%.
-				delay := c.recoveryQueue.AvgDelay() * 3
-				for seqNum := range c.recoveryQueue.queue {
-					// These packets have not been acknowledged for too long: We resend them by ourselves, even though no
-					// NACK has been issued yet.
-					if time.Now().Sub(c.recoveryQueue.Timestamp(seqNum)) > delay {
-						resendSeqNums = append(resendSeqNums, seqNum)
-					}
-				}
-				_ = c.resend(resendSeqNums)
-				c.writeLock.Unlock()
-
 			case <-c.closeCtx.Done():
 				return
 			}
@@ -206,34 +518,107 @@ func newConn(conn net.PacketConn, addr net.Addr, mtuSize int16, id int64) *Conn
 	return c
 }
 
-// Write writes a buffer b over the RakNet connection. The amount of bytes written n is always equal to the
-// length of the bytes written if the write was successful. If not, an error is returned and n is 0.
-// Write may be called simultaneously from multiple goroutines, but will write one by one.
+// tick performs the work done by the connection every tick interval: It closes the connection if it timed
+// out, acknowledges the datagrams received since the last tick and resends datagrams that are overdue. tick
+// returns false if the connection can no longer be used.
+func (conn *Conn) tick(t time.Time) bool {
+	// We first check if the other end has actually timed out. If so, we closeCtx the conn, as it is likely the
+	// client was disconnected.
+	idle, idleTimeout := t.Sub(conn.lastPacketTime.Load().(time.Time)), conn.idleTimeout.Load().(time.Duration)
+	if idle > idleTimeout {
+		// If the timeout was long enough, we closeCtx the conn.
+		_ = conn.Close()
+		return false
+	}
+	if idle > idleTimeout/2 && t.Sub(conn.lastDetectTime) > idleTimeout/2 {
+		// Nothing was received for half of the timeout, so we send a detect lost connections packet like
+		// RakNet does. Its acknowledgement keeps the connection alive if the other end is idle but present.
+		conn.lastDetectTime = t
+		if _, err := conn.WriteReliability([]byte{idDetectLostConnections}, Reliable); err != nil {
+			return false
+		}
+	}
+	received := conn.datagramsReceived.Load().([]uint24)
+	if len(received) > 0 && !conn.chaos.acksStalled() {
+		// Write an ACK packet to the connection containing all datagram sequence numbers that we received
+		// since the last tick.
+		if err := conn.sendACK(received...); err != nil {
+			return false
+		}
+		conn.datagramsReceived.Store(received[:0])
+	}
+	conn.writeLock.Lock()
+	_ = conn.resendOverdue()
+	_ = conn.tickPMTU(t)
+	conn.writeLock.Unlock()
+	return true
+}
+
+// Write writes a buffer b over the RakNet connection as a reliable ordered message. The amount of bytes
+// written n is always equal to the length of the bytes written if the write was successful. If not, an error
+// is returned and n is 0.
+// Write is equivalent to WriteReliability(b, ReliableOrdered). Like WriteReliability, it may be called
+// simultaneously from multiple goroutines.
 func (conn *Conn) Write(b []byte) (n int, err error) {
+	return conn.WriteReliability(b, ReliableOrdered)
+}
+
+// WriteReliability writes a buffer b over the RakNet connection as a message with the Reliability passed.
+// The amount of bytes written n is always equal to the length of the bytes written if the write was
+// successful. If not, an error is returned and n is 0. Messages too large for a single datagram are split,
+// in which case Unreliable and UnreliableSequenced messages are sent as Reliable and ReliableSequenced
+// messages respectively, so that a lost fragment does not lose the entire message.
+// Write and WriteReliability may be called simultaneously from multiple goroutines. Each call is atomic:
+// The message is queued as a whole before the next call proceeds, so that the fragments of messages are
+// never interleaved, and messages are sent in the order in which the calls obtain the connection. As a
+// result, ReliableOrdered messages written by a single goroutine are always read in the order in which they
+// were written, and those written by different goroutines are read in the order in which their calls
+// completed.
+func (conn *Conn) WriteReliability(b []byte, reliability Reliability) (n int, err error) {
+	if reliability > ReliableSequenced {
+		return 0, opError("write", conn.LocalAddr(), conn.addr, fmt.Errorf("invalid reliability %v", reliability))
+	}
 	conn.writeLock.Lock()
 	defer conn.writeLock.Unlock()
+	if conn.closed() {
+		return 0, opError("write", conn.LocalAddr(), conn.addr, conn.closeErr())
+	}
 
 	fragments := conn.split(b)
+	r := byte(reliability)
+	if len(fragments) > 1 {
+		switch r {
+		case reliabilityUnreliable:
+			r = reliabilityReliable
+		case reliabilityUnreliableSequenced:
+			r = reliabilityReliableSequenced
+		}
+	}
+	// Sequenced messages carry the current order index without advancing it, while ordered messages each
+	// take an order index of their own.
 	orderIndex := conn.sendOrderIndex
-	conn.sendOrderIndex++
+	var sequenceIndex uint24
+	switch r {
+	case reliabilityReliableOrdered:
+		conn.sendOrderIndex++
+	case reliabilityUnreliableSequenced, reliabilityReliableSequenced:
+		sequenceIndex = conn.sendSequenceIndex
+		conn.sendSequenceIndex++
+	}
 
 	splitID := uint16(conn.sendSplitID)
 	if len(fragments) > 1 {
 		conn.sendSplitID++
 	}
 	for splitIndex, content := range fragments {
-		sequenceNumber := conn.sendSequenceNumber
-		conn.sendSequenceNumber++
-		messageIndex := conn.sendMessageIndex
-		conn.sendMessageIndex++
-
-		if err := conn.writeBuffer.WriteByte(bitFlagValid); err != nil {
-			return 0, fmt.Errorf("error writing datagram header: %v", err)
-		}
-		if err := writeUint24(conn.writeBuffer, sequenceNumber); err != nil {
-			return 0, fmt.Errorf("error writing datagram sequence number: %v", err)
+		var messageIndex uint24
+		if r >= reliabilityReliable {
+			messageIndex = conn.sendMessageIndex
+			conn.sendMessageIndex++
 		}
+
 		packet := packetPool.Get().(*packet)
+		packet.reliability = r
 		if cap(packet.content) < len(content) {
 			packet.content = make([]byte, len(content))
 		}
@@ -244,6 +629,7 @@ func (conn *Conn) Write(b []byte) (n int, err error) {
 
 		packet.orderIndex = orderIndex
 		packet.messageIndex = messageIndex
+		packet.sequenceIndex = sequenceIndex
 
 		if len(fragments) > 1 {
 			// If there were more than one fragment, the packet was split, so we need to make sure we set the
@@ -255,50 +641,257 @@ func (conn *Conn) Write(b []byte) (n int, err error) {
 		} else {
 			packet.split = false
 		}
+		if err := conn.queuePacket(packet); err != nil {
+			return 0, opError("write", conn.LocalAddr(), conn.addr, err)
+		}
+		n += len(content)
+	}
+	conn.meter.add(UserMessageBytesPushed, n)
+	return
+}
+
+// queuePacket adds a packet to the datagram that is currently being built. If the packet does not fit in the
+// datagram, the datagram is flushed first. Once a full MTU's worth of packets is queued, the datagram is
+// flushed immediately. Otherwise, it is flushed after an interval derived from the round-trip time.
+// queuePacket must be called while holding the write lock.
+func (conn *Conn) queuePacket(p *packet) error {
+	maxSize := conn.maxDatagramSize(int(conn.pathMTU))
+	if conn.sendDatagram.size()+p.size() > maxSize {
+		if err := conn.flush(); err != nil {
+			return err
+		}
+	}
+	conn.sendDatagram.packets = append(conn.sendDatagram.packets, p)
+	if conn.sendDatagram.size() >= maxSize {
+		return conn.flush()
+	}
+	if !conn.flushScheduled && !conn.synchronous {
+		conn.flushScheduled = true
+		// The goroutine of the flush is tracked from the moment it is scheduled, so that Close may stop it.
+		conn.goroutines.Add(1)
+		trackedTimers.create(conn.tracked, 1)
+		if conn.flushTimer == nil {
+			conn.flushTimer = conn.clock.AfterFunc(conn.flushInterval(), conn.scheduledFlush)
+		} else {
+			conn.flushTimer.Reset(conn.flushInterval())
+		}
+	}
+	return nil
+}
+
+// scheduledFlush flushes the datagram currently being built. It is called by the flush timer.
+func (conn *Conn) scheduledFlush() {
+	defer conn.goroutines.Done()
+	defer trackedTimers.release(conn.tracked, 1)
+	pprof.SetGoroutineLabels(conn.labels)
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	conn.flushScheduled = false
+	_ = conn.flush()
+}
+
+// flushInterval returns the interval after which a datagram that is not yet full is flushed. It is derived
+// from the round-trip time of the connection, so that small messages are not delayed much on connections
+// with a low latency, and is never longer than the tick interval.
+func (conn *Conn) flushInterval() time.Duration {
+	interval := time.Duration(conn.Latency()*2) * time.Millisecond / 8
+	if interval < time.Millisecond {
+		return time.Millisecond
+	}
+	if interval > tickInterval {
+		return tickInterval
+	}
+	return interval
+}
+
+// maxDatagramSize returns the maximum size of the packets in a single datagram sent with the MTU size passed,
+// which is the MTU size minus the UDP/IP header size, the datagram header size and the security overhead.
+func (conn *Conn) maxDatagramSize(mtu int) int {
+	return mtu - conn.framing.mtuHeaderSize() - datagramHeaderSize - conn.securityOverhead()
+}
+
+// flush sends the datagram currently being built to the other end of the connection, if it holds any packets,
+// and adds it to the recovery queue.
+// flush must be called while holding the write lock.
+func (conn *Conn) flush() error {
+	if len(conn.sendDatagram.packets) == 0 {
+		return nil
+	}
+	d := conn.sendDatagram
+	conn.sendDatagram = datagramPool.Get().(*datagram)
+	conn.addMemory(d.contentSize())
+
+	if conn.sendWindow > 0 && (conn.recoveryQueue.Len() >= conn.sendWindow || len(conn.windowQueue) > 0) {
+		// The send window is full, so we hold the datagram until enough datagrams are acknowledged.
+		conn.windowQueue = append(conn.windowQueue, d)
+		return nil
+	}
+	return conn.send(d)
+}
+
+// send sends a datagram to the other end of the connection with a new sequence number and adds it to the
+// recovery queue.
+// send must be called while holding the write lock.
+func (conn *Conn) send(d *datagram) error {
+	sequenceNumber := conn.sendSequenceNumber
+	conn.sendSequenceNumber++
+	if err := conn.writeDatagram(sequenceNumber, d); err != nil {
+		return err
+	}
+	conn.loss.sent()
+	conn.meter.add(UserMessageBytesSent, d.contentSize())
+	// Finally we add the datagram to the recovery queue.
+	_ = conn.recoveryQueue.put(sequenceNumber, d)
+	return nil
+}
+
+// sendWindowQueue sends the datagrams held in the window queue for as long as the send window of the
+// connection has room for them.
+// sendWindowQueue must be called while holding the write lock.
+func (conn *Conn) sendWindowQueue() error {
+	for len(conn.windowQueue) > 0 && (conn.sendWindow == 0 || conn.recoveryQueue.Len() < conn.sendWindow) {
+		d := conn.windowQueue[0]
+		conn.windowQueue[0] = nil
+		conn.windowQueue = conn.windowQueue[1:]
+		if err := conn.send(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDatagram writes a datagram with the sequence number passed to the other end of the connection.
+// writeDatagram must be called while holding the write lock.
+func (conn *Conn) writeDatagram(sequenceNumber uint24, d *datagram) error {
+	// We reset the buffer so that we can re-use it for each datagram written.
+	defer conn.writeBuffer.Reset()
+
+	header := datagramHeader{flags: bitFlagValid, sequenceNumber: sequenceNumber}
+	if err := header.write(conn.writeBuffer); err != nil {
+		return err
+	}
+	for _, packet := range d.packets {
 		if err := packet.write(conn.writeBuffer); err != nil {
-			return 0, fmt.Errorf("error writing packet to buffer: %v", err)
+			return fmt.Errorf("error writing packet to buffer: %v", err)
 		}
-		// We then send the packet to the connection.
-		v := conn.packetLossChance.Load().(float64)
-		if v == 0 || conn.writeRand.Float64() > v {
-			if _, err := conn.conn.WriteTo(conn.writeBuffer.Bytes(), conn.addr); err != nil {
-				return 0, fmt.Errorf("error sending packet to addr %v: %v", conn.addr, err)
+	}
+	// We then send the datagram to the connection.
+	conn.count(datagramsSent)
+	conn.meter.add(ActualBytesSent, conn.writeBuffer.Len())
+	v := conn.packetLossChance.Load().(float64)
+	if v == 0 || conn.writeRand.Float64() > v {
+		conn.capture(Outbound, conn.writeBuffer.Bytes())
+		if conn.batchWindow > 0 {
+			if err := conn.queueBatch(conn.writeBuffer.Bytes()); err != nil {
+				return fmt.Errorf("error sending packets to addr %v: %v", conn.addr, err)
 			}
+			return nil
+		}
+		if _, err := conn.conn.WriteTo(conn.writeBuffer.Bytes(), conn.addr); err != nil {
+			return fmt.Errorf("error sending packet to addr %v: %v", conn.addr, err)
 		}
-		// We reset the buffer so that we can re-use it for each fragment created when splitting the packet.
-		conn.writeBuffer.Reset()
-
-		// Finally we add the packet to the recovery queue.
-		_ = conn.recoveryQueue.put(sequenceNumber, packet)
-		n += len(content)
 	}
-	return
+	return nil
 }
 
 // Read reads from the connection into the byte slice passed. If successful, the amount of bytes read n is
 // returned, and the error returned will be nil.
 // Read blocks until a packet is received over the connection, or until the session is closed or the read
 // times out, in which case an error is returned.
+// If b is at least as big as the MTU size of the connection, packets are copied directly into b as they are
+// received, without being buffered in between. Packets that do not fit in b are buffered as usual.
 func (conn *Conn) Read(b []byte) (n int, err error) {
-	select {
-	case packet := <-conn.packetChan:
-		if len(b) < packet.Len() {
-			err = fmt.Errorf("raknet.Conn read: read raknet: A message sent on a RakNet socket was larger than the buffer used to receive the message into")
+	res, err := conn.read(b)
+	return res.n, err
+}
+
+// readResult is the result of reading a packet: The amount of bytes read and the encapsulation that the
+// packet was received with.
+type readResult struct {
+	n   int
+	enc Encapsulation
+}
+
+// read reads a packet from the connection into b like Read, but also returns the encapsulation that the
+// packet was received with.
+func (conn *Conn) read(b []byte) (readResult, error) {
+	var direct chan []byte
+	if len(b) >= int(conn.mtuSize) {
+		direct = conn.directReads
+	}
+	for {
+		select {
+		case direct <- b:
+			if res := <-conn.directN; res.n >= 0 {
+				return res, nil
+			}
+			// The packet received did not fit in b. It will be sent over the packet channel instead, so we stop
+			// offering the buffer directly.
+			direct = nil
+		case packet := <-conn.packetChan:
+			var err error
+			if len(b) < packet.Len() {
+				err = opError("read", conn.LocalAddr(), conn.addr, errMessageTooLarge)
+			}
+			n := copy(b, packet.Bytes())
+			conn.meter.add(UserMessageBytesReceivedProcessed, packet.Len())
+			conn.addMemory(-packet.Len())
+			// The packet was copied into b, so its content may be re-used.
+			putBuffer(packet.Bytes())
+			return readResult{n: n, enc: packet.enc}, err
+		case <-conn.closeCtx.Done():
+			return readResult{}, opError("read", conn.LocalAddr(), conn.addr, conn.closeErr())
+		case <-conn.readDeadline:
+			return readResult{}, opError("read", conn.LocalAddr(), conn.addr, ErrTimeout)
 		}
-		return copy(b, packet.Bytes()), err
-	case <-conn.closeCtx.Done():
-		return 0, errors.New(errConnectionClosed)
-	case <-conn.readDeadline:
-		return 0, errors.New(errReadTimeout)
 	}
 }
 
 // Close closes the connection. All blocking Read or Write actions are cancelled and will return an error.
+// Packets written that were not yet sent are flushed before the connection is closed. If the connection
+// sequence was completed, a disconnect notification is sent to the other end, so that it does not have to
+// wait for the connection to time out.
 func (conn *Conn) Close() error {
+	return conn.CloseWithReason(nil)
+}
+
+// CloseWithReason closes the connection like Close, but appends the reason passed to the disconnect
+// notification sent to the other end. The official RakNet library ignores the reason, but some
+// implementations surface it to the application, like this package does with a *DisconnectError.
+func (conn *Conn) CloseWithReason(reason []byte) error {
+	_, span := conn.tracer.Start(conn.traceCtx, "raknet.Close", Attribute{Key: "raknet.remote_addr", Value: conn.addr.String()})
+	defer span.End()
+
+	if conn.completingSequence.Err() != nil && conn.disconnect.Load() == nil && atomic.CompareAndSwapInt32(&conn.disconnectSent, 0, 1) {
+		_, _ = conn.WriteReliability(append([]byte{idDisconnectNotification}, reason...), ReliableOrdered)
+	}
+	conn.writeLock.Lock()
+	if conn.flushTimer != nil && conn.flushTimer.Stop() {
+		// The flush will no longer happen, so it is no longer tracked either.
+		conn.goroutines.Done()
+		trackedTimers.release(conn.tracked, 1)
+	}
+	_ = conn.flush()
+	if conn.batchTimer != nil && conn.batchTimer.Stop() {
+		conn.goroutines.Done()
+		trackedTimers.release(conn.tracked, 1)
+	}
+	_ = conn.writeBatch()
+	conn.writeLock.Unlock()
+
 	conn.close()
 	return nil
 }
 
+// closeErr returns the error returned when using the connection after it was closed: A *DisconnectError if
+// the other end closed it, or ErrClosed otherwise.
+func (conn *Conn) closeErr() error {
+	if err, ok := conn.disconnect.Load().(*DisconnectError); ok {
+		return err
+	}
+	return ErrClosed
+}
+
 // RemoteAddr returns the remote address of the connection, meaning the address this connection leads to.
 func (conn *Conn) RemoteAddr() net.Addr {
 	return conn.addr
@@ -310,7 +903,7 @@ func (conn *Conn) LocalAddr() net.Addr {
 }
 
 // SetReadDeadline sets the read deadline of the connection. An error is returned only if the time passed is
-// before time.Now().
+// before the current time of the Clock of the connection.
 // Calling SetReadDeadline means the next Read call that exceeds the deadline will fail and return an error.
 // Setting the read deadline to the default value of time.Time removes the deadline.
 func (conn *Conn) SetReadDeadline(t time.Time) error {
@@ -318,10 +911,11 @@ func (conn *Conn) SetReadDeadline(t time.Time) error {
 		conn.readDeadline = make(chan time.Time)
 		return nil
 	}
-	if t.Before(time.Now()) {
+	now := conn.clock.Now()
+	if t.Before(now) {
 		return fmt.Errorf("read deadline cannot be before now")
 	}
-	conn.readDeadline = time.After(t.Sub(time.Now()))
+	conn.readDeadline = conn.clock.After(t.Sub(now))
 	return nil
 }
 
@@ -343,9 +937,22 @@ func (conn *Conn) Latency() int {
 	return conn.latency.Load().(int)
 }
 
+// MTUSize returns the MTU size negotiated during the connection sequence, which includes the size of the
+// UDP/IP headers. No datagram sent by the connection is bigger than it, unless path MTU discovery found a
+// larger path MTU.
+func (conn *Conn) MTUSize() int {
+	return int(conn.mtuSize)
+}
+
+// Protocol returns the RakNet protocol version negotiated for the connection during the connection
+// sequence.
+func (conn *Conn) Protocol() byte {
+	return conn.protocol
+}
+
 // Ping pings the connection, updating the latency of the Conn if successful.
 func (conn *Conn) Ping() {
-	packet := &connectedPing{PingTimestamp: timestamp()}
+	packet := &connectedPing{PingTimestamp: conn.timestamp()}
 	b := bytes.NewBuffer([]byte{idConnectedPing})
 	_ = binary.Write(b, binary.BigEndian, packet)
 	if _, err := conn.Write(b.Bytes()); err != nil {
@@ -353,6 +960,38 @@ func (conn *Conn) Ping() {
 	}
 }
 
+// SetValue attaches a value to the connection under the key passed, such as the name of a player or the
+// state of its authentication, so that applications and middleware do not need to keep maps keyed by the
+// address of the connection. Like with context.WithValue, keys should be of an unexported type to avoid
+// collisions. Passing a nil value removes the value stored under the key.
+// SetValue may be called from multiple goroutines simultaneously.
+func (conn *Conn) SetValue(key, value interface{}) {
+	if value == nil {
+		conn.values.Delete(key)
+		return
+	}
+	conn.values.Store(key, value)
+}
+
+// Value returns the value attached to the connection under the key passed using SetValue, or nil if no
+// value was attached under that key.
+func (conn *Conn) Value(key interface{}) interface{} {
+	value, _ := conn.values.Load(key)
+	return value
+}
+
+// MemoryUsage returns the amount of bytes currently held in memory by the connection for packets that are
+// awaiting acknowledgement, split packets that are being reassembled, packets awaiting ordering and packets
+// in the read queue.
+func (conn *Conn) MemoryUsage() int64 {
+	return atomic.LoadInt64(&conn.memUsage)
+}
+
+// addMemory adds n bytes to the memory usage of the connection. n may be negative to release memory.
+func (conn *Conn) addMemory(n int) {
+	atomic.AddInt64(&conn.memUsage, int64(n))
+}
+
 // SimulatePacketLoss makes the connection simulate packet loss, with a loss chance passed. It will start
 // to discard packets randomly depending on the loss chance, both for sending and for receiving packets.
 // The function panics if a loss change is higher than 1 or lower than 0.
@@ -361,8 +1000,12 @@ func (conn *Conn) SimulatePacketLoss(lossChance float64) {
 		panic(fmt.Sprintf("packet loss must be between 0-1, but got %v", lossChance))
 	}
 	conn.packetLossChance.Store(lossChance)
-	conn.readRand = rand.New(rand.NewSource(time.Now().Unix()))
-	conn.writeRand = rand.New(rand.NewSource(time.Now().Unix()))
+	seed, err := randInt63(conn.rand)
+	if err != nil {
+		seed = conn.clock.Now().Unix()
+	}
+	conn.readRand = rand.New(rand.NewSource(seed))
+	conn.writeRand = rand.New(rand.NewSource(seed))
 }
 
 // packetPool is a sync.Pool used to pool packets that encapsulate their content.
@@ -372,6 +1015,35 @@ var packetPool = sync.Pool{
 	},
 }
 
+// datagramPool is a sync.Pool used to pool datagrams that are sent. A datagram is returned to the pool once
+// it is acknowledged.
+var datagramPool = sync.Pool{
+	New: func() interface{} {
+		return &datagram{}
+	},
+}
+
+// acknowledgementPool is a sync.Pool used to pool acknowledgements that are sent and received, so that the
+// slice holding their sequence numbers may be re-used.
+var acknowledgementPool = sync.Pool{
+	New: func() interface{} {
+		return &acknowledgement{}
+	},
+}
+
+// releaseDatagram returns a datagram and all packets it holds to their pools. The datagram must not be used
+// after calling releaseDatagram.
+func releaseDatagram(d *datagram) {
+	for i, p := range d.packets {
+		// Clear the packet and return it to the pool so that it may be re-used.
+		p.content = nil
+		packetPool.Put(p)
+		d.packets[i] = nil
+	}
+	d.packets = d.packets[:0]
+	datagramPool.Put(d)
+}
+
 const (
 	// Datagram header +
 	// Datagram sequence number +
@@ -390,7 +1062,7 @@ const (
 // split splits a content buffer in smaller buffers so that they do not exceed the MTU size that the
 // connection holds.
 func (conn *Conn) split(b []byte) [][]byte {
-	maxSize := int(conn.mtuSize-packetAdditionalSize) - 28
+	maxSize := int(conn.pathMTU-packetAdditionalSize) - conn.framing.mtuHeaderSize() - conn.securityOverhead()
 	contentLength := len(b)
 	if contentLength > maxSize {
 		// If the content size is bigger than the maximum size here, it means the packet will get split. This
@@ -415,41 +1087,70 @@ func (conn *Conn) split(b []byte) [][]byte {
 
 // receive receives a packet from the connection, handling it as appropriate. If not successful, an error is
 // returned.
-func (conn *Conn) receive(b *bytes.Buffer) error {
+func (conn *Conn) receive(b *bytes.Buffer) (err error) {
+	if conn.recoverPanics {
+		defer conn.recoverPanic(&err)
+	}
 	v := conn.packetLossChance.Load().(float64)
 	if v != 0 && conn.readRand.Float64() < v {
 		// Random discard.
 		return nil
 	}
-	headerFlags, err := b.ReadByte()
-	if err != nil {
-		return fmt.Errorf("error reading datagram header flags: %v", err)
+	conn.meter.add(ActualBytesReceived, b.Len())
+	if err := conn.decrypt(b); errors.Is(err, errReplayed) {
+		// Replayed datagrams are dropped like duplicates, without returning an error, so that replaying
+		// them does not cost more than it would otherwise.
+		conn.count(duplicateDatagrams)
+		return nil
+	} else if err != nil {
+		return err
 	}
-	if headerFlags&bitFlagValid == 0 {
+	conn.capture(Inbound, b.Bytes())
+	var header datagramHeader
+	if err := header.read(b); err != nil {
+		return err
+	}
+	if header.flags&bitFlagValid == 0 {
 		// Close the connection if a non-datagram packet was received. This is probably an offline message.
 		return nil
 	}
+	if header.flags&(bitFlagACK|bitFlagNACK) != 0 && conn.ackLimit.Rate > 0 && !conn.ackBucket.take(conn.ackLimit, conn.clock.Now()) {
+		conn.count(ackRateLimitDrops)
+		return nil
+	}
 	switch {
-	case headerFlags&bitFlagACK != 0:
+	case header.flags&bitFlagACK != 0:
+		conn.count(acksReceived)
 		return conn.handleACK(b)
-	case headerFlags&bitFlagNACK != 0:
+	case header.flags&bitFlagNACK != 0:
+		conn.count(nacksReceived)
 		return conn.handleNACK(b)
 	default:
-		return conn.receiveDatagram(b)
+		return conn.receiveDatagram(b, header.sequenceNumber)
 	}
 }
 
-// receiveDatagram handles the receiving of a datagram found in buffer b. If successful, all packets inside
-// of the datagram are handled. if not, an error is returned.
-func (conn *Conn) receiveDatagram(b *bytes.Buffer) error {
-	sequenceNumber, err := readUint24(b)
-	if err != nil {
-		return fmt.Errorf("error reading datagram sequence number: %v", err)
+// receiveDatagram handles the receiving of a datagram with the sequence number passed, of which the packets
+// are found in buffer b. If successful, all packets inside of the datagram are handled. if not, an error is
+// returned. Datagrams received before or outside of the receive window are counted and dropped without an
+// error, as they are common on lossy connections and may be sent by anyone able to spoof the address.
+func (conn *Conn) receiveDatagram(b *bytes.Buffer, sequenceNumber uint24) error {
+	if !conn.datagramRecvQueue.inWindow(sequenceNumber, receiveWindow) {
+		// The datagram was either received and taken out before, or is so far ahead that accepting it would
+		// make us track, and NACK, every sequence number in between.
+		conn.count(duplicateDatagrams)
+		conn.meter.add(UserMessageBytesReceivedIgnored, b.Len())
+		return nil
 	}
 	if err := conn.datagramRecvQueue.put(sequenceNumber, true); err != nil {
-		return fmt.Errorf("error handing datagram: datagram already received")
+		// The datagram was already received.
+		conn.count(duplicateDatagrams)
+		conn.meter.add(UserMessageBytesReceivedIgnored, b.Len())
+		return nil
 	}
 	conn.datagramsReceived.Store(append(conn.datagramsReceived.Load().([]uint24), sequenceNumber))
+	conn.count(datagramsReceived)
+	conn.loss.receivedDatagram(sequenceNumber)
 	if len(conn.datagramRecvQueue.takeOut()) == 0 {
 		// We couldn't take any datagram out of the receive queue, meaning we are missing a datagram. We
 		// increment the counter, and if it exceeds the threshold we send a NACK to request again.
@@ -471,12 +1172,13 @@ func (conn *Conn) receiveDatagram(b *bytes.Buffer) error {
 			return fmt.Errorf("error decoding datagram packet: %v", err)
 		}
 		if conn.readPacket.split {
-			if err := conn.handleSplitPacket(conn.readPacket); err != nil {
-				return fmt.Errorf("error receiving split packet: %v", err)
+			trackedBuffers.create(conn.tracked, 1)
+			if err := conn.handleSplitPacket(&conn.readPacket); err != nil {
+				return fmt.Errorf("error receiving split packet: %w", err)
 			}
 			continue
 		}
-		if err := conn.receivePacket(conn.readPacket); err != nil {
+		if err := conn.receivePacket(&conn.readPacket); err != nil {
 			return fmt.Errorf("error receiving packet: %v", err)
 		}
 	}
@@ -486,29 +1188,42 @@ func (conn *Conn) receiveDatagram(b *bytes.Buffer) error {
 // receivePacket handles the receiving of a packet. It puts the packet in the queue and takes out all packets
 // that were obtainable after that, and handles them.
 func (conn *Conn) receivePacket(packet *packet) error {
+	if packet.sequenced() {
+		if conn.receivedSequenced && !uint24After(packet.sequenceIndex, conn.receiveSequenceIndex) {
+			// A newer sequenced packet was already handled, so this one is outdated.
+			return nil
+		}
+		conn.receiveSequenceIndex, conn.receivedSequenced = packet.sequenceIndex, true
+	}
+	enc := packet.encapsulation()
 	if packet.reliability != reliabilityReliableOrdered {
 		// If it isn't a reliable ordered packet, handle it immediately.
-		return conn.handlePacket(packet.content)
+		return conn.handlePacket(packet.content, enc)
 	}
-	if err := conn.packetQueue.put(packet.orderIndex, packet.content); err != nil {
+	if err := conn.packetQueue.put(packet.orderIndex, orderedPacket{content: packet.content, enc: enc}); err != nil {
 		if packet.orderIndex == 0 {
-			return conn.handlePacket(packet.content)
+			return conn.handlePacket(packet.content, enc)
 		}
 		// Don't return these errors. We'll have a packet that was sent either multiple times, arrived
 		// multiple times or something else. These aren't critical errors.
 		return nil
 	}
-	for _, packetContent := range conn.packetQueue.takeOut() {
-		if err := conn.handlePacket(packetContent.([]byte)); err != nil {
+	conn.addMemory(len(packet.content))
+	for _, v := range conn.packetQueue.takeOut() {
+		ordered := v.(orderedPacket)
+		conn.addMemory(-len(ordered.content))
+		if err := conn.handlePacket(ordered.content, ordered.enc); err != nil {
 			return fmt.Errorf("error handling packet: %v", err)
 		}
 	}
 	return nil
 }
 
-// handlePacket handles a packet serialised in byte slice b. If not successful, an error is returned. If the
-// packet was not handled by RakNet, it is sent to the packet channel.
-func (conn *Conn) handlePacket(b []byte) error {
+// handlePacket handles a packet serialised in byte slice b, which was received with the encapsulation passed.
+// If not successful, an error is returned. If the packet was not handled by RakNet, it is sent to the packet
+// channel.
+func (conn *Conn) handlePacket(b []byte, enc Encapsulation) error {
+	conn.chaos.corruptPacket(b)
 	buffer := bytes.NewBuffer(b)
 	header, err := buffer.ReadByte()
 	if err != nil {
@@ -516,7 +1231,7 @@ func (conn *Conn) handlePacket(b []byte) error {
 	}
 
 	// Update the last time we received a packet so that the connection doesn't time out.
-	conn.lastPacketTime.Store(time.Now())
+	conn.lastPacketTime.Store(conn.clock.Now())
 
 	switch header {
 	case idConnectionRequest:
@@ -526,27 +1241,25 @@ func (conn *Conn) handlePacket(b []byte) error {
 	case idNewIncomingConnection:
 		conn.finishSequence()
 	case idConnectedPing:
+		conn.count(connectedPings)
 		return conn.handleConnectedPing(buffer)
 	case idConnectedPong:
 		return conn.handleConnectedPong(buffer)
 	case idDisconnectNotification:
+		// The other end closed the connection, so there is no need to notify it in return. If the connection
+		// is secured, the datagram holding the notification was authenticated when it was decrypted, so it
+		// cannot have been sent from a spoofed address.
+		conn.disconnect.Store(&DisconnectError{Reason: append([]byte(nil), buffer.Bytes()...)})
 		return conn.Close()
-	case 04:
-		// This packet doesn't matter to us: We just ignore it but do put it in a switch case so that it isn't
-		// forwarded like a normal packet.
+	case idDetectLostConnections:
+		// The packet was sent reliably, so it is acknowledged like any other packet, which is all that the
+		// other end needs. It is not forwarded like a normal packet. Path MTU probes are padded packets of
+		// this ID, so that they are ignored in the same way.
 		return nil
 	default:
-		if err := buffer.UnreadByte(); err != nil {
-			return fmt.Errorf("error unreading custom packet ID: %v", err)
-		}
-		// Insert the packet contents the packet queue could release in the channel so that Conn.Read() can
-		// get a hold of them.
-		select {
-		case conn.packetChan <- buffer:
-		case <-conn.closeCtx.Done():
-			return nil
-		}
-
+		// Pass the packet contents the packet queue could release to Conn.Read(), either through the read
+		// queue or by copying them directly into the buffer of a Conn.Read() call if one is offered.
+		conn.deliver(b, enc)
 	}
 	return nil
 }
@@ -562,7 +1275,7 @@ func (conn *Conn) handleConnectedPing(b *bytes.Buffer) error {
 
 	// Respond with a connected pong that has the ping timestamp found in the connected ping, and our own
 	// timestamp for the pong timestamp.
-	response := &connectedPong{PingTimestamp: packet.PingTimestamp, PongTimestamp: timestamp()}
+	response := &connectedPong{PingTimestamp: packet.PingTimestamp, PongTimestamp: conn.timestamp()}
 	if err := b.WriteByte(idConnectedPong); err != nil {
 		return fmt.Errorf("error writing connected pong ID: %v", err)
 	}
@@ -575,6 +1288,11 @@ func (conn *Conn) handleConnectedPing(b *bytes.Buffer) error {
 	return nil
 }
 
+// timestamp returns a timestamp in milliseconds, according to the Clock of the connection.
+func (conn *Conn) timestamp() int64 {
+	return conn.clock.Now().UnixNano() / int64(time.Millisecond)
+}
+
 // handleConnectedPong handles a connected pong packet inside of buffer b. An error is returned if the packet
 // was invalid.
 func (conn *Conn) handleConnectedPong(b *bytes.Buffer) error {
@@ -582,13 +1300,20 @@ func (conn *Conn) handleConnectedPong(b *bytes.Buffer) error {
 	if err := binary.Read(b, binary.BigEndian, packet); err != nil {
 		return fmt.Errorf("error reading connected pong: %v", err)
 	}
-	now := timestamp()
+	now := conn.timestamp()
 	if packet.PingTimestamp > now {
 		return fmt.Errorf("error measuring latency: ping timestamp is in the future")
 	}
 	// We measure the latency for a single packet from one end to another, not the round-trip time, so we
 	// divide the total time by 2.
 	conn.latency.Store(int(now-packet.PingTimestamp) / 2)
+	conn.clockSamples.add(ClockSample{PingTimestamp: packet.PingTimestamp, PongTimestamp: packet.PongTimestamp, ReceiveTimestamp: now})
+
+	rtt := time.Duration(now-packet.PingTimestamp) * time.Millisecond
+	conn.counters.rtt.record(rtt)
+	if conn.listenerCounters != nil {
+		conn.listenerCounters.rtt.record(rtt)
+	}
 
 	return nil
 }
@@ -614,9 +1339,9 @@ func (conn *Conn) handleConnectionRequest(b *bytes.Buffer) error {
 		return fmt.Errorf("error writing connection request accepted client address: %v", err)
 	}
 	_ = binary.Write(b, binary.BigEndian, int16(0))
-	for i := 0; i < 20; i++ {
-		// The middle of the connection request accepted packet has 20 system addresses. We write these
-		// separately.
+	for i := 0; i < conn.framing.systemAddresses; i++ {
+		// The middle of the connection request accepted packet has 10 or 20 system addresses, depending on
+		// the protocol version. We write these separately.
 		var addr *rakAddr
 		encodedAddr, err := addr.MarshalBinary()
 		if err != nil {
@@ -626,7 +1351,7 @@ func (conn *Conn) handleConnectionRequest(b *bytes.Buffer) error {
 			return fmt.Errorf("error writing connection request accepted system address: %v", err)
 		}
 	}
-	response := &connectionRequestAccepted{RequestTimestamp: packet.RequestTimestamp, AcceptedTimestamp: timestamp()}
+	response := &connectionRequestAccepted{RequestTimestamp: packet.RequestTimestamp, AcceptedTimestamp: conn.timestamp()}
 	if err := binary.Write(b, binary.BigEndian, response); err != nil {
 		return fmt.Errorf("error writing connection request accepted: %v", err)
 	}
@@ -653,9 +1378,9 @@ func (conn *Conn) handleConnectionRequestAccepted(b *bytes.Buffer) error {
 	if _, err := b.Write(data); err != nil {
 		return fmt.Errorf("error writing new incoming connection server address: %v", err)
 	}
-	for i := 0; i < 20; i++ {
-		// The middle of the connection request accepted packet has 20 system addresses. We write these
-		// separately.
+	for i := 0; i < conn.framing.systemAddresses; i++ {
+		// The middle of the connection request accepted packet has 10 or 20 system addresses, depending on
+		// the protocol version. We write these separately.
 		var addr *rakAddr
 		encodedAddr, err := addr.MarshalBinary()
 		if err != nil {
@@ -666,7 +1391,7 @@ func (conn *Conn) handleConnectionRequestAccepted(b *bytes.Buffer) error {
 		}
 	}
 	// We fill out nonsense timestamps as RakNet doesn't REALLY care about these.
-	response := &newIncomingConnection{RequestTimestamp: timestamp(), AcceptedTimestamp: timestamp()}
+	response := &newIncomingConnection{RequestTimestamp: conn.timestamp(), AcceptedTimestamp: conn.timestamp()}
 	if err := binary.Write(b, binary.BigEndian, response); err != nil {
 		return fmt.Errorf("error writing new incoming connection: %v", err)
 	}
@@ -684,15 +1409,39 @@ func (conn *Conn) handleConnectionRequestAccepted(b *bytes.Buffer) error {
 func (conn *Conn) handleSplitPacket(p *packet) error {
 	m, ok := conn.splits[p.splitID]
 	if !ok {
+		if p.splitCount > uint32(conn.maxSplitCount) {
+			putBuffer(p.content)
+			trackedBuffers.release(conn.tracked, 1)
+			_ = conn.Close()
+			return fmt.Errorf("%w: split count %v (max %v)", errSplitLimit, p.splitCount, conn.maxSplitCount)
+		}
 		m = make([][]byte, p.splitCount)
 		conn.splits[p.splitID] = m
+		// The slots for the fragments are accounted for too, as a group that never completes holds on to them
+		// no matter how little content was sent for it.
+		if err := conn.addSplitMemory(len(m) * splitSlotSize); err != nil {
+			putBuffer(p.content)
+			trackedBuffers.release(conn.tracked, 1)
+			return err
+		}
 	}
 	if p.splitIndex > uint32(len(m)-1) {
 		// The split index was either negative or was bigger than the slice size, meaning the packet is
 		// invalid.
+		putBuffer(p.content)
+		trackedBuffers.release(conn.tracked, 1)
 		return fmt.Errorf("error handing split packet: split ID %v is out of range (0 - %v)", p.splitID, len(m)-1)
 	}
+	// The fragment might have arrived before, in which case we release the memory of the old one.
+	memErr := conn.addSplitMemory(len(p.content) - len(m[p.splitIndex]))
+	if m[p.splitIndex] != nil {
+		putBuffer(m[p.splitIndex])
+		trackedBuffers.release(conn.tracked, 1)
+	}
 	m[p.splitIndex] = p.content
+	if memErr != nil {
+		return memErr
+	}
 
 	for _, splitPacket := range m {
 		if len(splitPacket) == 0 {
@@ -706,7 +1455,8 @@ func (conn *Conn) handleSplitPacket(p *packet) error {
 		// First we calculate the total size required to hold the content of the combined content.
 		totalSize += len(splitPacket)
 	}
-	fullContent := make([]byte, totalSize)
+	// The full content is taken from the buffer pool. It is returned to it once it was read by Conn.Read().
+	fullContent := getBuffer(totalSize)
 	currentOffset := 0
 	for _, splitPacket := range m {
 		// We finally copy the packet into our new full content slice and make sure it is copied at the
@@ -716,8 +1466,12 @@ func (conn *Conn) handleSplitPacket(p *packet) error {
 			panic(fmt.Sprintf("invalid length full split packet content byte slice produced: should have copied %v, but only copied %v", contentLength, n))
 		}
 		currentOffset += contentLength
+		putBuffer(splitPacket)
 	}
+	trackedBuffers.release(conn.tracked, len(m))
 	delete(conn.splits, p.splitID)
+	conn.splitMemory -= totalSize + len(m)*splitSlotSize
+	conn.addMemory(-totalSize - len(m)*splitSlotSize)
 
 	p.content = fullContent
 	return conn.receivePacket(p)
@@ -726,28 +1480,50 @@ func (conn *Conn) handleSplitPacket(p *packet) error {
 // sendACK sends an acknowledgement packet containing the packet sequence numbers passed. If not successful,
 // an error is returned.
 func (conn *Conn) sendACK(packets ...uint24) error {
-	ack := &acknowledgement{packets: packets}
-	buffer := bytes.NewBuffer([]byte{bitFlagACK | bitFlagValid})
+	ack := acknowledgementPool.Get().(*acknowledgement)
+	ack.packets = packets
+	defer func() {
+		ack.packets = nil
+		acknowledgementPool.Put(ack)
+	}()
+	buffer := bytes.NewBuffer(make([]byte, 0, conn.mtuSize))
+	if err := (datagramHeader{flags: bitFlagACK | bitFlagValid}).write(buffer); err != nil {
+		return fmt.Errorf("error encoding ACK header: %v", err)
+	}
 	if err := ack.write(buffer); err != nil {
 		return fmt.Errorf("error encoding ACK packet: %v", err)
 	}
+	conn.capture(Outbound, buffer.Bytes())
 	if _, err := conn.conn.WriteTo(buffer.Bytes(), conn.addr); err != nil {
 		return fmt.Errorf("error sending ACK packet: %v", err)
 	}
+	conn.meter.add(ActualBytesSent, buffer.Len())
+	conn.emitAck(Outbound, false, packets)
 	return nil
 }
 
 // sendNACK sends an acknowledgement packet containing the packet sequence numbers passed. If not successful,
 // an error is returned.
 func (conn *Conn) sendNACK(packets ...uint24) error {
-	ack := &acknowledgement{packets: packets}
-	buffer := bytes.NewBuffer([]byte{bitFlagNACK | bitFlagValid})
+	ack := acknowledgementPool.Get().(*acknowledgement)
+	ack.packets = packets
+	defer func() {
+		ack.packets = nil
+		acknowledgementPool.Put(ack)
+	}()
+	buffer := bytes.NewBuffer(make([]byte, 0, conn.mtuSize))
+	if err := (datagramHeader{flags: bitFlagNACK | bitFlagValid}).write(buffer); err != nil {
+		return fmt.Errorf("error encoding NACK header: %v", err)
+	}
 	if err := ack.write(buffer); err != nil {
 		return fmt.Errorf("error encoding NACK packet: %v", err)
 	}
+	conn.capture(Outbound, buffer.Bytes())
 	if _, err := conn.conn.WriteTo(buffer.Bytes(), conn.addr); err != nil {
 		return fmt.Errorf("error sending NACK packet: %v", err)
 	}
+	conn.meter.add(ActualBytesSent, buffer.Len())
+	conn.emitAck(Outbound, true, packets)
 	return nil
 }
 
@@ -757,20 +1533,33 @@ func (conn *Conn) handleACK(b *bytes.Buffer) error {
 	conn.writeLock.Lock()
 	defer conn.writeLock.Unlock()
 
-	ack := &acknowledgement{}
+	ack := acknowledgementPool.Get().(*acknowledgement)
+	defer func() {
+		ack.packets = ack.packets[:0]
+		acknowledgementPool.Put(ack)
+	}()
 	if err := ack.read(b); err != nil {
 		return fmt.Errorf("error reading ACK: %v", err)
 	}
+	conn.emitAck(Inbound, false, ack.packets)
+	// An ACK shows that the other end is still there, even if it has nothing to send itself.
+	conn.lastPacketTime.Store(conn.clock.Now())
 	for _, sequenceNumber := range ack.packets {
-		// Take out all stored packets from the recovery queue.
-		p, ok := conn.recoveryQueue.take(sequenceNumber)
+		if conn.pmtuProbeAcknowledged(sequenceNumber) {
+			continue
+		}
+		// Take out all stored datagrams from the recovery queue.
+		val, ok := conn.recoveryQueue.take(sequenceNumber)
 		if ok {
-			// Clear the packet and return it to the pool so that it may be re-used.
-			p.(*packet).content = nil
-			packetPool.Put(p)
+			d := val.(*datagram)
+			conn.addMemory(-d.contentSize())
+			releaseDatagram(d)
+		} else if conn.resends.remove(sequenceNumber) {
+			// The datagram was resent under a new sequence number, but the original arrived after all.
+			conn.count(spuriousResends)
 		}
 	}
-	return nil
+	return conn.sendWindowQueue()
 }
 
 // handleNACK handles a negative acknowledgment packet from the other end of the connection. These mean that a
@@ -779,11 +1568,58 @@ func (conn *Conn) handleNACK(b *bytes.Buffer) error {
 	conn.writeLock.Lock()
 	defer conn.writeLock.Unlock()
 
-	nack := &acknowledgement{}
+	nack := acknowledgementPool.Get().(*acknowledgement)
+	defer func() {
+		nack.packets = nack.packets[:0]
+		acknowledgementPool.Put(nack)
+	}()
 	if err := nack.read(b); err != nil {
 		return fmt.Errorf("error reading NACK: %v", err)
 	}
-	return conn.resend(nack.packets)
+	conn.emitAck(Inbound, true, nack.packets)
+	packets := nack.packets[:0]
+	for _, sequenceNumber := range nack.packets {
+		// Path MTU probes are never resent, so we filter them out.
+		if !conn.pmtuProbeLost(sequenceNumber) {
+			packets = append(packets, sequenceNumber)
+		}
+	}
+	conn.loss.nacked(len(packets))
+	return conn.resend(packets)
+}
+
+// resendOverdue resends datagrams in the recovery queue that have not been acknowledged for too long, even
+// though no NACK was issued for them yet. Rather than resending all of them at once, the resends are spread
+// out over the round-trip time of the connection, so that a large backlog does not cause a burst that leads
+// to even more packet loss.
+// resendOverdue must be called while holding the write lock.
+func (conn *Conn) resendOverdue() error {
+	var overdue []uint24
+	// Allow the average delay with a deviation of 200%.
+	delay := conn.recoveryQueue.AvgDelay() * 3
+	now := conn.clock.Now()
+	for seqNum := range conn.recoveryQueue.queue {
+		if now.Sub(conn.recoveryQueue.Timestamp(seqNum)) > delay {
+			overdue = append(overdue, seqNum)
+		}
+	}
+	if len(overdue) == 0 {
+		return nil
+	}
+	// The datagrams sent first are resent first. Sequence numbers wrap around once they exceed 24 bits, so
+	// rather than by their value, they are ordered by how far they lie behind the next sequence number.
+	next := conn.sendSequenceNumber
+	sort.Slice(overdue, func(i, j int) bool {
+		return (next-overdue[i])&0xffffff > (next-overdue[j])&0xffffff
+	})
+	ticks := int(time.Duration(conn.Latency()*2) * time.Millisecond / tickInterval)
+	if ticks < 1 {
+		ticks = 1
+	}
+	// We resend only as many datagrams this tick as required to resend the full backlog within a single
+	// round-trip time. The remaining datagrams are resent in the ticks that follow.
+	n := (len(overdue) + ticks - 1) / ticks
+	return conn.resend(overdue[:n])
 }
 
 // resend resends all datagrams in the recovery queue with the sequence numbers passed.
@@ -793,41 +1629,59 @@ func (conn *Conn) resend(sequenceNumbers []uint24) error {
 		if !ok {
 			return fmt.Errorf("error recovering NACK for sequence number %v", sequenceNumber)
 		}
-		packet := val.(*packet)
-
-		// We first write a new datagram header using a new send sequence number that we find.
-		if err := conn.writeBuffer.WriteByte(bitFlagValid); err != nil {
-			return fmt.Errorf("error writing recovered datagram header: %v", err)
+		d := val.(*datagram)
+		if !conn.dropUnreliable(d) {
+			// The datagram held only unreliable packets, which are never resent.
+			continue
 		}
+		conn.count(datagramsResent)
+		conn.loss.sent()
+		conn.meter.add(UserMessageBytesResent, d.contentSize())
+		conn.resends.add(sequenceNumber)
+
+		// We write the datagram again using a new send sequence number.
 		newSeqNum := conn.sendSequenceNumber
 		conn.sendSequenceNumber++
-		if err := writeUint24(conn.writeBuffer, newSeqNum); err != nil {
-			return fmt.Errorf("error writing recovered datagram sequence number: %v", err)
+		if err := conn.writeDatagram(newSeqNum, d); err != nil {
+			return fmt.Errorf("error writing recovered datagram: %v", err)
 		}
-		if err := packet.write(conn.writeBuffer); err != nil {
-			return fmt.Errorf("error writing recovered packet to buffer: %v", err)
-		}
-
-		// We then send the packet to the connection.
-		v := conn.packetLossChance.Load().(float64)
-		if v == 0 || conn.writeRand.Float64() > v {
-			if _, err := conn.conn.WriteTo(conn.writeBuffer.Bytes(), conn.addr); err != nil {
-				return fmt.Errorf("error sending packet to addr %v: %v", conn.addr, err)
-			}
-		}
-		// We then re-add the packet to the recovery queue in case the new one gets lost too, in which case
+		// We then re-add the datagram to the recovery queue in case the new one gets lost too, in which case
 		// we need to resend it again.
-		_ = conn.recoveryQueue.put(newSeqNum, packet)
-		conn.writeBuffer.Reset()
+		_ = conn.recoveryQueue.put(newSeqNum, d)
 	}
 	return nil
 }
 
+// dropUnreliable removes the unreliable packets from a datagram that is about to be resent. If no packets
+// remain, the datagram is released and false is returned.
+// dropUnreliable must be called while holding the write lock.
+func (conn *Conn) dropUnreliable(d *datagram) bool {
+	packets := d.packets[:0]
+	for _, p := range d.packets {
+		if p.reliable() {
+			packets = append(packets, p)
+			continue
+		}
+		conn.addMemory(-len(p.content))
+		p.content = nil
+		packetPool.Put(p)
+	}
+	for i := len(packets); i < len(d.packets); i++ {
+		d.packets[i] = nil
+	}
+	d.packets = packets
+	if len(packets) == 0 {
+		releaseDatagram(d)
+		return false
+	}
+	return true
+}
+
 // requestConnection requests the connection from the server, provided this connection operates as a client.
 // An error occurs if the request was not successful.
 func (conn *Conn) requestConnection() error {
 	b := bytes.NewBuffer([]byte{idConnectionRequest})
-	packet := &connectionRequest{ClientGUID: conn.id, RequestTimestamp: timestamp()}
+	packet := &connectionRequest{ClientGUID: conn.id, RequestTimestamp: conn.timestamp()}
 	if err := binary.Write(b, binary.BigEndian, packet); err != nil {
 		return fmt.Errorf("error writing connection request: %v", err)
 	}
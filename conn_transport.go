@@ -0,0 +1,214 @@
+package raknet
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConnTransport returns a Transport that reads and writes datagrams using the net.Conn passed, which is
+// connected to a single remote address. It may be used to dial RakNet connections over connection-oriented
+// datagram protocols, such as a DTLS client connection, using Dialer.DialTransport. The net.Conn must preserve
+// the boundaries of datagrams: Every Write must be read as a single Read on the other end. Stream protocols
+// such as TCP can therefore not be used.
+// The address passed to WriteDatagram is ignored, and ReadDatagram always returns the remote address of the
+// net.Conn.
+func ConnTransport(conn net.Conn) Transport {
+	return connTransport{conn: conn}
+}
+
+// connTransport implements Transport using a net.Conn.
+type connTransport struct {
+	conn net.Conn
+}
+
+// ReadDatagram ...
+func (t connTransport) ReadDatagram(b []byte) (n int, addr net.Addr, err error) {
+	n, err = t.conn.Read(b)
+	return n, t.conn.RemoteAddr(), err
+}
+
+// WriteDatagram ...
+func (t connTransport) WriteDatagram(b []byte, _ net.Addr) error {
+	_, err := t.conn.Write(b)
+	return err
+}
+
+// SetReadDeadline ...
+func (t connTransport) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+// LocalAddr ...
+func (t connTransport) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+// Close ...
+func (t connTransport) Close() error {
+	return t.conn.Close()
+}
+
+// listenerTransportQueueSize is the amount of datagrams read from the connections of a ListenerTransport that
+// may be waiting to be read from the Transport.
+const listenerTransportQueueSize = 1024
+
+// ListenerTransport returns a Transport that accepts connections from the net.Listener passed and reads and
+// writes datagrams using each of them, so that a Listener may accept RakNet connections over
+// connection-oriented datagram protocols, such as DTLS, using ListenConfig.ListenTransport. Like with
+// ConnTransport, the connections accepted must preserve the boundaries of datagrams, and their remote
+// addresses must be *net.UDPAddrs.
+// Datagrams written to an address that no connection is open to are dropped.
+func ListenerTransport(l net.Listener) Transport {
+	t := &listenerTransport{
+		l:        l,
+		conns:    make(map[string]net.Conn),
+		incoming: make(chan transportDatagram, listenerTransportQueueSize),
+		closed:   make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+	go t.accept()
+	return t
+}
+
+// transportDatagram is a datagram read from one of the connections of a listenerTransport.
+type transportDatagram struct {
+	b    []byte
+	addr net.Addr
+}
+
+// listenerTransport implements Transport using the connections accepted from a net.Listener.
+type listenerTransport struct {
+	l        net.Listener
+	incoming chan transportDatagram
+
+	// mu guards the fields below.
+	mu    sync.Mutex
+	conns map[string]net.Conn
+	// deadline is the read deadline of the transport. changed is closed and replaced every time it is set,
+	// so that blocked reads pick up the new deadline.
+	deadline time.Time
+	changed  chan struct{}
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+// accept accepts connections from the net.Listener until it is closed.
+func (t *listenerTransport) accept() {
+	for {
+		conn, err := t.l.Accept()
+		if err != nil {
+			_ = t.Close()
+			return
+		}
+		t.mu.Lock()
+		t.conns[conn.RemoteAddr().String()] = conn
+		t.mu.Unlock()
+		go t.read(conn)
+	}
+}
+
+// read reads datagrams from the connection passed until it is closed.
+func (t *listenerTransport) read(conn net.Conn) {
+	defer func() {
+		t.mu.Lock()
+		if t.conns[conn.RemoteAddr().String()] == conn {
+			delete(t.conns, conn.RemoteAddr().String())
+		}
+		t.mu.Unlock()
+		_ = conn.Close()
+	}()
+	b := make([]byte, maxMTUSize)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return
+		}
+		select {
+		case t.incoming <- transportDatagram{b: append([]byte(nil), b[:n]...), addr: conn.RemoteAddr()}:
+		case <-t.closed:
+			return
+		default:
+			// The queue is full, so the datagram is dropped like it would be by a full socket buffer.
+		}
+	}
+}
+
+// ReadDatagram ...
+func (t *listenerTransport) ReadDatagram(b []byte) (n int, addr net.Addr, err error) {
+	for {
+		t.mu.Lock()
+		deadline, changed := t.deadline, t.changed
+		t.mu.Unlock()
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case d := <-t.incoming:
+			stopTimer(timer)
+			return copy(b, d.b), d.addr, nil
+		case <-t.closed:
+			stopTimer(timer)
+			return 0, nil, net.ErrClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-changed:
+			stopTimer(timer)
+		}
+	}
+}
+
+// stopTimer stops the timer passed if it is not nil.
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// WriteDatagram ...
+func (t *listenerTransport) WriteDatagram(b []byte, addr net.Addr) error {
+	t.mu.Lock()
+	conn, ok := t.conns[addr.String()]
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	_, err := conn.Write(b)
+	return err
+}
+
+// SetReadDeadline ...
+func (t *listenerTransport) SetReadDeadline(deadline time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadline = deadline
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return nil
+}
+
+// LocalAddr ...
+func (t *listenerTransport) LocalAddr() net.Addr {
+	return t.l.Addr()
+}
+
+// Close closes the net.Listener and all connections accepted from it.
+func (t *listenerTransport) Close() error {
+	var err error
+	t.once.Do(func() {
+		close(t.closed)
+		err = t.l.Close()
+		t.mu.Lock()
+		for _, conn := range t.conns {
+			_ = conn.Close()
+		}
+		t.mu.Unlock()
+	})
+	return err
+}
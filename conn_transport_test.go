@@ -0,0 +1,73 @@
+package raknet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// addrConn is a net.Conn with fixed local and remote addresses.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// pipeListener is a net.Listener accepting the connections sent to it.
+type pipeListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return l.addr }
+
+func TestConnTransport(t *testing.T) {
+	serverAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132}
+	clientAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 50000}
+	pl := &pipeListener{addr: serverAddr, conns: make(chan net.Conn, 1), done: make(chan struct{})}
+	// net.Pipe preserves the boundaries of writes as long as reads use buffers big enough to hold them.
+	client, server := net.Pipe()
+	pl.conns <- addrConn{Conn: server, local: serverAddr, remote: clientAddr}
+
+	l, err := ListenConfig{}.ListenTransport(ListenerTransport(pl))
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			_, _ = c.Write([]byte{0xfe, 1, 2, 3})
+		}
+	}()
+
+	c, err := Dialer{}.DialTransport(ConnTransport(addrConn{Conn: client, local: clientAddr, remote: serverAddr}), serverAddr)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+}
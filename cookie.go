@@ -0,0 +1,69 @@
+package raknet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// cookieLifetime is the duration of a cookie epoch. Cookies are accepted in the epoch they were made in and
+// the one after it, so a cookie remains valid for at least cookieLifetime.
+const cookieLifetime = time.Second * 10
+
+// cookieKey is the key that the cookies sent in open connection reply 1 packets are derived from, so that
+// they need not be stored.
+type cookieKey [32]byte
+
+// newCookieKey returns a new, random cookieKey.
+func newCookieKey() (*cookieKey, error) {
+	key := &cookieKey{}
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, fmt.Errorf("error generating cookie key: %v", err)
+	}
+	return key, nil
+}
+
+// cookie returns the cookie sent to the address passed in an open connection reply 1, answering an open
+// connection request 1 with the protocol version passed at the time passed. The client must send it back in
+// its open connection request 2, which shows that it owns the address. As the cookie authenticates the
+// protocol version, it need not be stored between both requests.
+func (key *cookieKey) cookie(addr net.Addr, protocol byte, now time.Time) uint32 {
+	return binary.BigEndian.Uint32(key.mac(addr, protocol, cookieEpoch(now)))
+}
+
+// verify checks if the cookie passed was returned by cookie for the address passed and one of the protocol
+// versions passed, in the current or previous epoch. If so, the protocol version it was made for is
+// returned.
+func (key *cookieKey) verify(cookie uint32, addr net.Addr, protocols []byte, now time.Time) (byte, bool) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], cookie)
+	epoch := cookieEpoch(now)
+	for _, protocol := range protocols {
+		for _, e := range [...]int64{epoch, epoch - 1} {
+			if hmac.Equal(key.mac(addr, protocol, e), b[:]) {
+				return protocol, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// cookieEpoch returns the cookie epoch of the time passed.
+func cookieEpoch(now time.Time) int64 {
+	return now.Unix() / int64(cookieLifetime/time.Second)
+}
+
+// mac returns the first 4 bytes of the MAC of the address, protocol version and epoch passed.
+func (key *cookieKey) mac(addr net.Addr, protocol byte, epoch int64) []byte {
+	mac := hmac.New(sha256.New, key[:])
+	var b [9]byte
+	b[0] = protocol
+	binary.BigEndian.PutUint64(b[1:], uint64(epoch))
+	_, _ = mac.Write(b[:])
+	_, _ = mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)[:4]
+}
@@ -0,0 +1,96 @@
+package raknet
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Corruption specifies how datagrams are corrupted by CorruptDatagrams. Every datagram written is subject
+// to each kind of corruption independently.
+type Corruption struct {
+	// BitFlips is the chance from 0-1 that bits of a datagram written are flipped.
+	BitFlips float64
+	// MaxBitFlips is the maximum number of bits flipped in a datagram of which bits are flipped. The number of
+	// bits flipped is random.
+	// MaxBitFlips is 1 by default.
+	MaxBitFlips int
+	// Truncation is the chance from 0-1 that a datagram written is cut off at a random length.
+	Truncation float64
+	// Extension is the chance from 0-1 that random bytes are appended to a datagram written.
+	Extension float64
+	// MaxExtension is the maximum number of bytes appended to a datagram that is extended. The number of bytes
+	// appended is random.
+	// MaxExtension is 16 by default.
+	MaxExtension int
+
+	// Seed is the seed of the random source that decides which datagrams are corrupted and how. Passing the
+	// same seed corrupts the same datagrams in the same way for the same writes.
+	// Seed is 0 by default, meaning a random seed is used.
+	Seed int64
+}
+
+// CorruptDatagrams wraps the net.PacketConn passed so that datagrams written to it are corrupted according
+// to the Corruption passed. As RakNet has no checksums of its own, it may be passed to the WrapConn field of
+// a ListenConfig or Dialer to verify that corrupted datagrams are rejected or degrade a connection
+// gracefully, rather than causing panics or stalling it:
+//
+//	dialer := raknet.Dialer{WrapConn: func(conn net.PacketConn) net.PacketConn {
+//		return raknet.CorruptDatagrams(conn, raknet.Corruption{BitFlips: 0.01, Truncation: 0.01})
+//	}}
+//
+// CorruptDatagrams may be combined with SimulateNetwork.
+func CorruptDatagrams(conn net.PacketConn, corruption Corruption) net.PacketConn {
+	if corruption.MaxBitFlips <= 0 {
+		corruption.MaxBitFlips = 1
+	}
+	if corruption.MaxExtension <= 0 {
+		corruption.MaxExtension = 16
+	}
+	if corruption.Seed == 0 {
+		corruption.Seed = time.Now().UnixNano()
+	}
+	return &corruptConn{PacketConn: conn, corruption: corruption, rand: rand.New(rand.NewSource(corruption.Seed))}
+}
+
+// corruptConn is a net.PacketConn that corrupts the datagrams written to it according to a Corruption.
+type corruptConn struct {
+	net.PacketConn
+	corruption Corruption
+
+	// mu guards rand, which is not safe for concurrent use.
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// WriteTo writes the datagram passed to the address passed, corrupting it according to the Corruption of
+// the conn. The datagram passed itself is never modified.
+func (conn *corruptConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	if len(b) == 0 {
+		return conn.PacketConn.WriteTo(b, addr)
+	}
+	conn.mu.Lock()
+	data := b
+	if conn.rand.Float64() < conn.corruption.BitFlips {
+		data = append([]byte(nil), data...)
+		flips := 1 + conn.rand.Intn(conn.corruption.MaxBitFlips)
+		for i := 0; i < flips; i++ {
+			data[conn.rand.Intn(len(data))] ^= 1 << uint(conn.rand.Intn(8))
+		}
+	}
+	if conn.rand.Float64() < conn.corruption.Truncation {
+		data = data[:conn.rand.Intn(len(data))]
+	}
+	if conn.rand.Float64() < conn.corruption.Extension {
+		extension := make([]byte, 1+conn.rand.Intn(conn.corruption.MaxExtension))
+		_, _ = conn.rand.Read(extension)
+		data = append(data[:len(data):len(data)], extension...)
+	}
+	conn.mu.Unlock()
+
+	if _, err := conn.PacketConn.WriteTo(data, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
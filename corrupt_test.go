@@ -0,0 +1,101 @@
+package raknet
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCorruptDatagrams(t *testing.T) {
+	original := bytes.Repeat([]byte{0x84, 1, 2, 3}, 16)
+	for _, c := range []struct {
+		name       string
+		corruption Corruption
+		check      func(data []byte) bool
+	}{
+		{name: "bit flips", corruption: Corruption{BitFlips: 1}, check: func(data []byte) bool {
+			return len(data) == len(original) && !bytes.Equal(data, original)
+		}},
+		{name: "truncation", corruption: Corruption{Truncation: 1}, check: func(data []byte) bool {
+			return len(data) < len(original) && bytes.Equal(data, original[:len(data)])
+		}},
+		{name: "extension", corruption: Corruption{Extension: 1}, check: func(data []byte) bool {
+			return len(data) > len(original) && bytes.Equal(data[:len(original)], original)
+		}},
+	} {
+		a, b := newPipeConn("a"), newPipeConn("b")
+		a.peer, b.peer = b, a
+		conn := CorruptDatagrams(a, c.corruption)
+		b2 := append([]byte(nil), original...)
+		if _, err := conn.WriteTo(b2, b.local); err != nil {
+			t.Fatalf("%v: error writing: %v", c.name, err)
+		}
+		if !bytes.Equal(b2, original) {
+			t.Fatalf("%v: datagram passed was modified", c.name)
+		}
+		if data := <-b.in; !c.check(data) {
+			t.Errorf("%v: datagram not corrupted as expected: %x", c.name, data)
+		}
+	}
+}
+
+// TestCorruptedConnection checks that a Listener survives connections of which the datagrams are corrupted
+// and keeps accepting new connections.
+func TestCorruptedConnection(t *testing.T) {
+	discard := log.New(io.Discard, "", 0)
+	l, err := ListenConfig{ErrorLog: discard}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b := make([]byte, 30000)
+				for {
+					n, err := c.Read(b)
+					if err != nil {
+						return
+					}
+					if _, err := c.Write(b[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	wrap := func(conn net.PacketConn) net.PacketConn {
+		return CorruptDatagrams(conn, Corruption{BitFlips: 0.05, MaxBitFlips: 8, Truncation: 0.05, Extension: 0.05, Seed: 1})
+	}
+	client, err := Dialer{WrapConn: wrap, ErrorLog: discard}.Dial(l.Addr().String())
+	if err == nil {
+		for i := 0; i < 200; i++ {
+			if _, err := client.Write(bytes.Repeat([]byte{0xfe, byte(i)}, 1+i*20)); err != nil {
+				break
+			}
+		}
+		time.Sleep(time.Second)
+		_ = client.Close()
+	}
+
+	conn, err := Dialer{ErrorLog: discard}.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing after corrupted connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{0xfe, 1}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("error reading echo after corrupted connection: %v", err)
+	}
+}
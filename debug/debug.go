@@ -0,0 +1,148 @@
+// Package debug provides an http.Handler that renders the live state of a raknet.Listener, such as its
+// configuration, statistics and connections, as HTML or JSON. Like net/http/pprof, the handler may be
+// mounted on an existing admin mux:
+//
+//	mux.Handle("/debug/raknet", debug.Handler(listener))
+//
+// The state is rendered as JSON if the format=json query parameter is passed or if the request accepts
+// application/json, and as HTML otherwise.
+package debug
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// Handler returns an http.Handler that renders the live state of the listener passed.
+func Handler(listener *raknet.Listener) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := newState(listener)
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(s)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = page.Execute(w, s)
+	})
+}
+
+// state is the state of a listener as rendered by the handler.
+type state struct {
+	Addr        string               `json:"addr"`
+	Config      config               `json:"config"`
+	Stats       raknet.ListenerStats `json:"stats"`
+	Connections []connection         `json:"connections"`
+}
+
+// config holds the fields of a raknet.ListenConfig that can be rendered.
+type config struct {
+	Protocol         byte          `json:"protocol"`
+	MaxMemory        int64         `json:"max_memory"`
+	IdleTimeout      time.Duration `json:"idle_timeout"`
+	MaxDatagramSize  int           `json:"max_datagram_size"`
+	PathMTUDiscovery bool          `json:"path_mtu_discovery"`
+	WriteBatchWindow time.Duration `json:"write_batch_window"`
+	AcceptBacklog    int           `json:"accept_backlog"`
+	SendWindow       int           `json:"send_window"`
+	DelayRecordCount int           `json:"delay_record_count"`
+	LowFootprint     bool          `json:"low_footprint"`
+}
+
+// connection holds the statistics of a single connection.
+type connection struct {
+	RemoteAddr         string           `json:"remote_addr"`
+	Latency            time.Duration    `json:"latency"`
+	DatagramsSent      uint64           `json:"datagrams_sent"`
+	DatagramsReceived  uint64           `json:"datagrams_received"`
+	DatagramsResent    uint64           `json:"datagrams_resent"`
+	SpuriousResends    uint64           `json:"spurious_resends"`
+	DuplicateDatagrams uint64           `json:"duplicate_datagrams"`
+	OutboundLoss       float64          `json:"outbound_loss"`
+	InboundLoss        float64          `json:"inbound_loss"`
+	RTT                raknet.Histogram `json:"rtt"`
+}
+
+// newState collects the current state of the listener passed.
+func newState(listener *raknet.Listener) state {
+	c := listener.Config()
+	stats := listener.Stats()
+	s := state{
+		Addr: listener.Addr().String(),
+		Config: config{
+			Protocol:         c.Protocol,
+			MaxMemory:        c.MaxMemory,
+			IdleTimeout:      c.IdleTimeout,
+			MaxDatagramSize:  c.MaxDatagramSize,
+			PathMTUDiscovery: c.PathMTUDiscovery,
+			WriteBatchWindow: c.WriteBatchWindow,
+			AcceptBacklog:    c.AcceptBacklog,
+			SendWindow:       c.SendWindow,
+			DelayRecordCount: c.DelayRecordCount,
+			LowFootprint:     c.LowFootprint,
+		},
+		Stats:       stats,
+		Connections: []connection{},
+	}
+	for _, conn := range listener.ConnStats() {
+		s.Connections = append(s.Connections, connection{
+			RemoteAddr:         conn.RemoteAddr.String(),
+			Latency:            conn.Latency,
+			DatagramsSent:      conn.DatagramsSent,
+			DatagramsReceived:  conn.DatagramsReceived,
+			DatagramsResent:    conn.DatagramsResent,
+			SpuriousResends:    conn.SpuriousResends,
+			DuplicateDatagrams: conn.DuplicateDatagrams,
+			OutboundLoss:       conn.OutboundLoss,
+			InboundLoss:        conn.InboundLoss,
+			RTT:                conn.RTT,
+		})
+	}
+	return s
+}
+
+// page is the template of the HTML page rendered by the handler.
+var page = template.Must(template.New("raknet").Parse(`<!DOCTYPE html>
+<html>
+<head><title>raknet {{.Addr}}</title></head>
+<body>
+<h1>Listener {{.Addr}}</h1>
+<h2>Configuration</h2>
+<table>
+<tr><td>Protocol</td><td>{{.Config.Protocol}}</td></tr>
+<tr><td>Max memory</td><td>{{.Config.MaxMemory}}</td></tr>
+<tr><td>Idle timeout</td><td>{{.Config.IdleTimeout}}</td></tr>
+<tr><td>Max datagram size</td><td>{{.Config.MaxDatagramSize}}</td></tr>
+<tr><td>Path MTU discovery</td><td>{{.Config.PathMTUDiscovery}}</td></tr>
+<tr><td>Write batch window</td><td>{{.Config.WriteBatchWindow}}</td></tr>
+<tr><td>Accept backlog</td><td>{{.Config.AcceptBacklog}}</td></tr>
+<tr><td>Send window</td><td>{{.Config.SendWindow}}</td></tr>
+<tr><td>Delay record count</td><td>{{.Config.DelayRecordCount}}</td></tr>
+<tr><td>Low footprint</td><td>{{.Config.LowFootprint}}</td></tr>
+</table>
+<h2>Statistics</h2>
+<table>
+<tr><td>Connections</td><td>{{.Stats.Connections}}</td></tr>
+<tr><td>Datagrams sent</td><td>{{.Stats.DatagramsSent}}</td></tr>
+<tr><td>Datagrams received</td><td>{{.Stats.DatagramsReceived}}</td></tr>
+<tr><td>Datagrams resent</td><td>{{.Stats.DatagramsResent}}</td></tr>
+<tr><td>Spurious resends</td><td>{{.Stats.SpuriousResends}}</td></tr>
+<tr><td>Duplicate datagrams</td><td>{{.Stats.DuplicateDatagrams}}</td></tr>
+<tr><td>Handshake failures</td><td>{{.Stats.HandshakeFailures}}</td></tr>
+<tr><td>RTT p50/p95/p99</td><td>{{.Stats.RTT.Percentile 50}} / {{.Stats.RTT.Percentile 95}} / {{.Stats.RTT.Percentile 99}}</td></tr>
+</table>
+<h2>Connections</h2>
+<table>
+<tr><th>Address</th><th>Latency</th><th>Sent</th><th>Received</th><th>Resent</th><th>Loss out/in</th><th>RTT p99</th></tr>
+{{range .Connections}}<tr><td>{{.RemoteAddr}}</td><td>{{.Latency}}</td><td>{{.DatagramsSent}}</td><td>{{.DatagramsReceived}}</td><td>{{.DatagramsResent}}</td><td>{{printf "%.3f" .OutboundLoss}} / {{printf "%.3f" .InboundLoss}}</td><td>{{.RTT.Percentile 99}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,37 @@
+package debug
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sandertv/go-raknet"
+)
+
+func TestHandler(t *testing.T) {
+	listener, err := raknet.ListenConfig{ErrorLog: log.New(ioutil.Discard, "", 0)}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	handler := Handler(listener)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?format=json", nil))
+	var s state
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("error decoding JSON state: %v", err)
+	}
+	if s.Addr != listener.Addr().String() || s.Config.Protocol != raknet.MinecraftProtocol {
+		t.Errorf("unexpected state rendered: %+v", s)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "<h1>Listener "+listener.Addr().String()+"</h1>") {
+		t.Errorf("unexpected HTML rendered:\n%v", rec.Body.String())
+	}
+}
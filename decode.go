@@ -0,0 +1,114 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// DecodePacket decodes the datagram passed the way a Listener or Conn would when receiving it, without
+// handling it. Offline messages, such as unconnected pings and open connection requests, are decoded as
+// well as datagrams of connections, including ACKs, NACKs, encapsulated packets and the packets handled by
+// RakNet itself found in them. An error is returned if the datagram is invalid.
+// DecodePacket never panics, whatever the input. It exists so that the decoders may be targeted by fuzzing:
+//
+//	func FuzzDecodePacket(f *testing.F) {
+//		f.Fuzz(func(t *testing.T, b []byte) {
+//			_ = raknet.DecodePacket(b)
+//		})
+//	}
+func DecodePacket(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("error decoding packet: empty datagram")
+	}
+	buffer := bytes.NewBuffer(b)
+	if b[0]&bitFlagValid == 0 {
+		return decodeOfflineMessage(buffer)
+	}
+	return decodeDatagram(buffer)
+}
+
+// decodeOfflineMessage decodes an offline message, which is not contained in a datagram.
+func decodeOfflineMessage(b *bytes.Buffer) error {
+	id, _ := b.ReadByte()
+	var err error
+	switch id {
+	case idUnconnectedPing:
+		err = binary.Read(b, binary.BigEndian, &unconnectedPing{})
+	case idUnconnectedPong:
+		err = binary.Read(b, binary.BigEndian, &unconnectedPong{})
+	case idOpenConnectionRequest1:
+		err = binary.Read(b, binary.BigEndian, &openConnectionRequest1{})
+	case idOpenConnectionReply1:
+		err = (&openConnectionReply1{}).UnmarshalBinary(b.Bytes())
+	case idIncompatibleProtocolVersion:
+		err = binary.Read(b, binary.BigEndian, &incompatibleProtocolVersion{})
+	case idOpenConnectionRequest2:
+		err = (&openConnectionRequest2{}).UnmarshalBinary(b.Bytes())
+	case idOpenConnectionReply2:
+		err = (&openConnectionReply2{}).UnmarshalBinary(b.Bytes())
+	case idAlreadyConnected, idNoFreeIncomingConnections, idConnectionBanned:
+		err = binary.Read(b, binary.BigEndian, &connectionRefused{})
+	default:
+		return fmt.Errorf("unknown offline message ID %#x", id)
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding offline message %#x: %v", id, err)
+	}
+	return nil
+}
+
+// decodeDatagram decodes a datagram of a connection, which may be an ACK, a NACK or a datagram holding
+// encapsulated packets.
+func decodeDatagram(b *bytes.Buffer) error {
+	var header datagramHeader
+	if err := header.read(b); err != nil {
+		return err
+	}
+	if header.flags&(bitFlagACK|bitFlagNACK) != 0 {
+		ack := &acknowledgement{}
+		if err := ack.read(b); err != nil {
+			return fmt.Errorf("error decoding acknowledgement: %v", err)
+		}
+		return nil
+	}
+	for b.Len() > 0 {
+		var p packet
+		if err := p.read(b); err != nil {
+			return fmt.Errorf("error decoding datagram packet: %v", err)
+		}
+		if p.split {
+			// The content of split packets is only decoded once all fragments arrived.
+			putBuffer(p.content)
+			continue
+		}
+		if err := decodeConnectedPacket(p.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeConnectedPacket decodes the content of an encapsulated packet. Packets not handled by RakNet itself
+// are passed on to the application as they are and are therefore not decoded, and neither are the fields of
+// connection request accepted packets, which are ignored by a Conn.
+func decodeConnectedPacket(content []byte) error {
+	b := bytes.NewBuffer(content)
+	id, err := b.ReadByte()
+	if err != nil {
+		return fmt.Errorf("error reading packet ID: %v", err)
+	}
+	switch id {
+	case idConnectedPing:
+		err = binary.Read(b, binary.BigEndian, &connectedPing{})
+	case idConnectedPong:
+		err = binary.Read(b, binary.BigEndian, &connectedPong{})
+	case idConnectionRequest:
+		err = binary.Read(b, binary.BigEndian, &connectionRequest{})
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding packet %#x: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/sandertv/go-raknet/message"
+)
+
+func FuzzDecodePacket(f *testing.F) {
+	f.Add([]byte{idUnconnectedPing, 0, 0, 0, 0, 0, 0, 0, 1})
+	f.Add(append([]byte{idOpenConnectionRequest1}, append(magic[:], MinecraftProtocol)...))
+	f.Add([]byte{bitFlagValid | bitFlagACK, 0, 1, packetSingle, 1, 0, 0})
+	f.Add([]byte{bitFlagValid, 0, 0, 0, reliabilityReliable<<5 | splitFlag, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfe})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_ = DecodePacket(b)
+	})
+}
+
+func TestDecodePacketSplit(t *testing.T) {
+	p := &packet{reliability: reliabilityReliable, content: []byte{0xfe}, split: true, splitCount: 0}
+	buf := bytes.NewBuffer([]byte{bitFlagValid, 0, 0, 0})
+	if err := p.write(buf); err != nil {
+		t.Fatalf("error encoding packet: %v", err)
+	}
+	if err := DecodePacket(buf.Bytes()); err == nil {
+		t.Fatalf("expected error decoding packet with a split count of 0")
+	}
+}
+
+// TestMessageCompatibility checks that messages encoded by the package are decoded to the same values by the
+// message package, which mirrors them.
+func TestMessageCompatibility(t *testing.T) {
+	addr := rakAddr(net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 60000})
+	reply2, _ := (&openConnectionReply2{Magic: magic, ServerGUID: 5, ClientAddress: &addr, MTUSize: 1400, Secure: true}).MarshalBinary()
+
+	b := bytes.NewBuffer([]byte{idConnectionRequest})
+	_ = binary.Write(b, binary.BigEndian, &connectionRequest{ClientGUID: 6, RequestTimestamp: 7})
+	ping := bytes.NewBuffer([]byte{idUnconnectedPing})
+	_ = binary.Write(ping, binary.BigEndian, &unconnectedPing{SendTimestamp: 1, Magic: magic, ClientGUID: 2})
+
+	for _, test := range []struct {
+		b        []byte
+		expected message.Message
+	}{
+		{append([]byte{idOpenConnectionReply2}, reply2...), &message.OpenConnectionReply2{Magic: magic, ServerGUID: 5, ClientAddress: (*net.UDPAddr)(&addr), MTUSize: 1400, Secure: true}},
+		{b.Bytes(), &message.ConnectionRequest{ClientGUID: 6, RequestTimestamp: 7}},
+		{ping.Bytes(), &message.UnconnectedPing{SendTimestamp: 1, Magic: message.Magic, ClientGUID: 2}},
+	} {
+		msg, err := message.Decode(test.b)
+		if err != nil {
+			t.Fatalf("error decoding %T: %v", test.expected, err)
+		}
+		if !reflect.DeepEqual(msg, test.expected) {
+			t.Fatalf("decoded %+v, expected %+v", msg, test.expected)
+		}
+	}
+}
+
+// TestDatagramCompatibility checks that datagrams and acknowledgements encoded by the package are decoded to
+// the same values by the message package.
+func TestDatagramCompatibility(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	_ = datagramHeader{flags: bitFlagValid, sequenceNumber: 7}.write(b)
+	p := &packet{reliability: reliabilityReliableOrdered, messageIndex: 1, orderIndex: 2, split: true, splitCount: 3, splitID: 4, splitIndex: 2, content: []byte{0xfe, 1}}
+	_ = p.write(b)
+	d := &message.Datagram{}
+	if err := d.UnmarshalBinary(b.Bytes()); err != nil {
+		t.Fatalf("error decoding datagram: %v", err)
+	}
+	expected := &message.Datagram{Flags: message.FlagValid, SequenceNumber: 7, Packets: []message.Packet{
+		{Reliability: message.ReliableOrdered, MessageIndex: 1, OrderIndex: 2, Split: true, SplitCount: 3, SplitID: 4, SplitIndex: 2, Content: []byte{0xfe, 1}},
+	}}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("decoded %+v, expected %+v", d, expected)
+	}
+
+	b.Reset()
+	_ = datagramHeader{flags: bitFlagValid | bitFlagNACK}.write(b)
+	_ = (&acknowledgement{packets: []uint24{5, 1, 2, 3}}).write(b)
+	ack := &message.Acknowledgement{}
+	if err := ack.UnmarshalBinary(b.Bytes()); err != nil {
+		t.Fatalf("error decoding acknowledgement: %v", err)
+	}
+	if expected := (&message.Acknowledgement{NACK: true, Records: []message.AckRecord{{First: 1, Last: 3}, {First: 5, Last: 5}}}); !reflect.DeepEqual(ack, expected) {
+		t.Fatalf("decoded %+v, expected %+v", ack, expected)
+	}
+}
@@ -2,12 +2,16 @@ package raknet
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdh"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"net"
 	"os"
+	"runtime/pprof"
+	"sync"
 	"time"
 )
 
@@ -35,10 +39,147 @@ type Dialer struct {
 	// ErrorLog is a logger that errors from packet decoding are logged to. It may be set to a logger that
 	// simply discards the messages.
 	ErrorLog *log.Logger
+	// Logger is a structured logger that records are logged to at different levels, with the remote address,
+	// packet ID and error category as attributes. A *slog.Logger may be used. If set, it is used instead of
+	// ErrorLog.
+	Logger Logger
 	// Protocol is the protocol of the RakNet connection. Servers will only accept connections with the same
 	// protocol version as theirs, which is one of the constants found in conn.go.
-	// Protocol is raknet.MinecraftProtocol by default.
+	// Protocol is raknet.MinecraftProtocol by default, or raknet.OfficialProtocol if Profile is
+	// raknet.ProfileVanilla.
 	Protocol byte
+	// Profile selects the flavour of RakNet spoken by the connection, such as the one of Minecraft or the one
+	// of the official RakNet library.
+	// Profile is raknet.ProfileAuto by default, meaning it is selected from the protocol version.
+	Profile Profile
+	// Quirks toggles deviations from RakNet made by other implementations of it, such as JRakNet, on top of
+	// those of Profile, so that servers using them may be dialed.
+	// Quirks is the zero value by default, meaning only the deviations of Profile apply.
+	Quirks Quirks
+	// Protocols is a list of RakNet protocol versions that the Dialer falls back to if the server does not
+	// support Protocol. If the server responds with an incompatible protocol version that is found in
+	// Protocols, the connection sequence is restarted using that version. The version negotiated is
+	// returned by Conn.Protocol.
+	// Protocols is nil by default, meaning only Protocol is attempted.
+	Protocols []byte
+	// Magic is the 16-byte sequence found in every offline message, such as unconnected pings and open
+	// connection requests. It must be equal to the ListenConfig.Magic of the server. Replies holding a
+	// different sequence are ignored.
+	// Magic is the zero value by default, meaning the magic of RakNet itself is used.
+	Magic [16]byte
+	// Encrypt makes the Dialer require the connection to be encrypted as described for
+	// ListenConfig.EncryptionKey, accepting any public key of the server. The connection is then encrypted,
+	// but not protected against a man in the middle. Dialing a server that does not encrypt its connections,
+	// which includes all servers not using this package, fails.
+	// Encrypt is false by default, meaning dialing a server that encrypts its connections fails, unless
+	// ServerPublicKey is set.
+	Encrypt bool
+	// ServerPublicKey is the X25519 public key of the ListenConfig.EncryptionKey of the server. If set, the
+	// connection must be encrypted using it, which protects it against a man in the middle.
+	// ServerPublicKey is nil by default.
+	ServerPublicKey *ecdh.PublicKey
+	// MaxDatagramSize is the maximum size of datagrams read by the connection. It is also the MTU size that
+	// the Dialer starts discovering the MTU size with, so that it may be raised for networks supporting
+	// jumbo frames.
+	// MaxDatagramSize is 1500 by default, and can be no higher than 8192.
+	MaxDatagramSize int
+	// PingPadding is the amount of zero bytes appended to the unconnected pings sent by Ping, so that
+	// servers limiting the amplification of their pongs, or requiring a minimum ping size, answer them.
+	// PingPadding is 0 by default.
+	PingPadding int
+	// MaxSplitCount is the maximum amount of fragments that a packet sent by the server may be split into.
+	// MaxSplitCount is 0 by default, meaning the maximum of 8192 fragments is used. It can be no higher.
+	MaxSplitCount int
+	// MaxSplitMemory is the maximum amount of bytes the connection holds for split packets that are being
+	// reassembled. The connection is closed if either maximum is exceeded.
+	// MaxSplitMemory is 0 by default, meaning a maximum of 32 MiB is used.
+	MaxSplitMemory int
+	// RecoverPanics makes the connection recover panics that occur while handling the packets of the
+	// server, for example because of a bug triggered by malformed input. The connection is closed instead.
+	// RecoverPanics is false by default.
+	RecoverPanics bool
+	// PathMTUDiscovery makes the connection discover the path MTU once it is established, by periodically
+	// sending probes of increasing size with the don't fragment flag set. The MTU size used is adjusted up
+	// or down according to the probes that arrive, up to MaxDatagramSize. The don't fragment flag is only
+	// set on Linux.
+	PathMTUDiscovery bool
+
+	// WriteBatchWindow is the time datagrams written to the connection are held before they are written in a
+	// single batch. On Linux, a batch is written using a single sendmmsg system call, which reduces the
+	// amount of system calls made at the cost of added latency.
+	// WriteBatchWindow is 0 by default, meaning datagrams are written immediately. A value of about a
+	// millisecond is reasonable for servers with a high throughput.
+	WriteBatchWindow time.Duration
+
+	// Tracer is used to trace the connection sequence and the closing of the connection with spans.
+	// Tracer is nil by default, meaning no spans are started.
+	Tracer Tracer
+	// Clock is the Clock that drives the timers of the connection once it is established, such as those for
+	// resending datagrams, pinging and timing out. The connection sequence itself is always timed using the
+	// system clock. A ManualClock may be used to test this logic deterministically.
+	// Clock is nil by default, meaning SystemClock is used.
+	Clock Clock
+	// Rand is the source of randomness used to generate the client GUID of the connection and any other
+	// random values used by it, such as the seeds used to simulate packet loss. Passing a deterministic
+	// source, such as a *rand.Rand with a fixed seed, makes connection sequences reproducible.
+	// Rand is nil by default, meaning crypto/rand is used.
+	Rand io.Reader
+	// OnAck is called for every ACK and NACK sent or received by the connection once it is established, with
+	// the ranges of datagram sequence numbers they hold. OnAck is called from the goroutines that process
+	// packets and must therefore not block.
+	// OnAck is nil by default.
+	OnAck func(event AckEvent)
+	// Chaos is used to inject misbehaviour into the connection on demand, such as dropping it or stalling its
+	// ACKs, so that error-handling paths of applications may be tested.
+	// Chaos is nil by default, meaning no misbehaviour is injected.
+	Chaos *Chaos
+	// WrapConn is called with the UDP connection of the Dialer before dialing, if set. The net.PacketConn it
+	// returns is used for all datagrams of the connection instead, so that the datagrams may be altered,
+	// delayed or dropped, for example using SimulateNetwork. Datagrams passed to PacketTrace are those of the
+	// UDP connection itself.
+	// WrapConn is nil by default.
+	WrapConn func(conn net.PacketConn) net.PacketConn
+	// PacketTrace is called for every raw datagram received or sent by the connection, if set, so that protocol
+	// issues may be captured without access to tools such as tcpdump. HexDumpTrace may be used to write a
+	// hex dump of each datagram.
+	// PacketTrace is nil by default.
+	PacketTrace PacketTraceFunc
+	// PcapWriter is a writer that all datagrams received and sent by the connection are written to in the
+	// pcapng format, with synthesized IP and UDP headers, so that they may be opened in Wireshark. It may be
+	// used together with PacketTrace.
+	// PcapWriter is nil by default.
+	PcapWriter io.Writer
+	// TraceSampling specifies which datagrams are passed to PacketTrace. Datagrams written to the PcapWriter
+	// are not sampled.
+	// TraceSampling is the zero value by default, meaning every datagram is traced.
+	TraceSampling Sampling
+	// LogSampling specifies which records are written to the Logger or ErrorLog of the connection.
+	// LogSampling is the zero value by default, meaning every record is logged.
+	LogSampling Sampling
+
+	// SendWindow is the maximum amount of datagrams the connection may have awaiting acknowledgement at the
+	// same time. Datagrams written while the window is full are held until some of them are acknowledged.
+	// SendWindow is 0 by default, meaning there is no maximum.
+	SendWindow int
+	// DelayRecordCount is the amount of datagram delays recorded by the connection to calculate the average
+	// delay, after which datagrams that were not acknowledged are resent.
+	// DelayRecordCount is raknet.DelayRecordCount by default.
+	DelayRecordCount int
+	// IdleTimeout is the time after which the connection is closed if it has not received any packets.
+	// IdleTimeout is 7 seconds by default.
+	IdleTimeout time.Duration
+	// ReadQueueSize is the amount of packets received that may be waiting to be read from the connection.
+	// Once the read queue is full, the SlowConsumerPolicy is applied.
+	// ReadQueueSize is 1024 by default.
+	ReadQueueSize int
+	// SlowConsumerPolicy specifies what the connection does when its read queue is full.
+	// SlowConsumerPolicy is SlowConsumerDisconnect by default.
+	SlowConsumerPolicy SlowConsumerPolicy
+
+	// LowFootprint makes the connection use smaller defaults for the SendWindow, DelayRecordCount and
+	// ReadQueueSize fields left empty, so that it may run on memory constrained devices. Values that are set
+	// explicitly are not changed.
+	LowFootprint bool
 }
 
 // Ping sends a ping to an address and returns the response obtained. If successful, a non-nil response byte
@@ -51,30 +192,35 @@ func (dialer Dialer) Ping(address string) (response []byte, err error) {
 		return nil, fmt.Errorf("error dialing UDP conn: %v", err)
 	}
 	if dialer.Protocol == 0 {
-		dialer.Protocol = MinecraftProtocol
+		dialer.Protocol = dialer.Profile.defaultProtocol()
 	}
 
 	buffer := bytes.NewBuffer(nil)
 	if err := buffer.WriteByte(idUnconnectedPing); err != nil {
 		return nil, fmt.Errorf("error writing unconnected ping ID: %v", err)
 	}
-	// Seed rand with the current time so that we can produce a random ID for the ping.
-	rand.Seed(time.Now().Unix())
-	id := rand.Int63()
+	id, err := randInt63(dialer.Rand)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error generating ping ID: %v", err)
+	}
 
-	packet := &unconnectedPing{SendTimestamp: timestamp(), Magic: magic, ClientGUID: id}
+	packet := &unconnectedPing{SendTimestamp: timestamp(), Magic: dialer.magic(), ClientGUID: id}
 	if err := binary.Write(buffer, binary.BigEndian, packet); err != nil {
 		return nil, fmt.Errorf("error writing unconnected ping packet: %v", err)
 	}
+	if dialer.PingPadding > 0 {
+		_, _ = buffer.Write(make([]byte, dialer.PingPadding))
+	}
 	if _, err := conn.Write(buffer.Bytes()); err != nil {
 		return nil, fmt.Errorf("error sending unconnected ping: %v", err)
 	}
 
-	data := make([]byte, 1492)
+	data := make([]byte, maxDatagramSize(dialer.MaxDatagramSize))
 	// Set a read deadline so that we get a timeout if the server doesn't respond to us.
 	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
 	if n, err := conn.Read(data); err != nil {
-		return nil, fmt.Errorf("timeout reading the response: %v", err)
+		return nil, dialError("timeout reading the response", err)
 	} else {
 		data = data[:n]
 	}
@@ -92,7 +238,10 @@ func (dialer Dialer) Ping(address string) (response []byte, err error) {
 	if err := binary.Read(buffer, binary.BigEndian, pong); err != nil {
 		return nil, fmt.Errorf("error decoding unconnected pong: %v", err)
 	}
-	if dialer.Protocol == MinecraftProtocol {
+	if pong.Magic != dialer.magic() {
+		return nil, fmt.Errorf("error decoding unconnected pong: invalid offline message magic %x", pong.Magic)
+	}
+	if dialer.Quirks.apply(dialer.Profile.framing(dialer.Protocol)).pongLengthPrefix {
 		// Skip the length as we don't need it for reading.
 		_ = buffer.Next(2)
 	}
@@ -107,62 +256,165 @@ func (dialer Dialer) Ping(address string) (response []byte, err error) {
 // Dial will attempt to dial a connection within 10 seconds. If not all packets are received after that, the
 // connection will timeout and an error will be returned.
 // Dial will fill out any values left as their empty values with the default values of those fields.
-func (dialer Dialer) Dial(address string) (*Conn, error) {
-	udpConn, err := net.Dial("udp", address)
+func (dialer Dialer) Dial(address string) (conn *Conn, err error) {
+	return dialer.dial(address, func() (net.Conn, error) {
+		return net.Dial("udp", address)
+	})
+}
+
+// dial dials a RakNet connection to the address passed over the net.Conn returned by the function passed.
+func (dialer Dialer) dial(address string, dial func() (net.Conn, error)) (conn *Conn, err error) {
+	if dialer.Tracer == nil {
+		dialer.Tracer = nopTracer{}
+	}
+	ctx, span := dialer.Tracer.Start(context.Background(), "raknet.Dial", Attribute{Key: "raknet.remote_addr", Value: address})
+	var remoteAddr net.Addr
+	defer func() {
+		endSpan(span, err)
+	}()
+	defer func() {
+		if err != nil {
+			err = opError("dial", nil, remoteAddr, err)
+		}
+	}()
+
+	udpConn, err := dial()
 	if err != nil {
 		return nil, fmt.Errorf("error dialing UDP conn: %v", err)
 	}
+	remoteAddr = udpConn.RemoteAddr()
+	dialer.PacketTrace = sampleTrace(dialer.PacketTrace, newSampler(dialer.TraceSampling, dialer.Clock))
+	if dialer.PcapWriter != nil {
+		pcap, err := NewPcapWriter(dialer.PcapWriter)
+		if err != nil {
+			_ = udpConn.Close()
+			return nil, err
+		}
+		dialer.PacketTrace = combineTraces(dialer.PacketTrace, pcap.Trace(udpConn.LocalAddr(), nil))
+	}
+	udpConn = newTraceConn(udpConn.(net.PacketConn), dialer.PacketTrace).(net.Conn)
+	socket := udpConn.(net.PacketConn)
+	if dialer.WrapConn != nil {
+		udpConn = &connectedConn{PacketConn: dialer.WrapConn(&wrappedConn{PacketConn: socket}), remoteAddr: remoteAddr}
+	}
 	packetConn := udpConn.(net.PacketConn)
 	_ = udpConn.SetReadDeadline(time.Now().Add(time.Second * 10))
 	timeout := time.After(time.Second * 10)
 
-	// Seed rand with the current time so that we can produce a random ID for the connection.
-	rand.Seed(time.Now().Unix())
-	id := rand.Int63()
+	id, err := randInt63(dialer.Rand)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, fmt.Errorf("error generating client GUID: %v", err)
+	}
 
 	if dialer.ErrorLog == nil {
 		dialer.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
 	}
+	if dialer.Logger == nil {
+		dialer.Logger = errorLogLogger{log: dialer.ErrorLog}
+	}
+	dialer.Logger = sampleLogger(dialer.Logger, newSampler(dialer.LogSampling, dialer.Clock))
 	if dialer.Protocol == 0 {
-		dialer.Protocol = MinecraftProtocol
+		dialer.Protocol = dialer.Profile.defaultProtocol()
+	}
+	maxSize := maxDatagramSize(dialer.MaxDatagramSize)
+	discoveringMTUSize := int16(defaultDiscoveringMTUSize)
+	if maxSize > defaultMaxDatagramSize || maxSize < defaultDiscoveringMTUSize {
+		discoveringMTUSize = int16(maxSize)
 	}
 	state := &connState{
 		conn:               udpConn,
 		remoteAddr:         udpConn.RemoteAddr(),
-		discoveringMTUSize: 1492,
+		discoveringMTUSize: discoveringMTUSize,
+		maxDatagramSize:    maxSize,
 		id:                 id,
 		protocol:           dialer.Protocol,
+		protocols:          dialer.Protocols,
+		magic:              dialer.magic(),
+		secure:             dialer.Encrypt,
+		serverPublicKey:    dialer.ServerPublicKey,
+		framing:            dialer.Quirks.apply(dialer.Profile.framing(dialer.Protocol)),
 	}
-	if err := state.discoverMTUSize(); err != nil {
-		return nil, fmt.Errorf("error discovering MTU size: %v", err)
+	_, requestSpan := dialer.Tracer.Start(ctx, "raknet.OpenConnectionRequest1", Attribute{Key: "raknet.protocol", Value: int(dialer.Protocol)})
+	err = state.discoverMTUSize()
+	requestSpan.SetAttributes(Attribute{Key: "raknet.mtu_size", Value: int(state.mtuSize)})
+	endSpan(requestSpan, err)
+	if err != nil {
+		return nil, dialError("error discovering MTU size", err)
 	}
-	if err := state.openConnectionRequest(); err != nil {
-		return nil, fmt.Errorf("error receiving open connection reply: %v", err)
+	_, requestSpan = dialer.Tracer.Start(ctx, "raknet.OpenConnectionRequest2")
+	err = state.openConnectionRequest()
+	endSpan(requestSpan, err)
+	if err != nil {
+		return nil, dialError("error receiving open connection reply", err)
 	}
 
-	conn := newConn(&wrappedConn{PacketConn: packetConn}, udpConn.RemoteAddr(), state.mtuSize, id)
-	go func() {
+	config := connConfig{
+		sendWindow:         dialer.SendWindow,
+		delayRecordCount:   dialer.DelayRecordCount,
+		readQueueSize:      dialer.ReadQueueSize,
+		idleTimeout:        dialer.IdleTimeout,
+		slowConsumerPolicy: dialer.SlowConsumerPolicy,
+		maxDatagramSize:    maxSize,
+		maxSplitCount:      dialer.MaxSplitCount,
+		maxSplitMemory:     dialer.MaxSplitMemory,
+		recoverPanics:      dialer.RecoverPanics,
+		pathMTUDiscovery:   dialer.PathMTUDiscovery,
+		writeBatchWindow:   dialer.WriteBatchWindow,
+		tracer:             dialer.Tracer,
+		traceCtx:           ctx,
+		clock:              dialer.Clock,
+		rand:               dialer.Rand,
+		onAck:              dialer.OnAck,
+		chaos:              dialer.Chaos,
+		protocol:           state.currentProtocol(),
+		profile:            dialer.Profile,
+		quirks:             dialer.Quirks,
+		session:            state.session,
+	}
+	if dialer.PathMTUDiscovery {
+		if err := setDontFragment(socket); err != nil {
+			return nil, fmt.Errorf("error setting don't fragment flag: %v", err)
+		}
+	}
+	if dialer.LowFootprint {
+		config = config.lowFootprint()
+	}
+	conn = newConn(&wrappedConn{PacketConn: packetConn}, udpConn.RemoteAddr(), state.mtuSize, id, config)
+	go func(conn *Conn) {
 		// Wait for the connection to be closed...
 		<-conn.closeCtx.Done()
 		if err := conn.conn.Close(); err != nil {
 			// Should not happen.
 			panic(err)
 		}
-	}()
+	}(conn)
+	_, requestSpan = dialer.Tracer.Start(ctx, "raknet.ConnectionRequest")
 	if err := conn.requestConnection(); err != nil {
-		return nil, fmt.Errorf("error requesting connection: %v", err)
+		endSpan(requestSpan, err)
+		return nil, dialError("error requesting connection", err)
 	}
 
-	go clientListen(conn, udpConn, dialer.ErrorLog)
+	go clientListen(conn, udpConn, maxSize, dialer.Logger)
 	select {
 	case <-conn.completingSequence.Done():
+		requestSpan.End()
 		// Clear all read deadlines as we no longer need these.
 		_ = udpConn.SetReadDeadline(time.Time{})
 		_ = conn.SetReadDeadline(time.Time{})
 		return conn, nil
 	case <-timeout:
-		return nil, fmt.Errorf("error establishing a connection: connection timed out")
+		endSpan(requestSpan, fmt.Errorf("connection timed out"))
+		return nil, wrapNetError(ErrTimeout, "error establishing a connection: connection timed out")
+	}
+}
+
+// magic returns the offline message magic of the Dialer, which is the magic of RakNet itself if none is set.
+func (dialer Dialer) magic() [16]byte {
+	if dialer.Magic == ([16]byte{}) {
+		return magic
 	}
+	return dialer.Magic
 }
 
 // wrappedCon wraps around a 'pre-connected' UDP connection. Its only purpose is to wrap around WriteTo and
@@ -177,12 +429,36 @@ func (conn *wrappedConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
 	return conn.PacketConn.(net.Conn).Write(b)
 }
 
+// connectedConn turns a net.PacketConn, such as one returned by Dialer.WrapConn, into a net.Conn that reads
+// and writes datagrams from and to a single remote address.
+type connectedConn struct {
+	net.PacketConn
+	remoteAddr net.Addr
+}
+
+// Read reads a datagram from the net.PacketConn.
+func (conn *connectedConn) Read(b []byte) (n int, err error) {
+	n, _, err = conn.PacketConn.ReadFrom(b)
+	return n, err
+}
+
+// Write writes a datagram to the remote address of the connectedConn.
+func (conn *connectedConn) Write(b []byte) (n int, err error) {
+	return conn.PacketConn.WriteTo(b, conn.remoteAddr)
+}
+
+// RemoteAddr ...
+func (conn *connectedConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
 // clientListen makes the RakNet connection passed listen as a client for packets received in the connection
 // passed.
-func clientListen(rakConn *Conn, conn net.Conn, errorLog *log.Logger) {
+func clientListen(rakConn *Conn, conn net.Conn, maxDatagramSize int, logger Logger) {
+	pprof.SetGoroutineLabels(rakConn.labels)
 	// Create a buffer with the maximum size a UDP packet sent over RakNet is allowed to have. We can re-use
 	// this buffer for each packet.
-	b := make([]byte, 1492)
+	b := make([]byte, maxDatagramSize)
 	for {
 		n, err := conn.Read(b)
 		if err != nil {
@@ -190,11 +466,16 @@ func clientListen(rakConn *Conn, conn net.Conn, errorLog *log.Logger) {
 				// The connection was closed, so we can return from the function without logging the error.
 				return
 			}
-			errorLog.Printf("client: error reading from Conn: %v", err)
+			logger.Error("client: error reading from Conn", "remote_addr", rakConn.addr, "category", categoryRead, "error", err)
 			return
 		}
+		if n == len(b) {
+			// The datagram filled the entire buffer, meaning it was likely truncated. Datagrams sent by the
+			// server are never bigger than the MTU size, so we drop it.
+			continue
+		}
 		if err := rakConn.receive(bytes.NewBuffer(b[:n])); err != nil {
-			errorLog.Printf("error handling packet: %v\n", err)
+			logger.Warn("error handling packet", "remote_addr", rakConn.addr, "packet_id", fmt.Sprintf("%#x", b[0]), "category", categoryDatagram, "error", err)
 		}
 	}
 }
@@ -206,8 +487,27 @@ type connState struct {
 	remoteAddr net.Addr
 	id         int64
 
-	// protocol is the RakNet protocol version used by the connection state.
-	protocol byte
+	// protocol is the RakNet protocol version used by the connection state. It may be changed to one of the
+	// protocols to fall back to if the server does not support it. protocolMu guards protocol.
+	protocol   byte
+	protocols  []byte
+	protocolMu sync.Mutex
+	// magic is the offline message magic sent and expected by the connection state.
+	magic [16]byte
+	// secure and serverPublicKey are the security settings of the Dialer. security is set once the server
+	// replied with its public key, and session once the key exchange completed.
+	secure          bool
+	serverPublicKey *ecdh.PublicKey
+	security        *clientSecurity
+	session         *securitySession
+	// cookie is the cookie sent by a server that verifies the addresses of its clients without securing its
+	// connections. hasCookie is true if one was sent.
+	cookie    uint32
+	hasCookie bool
+
+	// framing is the framing of the Profile and Quirks of the Dialer. Only the framing of the MTU size is
+	// used during the connection sequence, which does not differ between protocol versions.
+	framing protocolFraming
 
 	// mtuSize is the final MTU size found by sending open connection request 1 packets. It is the MTU size
 	// sent by the server.
@@ -216,8 +516,21 @@ type connState struct {
 	// discoveringMTUSize is the current MTU size 'discovered'. This MTU size decreases the more the open
 	// connection request 1 is sent, so that the max packet size can be discovered.
 	discoveringMTUSize int16
+	// maxDatagramSize is the maximum size of datagrams read and the maximum MTU size accepted.
+	maxDatagramSize int
+}
+
+// currentProtocol returns the RakNet protocol version currently used by the connection state.
+func (state *connState) currentProtocol() byte {
+	state.protocolMu.Lock()
+	defer state.protocolMu.Unlock()
+	return state.protocol
 }
 
+// defaultDiscoveringMTUSize is the MTU size that MTU discovery starts with if the maximum datagram size is
+// not raised above the default.
+const defaultDiscoveringMTUSize = 1492
+
 // openConnectionRequest sends open connection request 2 packets continuously until it receives an open
 // connection reply 2 packet from the server.
 func (state *connState) openConnectionRequest() (e error) {
@@ -241,7 +554,7 @@ func (state *connState) openConnectionRequest() (e error) {
 		}
 	}()
 
-	b := make([]byte, 1492)
+	b := make([]byte, state.maxDatagramSize)
 	for {
 		// Start reading in a loop so that we can find open connection reply 2 packets.
 		n, err := state.conn.Read(b)
@@ -253,6 +566,19 @@ func (state *connState) openConnectionRequest() (e error) {
 		if err != nil {
 			return fmt.Errorf("error reading packet ID: %v", err)
 		}
+		if id == idAlreadyConnected || id == idNoFreeIncomingConnections || id == idConnectionBanned {
+			response := &connectionRefused{}
+			if err := binary.Read(buffer, binary.BigEndian, response); err != nil || response.Magic != state.magic {
+				continue
+			}
+			switch id {
+			case idAlreadyConnected:
+				return ErrAlreadyConnected
+			case idConnectionBanned:
+				return ErrBanned
+			}
+			return ErrServerFull
+		}
 		if id != idOpenConnectionReply2 {
 			// We got a packet, but the packet was not an open connection reply 2 packet. We simply discard it
 			// and continue reading.
@@ -262,7 +588,18 @@ func (state *connState) openConnectionRequest() (e error) {
 		if err := response.UnmarshalBinary(buffer.Bytes()); err != nil {
 			return fmt.Errorf("error reading open connection reply 2: %v", err)
 		}
-		state.mtuSize = response.MTUSize
+		if response.Magic != state.magic {
+			continue
+		}
+		if state.security != nil {
+			if !response.Secure {
+				return fmt.Errorf("server did not encrypt the connection")
+			}
+			if state.session, err = state.security.finish(response.SecurityAnswer); err != nil {
+				return fmt.Errorf("error completing key exchange: %v", err)
+			}
+		}
+		state.mtuSize = int16(state.framing.roundMTU(int(response.MTUSize)))
 		return
 	}
 }
@@ -286,14 +623,19 @@ func (state *connState) discoverMTUSize() (e error) {
 				}
 				// Each half second we decrease the MTU size by 40. This means that in 10 seconds, we have an MTU
 				// size of 692. This is a little above the actual RakNet minimum, but that should not be an issue.
-				state.discoveringMTUSize -= 40
+				// If we started with an MTU size above the default, we go back to the default straight away.
+				if state.discoveringMTUSize > defaultDiscoveringMTUSize {
+					state.discoveringMTUSize = defaultDiscoveringMTUSize
+				} else {
+					state.discoveringMTUSize -= 40
+				}
 			case <-stop:
 				return
 			}
 		}
 	}()
 
-	b := make([]byte, 1492)
+	b := make([]byte, state.maxDatagramSize)
 	for {
 		// Start reading in a loop so that we can find open connection reply 1 packets.
 		n, err := state.conn.Read(b)
@@ -308,20 +650,48 @@ func (state *connState) discoverMTUSize() (e error) {
 		switch id {
 		case idOpenConnectionReply1:
 			response := &openConnectionReply1{}
-			if err := binary.Read(buffer, binary.BigEndian, response); err != nil {
+			if err := response.UnmarshalBinary(buffer.Bytes()); err != nil {
 				return fmt.Errorf("error reading open connection reply 1: %v", err)
 			}
-			if response.MTUSize < 400 || response.MTUSize > 1500 {
+			if response.Magic != state.magic {
+				continue
+			}
+			if response.Secure && len(response.ServerPublicKey) == 0 {
+				// The server only verifies our address using the cookie, so the connection is not secured.
+				if state.secure || state.serverPublicKey != nil {
+					return fmt.Errorf("dialer requires encryption, but the server does not encrypt its connections")
+				}
+				state.cookie, state.hasCookie = response.Cookie, true
+			} else if response.Secure {
+				if state.security, err = newClientSecurity(state.secure, state.serverPublicKey, response.ServerPublicKey, response.Cookie); err != nil {
+					return err
+				}
+			} else if state.secure || state.serverPublicKey != nil {
+				return fmt.Errorf("dialer requires encryption, but the server does not encrypt its connections")
+			}
+			if response.MTUSize < minMTUSize || int(response.MTUSize) > state.maxDatagramSize {
 				return fmt.Errorf("invalid MTU size %v received in open connection reply 1", response.MTUSize)
 			}
-			state.mtuSize = response.MTUSize
+			state.mtuSize = int16(state.framing.roundMTU(int(response.MTUSize)))
 			return
 		case idIncompatibleProtocolVersion:
 			response := &incompatibleProtocolVersion{}
 			if err := binary.Read(buffer, binary.BigEndian, response); err != nil {
 				return fmt.Errorf("error reading incompatible protocol version: %v", err)
 			}
-			return fmt.Errorf("mismatched protocol: client protocol = %v, server protocol = %v", state.protocol, response.ServerProtocol)
+			if response.Magic != state.magic {
+				continue
+			}
+			protocol := state.currentProtocol()
+			if response.ServerProtocol != protocol && hasProtocol(state.protocols, response.ServerProtocol) {
+				// The server supports a protocol version we may fall back to. The next open connection
+				// request 1 sent uses that version instead.
+				state.protocolMu.Lock()
+				state.protocol = response.ServerProtocol
+				state.protocolMu.Unlock()
+				continue
+			}
+			return fmt.Errorf("mismatched protocol: client protocol = %v, server protocol = %v: %w", protocol, response.ServerProtocol, ErrIncompatibleProtocol)
 		}
 	}
 }
@@ -331,7 +701,12 @@ func (state *connState) discoverMTUSize() (e error) {
 func (state *connState) sendOpenConnectionRequest2() error {
 	b := bytes.NewBuffer([]byte{idOpenConnectionRequest2})
 	addr := rakAddr(*state.remoteAddr.(*net.UDPAddr))
-	packet := &openConnectionRequest2{Magic: magic, ServerAddress: &addr, MTUSize: state.mtuSize, ClientGUID: state.id}
+	packet := &openConnectionRequest2{Magic: state.magic, ServerAddress: &addr, MTUSize: state.mtuSize, ClientGUID: state.id}
+	if state.security != nil {
+		packet.Secure, packet.Cookie, packet.Challenge = true, state.security.cookie, state.security.challenge()
+	} else if state.hasCookie {
+		packet.Secure, packet.Cookie = true, state.cookie
+	}
 	data, err := packet.MarshalBinary()
 	if err != nil {
 		return fmt.Errorf("error encoding open connection request 2: %v", err)
@@ -349,11 +724,11 @@ func (state *connState) sendOpenConnectionRequest2() error {
 // error is returned.
 func (state *connState) sendOpenConnectionRequest1() error {
 	b := bytes.NewBuffer([]byte{idOpenConnectionRequest1})
-	packet := &openConnectionRequest1{Magic: magic, Protocol: state.protocol}
+	packet := &openConnectionRequest1{Magic: state.magic, Protocol: state.currentProtocol()}
 	if err := binary.Write(b, binary.BigEndian, packet); err != nil {
 		return fmt.Errorf("error writing open connection request 1: %v", err)
 	}
-	padding := make([]byte, state.discoveringMTUSize-int16(b.Len())-28)
+	padding := make([]byte, state.discoveringMTUSize-int16(b.Len()+state.framing.mtuHeaderSize()))
 	if _, err := b.Write(padding); err != nil {
 		return fmt.Errorf("error writing open connection request 1 padding: %v", err)
 	}
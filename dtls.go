@@ -0,0 +1,75 @@
+package raknet
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+// DTLS is an implementation of DTLS used by ListenDTLS and DialDTLS to terminate DTLS on the UDP socket and
+// run RakNet inside of it. The standard library does not implement DTLS, so an implementation such as that
+// of github.com/pion/dtls must be adapted to this interface, which typically takes a few lines.
+type DTLS interface {
+	// Listen returns a net.Listener that accepts DTLS connections from clients sending datagrams to the
+	// net.PacketConn passed, performing the handshake using the tls.Config passed. The remote addresses of
+	// the connections accepted must be *net.UDPAddrs.
+	Listen(conn net.PacketConn, config *tls.Config) (net.Listener, error)
+	// Dial performs a DTLS handshake with the server at the address passed over the net.PacketConn passed,
+	// using the tls.Config passed, and returns the connection established.
+	Dial(conn net.PacketConn, addr net.Addr, config *tls.Config) (net.Conn, error)
+}
+
+// DTLSConfig holds the DTLS implementation and certificate configuration used by ListenDTLS and DialDTLS.
+type DTLSConfig struct {
+	// DTLS is the DTLS implementation used. It must be set.
+	DTLS DTLS
+	// TLSConfig is the configuration of the DTLS handshake. Servers must set its Certificates. Clients may set
+	// its RootCAs and ServerName to verify the certificate of the server, and servers may set its ClientCAs
+	// and ClientAuth to require clients to present certificates too.
+	// TLSConfig is nil by default, meaning the defaults of the DTLS implementation are used.
+	TLSConfig *tls.Config
+}
+
+// ListenDTLS listens on the address passed for DTLS connections and returns a Listener that accepts RakNet
+// connections running inside of them, so that the connections are encrypted without changing the RakNet
+// layer. Clients must connect using Dialer.DialDTLS or an equivalent DTLS client. If not successful, an
+// error is returned.
+// ListenDTLS fills out any values of the ListenConfig left as their empty values with the default values of
+// those fields.
+func (config ListenConfig) ListenDTLS(address string, dtls DTLSConfig) (*Listener, error) {
+	if dtls.DTLS == nil {
+		return nil, fmt.Errorf("error listening for DTLS: no DTLS implementation set")
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error creating UDP listener: %v", err)
+	}
+	l, err := dtls.DTLS.Listen(conn, dtls.TLSConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error listening for DTLS: %v", err)
+	}
+	return config.ListenTransport(ListenerTransport(l))
+}
+
+// DialDTLS dials a DTLS connection to the address passed and dials a RakNet connection inside of it, so that
+// the connection is encrypted without changing the RakNet layer. If not successful, an error is returned.
+func (dialer Dialer) DialDTLS(address string, dtls DTLSConfig) (*Conn, error) {
+	if dtls.DTLS == nil {
+		return nil, fmt.Errorf("error dialing DTLS: no DTLS implementation set")
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving UDP address: %v", err)
+	}
+	conn, err := net.ListenPacket("udp", "")
+	if err != nil {
+		return nil, fmt.Errorf("error creating UDP conn: %v", err)
+	}
+	c, err := dtls.DTLS.Dial(conn, addr, dtls.TLSConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error performing DTLS handshake: %v", err)
+	}
+	return dialer.DialTransport(ConnTransport(c), addr)
+}
@@ -0,0 +1,149 @@
+package raknet
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// plainDTLS is a DTLS implementation without encryption, which demultiplexes the datagrams received by a
+// server into a connection per client address.
+type plainDTLS struct {
+	configs chan *tls.Config
+}
+
+func (d plainDTLS) Listen(conn net.PacketConn, config *tls.Config) (net.Listener, error) {
+	d.configs <- config
+	l := &plainListener{pc: conn, conns: make(map[string]*plainConn), accept: make(chan net.Conn, 16)}
+	go l.listen()
+	return l, nil
+}
+
+func (d plainDTLS) Dial(conn net.PacketConn, addr net.Addr, config *tls.Config) (net.Conn, error) {
+	d.configs <- config
+	c := &plainConn{pc: conn, remote: addr, in: make(chan []byte, 256)}
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(b)
+			if err != nil {
+				_ = c.Close()
+				return
+			}
+			c.in <- append([]byte(nil), b[:n]...)
+		}
+	}()
+	return c, nil
+}
+
+type plainListener struct {
+	pc     net.PacketConn
+	mu     sync.Mutex
+	conns  map[string]*plainConn
+	accept chan net.Conn
+}
+
+func (l *plainListener) listen() {
+	b := make([]byte, 1500)
+	for {
+		n, addr, err := l.pc.ReadFrom(b)
+		if err != nil {
+			close(l.accept)
+			return
+		}
+		l.mu.Lock()
+		c, ok := l.conns[addr.String()]
+		if !ok {
+			c = &plainConn{pc: l.pc, remote: addr, in: make(chan []byte, 256)}
+			l.conns[addr.String()] = c
+			l.accept <- c
+		}
+		l.mu.Unlock()
+		c.in <- append([]byte(nil), b[:n]...)
+	}
+}
+
+func (l *plainListener) Accept() (net.Conn, error) {
+	c, ok := <-l.accept
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+
+func (l *plainListener) Close() error   { return l.pc.Close() }
+func (l *plainListener) Addr() net.Addr { return l.pc.LocalAddr() }
+
+type plainConn struct {
+	pc       net.PacketConn
+	remote   net.Addr
+	in       chan []byte
+	once     sync.Once
+	closed   chan struct{}
+	closedMu sync.Mutex
+}
+
+func (c *plainConn) done() chan struct{} {
+	c.closedMu.Lock()
+	defer c.closedMu.Unlock()
+	if c.closed == nil {
+		c.closed = make(chan struct{})
+	}
+	return c.closed
+}
+
+func (c *plainConn) Read(b []byte) (int, error) {
+	select {
+	case data := <-c.in:
+		return copy(b, data), nil
+	case <-c.done():
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *plainConn) Write(b []byte) (int, error) { return c.pc.WriteTo(b, c.remote) }
+func (c *plainConn) Close() error {
+	c.once.Do(func() { close(c.done()) })
+	return nil
+}
+func (c *plainConn) LocalAddr() net.Addr              { return c.pc.LocalAddr() }
+func (c *plainConn) RemoteAddr() net.Addr             { return c.remote }
+func (c *plainConn) SetDeadline(time.Time) error      { return nil }
+func (c *plainConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *plainConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestDTLS(t *testing.T) {
+	d := plainDTLS{configs: make(chan *tls.Config, 2)}
+	serverConfig, clientConfig := &tls.Config{ServerName: "server"}, &tls.Config{ServerName: "client"}
+	l, err := ListenConfig{}.ListenDTLS("127.0.0.1:0", DTLSConfig{DTLS: d, TLSConfig: serverConfig})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			_, _ = c.Write([]byte{0xfe, 1, 2, 3})
+		}
+	}()
+
+	c, err := Dialer{}.DialDTLS(l.Addr().String(), DTLSConfig{DTLS: d, TLSConfig: clientConfig})
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer c.Close()
+	if config := <-d.configs; config != serverConfig {
+		t.Fatalf("expected server TLS config to be passed to DTLS implementation")
+	}
+	if config := <-d.configs; config != clientConfig {
+		t.Fatalf("expected client TLS config to be passed to DTLS implementation")
+	}
+	_ = c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if _, err := (Dialer{}).DialDTLS(l.Addr().String(), DTLSConfig{}); err == nil {
+		t.Fatalf("expected error dialing without DTLS implementation")
+	}
+}
@@ -0,0 +1,59 @@
+package raknet
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+// String returns a one-line description of the connection, holding its addresses, GUID, MTU size and
+// latency. It is intended for logs and bug reports.
+func (conn *Conn) String() string {
+	return fmt.Sprintf("raknet.Conn(%v->%v guid=%v mtu=%v latency=%vms closed=%v)", conn.LocalAddr(), conn.addr, conn.id, conn.mtuSize, conn.Latency(), conn.closed())
+}
+
+// Dump returns a verbose, multi-line dump of the internal state of the connection, such as its send window,
+// queue depths, round-trip times and MTU sizes, for inclusion in bug reports and panic messages.
+// Dump does not block: If the write lock of the connection is held, for example because Dump is called while
+// a panic occurs during a write, the state guarded by it is left out.
+func (conn *Conn) Dump() string {
+	stats := conn.Stats()
+	b := bytes.NewBuffer(nil)
+	_, _ = fmt.Fprintf(b, "raknet.Conn %v -> %v\n", conn.LocalAddr(), conn.addr)
+	_, _ = fmt.Fprintf(b, "  guid: %v\n", conn.id)
+	_, _ = fmt.Fprintf(b, "  closed: %v\n", conn.closed())
+	_, _ = fmt.Fprintf(b, "  mtu size: %v\n", conn.mtuSize)
+	_, _ = fmt.Fprintf(b, "  latency: %vms\n", conn.Latency())
+	_, _ = fmt.Fprintf(b, "  rtt: count=%v mean=%v p50=%v p99=%v max=%v\n", stats.RTT.Count(), stats.RTT.Mean(), stats.RTT.Percentile(50), stats.RTT.Percentile(99), stats.RTT.Max())
+	_, _ = fmt.Fprintf(b, "  loss: outbound=%.3f inbound=%.3f\n", stats.OutboundLoss, stats.InboundLoss)
+	_, _ = fmt.Fprintf(b, "  memory usage: %v bytes\n", atomic.LoadInt64(&conn.memUsage))
+	_, _ = fmt.Fprintf(b, "  read queue: %v/%v (slow consumer drops=%v)\n", len(conn.packetChan), cap(conn.packetChan), stats.SlowConsumerDrops)
+	_, _ = fmt.Fprintf(b, "  datagrams: sent=%v received=%v resent=%v spurious=%v duplicate=%v\n", stats.DatagramsSent, stats.DatagramsReceived, stats.DatagramsResent, stats.SpuriousResends, stats.DuplicateDatagrams)
+	_, _ = fmt.Fprintf(b, "  acknowledgements: acks=%v nacks=%v\n", stats.ACKsReceived, stats.NACKsReceived)
+
+	if !conn.writeLock.TryLock() {
+		b.WriteString("  (write lock held: send state omitted)\n")
+		return b.String()
+	}
+	defer conn.writeLock.Unlock()
+	_, _ = fmt.Fprintf(b, "  path mtu: %v (discovery=%v)\n", conn.pathMTU, conn.pmtu.enabled)
+	window := "unlimited"
+	if conn.sendWindow > 0 {
+		window = fmt.Sprint(conn.sendWindow)
+	}
+	_, _ = fmt.Fprintf(b, "  send window: %v (awaiting ack=%v, queued=%v)\n", window, conn.recoveryQueue.Len(), len(conn.windowQueue))
+	_, _ = fmt.Fprintf(b, "  pending datagram: %v packets, %v bytes\n", len(conn.sendDatagram.packets), conn.sendDatagram.size())
+	_, _ = fmt.Fprintf(b, "  write batch: %v datagrams (window=%v)\n", len(conn.batch), conn.batchWindow)
+	_, _ = fmt.Fprintf(b, "  sequence: datagram=%v order=%v message=%v split=%v\n", conn.sendSequenceNumber, conn.sendOrderIndex, conn.sendMessageIndex, conn.sendSplitID)
+	return b.String()
+}
+
+// closed checks if the connection is closed.
+func (conn *Conn) closed() bool {
+	select {
+	case <-conn.closeCtx.Done():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,61 @@
+package raknet
+
+// Encapsulation describes how a message read from a Conn was encapsulated when it was received, so that
+// protocol bridges may write it to another connection with the same semantics.
+type Encapsulation struct {
+	// Reliability is the reliability that the message was sent with. Some RakNet implementations send
+	// messages with an ack receipt reliability, which is reported as the reliability number it has on the
+	// wire, between 5 and 7. Conn.WriteReliability does not accept these: They must be written as
+	// Unreliable, Reliable and ReliableOrdered respectively.
+	Reliability Reliability
+	// OrderChannel is the ordering channel of the message, if it was sequenced or ordered. Connections of this
+	// package always send messages on channel 0, but other implementations may use up to 32 channels.
+	OrderChannel byte
+	// Split specifies if the message was too large for a single datagram, so that it was split into
+	// SplitCount fragments with the split ID SplitID and reassembled when received.
+	Split      bool
+	SplitCount uint32
+	SplitID    uint16
+}
+
+// ReadEncapsulated reads a message from the connection into b like Read, but also returns the encapsulation
+// that the message was received with: Its reliability, ordering channel and split origin. A message may be
+// written to another connection with the same reliability using WriteReliability(b[:n], enc.Reliability),
+// unless it was received with an ack receipt reliability. Splice does this for every message.
+func (conn *Conn) ReadEncapsulated(b []byte) (n int, enc Encapsulation, err error) {
+	res, err := conn.read(b)
+	return res.n, res.enc, err
+}
+
+// orderedPacket is the content of a reliable ordered packet held in the ordered queue of a connection until
+// the packets before it arrive, along with its encapsulation.
+type orderedPacket struct {
+	content []byte
+	enc     Encapsulation
+}
+
+// encapsulation returns the Encapsulation of the packet.
+func (packet *packet) encapsulation() Encapsulation {
+	enc := Encapsulation{Reliability: Reliability(packet.reliability)}
+	if packet.sequencedOrOrdered() {
+		enc.OrderChannel = packet.orderChannel
+	}
+	if packet.split {
+		enc.Split, enc.SplitCount, enc.SplitID = true, packet.splitCount, packet.splitID
+	}
+	return enc
+}
+
+// withoutReceipt returns the reliability without an ack receipt, so that messages received with an ack
+// receipt reliability may be written using Conn.WriteReliability.
+func (r Reliability) withoutReceipt() Reliability {
+	switch r {
+	case 5:
+		return Unreliable
+	case 6:
+		return Reliable
+	case 7:
+		return ReliableOrdered
+	}
+	return r
+}
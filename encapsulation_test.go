@@ -0,0 +1,38 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReadEncapsulated(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+
+	large := bytes.Repeat([]byte{0xfe, 1, 2, 3}, 2000)
+	for _, test := range []struct {
+		payload     []byte
+		reliability Reliability
+		split       bool
+	}{
+		{[]byte{0xfe, 1}, Unreliable, false},
+		{large, ReliableOrdered, true},
+	} {
+		if _, err := a.WriteReliability(test.payload, test.reliability); err != nil {
+			t.Fatalf("error writing with %v: %v", test.reliability, err)
+		}
+		_ = b.SetReadDeadline(time.Now().Add(time.Second * 5))
+		buf := make([]byte, 10000)
+		n, enc, err := b.ReadEncapsulated(buf)
+		if err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(buf[:n], test.payload) || enc.Reliability != test.reliability || enc.OrderChannel != 0 {
+			t.Fatalf("expected %v bytes with %v on channel 0, got %v bytes with %+v", len(test.payload), test.reliability, n, enc)
+		}
+		if enc.Split != test.split || (test.split && enc.SplitCount < 2) {
+			t.Fatalf("expected split to be %v, got %+v", test.split, enc)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package raknet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+var (
+	// ErrClosed is the error returned when using a Conn or Listener that was closed. Errors returned for this
+	// reason may be checked using errors.Is(err, ErrClosed). They also match net.ErrClosed.
+	ErrClosed error = &netError{msg: "use of closed network connection", err: net.ErrClosed}
+	// ErrTimeout is the error returned when a deadline set on a Conn passes, or when a connection could not
+	// be established in time. Errors returned for this reason implement net.Error and return true from
+	// Timeout. They also match os.ErrDeadlineExceeded.
+	ErrTimeout error = &netError{msg: "i/o timeout", timeout: true, temporary: true, err: os.ErrDeadlineExceeded}
+	// ErrIncompatibleProtocol is the error returned by a Dialer when the server does not support the protocol
+	// version of the Dialer.
+	ErrIncompatibleProtocol = errors.New("incompatible protocol version")
+	// ErrAlreadyConnected is the error returned by a Dialer when the server already has a connection with the
+	// address or GUID of the Dialer.
+	ErrAlreadyConnected = errors.New("already connected")
+	// ErrServerFull is the error returned by a Dialer when the server has no free incoming connections.
+	ErrServerFull = errors.New("no free incoming connections")
+	// ErrBanned is the error returned by a Dialer when the server banned the address or GUID of the Dialer.
+	ErrBanned = errors.New("connection banned")
+)
+
+// DisconnectError is the error returned when using a Conn that was closed because the other end sent a
+// disconnect notification. Some RakNet implementations append a reason to the notification, which is held in
+// Reason. DisconnectErrors may be checked using errors.Is(err, ErrClosed), like other errors returned when
+// using a closed Conn.
+type DisconnectError struct {
+	// Reason is the data following the ID of the disconnect notification, or nil if there was none.
+	Reason []byte
+}
+
+// Error ...
+func (e *DisconnectError) Error() string {
+	if len(e.Reason) == 0 {
+		return "connection closed by remote"
+	}
+	return fmt.Sprintf("connection closed by remote: %q", e.Reason)
+}
+
+// Unwrap ...
+func (e *DisconnectError) Unwrap() error {
+	return ErrClosed
+}
+
+// netError is an error that implements net.Error. It wraps an error that it may be compared with using
+// errors.Is, such as one of the sentinel errors of this package.
+type netError struct {
+	msg                string
+	timeout, temporary bool
+	err                error
+}
+
+// wrapNetError returns a net.Error with the message passed that wraps the netError passed and takes over its
+// Timeout and Temporary values.
+func wrapNetError(err error, msg string) error {
+	e := err.(*netError)
+	return &netError{msg: msg, timeout: e.timeout, temporary: e.temporary, err: err}
+}
+
+// opError returns a *net.OpError for the operation op between the addresses passed, wrapping err, so that
+// errors are formatted like those of the standard library, such as 'read raknet 1.2.3.4:19132: i/o timeout'.
+func opError(op string, source, addr net.Addr, err error) error {
+	return &net.OpError{Op: op, Net: "raknet", Source: source, Addr: addr, Err: err}
+}
+
+// dialError returns an error with the message passed wrapping err. If err is a timeout, the error returned
+// wraps ErrTimeout, so that it implements net.Error.
+func dialError(msg string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return wrapNetError(ErrTimeout, fmt.Sprintf("%v: %v", msg, err))
+	}
+	return fmt.Errorf("%v: %w", msg, err)
+}
+
+// Error ...
+func (e *netError) Error() string {
+	return e.msg
+}
+
+// Timeout ...
+func (e *netError) Timeout() bool {
+	return e.timeout
+}
+
+// Temporary ...
+func (e *netError) Temporary() bool {
+	return e.temporary
+}
+
+// Unwrap ...
+func (e *netError) Unwrap() error {
+	return e.err
+}
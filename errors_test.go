@@ -0,0 +1,44 @@
+package raknet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConnErrors(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer pc.Close()
+	conn := newConn(pc, pc.LocalAddr(), 1400, 1, connConfig{})
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+	_, err = conn.Read(make([]byte, 1500))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected a net.Error timeout from Read, got %v", err)
+	}
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, os.ErrDeadlineExceeded) || !ErrReadTimeout(err) {
+		t.Errorf("expected %v to match ErrTimeout and os.ErrDeadlineExceeded", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "read" || opErr.Net != "raknet" || opErr.Addr != conn.RemoteAddr() {
+		t.Errorf("expected a *net.OpError for read from %v, got %#v", conn.RemoteAddr(), err)
+	}
+	if expected := "read raknet " + conn.LocalAddr().String() + "->" + conn.RemoteAddr().String() + ": i/o timeout"; err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err.Error())
+	}
+
+	_ = conn.Close()
+	_, err = conn.Read(make([]byte, 1500))
+	if !errors.Is(err, ErrClosed) || !errors.Is(err, net.ErrClosed) || !ErrConnectionClosed(err) {
+		t.Errorf("expected %v from Read to match ErrClosed and net.ErrClosed", err)
+	}
+	_, err = conn.Write([]byte{0xfe})
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v from Write to match ErrClosed", err)
+	}
+}
@@ -0,0 +1,141 @@
+package raknet
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventHandshakeStarted is emitted when a client sends an open connection request 2, after which a
+	// connection is created for it that completes the connection sequence.
+	EventHandshakeStarted EventType = iota
+	// EventAccepted is emitted when a connection completed the connection sequence and was accepted.
+	EventAccepted
+	// EventTimedOut is emitted when a connection did not complete the connection sequence in time.
+	EventTimedOut
+	// EventResumed is emitted when a client with an established connection completes an open connection
+	// request 2 again from the same address, for example after it lost its state. The old connection is
+	// closed and replaced by the new one. This happens only if the request held a valid cookie, or the GUID of
+	// the old connection after an open connection request 1 of the address was answered, and the new
+	// connection was not refused.
+	EventResumed
+	// EventClosed is emitted when an accepted connection is closed. If the client closed it by sending a
+	// disconnect notification, the Err field of the Event holds a *DisconnectError.
+	EventClosed
+	// EventErrored is emitted when a packet of a connection could not be handled. The Err field of the Event
+	// holds the error, which is a *PanicError if the handling panicked and ListenConfig.RecoverPanics is set.
+	EventErrored
+	// EventSlowConsumer is emitted when the read queue of a connection fills up because the application does
+	// not call Read fast enough. The slow consumer policy of the connection is applied after. It is emitted
+	// again only once the read queue was empty in between.
+	EventSlowConsumer
+	// EventHandshakeFailed is emitted when a client fails to complete the connection sequence. The Err field
+	// of the Event holds a *HandshakeError with the reason. A connection sequence that timed out emits both an
+	// EventTimedOut and an EventHandshakeFailed.
+	EventHandshakeFailed
+)
+
+// String returns the name of the event type, such as "accepted".
+func (t EventType) String() string {
+	switch t {
+	case EventHandshakeStarted:
+		return "handshake started"
+	case EventAccepted:
+		return "accepted"
+	case EventTimedOut:
+		return "timed out"
+	case EventResumed:
+		return "resumed"
+	case EventClosed:
+		return "closed"
+	case EventErrored:
+		return "errored"
+	case EventSlowConsumer:
+		return "slow consumer"
+	case EventHandshakeFailed:
+		return "handshake failed"
+	}
+	return "unknown"
+}
+
+// Event is an event in the lifecycle of a connection of a Listener.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+	// Time is the time at which the event occurred.
+	Time time.Time
+	// Addr is the address of the client that the event concerns.
+	Addr net.Addr
+	// GUID is the GUID of the client, or 0 if it is not yet known.
+	GUID int64
+	// Err is the error that caused the event, if any.
+	Err error
+}
+
+// eventBus sends events to subscribers. Events are sent without blocking: If the channel of a subscriber
+// is full, the event is dropped for that subscriber.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// subscribe adds a new subscriber with a channel of the buffer size passed.
+func (bus *eventBus) subscribe(buffer int) chan Event {
+	c := make(chan Event, buffer)
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.subscribers == nil {
+		bus.subscribers = make(map[chan Event]struct{})
+	}
+	bus.subscribers[c] = struct{}{}
+	return c
+}
+
+// unsubscribe removes the subscriber with the channel passed and closes the channel.
+func (bus *eventBus) unsubscribe(c chan Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if _, ok := bus.subscribers[c]; ok {
+		delete(bus.subscribers, c)
+		close(c)
+	}
+}
+
+// emit sends an event to all subscribers.
+func (bus *eventBus) emit(event Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for c := range bus.subscribers {
+		select {
+		case c <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe subscribes to the events of the connections of the listener. Events are sent on the channel
+// returned, which has the buffer size passed. Events are never blocked on: If the channel is full, events
+// are dropped until there is room again. The function returned unsubscribes and closes the channel.
+func (listener *Listener) Subscribe(buffer int) (<-chan Event, func()) {
+	c := listener.events.subscribe(buffer)
+	return c, func() {
+		listener.events.unsubscribe(c)
+	}
+}
+
+// emit emits an event of the type passed for the connection, if it was created by a Listener.
+func (conn *Conn) emit(t EventType, err error) {
+	if conn.events == nil {
+		return
+	}
+	conn.events.emit(Event{Type: t, Time: conn.clock.Now(), Addr: conn.addr, GUID: conn.id, Err: err})
+}
+
+// emit emits an event of the type passed for the address and GUID passed.
+func (listener *Listener) emit(t EventType, addr net.Addr, guid int64, err error) {
+	listener.events.emit(Event{Type: t, Time: listener.config.clock.Now(), Addr: addr, GUID: guid, Err: err})
+}
@@ -0,0 +1,69 @@
+//go:build ignore
+
+// This example runs RakNet over DTLS using github.com/pion/dtls, which is not a dependency of this module. It
+// is excluded from builds, and may be run using `go run dtls.go` in a module that requires pion/dtls.
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
+	"github.com/sandertv/go-raknet"
+)
+
+func main() {
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		panic(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}
+
+	// The DTLS listener accepts a net.Conn per client, which preserves the boundaries of datagrams. The
+	// ListenerTransport turns these connections into a single Transport that a RakNet Listener runs over.
+	dtlsListener, err := dtls.Listen("udp", addr, &dtls.Config{
+		Certificates:         []tls.Certificate{cert},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	})
+	if err != nil {
+		panic(err)
+	}
+	listener, err := raknet.ListenConfig{}.ListenTransport(raknet.ListenerTransport(dtlsListener))
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte{0xfe, 'h', 'i'})
+		}
+	}()
+
+	// The client side dials a single DTLS connection, which the ConnTransport turns into a Transport.
+	dtlsConn, err := dtls.Dial("udp", addr, &dtls.Config{
+		Certificates:         []tls.Certificate{cert},
+		InsecureSkipVerify:   true,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	})
+	if err != nil {
+		panic(err)
+	}
+	conn, err := raknet.Dialer{}.DialTransport(raknet.ConnTransport(dtlsConn), addr)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	b := make([]byte, 1500)
+	n, err := conn.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("received %q over RakNet over DTLS\n", b[1:n])
+}
@@ -1,75 +1,25 @@
 package main
 
 import (
-	"github.com/sandertv/go-raknet"
 	"log"
+
+	"github.com/sandertv/go-raknet"
 )
 
 func main() {
-	listener, err := raknet.Listen("0.0.0.0:19132")
-	defer func() {
-		_ = listener.Close()
-	}()
+	// We proxy all clients to a Minecraft server. A new connection with the server is spun up each time a
+	// client connects to the proxy, and the pong data of the server is continuously forwarded to clients.
+	proxy, err := raknet.ProxyConfig{
+		OnConnect: func(client, server *raknet.Conn) error {
+			log.Printf("proxying %v to %v\n", client.RemoteAddr(), server.RemoteAddr())
+			return nil
+		},
+	}.Listen("0.0.0.0:19132", "mco.mineplex.com:19132")
 	if err != nil {
 		panic(err)
 	}
-	// We hijack the pong of a Minecraft server, so our proxy will continuously send the pong data of the
-	// server.
-	if err := listener.HijackPong("mco.mineplex.com:19132"); err != nil {
-		panic(err)
-	}
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			panic(err)
-		}
-		// We spin up a new connection with the server each time a client connects to the proxy.
-		server, err := raknet.Dial("mco.mineplex.com:19132")
-		if err != nil {
-			panic(err)
-		}
-		go func() {
-			b := make([]byte, 300000)
-			for {
-				n, err := conn.Read(b)
-				if err != nil {
-					if !raknet.ErrConnectionClosed(err) {
-						log.Printf("error reading from client connection: %v\n", err)
-					}
-					_ = server.Close()
-					return
-				}
-				packet := b[:n]
-				if _, err := server.Write(packet); err != nil {
-					if !raknet.ErrConnectionClosed(err) {
-						log.Printf("error writing to server connection: %v\n", err)
-					}
-					_ = conn.Close()
-					return
-				}
-			}
-		}()
-		go func() {
-			b := make([]byte, 300000)
-			for {
-				n, err := server.Read(b)
-				if err != nil {
-					if !raknet.ErrConnectionClosed(err) {
-						log.Printf("error reading from server connection: %v\n", err)
-					}
-					_ = conn.Close()
-					return
-				}
-				packet := b[:n]
-				if _, err := conn.Write(packet); err != nil {
-					if !raknet.ErrConnectionClosed(err) {
-						log.Printf("error writing to client connection: %v\n", err)
-					}
-					_ = server.Close()
-					return
-				}
-			}
-		}()
-	}
+	defer func() {
+		_ = proxy.Close()
+	}()
+	proxy.Listener().Wait()
 }
@@ -0,0 +1,67 @@
+package raknet
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarMu guards the publishing of expvar variables, so that listeners created simultaneously with the same
+// prefix do not both find the names free and then both publish them, which would panic.
+var expvarMu sync.Mutex
+
+// publishExpvar publishes the counters of the listener using the expvar package, with names starting with
+// the prefix passed. The variables are read from the same counters returned by Listener.Stats. As expvar
+// variables cannot be removed, they remain published after the listener is closed.
+func (listener *Listener) publishExpvar(prefix string) error {
+	vars := map[string]expvar.Func{
+		"connections": func() interface{} {
+			return listener.Stats().Connections
+		},
+		"datagrams_sent": func() interface{} {
+			return listener.Stats().DatagramsSent
+		},
+		"datagrams_received": func() interface{} {
+			return listener.Stats().DatagramsReceived
+		},
+		"datagrams_resent": func() interface{} {
+			return listener.Stats().DatagramsResent
+		},
+		"spurious_resends": func() interface{} {
+			return listener.Stats().SpuriousResends
+		},
+		"duplicate_datagrams": func() interface{} {
+			return listener.Stats().DuplicateDatagrams
+		},
+		"slow_consumer_drops": func() interface{} {
+			return listener.Stats().SlowConsumerDrops
+		},
+		"handshake_failures": func() interface{} {
+			return listener.Stats().HandshakeFailures
+		},
+		"handshake_failure_reasons": func() interface{} {
+			return listener.Stats().HandshakeFailureReasons
+		},
+		"rate_limit_drops": func() interface{} {
+			return listener.Stats().RateLimitDrops
+		},
+		"blackhole_drops": func() interface{} {
+			return listener.Stats().BlackholeDrops
+		},
+		"packets": func() interface{} {
+			return listener.Stats().Packets
+		},
+	}
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	// We first make sure none of the names are taken, as expvar.Publish panics if they are.
+	for name := range vars {
+		if expvar.Get(prefix+"."+name) != nil {
+			return fmt.Errorf("expvar %v.%v already published", prefix, name)
+		}
+	}
+	for name, f := range vars {
+		expvar.Publish(prefix+"."+name, f)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package raknet
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpvar(t *testing.T) {
+	// Published variables cannot be removed, so the prefix is unique for every run of the test.
+	prefix := fmt.Sprintf("raknet_expvar_test_%v", time.Now().UnixNano())
+	l, err := ListenConfig{ExpvarPrefix: prefix}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := client.Write([]byte{0xfe, byte(i)}); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		_ = server.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := server.Read(make([]byte, 1500)); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+	}
+
+	get := func(name string, v interface{}) {
+		variable := expvar.Get(prefix + "." + name)
+		if variable == nil {
+			t.Fatalf("expvar %v not published", name)
+		}
+		if err := json.Unmarshal([]byte(variable.String()), v); err != nil {
+			t.Fatalf("error decoding expvar %v: %v", name, err)
+		}
+	}
+	var connections int
+	get("connections", &connections)
+	if connections != 1 {
+		t.Fatalf("expected 1 connection, got %v", connections)
+	}
+	var received uint64
+	get("datagrams_received", &received)
+	if received < 10 || received > l.Stats().DatagramsReceived {
+		t.Fatalf("expected at least 10 and at most %v datagrams received, got %v", l.Stats().DatagramsReceived, received)
+	}
+	var packets PacketStats
+	get("packets", &packets)
+	if packets.OpenConnectionRequests1 != 1 || packets.OpenConnectionRequests2 != 1 || packets.Datagrams < 10 {
+		t.Fatalf("unexpected packet stats %+v", packets)
+	}
+	var failures HandshakeFailureStats
+	get("handshake_failure_reasons", &failures)
+	if failures != (HandshakeFailureStats{}) {
+		t.Fatalf("expected no handshake failures, got %+v", failures)
+	}
+}
+
+func TestExpvarDuplicatePrefix(t *testing.T) {
+	// Listeners created simultaneously with the same prefix must not panic: Only one of them may publish its
+	// statistics, and the others fail to listen.
+	const n = 8
+	prefix := fmt.Sprintf("raknet_expvar_duplicate_test_%v", time.Now().UnixNano())
+	var wg sync.WaitGroup
+	listeners := make(chan *Listener, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l, err := (ListenConfig{ExpvarPrefix: prefix}).Listen("127.0.0.1:0"); err == nil {
+				listeners <- l
+			}
+		}()
+	}
+	wg.Wait()
+	close(listeners)
+	count := 0
+	for l := range listeners {
+		count++
+		_ = l.Close()
+	}
+	if count != 1 {
+		t.Fatalf("expected exactly one listener to publish its statistics, got %v", count)
+	}
+}
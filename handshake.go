@@ -0,0 +1,159 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// HandshakeFailureReason is the reason that a client failed to complete the connection sequence with a
+// Listener.
+type HandshakeFailureReason int
+
+const (
+	// HandshakeInvalidPacket means the client sent an open connection request that could not be decoded.
+	HandshakeInvalidPacket HandshakeFailureReason = iota
+	// HandshakeIncompatibleProtocol means the client attempted to connect using a protocol version other
+	// than that of the Listener.
+	HandshakeIncompatibleProtocol
+	// HandshakeTimeout means the client did not complete the connection sequence in time after sending an
+	// open connection request 2.
+	HandshakeTimeout
+	// HandshakeSecurityFailed means the client of a Listener that secures its connections or requires
+	// cookies did not send back the cookie of the Listener, or sent an invalid challenge for the key
+	// exchange.
+	HandshakeSecurityFailed
+)
+
+// String returns a short description of the reason, such as "incompatible protocol".
+func (reason HandshakeFailureReason) String() string {
+	switch reason {
+	case HandshakeInvalidPacket:
+		return "invalid packet"
+	case HandshakeIncompatibleProtocol:
+		return "incompatible protocol"
+	case HandshakeTimeout:
+		return "timeout"
+	case HandshakeSecurityFailed:
+		return "security failed"
+	}
+	return "unknown"
+}
+
+// HandshakeError is the error held by an Event of the type EventHandshakeFailed. It holds the reason that
+// the handshake failed.
+type HandshakeError struct {
+	// Reason is the reason that the handshake failed.
+	Reason HandshakeFailureReason
+	// Err is the error that caused the handshake to fail.
+	Err error
+}
+
+// Error ...
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake failed (%v): %v", e.Reason, e.Err)
+}
+
+// Unwrap ...
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// HandshakeFailureStats holds the amount of handshakes that failed per reason.
+type HandshakeFailureStats struct {
+	// InvalidPacket is the amount of handshakes that failed because an open connection request could not be
+	// decoded.
+	InvalidPacket uint64
+	// IncompatibleProtocol is the amount of handshakes that failed because the client used an incompatible
+	// protocol version.
+	IncompatibleProtocol uint64
+	// Timeout is the amount of handshakes that failed because the client did not complete the connection
+	// sequence in time.
+	Timeout uint64
+	// SecurityFailed is the amount of handshakes that failed because the client did not complete the key
+	// exchange of a secured connection.
+	SecurityFailed uint64
+}
+
+// handshakeFailed records a handshake of the client with the address and GUID passed that failed for the
+// reason passed, and emits an EventHandshakeFailed. guid is 0 if not yet known.
+func (listener *Listener) handshakeFailed(addr net.Addr, guid int64, reason HandshakeFailureReason, err error) {
+	atomic.AddUint64(&listener.counters.handshakeFailures, 1)
+	switch reason {
+	case HandshakeInvalidPacket:
+		atomic.AddUint64(&listener.counters.handshakeInvalidPacket, 1)
+	case HandshakeIncompatibleProtocol:
+		atomic.AddUint64(&listener.counters.handshakeIncompatibleProtocol, 1)
+	case HandshakeTimeout:
+		atomic.AddUint64(&listener.counters.handshakeTimeout, 1)
+	case HandshakeSecurityFailed:
+		atomic.AddUint64(&listener.counters.handshakeSecurityFailed, 1)
+	}
+	listener.emit(EventHandshakeFailed, addr, guid, &HandshakeError{Reason: reason, Err: err})
+	listener.reject(addr, handshakeRejectReasons[reason], err)
+}
+
+// resumable returns the established connection of the address passed if its client may replace it by
+// starting the connection sequence again, for example because it lost its state, or nil if not. The open
+// connection request 2 must have held a valid cookie, or the GUID of the connection after the address had an
+// open connection request 1 answered, so that a connection cannot be torn down by a single request sent from
+// a spoofed address.
+func (listener *Listener) resumable(addr net.Addr, guid int64) *Conn {
+	value, ok := listener.connections.Load(addr.String())
+	if !ok {
+		return nil
+	}
+	conn := value.(*Conn)
+	if conn.completingSequence.Err() == nil {
+		return nil
+	}
+	if listener.cookies == nil && (conn.id != guid || !listener.mtuProbes.probed(addr.String(), listener.config.clock.Now())) {
+		return nil
+	}
+	return conn
+}
+
+// resume closes the connection passed, which is replaced by a new connection of its client once the
+// connection sequence was started again and every check of the open connection request 2 passed.
+func (listener *Listener) resume(conn *Conn) {
+	_ = conn.Close()
+	listener.connections.Delete(conn.addr.String())
+	listener.emit(EventResumed, conn.addr, conn.id, nil)
+}
+
+// connectedAs checks if a connection of the listener other than that of the address passed has the GUID
+// passed, or if the address passed has a connection with another GUID. The connection resumed, which may be
+// nil, is not taken into account, as it is about to be replaced.
+func (listener *Listener) connectedAs(addr net.Addr, guid int64, resumed *Conn) (connected bool) {
+	if value, ok := listener.connections.Load(addr.String()); ok && value != resumed {
+		return true
+	}
+	listener.connections.Range(func(key, value interface{}) bool {
+		connected = value != resumed && value.(*Conn).id == guid
+		return !connected
+	})
+	return connected
+}
+
+// connectionCount returns the amount of connections the listener currently holds.
+func (listener *Listener) connectionCount() (n int) {
+	listener.connections.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// refuse writes a message with the ID passed, which is either idAlreadyConnected,
+// idNoFreeIncomingConnections or idConnectionBanned, to buffer b and sends it to the address passed.
+func (listener *Listener) refuse(b *bytes.Buffer, id byte, addr net.Addr) error {
+	b.Reset()
+	b.WriteByte(id)
+	_ = binary.Write(b, binary.BigEndian, &connectionRefused{Magic: listener.magic, ServerGUID: listener.id})
+	if _, err := listener.conn.WriteTo(b.Bytes(), addr); err != nil {
+		return fmt.Errorf("error sending connection refusal: %v", err)
+	}
+	return nil
+}
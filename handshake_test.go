@@ -0,0 +1,74 @@
+package raknet
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeFailureIncompatibleProtocol(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	events, unsubscribe := l.Subscribe(16)
+	defer unsubscribe()
+
+	_, err = Dialer{Protocol: MinecraftProtocol + 1}.Dial(l.Addr().String())
+	if !errors.Is(err, ErrIncompatibleProtocol) {
+		t.Fatalf("expected dial to fail with ErrIncompatibleProtocol, got %v", err)
+	}
+	select {
+	case e := <-events:
+		var handshakeErr *HandshakeError
+		if e.Type != EventHandshakeFailed || !errors.As(e.Err, &handshakeErr) || handshakeErr.Reason != HandshakeIncompatibleProtocol {
+			t.Fatalf("expected handshake failed event with incompatible protocol reason, got %v: %v", e.Type, e.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no handshake failed event emitted")
+	}
+	if n := l.Stats().HandshakeFailureReasons.IncompatibleProtocol; n == 0 {
+		t.Errorf("expected incompatible protocol handshake failure to be counted")
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	l, err := ListenConfig{MaxConnections: 1}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer conn.Close()
+	addr := rakAddr(*l.Addr().(*net.UDPAddr))
+	request, _ := (&openConnectionRequest2{Magic: magic, ServerAddress: &addr, MTUSize: 1400, ClientGUID: client.id}).MarshalBinary()
+	if _, err := conn.WriteTo(append([]byte{idOpenConnectionRequest2}, request...), l.Addr()); err != nil {
+		t.Fatalf("error writing request: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1500)
+	if _, _, err := conn.ReadFrom(b); err != nil || b[0] != idAlreadyConnected {
+		t.Fatalf("expected already connected in response to request with GUID in use, got %x (%v)", b[0], err)
+	}
+
+	if _, err := Dial(l.Addr().String()); !errors.Is(err, ErrServerFull) {
+		t.Fatalf("expected dialing full listener to fail with ErrServerFull, got %v", err)
+	}
+	l.SetLimits(Limits{MaxConnections: 2})
+	other, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing after raising maximum connections: %v", err)
+	}
+	_ = other.Close()
+}
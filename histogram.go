@@ -0,0 +1,145 @@
+package raknet
+
+import (
+	"encoding/json"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// histogramLinearBuckets is the amount of buckets holding a single microsecond value each. Values above it
+	// are held in logarithmic buckets, histogramSubBuckets per power of two, so that each bucket spans at
+	// most 1/8th of its lower bound.
+	histogramLinearBuckets = 16
+	histogramSubBuckets    = 8
+	// histogramBuckets is the total amount of buckets, which covers values up to 2^37 microseconds.
+	histogramBuckets = histogramLinearBuckets + 33*histogramSubBuckets
+)
+
+// histogram is a histogram of durations with log-linear buckets, similar to an HDR histogram. Its fields
+// are updated atomically, so a histogram must be 64-bit aligned.
+type histogram struct {
+	count   uint64
+	sum     uint64
+	max     uint64
+	buckets [histogramBuckets]uint64
+}
+
+// record records a duration in the histogram.
+func (h *histogram) record(d time.Duration) {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d / time.Microsecond)
+	}
+	atomic.AddUint64(&h.buckets[histogramIndex(v)], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, v)
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if v <= max || atomic.CompareAndSwapUint64(&h.max, max, v) {
+			break
+		}
+	}
+}
+
+// snapshot returns a Histogram holding the current values of the histogram.
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		count: atomic.LoadUint64(&h.count),
+		sum:   atomic.LoadUint64(&h.sum),
+		max:   atomic.LoadUint64(&h.max),
+	}
+	for i := range h.buckets {
+		s.buckets[i] = atomic.LoadUint64(&h.buckets[i])
+	}
+	return s
+}
+
+// histogramIndex returns the index of the bucket that holds the value v in microseconds.
+func histogramIndex(v uint64) int {
+	if v < histogramLinearBuckets {
+		return int(v)
+	}
+	// e is the shift that leaves the 4 most significant bits of v, which are between 8 and 15.
+	e := bits.Len64(v) - 4
+	index := histogramLinearBuckets + (e-1)*histogramSubBuckets + int(v>>uint(e)) - histogramSubBuckets
+	if index >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return index
+}
+
+// histogramValue returns the value in microseconds in the middle of the bucket with the index passed.
+func histogramValue(index int) uint64 {
+	if index < histogramLinearBuckets {
+		return uint64(index)
+	}
+	e := uint((index-histogramLinearBuckets)/histogramSubBuckets + 1)
+	m := uint64((index-histogramLinearBuckets)%histogramSubBuckets + histogramSubBuckets)
+	return m<<e + (1<<e)/2
+}
+
+// Histogram is a snapshot of a histogram of round-trip times. Values are recorded in log-linear buckets,
+// so that percentiles are accurate to within about 6% of their value.
+type Histogram struct {
+	count   uint64
+	sum     uint64
+	max     uint64
+	buckets [histogramBuckets]uint64
+}
+
+// Count returns the amount of values recorded in the histogram.
+func (h Histogram) Count() uint64 {
+	return h.count
+}
+
+// Mean returns the mean of the values recorded in the histogram, or 0 if no values were recorded.
+func (h Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum/h.count) * time.Microsecond
+}
+
+// Max returns the highest value recorded in the histogram.
+func (h Histogram) Max() time.Duration {
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// Percentile returns the value below which the percentage p of values recorded fall, where p is between 0
+// and 100. Percentile(99) returns the p99, for example. If no values were recorded, 0 is returned.
+func (h Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	target := uint64(p / 100 * float64(h.count))
+	if target >= h.count {
+		target = h.count - 1
+	}
+	var seen uint64
+	for i, n := range h.buckets {
+		seen += n
+		if seen > target {
+			v := histogramValue(i)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+	return h.Max()
+}
+
+// MarshalJSON encodes the count, mean, maximum and most used percentiles of the histogram as JSON, with the
+// durations in nanoseconds.
+func (h Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"count": h.Count(),
+		"mean":  h.Mean(),
+		"p50":   h.Percentile(50),
+		"p95":   h.Percentile(95),
+		"p99":   h.Percentile(99),
+		"max":   h.Max(),
+	})
+}
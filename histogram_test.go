@@ -0,0 +1,31 @@
+package raknet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := &histogram{}
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	s := h.snapshot()
+	if s.Count() != 1000 {
+		t.Errorf("expected count 1000, but got %v", s.Count())
+	}
+	if s.Max() != time.Second {
+		t.Errorf("expected max 1s, but got %v", s.Max())
+	}
+	for _, p := range []float64{50, 95, 99} {
+		expected := time.Duration(p*10) * time.Millisecond
+		if got := s.Percentile(p); got < expected*15/16 || got > expected*17/16 {
+			t.Errorf("expected p%v of about %v, but got %v", p, expected, got)
+		}
+	}
+	for v := uint64(0); v < 1<<20; v += 7 {
+		if index := histogramIndex(v); index > 0 && histogramIndex(v-1) > index {
+			t.Fatalf("histogram index decreased at value %v", v)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package interop runs scripted handshake and reliability scenarios against an external RakNet endpoint, such
+// as a server running the reference C++ RakNet library, NukkitX or gophertunnel, and reports where it
+// diverges from the behaviour this package expects. It may be used in tests to guard protocol compatibility:
+//
+//	report := interop.Run("127.0.0.1:19132", interop.Options{}, interop.Scenarios...)
+//	if report.Failed() {
+//		t.Fatal(report)
+//	}
+package interop
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// Options holds the options passed to each Scenario.
+type Options struct {
+	// Protocol is the RakNet protocol version that the endpoint accepts.
+	// Protocol is raknet.MinecraftProtocol by default.
+	Protocol byte
+	// Echo specifies if the endpoint writes every packet received by a connection back to it. Scenarios that
+	// verify the delivery of packets are skipped if Echo is false.
+	Echo bool
+	// Timeout is the time each Scenario may take before it fails.
+	// Timeout is 10 seconds by default.
+	Timeout time.Duration
+}
+
+// Scenario is a scripted interaction with an endpoint.
+type Scenario struct {
+	// Name is the name of the scenario, such as "handshake".
+	Name string
+	// Run runs the scenario against the endpoint with the address passed. It returns ErrSkipped if the
+	// scenario cannot run with the Options passed, or an error describing the divergence if the endpoint did
+	// not behave as expected.
+	Run func(addr string, opts Options) error
+}
+
+// ErrSkipped is returned by a Scenario that cannot run with the Options passed.
+var ErrSkipped = errors.New("skipped")
+
+// Scenarios holds all scenarios shipped by the package, in the order in which they should be run.
+var Scenarios = []Scenario{
+	{Name: "ping", Run: ping},
+	{Name: "handshake", Run: handshake},
+	{Name: "incompatible protocol", Run: incompatibleProtocol},
+	{Name: "latency", Run: latency},
+	{Name: "ordered echo", Run: orderedEcho},
+	{Name: "split echo", Run: splitEcho},
+	{Name: "echo under loss", Run: lossyEcho},
+}
+
+// Result is the result of running a single Scenario.
+type Result struct {
+	// Scenario is the name of the scenario.
+	Scenario string
+	// Err is the divergence found, ErrSkipped if the scenario was skipped, or nil if it passed.
+	Err error
+	// Duration is the time the scenario took.
+	Duration time.Duration
+}
+
+// Report holds the results of all scenarios run against an endpoint.
+type Report struct {
+	// Addr is the address of the endpoint.
+	Addr    string
+	Results []Result
+}
+
+// Failed reports if any of the scenarios found a divergence.
+func (report Report) Failed() bool {
+	for _, r := range report.Results {
+		if r.Err != nil && r.Err != ErrSkipped {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a line for each scenario stating whether it passed, was skipped or failed.
+func (report Report) String() string {
+	b := &strings.Builder{}
+	_, _ = fmt.Fprintf(b, "interop report for %v:\n", report.Addr)
+	for _, r := range report.Results {
+		status := "PASS"
+		switch {
+		case r.Err == ErrSkipped:
+			status = "SKIP"
+		case r.Err != nil:
+			status = "FAIL: " + r.Err.Error()
+		}
+		_, _ = fmt.Fprintf(b, "  %-24v %v (%v)\n", r.Scenario, status, r.Duration.Round(time.Millisecond))
+	}
+	return b.String()
+}
+
+// Run runs the scenarios passed against the endpoint with the address passed, one after another, and
+// returns a Report with their results.
+func Run(addr string, opts Options, scenarios ...Scenario) Report {
+	if opts.Protocol == 0 {
+		opts.Protocol = raknet.MinecraftProtocol
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second * 10
+	}
+	report := Report{Addr: addr}
+	for _, s := range scenarios {
+		start := time.Now()
+		err := s.Run(addr, opts)
+		report.Results = append(report.Results, Result{Scenario: s.Name, Err: err, Duration: time.Since(start)})
+	}
+	return report
+}
+
+// ping checks that the endpoint responds to an unconnected ping.
+func ping(addr string, opts Options) error {
+	if _, err := (raknet.Dialer{Protocol: opts.Protocol}).Ping(addr); err != nil {
+		return fmt.Errorf("no unconnected pong received: %v", err)
+	}
+	return nil
+}
+
+// handshake checks that the connection sequence completes.
+func handshake(addr string, opts Options) error {
+	conn, err := dial(addr, opts, nil)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// incompatibleProtocol checks that the endpoint rejects a protocol version it does not support with an
+// incompatible protocol version packet.
+func incompatibleProtocol(addr string, opts Options) error {
+	conn, err := (raknet.Dialer{Protocol: opts.Protocol + 1, ErrorLog: discardLog()}).Dial(addr)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("connection with protocol %v was accepted, expected it to be rejected", opts.Protocol+1)
+	}
+	if !errors.Is(err, raknet.ErrIncompatibleProtocol) {
+		return fmt.Errorf("expected incompatible protocol version, got: %v", err)
+	}
+	return nil
+}
+
+// latency checks that the endpoint answers connected pings, so that the latency can be measured.
+func latency(addr string, opts Options) error {
+	conn, err := dial(addr, opts, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(opts.Timeout)
+	for time.Now().Before(deadline) {
+		conn.Ping()
+		time.Sleep(time.Millisecond * 100)
+		if conn.Stats().RTT.Count() > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("no connected pong received")
+}
+
+// orderedEcho checks that packets written are echoed back in order.
+func orderedEcho(addr string, opts Options) error {
+	return echo(addr, opts, nil, 100, 2)
+}
+
+// splitEcho checks that packets too large for a single datagram are split and reassembled correctly.
+func splitEcho(addr string, opts Options) error {
+	return echo(addr, opts, nil, 5, 20000)
+}
+
+// lossyEcho checks that packets written are echoed back in order if datagrams are lost in both directions,
+// so that resends and NACKs of the endpoint are exercised.
+func lossyEcho(addr string, opts Options) error {
+	wrap := func(conn net.PacketConn) net.PacketConn {
+		return raknet.SimulateNetwork(conn, raknet.NetworkConditions{Loss: 0.1, Reordering: 0.1})
+	}
+	return echo(addr, opts, wrap, 100, 2)
+}
+
+// echo writes count packets of the size passed and checks that they are echoed back in order. The
+// connection is dialed with the WrapConn function passed.
+func echo(addr string, opts Options, wrap func(net.PacketConn) net.PacketConn, count, size int) error {
+	if !opts.Echo {
+		return ErrSkipped
+	}
+	conn, err := dial(addr, opts, wrap)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for i := 0; i < count; i++ {
+		if _, err := conn.Write(payload(i, size)); err != nil {
+			return fmt.Errorf("error writing packet %v: %v", i, err)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(opts.Timeout))
+	b := make([]byte, size+1500)
+	for i := 0; i < count; i++ {
+		n, err := conn.Read(b)
+		if err != nil {
+			return fmt.Errorf("packet %v of %v was not echoed: %v", i, count, err)
+		}
+		if expected := payload(i, size); !bytes.Equal(b[:n], expected) {
+			return fmt.Errorf("packet %v was echoed as %v bytes starting with %x, expected %v bytes starting with %x", i, n, b[:min(n, 4)], len(expected), expected[:min(len(expected), 4)])
+		}
+	}
+	return nil
+}
+
+// payload returns the packet with index i and the size passed written by the echo scenarios. It starts with
+// 0xfe, the ID of game packets in Minecraft, so that Minecraft servers do not drop it.
+func payload(i, size int) []byte {
+	b := make([]byte, size)
+	b[0] = 0xfe
+	for j := 1; j < size; j++ {
+		b[j] = byte(i + j)
+	}
+	return b
+}
+
+// dial dials a connection to the endpoint with the options passed.
+func dial(addr string, opts Options, wrap func(net.PacketConn) net.PacketConn) (*raknet.Conn, error) {
+	conn, err := raknet.Dialer{Protocol: opts.Protocol, WrapConn: wrap, ErrorLog: discardLog()}.Dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("connection sequence failed: %v", err)
+	}
+	return conn, nil
+}
+
+// min returns the smallest of a and b.
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// discardLog returns a logger that discards all errors, as the errors of the endpoint are reported in the
+// results instead.
+func discardLog() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
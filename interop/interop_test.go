@@ -0,0 +1,47 @@
+package interop
+
+import (
+	"testing"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// TestScenarios runs all scenarios against a Listener of this package that echoes packets, which must
+// never diverge from the behaviour expected.
+func TestScenarios(t *testing.T) {
+	l, err := raknet.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b := make([]byte, 30000)
+				for {
+					n, err := c.Read(b)
+					if err != nil {
+						return
+					}
+					if _, err := c.Write(b[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	report := Run(l.Addr().String(), Options{Echo: true}, Scenarios...)
+	if report.Failed() {
+		t.Fatal(report)
+	}
+	for _, r := range report.Results {
+		if r.Err != nil {
+			t.Errorf("scenario %v was skipped", r.Scenario)
+		}
+	}
+}
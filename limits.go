@@ -0,0 +1,64 @@
+package raknet
+
+import (
+	"time"
+)
+
+// ShedPolicy decides which connections a Listener closes first when the combined memory usage of its
+// connections exceeds Limits.MaxMemory.
+type ShedPolicy int
+
+const (
+	// ShedMostMemory closes the connections holding the most memory first.
+	ShedMostMemory ShedPolicy = iota
+	// ShedLeastActive closes the connections that received a packet the longest ago first, so that
+	// connections that are in use are kept as long as possible.
+	ShedLeastActive
+)
+
+// Limits holds the limits of a Listener that may be changed while it is running, using Listener.SetLimits.
+type Limits struct {
+	// MaxMemory is the maximum amount of bytes that all connections of the Listener combined may hold in
+	// memory for unacknowledged, split, unordered and unread packets. If 0, no limit is enforced.
+	MaxMemory int64
+	// MaxConnections is the maximum amount of connections the Listener holds at once. Lowering it does not
+	// close connections that are already open. If 0, no limit is enforced.
+	MaxConnections int
+	// IdleTimeout is the time after which a connection that has not received any packets is closed. If 0,
+	// connections time out after 7 seconds.
+	IdleTimeout time.Duration
+	// SendWindow is the maximum amount of datagrams a connection may have awaiting acknowledgement at the
+	// same time. If 0, there is no maximum.
+	SendWindow int
+}
+
+// Limits returns the limits currently applied by the listener.
+func (listener *Listener) Limits() Limits {
+	return listener.limits.Load().(Limits)
+}
+
+// SetLimits changes the limits of the listener while it is running. The new limits are applied to
+// connections that are already open as well as to those that are created after: A lower IdleTimeout closes
+// idle connections at their next tick, and a higher SendWindow immediately sends datagrams that were held
+// back by the old window.
+func (listener *Listener) SetLimits(limits Limits) {
+	if limits.IdleTimeout <= 0 {
+		limits.IdleTimeout = connTimeout
+	}
+	listener.limits.Store(limits)
+	listener.connections.Range(func(key, value interface{}) bool {
+		conn := value.(*Conn)
+		conn.idleTimeout.Store(limits.IdleTimeout)
+		conn.setSendWindow(limits.SendWindow)
+		return true
+	})
+}
+
+// setSendWindow changes the send window of the connection. If the window is raised, datagrams held back by
+// the old window are sent immediately.
+func (conn *Conn) setSendWindow(n int) {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	conn.sendWindow = n
+	_ = conn.sendWindowQueue()
+}
@@ -0,0 +1,56 @@
+package raknet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenerSetLimits(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	listener, err := ListenConfig{Clock: clock, SendWindow: 16}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+
+	if got := listener.Limits(); got.IdleTimeout != connTimeout || got.SendWindow != 16 {
+		t.Fatalf("unexpected initial limits %+v", got)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer pc.Close()
+	conn := newConn(pc, pc.LocalAddr(), 1400, 1, connConfig{clock: clock, sendWindow: 16})
+	defer conn.Close()
+	listener.connections.Store(conn.addr.String(), conn)
+
+	listener.SetLimits(Limits{IdleTimeout: time.Second, SendWindow: 32, MaxMemory: 1 << 20})
+	if c := listener.Config(); c.IdleTimeout != time.Second || c.SendWindow != 32 || c.MaxMemory != 1<<20 {
+		t.Errorf("config does not reflect new limits: %+v", c)
+	}
+	conn.writeLock.Lock()
+	window := conn.sendWindow
+	conn.writeLock.Unlock()
+	if window != 32 {
+		t.Errorf("send window of existing connection is %v, expected 32", window)
+	}
+
+	// The clock is advanced in steps, so that ticks are not missed if the connection is still starting up.
+	for i := 0; ; i++ {
+		clock.Advance(tickInterval)
+		select {
+		case <-conn.closeCtx.Done():
+			if elapsed := tickInterval * time.Duration(i+1); elapsed < time.Second {
+				t.Fatalf("connection timed out after %v, before the new idle timeout passed", elapsed)
+			}
+			return
+		case <-time.After(time.Millisecond):
+		}
+		if i > 500 {
+			t.Fatalf("connection did not time out after the new idle timeout passed")
+		}
+	}
+}
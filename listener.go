@@ -3,13 +3,17 @@ package raknet
 import (
 	"bytes"
 	"context"
+	"crypto/ecdh"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
-	"math/rand"
 	"net"
 	"os"
+	"runtime/pprof"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -23,8 +27,12 @@ type Listener struct {
 	// ErrorLog is a logger that errors from packet decoding are logged to. It may be set to a logger that
 	// simply discards the messages.
 	ErrorLog *log.Logger
-	// Protocol is the protocol of the RakNet listener. It will only accept clients that attempt to connect
-	// with this RakNet protocol version, and is one of the constants found in conn.go.
+	// Logger is a structured logger that records are logged to at different levels. If set, it is used
+	// instead of ErrorLog.
+	Logger Logger
+	// Protocol is the primary protocol of the RakNet listener. It will only accept clients that attempt to
+	// connect with this RakNet protocol version or one of the versions in ListenConfig.Protocols, and is one
+	// of the constants found in conn.go.
 	// Protocol is raknet.MinecraftProtocol by default.
 	Protocol byte
 
@@ -38,6 +46,14 @@ type Listener struct {
 
 	closeCtx context.Context
 	close    context.CancelFunc
+	// goroutines tracks all goroutines started by the listener and its connections, so that Close may wait
+	// for them to exit. closing is set once Close is called, after which no goroutines are started by the
+	// listener itself. goroutineMu guards closing.
+	goroutines  sync.WaitGroup
+	goroutineMu sync.Mutex
+	closing     bool
+	// listenDone is closed once the goroutine reading packets exits.
+	listenDone chan struct{}
 
 	// id is a random server ID generated upon starting listening. It is used several times throughout the
 	// connection sequence of RakNet.
@@ -47,8 +63,341 @@ type Listener struct {
 	// and unconnected ping to the server.
 	pongData atomic.Value
 
-	// protocol is the RakNet protocol of the listener.
-	protocol byte
+	// protocol is the primary RakNet protocol of the listener. protocols holds all protocol versions
+	// accepted, including protocol.
+	protocol  byte
+	protocols []byte
+	// magic is the offline message magic sent and expected by the listener.
+	magic [16]byte
+	// security holds the key material used to secure connections, if ListenConfig.EncryptionKey is set.
+	security *listenerSecurity
+	// cookies is the key of the cookies sent in open connection reply 1 packets, if ListenConfig.EncryptionKey,
+	// ListenConfig.RequireCookie or ListenConfig.StatelessHandshake is set.
+	cookies *cookieKey
+	// pendingProtocols holds the protocol versions of clients that are connecting using a version other than
+	// protocol.
+	pendingProtocols pendingProtocols
+	// mtuProbes holds the largest open connection request 1 received from clients discovering their MTU size.
+	mtuProbes mtuProbes
+	// bans holds the IP addresses and GUIDs banned from the listener.
+	bans banList
+	// offlineLimiter and connectedLimiter apply the RateLimits of the listener to offline messages and to
+	// the datagrams of connections respectively.
+	offlineLimiter, connectedLimiter rateLimiter
+	// blackholes holds the addresses blackholed according to ListenConfig.Blackhole.
+	blackholes blackholes
+
+	// limits holds the Limits currently applied by the listener. They may be changed using SetLimits.
+	limits atomic.Value
+	// maxDatagramSize is the maximum size of datagrams read by the listener. maxMTUSize is the maximum MTU
+	// size it negotiates, which is no higher than maxDatagramSize.
+	maxDatagramSize int
+	maxMTUSize      int
+
+	// config is the configuration passed to each connection created by the listener. listenConfig is the
+	// ListenConfig that the listener was created with, with its defaults filled out.
+	config       connConfig
+	listenConfig ListenConfig
+	// counters holds the statistics of the listener, which are shared with all connections it creates.
+	counters *counters
+	// memoryExceeded is 1 if the memory usage of the connections exceeded the maximum memory and has not yet
+	// dropped below three quarters of it, in which case new clients are refused.
+	memoryExceeded int32
+
+	// events sends the events of the connections of the listener to subscribers.
+	events eventBus
+	// onReject is called for every packet or connection rejected by the listener, if set.
+	onReject func(r Rejection)
+	// logSampler decides which records are passed to the logger of the listener. If nil, all are passed.
+	logSampler *sampler
+
+	// labels is a context holding the pprof labels of the listener. The goroutine reading packets is tagged
+	// with these labels, and with those of a connection while it handles a packet of that connection.
+	labels context.Context
+}
+
+// ListenConfig may be used to pass additional configuration to a Listener. The zero value of ListenConfig
+// is valid and is used by the Listen function.
+type ListenConfig struct {
+	// ErrorLog is a logger that errors from packet decoding are logged to. It may be set to a logger that
+	// simply discards the messages.
+	// ErrorLog is a logger writing to os.Stderr by default.
+	ErrorLog *log.Logger
+	// Logger is a structured logger that records are logged to at different levels, with the remote address,
+	// packet ID and error category as attributes. A *slog.Logger may be used. If set, it is used instead of
+	// ErrorLog.
+	// Logger is nil by default.
+	Logger Logger
+	// Protocol is the protocol of the RakNet listener. It will only accept clients that attempt to connect
+	// with this RakNet protocol version, or one of the versions in Protocols, and is one of the constants
+	// found in conn.go. It is the version sent to clients that attempt to connect with an unsupported
+	// version.
+	// Protocol is raknet.MinecraftProtocol by default, or raknet.OfficialProtocol if Profile is
+	// raknet.ProfileVanilla.
+	Protocol byte
+	// Profile selects the flavour of RakNet spoken by the Listener and its connections, such as the one of
+	// Minecraft or the one of the official RakNet library.
+	// Profile is raknet.ProfileAuto by default, meaning it is selected from the primary protocol version.
+	Profile Profile
+	// Quirks toggles deviations from RakNet made by other implementations of it, such as JRakNet, on top of
+	// those of Profile, so that clients using them may connect.
+	// Quirks is the zero value by default, meaning only the deviations of Profile apply.
+	Quirks Quirks
+	// Protocols is a list of RakNet protocol versions accepted in addition to Protocol, so that clients of
+	// several versions may connect to the same Listener. The framing differences between versions are
+	// handled by the connections, which record the version that was negotiated. It is returned by
+	// Conn.Protocol.
+	// Protocols is nil by default, meaning only Protocol is accepted.
+	Protocols []byte
+	// AdvertiseClosestProtocol makes the Listener reply to clients connecting with an unsupported protocol
+	// version with the version closest to theirs out of Protocol and Protocols, rather than with Protocol.
+	// Clients that support several versions, such as a Dialer with Dialer.Protocols set, may then retry using
+	// the version advertised, which helps populations of clients of mixed versions connect.
+	// AdvertiseClosestProtocol is false by default, meaning Protocol is always advertised.
+	AdvertiseClosestProtocol bool
+	// Magic is the 16-byte sequence found in every offline message, such as unconnected pings and open
+	// connection requests. Offline messages holding a different sequence are rejected. Private deployments
+	// and modified clients may change it to keep scanners away, in which case clients must dial using the
+	// same Dialer.Magic.
+	// Magic is the zero value by default, meaning the magic of RakNet itself is used.
+	Magic [16]byte
+	// EncryptionKey enables the encryption of all connections of the Listener. Its public key is sent to
+	// clients during the connection sequence, after which a key exchange takes place, and all datagrams of
+	// the connection are encrypted and authenticated using the keys exchanged. The key must be an X25519 key,
+	// such as one generated using ecdh.X25519().GenerateKey(rand.Reader). Clients must dial using
+	// Dialer.Encrypt or Dialer.ServerPublicKey.
+	// Encryption is an extension of go-raknet and not the connection security of the official RakNet library:
+	// It reuses the security fields of the open connection messages, but the key exchange uses X25519 and the
+	// datagrams are encrypted using AES-GCM with a framing of its own. Encrypted connections are therefore
+	// only possible with clients using this package, and other RakNet clients fail to connect.
+	// EncryptionKey is nil by default, meaning connections are not encrypted.
+	EncryptionKey *ecdh.PrivateKey
+	// RequireCookie makes the Listener set the security flag in its open connection reply 1 packets along
+	// with a cookie derived from the address of the client, without a public key. Clients must send the
+	// cookie back in their open connection request 2, which proves that they own the address they send from
+	// and keeps clients with spoofed addresses from starting a connection. Connections are not encrypted.
+	// Dialers of this package support cookies, but Minecraft clients do not.
+	// RequireCookie is false by default, meaning a cookie is only sent if EncryptionKey is set.
+	RequireCookie bool
+	// StatelessHandshake makes the Listener answer open connection request 1 packets without storing
+	// anything about the client, so that a flood of requests from spoofed addresses costs CPU time only, not
+	// memory. Like with RequireCookie, a cookie is sent to the client, which authenticates its address and
+	// protocol version until it sends its open connection request 2. Retries of open connection request 1
+	// are answered with the size of the retry rather than the largest size received from the client, which
+	// may lower the MTU size of clients whose first reply was lost.
+	// StatelessHandshake is false by default, meaning the protocol version and MTU size probed by clients
+	// are held in memory for a short while.
+	StatelessHandshake bool
+	// ValidateSource makes the Listener only create a connection for an open connection request 2 if the
+	// client showed that it receives the replies sent to its address, so that requests from spoofed
+	// addresses cannot create connections or fill the accept backlog. If cookies are sent, because
+	// EncryptionKey, RequireCookie or StatelessHandshake is set, the cookie is that proof. Otherwise, the
+	// address must have sent an open connection request 1 that was answered in the last 10 seconds, which
+	// stops requests 2 sent blindly but not clients spoofing both requests. Only cookies stop those. As the
+	// requests of at most 4096 clients are held at once, clients may be refused during a flood of requests.
+	// ValidateSource is false by default.
+	ValidateSource bool
+	// TrustedProxies is a list of networks of UDP load balancers that prepend a PROXY protocol v2 header to
+	// the datagrams they forward. The client address carried in the header is used as the address of the
+	// client, for example for its connection, pongs and logs, while datagrams sent to the client are sent to
+	// the load balancer that forwarded its last header. Load balancers that only prepend a header to the
+	// first datagram of a client are supported too. Headers of datagrams from other addresses are never
+	// parsed.
+	// TrustedProxies is nil by default, meaning PROXY protocol headers are not accepted.
+	TrustedProxies []*net.IPNet
+	// RequireProxyHeader makes the Listener drop all datagrams that did not arrive with a valid PROXY
+	// protocol v2 header from one of the TrustedProxies, so that clients cannot bypass the load balancers.
+	// RequireProxyHeader is false by default, meaning datagrams without a header are accepted as they are.
+	RequireProxyHeader bool
+
+	// MaxMemory is the maximum amount of bytes that all connections of the Listener combined may hold in
+	// memory for unacknowledged, split, unordered and unread packets. If the combined usage exceeds
+	// MaxMemory, connections are closed according to the ShedPolicy until the usage drops below it again,
+	// and new clients are refused until the usage drops below three quarters of it. The usage is checked
+	// four times per second.
+	// MaxMemory is 0 by default, meaning no limit is enforced.
+	MaxMemory int64
+	// ShedPolicy decides which connections are closed first when the memory usage exceeds MaxMemory.
+	// ShedPolicy is ShedMostMemory by default.
+	ShedPolicy ShedPolicy
+	// MaxConnections is the maximum amount of connections that the Listener holds at once, including those
+	// that were not yet accepted. Clients that attempt to connect while the Listener is full are sent an
+	// ID_NO_FREE_INCOMING_CONNECTIONS, which makes a Dialer fail with ErrServerFull.
+	// MaxConnections is 0 by default, meaning no limit is enforced.
+	MaxConnections int
+	// Admit is called for every client attempting to connect, to decide if it may. Clients for which it
+	// returns false are refused like they are when the Listener is full. It overrides the MaxConnections
+	// check, which may be performed using Admission.Full, so that all capacity policy, such as reserving
+	// slots for operators, lives in one place.
+	// Admit is called from the goroutine that processes packets and must therefore not block.
+	// Admit is nil by default, meaning clients are refused only while the Listener is full.
+	Admit func(a Admission) bool
+	// AdvertiseCapacity makes the Listener replace the player count and maximum player count of Minecraft
+	// pong data, starting with "MCPE;", with its amount of connections and MaxConnections, so that server
+	// lists show the capacity that is enforced. The pong data is left as is if MaxConnections is 0.
+	// AdvertiseCapacity is false by default, meaning the pong data is sent as it was set.
+	AdvertiseCapacity bool
+	// RateLimits limits the rate at which datagrams are processed, globally and per address, separately for
+	// offline messages and for the datagrams of connections. Datagrams exceeding the limits are dropped
+	// before they are processed, and counted in ListenerStats.RateLimitDrops.
+	// RateLimits is the zero value by default, meaning no limits are enforced.
+	RateLimits RateLimits
+	// Blackhole makes the Listener silently drop all datagrams from addresses that sent too many packets that
+	// were rejected, for a while. See Blackhole for details.
+	// Blackhole is the zero value by default, meaning addresses are never blackholed.
+	Blackhole Blackhole
+	// StrictMagic makes the Listener silently drop offline messages that do not hold its offline message
+	// magic, or that are too short to hold it, without calling OnReject or logging an error, so that traffic
+	// that is not RakNet costs as little as possible and never leads to a reply. The magic is always checked
+	// before the rest of an offline message is decoded.
+	// StrictMagic is false by default, meaning offline messages with an invalid magic are rejected with
+	// RejectInvalidMagic.
+	StrictMagic bool
+	// MaxPongAmplification is the maximum ratio of the size of an unconnected pong to the size of the ping
+	// that it answers. Pings that would be answered with a bigger pong are dropped, so that the Listener
+	// cannot be abused to reflect traffic amplified to a spoofed address. Pong data is usually a lot bigger
+	// than the 33 bytes of a ping, so clients may need to pad their pings using Dialer.PingPadding.
+	// MaxPongAmplification is 0 by default, meaning pongs of any size are sent.
+	MaxPongAmplification float64
+	// MinPingSize is the minimum size in bytes of the unconnected pings that the Listener answers. Smaller
+	// pings are dropped. Clients may pad their pings using Dialer.PingPadding.
+	// MinPingSize is 0 by default, meaning pings of any size are answered.
+	MinPingSize int
+	// MaxSplitCount is the maximum amount of fragments that a packet sent by a client may be split into.
+	// Connections sending a packet split into more fragments are closed.
+	// MaxSplitCount is 0 by default, meaning the maximum of 8192 fragments is used. It can be no higher.
+	MaxSplitCount int
+	// MaxSplitMemory is the maximum amount of bytes a connection holds for split packets that are being
+	// reassembled, including a slot for every fragment announced. Connections exceeding it are closed, which
+	// stops clients from exhausting memory with split packets that never complete.
+	// MaxSplitMemory is 0 by default, meaning a maximum of 32 MiB is used.
+	MaxSplitMemory int
+	// RecoverPanics makes the Listener recover panics that occur while handling the packets of a connection,
+	// for example because of a bug triggered by malformed input. The connection is closed and an
+	// EventErrored holding a *PanicError is emitted, rather than the panic taking down the process.
+	// RecoverPanics is false by default.
+	RecoverPanics bool
+	// MaxDatagramSize is the maximum size of datagrams read by the Listener. Bigger datagrams are dropped.
+	// It may be raised for networks supporting jumbo frames.
+	// MaxDatagramSize is 1500 by default, and can be no higher than 8192.
+	MaxDatagramSize int
+	// MaxMTUSize is the maximum MTU size the Listener will agree to with clients, regardless of the MTU size
+	// they probe or claim in their open connection request 2. Lowering it below the MTU of the network keeps
+	// the datagrams sent to clients from being fragmented by the kernel, and keeps clients claiming absurd
+	// MTU sizes from making the Listener send datagrams bigger than it is willing to.
+	// MaxMTUSize is 0 by default, meaning MaxDatagramSize is used. It can be no lower than 400 and no higher
+	// than MaxDatagramSize.
+	MaxMTUSize int
+	// PathMTUDiscovery makes connections discover the path MTU once they are established, by periodically
+	// sending probes of increasing size with the don't fragment flag set. The MTU size used is adjusted up
+	// or down according to the probes that arrive, up to MaxDatagramSize. The don't fragment flag is only
+	// set on Linux.
+	PathMTUDiscovery bool
+
+	// WriteBatchWindow is the time datagrams written to a connection are held before they are written in a
+	// single batch. On Linux, a batch is written using a single sendmmsg system call, which reduces the
+	// amount of system calls made at the cost of added latency.
+	// WriteBatchWindow is 0 by default, meaning datagrams are written immediately. A value of about a
+	// millisecond is reasonable for servers with a high throughput.
+	WriteBatchWindow time.Duration
+
+	// AcceptBacklog is the maximum amount of connections that may be waiting to be accepted by a call to
+	// Listener.Accept. Connections that arrive while the backlog is full wait for room in the backlog.
+	// AcceptBacklog is 128 by default.
+	AcceptBacklog int
+	// SendWindow is the maximum amount of datagrams a connection may have awaiting acknowledgement at the
+	// same time. Datagrams written while the window is full are held until some of them are acknowledged.
+	// SendWindow is 0 by default, meaning there is no maximum.
+	SendWindow int
+	// DelayRecordCount is the amount of datagram delays recorded by a connection to calculate the average
+	// delay, after which datagrams that were not acknowledged are resent.
+	// DelayRecordCount is raknet.DelayRecordCount by default.
+	DelayRecordCount int
+	// IdleTimeout is the time after which a connection that has not received any packets is closed. It may be
+	// changed while the Listener is running using Listener.SetLimits.
+	// IdleTimeout is 7 seconds by default.
+	IdleTimeout time.Duration
+	// ReadQueueSize is the amount of packets received that may be waiting to be read from a connection. Once
+	// the read queue is full, the SlowConsumerPolicy is applied and an EventSlowConsumer is emitted.
+	// ReadQueueSize is 1024 by default.
+	ReadQueueSize int
+	// SlowConsumerPolicy specifies what a connection does when its read queue is full.
+	// SlowConsumerPolicy is SlowConsumerDisconnect by default.
+	SlowConsumerPolicy SlowConsumerPolicy
+
+	// ExpvarPrefix is the prefix of the names under which the statistics of the Listener are published
+	// using the expvar package, such as 'raknet.datagrams_sent'. The variables remain published after the
+	// Listener is closed, so the prefix of each Listener must be unique for the lifetime of the process.
+	// ExpvarPrefix is empty by default, meaning the statistics are not published.
+	ExpvarPrefix string
+
+	// Tracer is used to trace the connection sequence and the closing of connections with spans.
+	// Tracer is nil by default, meaning no spans are started.
+	Tracer Tracer
+	// Clock is the Clock that drives the timers of the Listener and its connections, such as those for
+	// resending datagrams, pinging, timing out connections and timing out the connection sequence. A
+	// ManualClock may be used to test this logic deterministically.
+	// Clock is nil by default, meaning SystemClock is used.
+	Clock Clock
+	// Rand is the source of randomness used to generate the ID of the Listener and any other random values
+	// used by it and its connections, such as the seeds used to simulate packet loss. Passing a
+	// deterministic source, such as a *rand.Rand with a fixed seed, makes connection sequences reproducible.
+	// Rand is nil by default, meaning crypto/rand is used.
+	Rand io.Reader
+	// LoadBans is called once when the Listener is created to load bans persisted by SaveBans, so that
+	// bans survive restarts. If it returns an error, creating the Listener fails.
+	// LoadBans is nil by default, meaning the Listener starts without bans.
+	LoadBans func() ([]Ban, error)
+	// SaveBans is called with all bans that have not expired every time a ban is added or lifted, so that
+	// they may be persisted. Errors returned are logged. SaveBans is called from the goroutine that changed
+	// the bans.
+	// SaveBans is nil by default.
+	SaveBans func(bans []Ban) error
+	// OnReject is called for every packet or connection rejected by the Listener, such as handshakes with an
+	// incompatible protocol, unknown offline packets and connections closed for exceeding the memory limit.
+	// The Rejection passed holds a reason code, so that it may be fed to tools that block abusive addresses.
+	// OnReject may be called from the goroutine that processes packets and must therefore not block.
+	// OnReject is nil by default.
+	OnReject func(r Rejection)
+	// OnAck is called for every ACK and NACK sent or received by the connections of the Listener, with the
+	// ranges of datagram sequence numbers they hold, so that the delivery of datagrams may be analysed
+	// externally. OnAck is called from the goroutines that process packets and must therefore not block.
+	// OnAck is nil by default.
+	OnAck func(event AckEvent)
+	// Chaos is used to inject misbehaviour into the connections of the Listener on demand, such as dropping
+	// them or stalling their ACKs, so that error-handling paths of applications may be tested.
+	// Chaos is nil by default, meaning no misbehaviour is injected.
+	Chaos *Chaos
+	// WrapConn is called with the UDP connection of the Listener once it is created, if set. The
+	// net.PacketConn it returns is used for all datagrams of the Listener instead, so that the datagrams may be
+	// altered, delayed or dropped, for example using SimulateNetwork. Datagrams passed to PacketTrace are those
+	// of the UDP connection itself.
+	// WrapConn is nil by default.
+	WrapConn func(conn net.PacketConn) net.PacketConn
+	// PacketTrace is called for every raw datagram received or sent by the Listener, if set, so that protocol
+	// issues may be captured without access to tools such as tcpdump. HexDumpTrace may be used to write a
+	// hex dump of each datagram.
+	// PacketTrace is nil by default.
+	PacketTrace PacketTraceFunc
+	// PcapWriter is a writer that all datagrams received and sent by the Listener are written to in the
+	// pcapng format, with synthesized IP and UDP headers, so that they may be opened in Wireshark. It may be
+	// used together with PacketTrace. The traffic of a single connection may be captured using
+	// Conn.SetPcapWriter instead.
+	// PcapWriter is nil by default.
+	PcapWriter io.Writer
+	// TraceSampling specifies which datagrams are passed to PacketTrace, so that tracing may stay enabled
+	// under high load. Datagrams written to the PcapWriter are not sampled.
+	// TraceSampling is the zero value by default, meaning every datagram is traced.
+	TraceSampling Sampling
+	// LogSampling specifies which records are written to the Logger or ErrorLog of the Listener, so that
+	// errors caused by floods of invalid packets do not overwhelm the log.
+	// LogSampling is the zero value by default, meaning every record is logged.
+	LogSampling Sampling
+
+	// LowFootprint makes the Listener use smaller defaults for the AcceptBacklog, SendWindow,
+	// DelayRecordCount and ReadQueueSize fields left empty, so that it may run on memory constrained devices.
+	// Values that are set explicitly are not changed.
+	LowFootprint bool
 }
 
 // Listen listens on the address passed and returns a listener that may be used to accept connections. If not
@@ -57,27 +406,202 @@ type Listener struct {
 // Specific features of the listener may be modified once it is returned, such as the used ErrorLog and/or the
 // accepted protocol.
 func Listen(address string) (*Listener, error) {
+	return ListenConfig{}.Listen(address)
+}
+
+// Listen listens on the address passed and returns a listener that may be used to accept connections. If not
+// successful, an error is returned.
+// The address follows the same rules as those defined in the net.TCPListen() function.
+// Listen fills out any values of the ListenConfig left as their empty values with the default values of
+// those fields.
+func (config ListenConfig) Listen(address string) (*Listener, error) {
 	conn, err := net.ListenPacket("udp", address)
 	if err != nil {
 		return nil, fmt.Errorf("error creating UDP listener: %v", err)
 	}
+	return config.ListenConn(conn)
+}
 
-	// Seed the global rand so we can get a random ID.
-	rand.Seed(time.Now().Unix())
+// ListenConn returns a listener that accepts connections on the net.PacketConn passed, rather than on a UDP
+// connection created by the listener, so that a fake net.PacketConn, such as the one of the testutil package,
+// may be used to test the behaviour of a Listener deterministically. The addresses of datagrams read from the
+// net.PacketConn must be *net.UDPAddrs. The listener takes ownership of the net.PacketConn: It is closed when
+// the listener is closed or if ListenConn returns an error.
+// ListenConn fills out any values of the ListenConfig left as their empty values with the default values of
+// those fields.
+func (config ListenConfig) ListenConn(conn net.PacketConn) (*Listener, error) {
+	clock := config.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	config.PacketTrace = sampleTrace(config.PacketTrace, newSampler(config.TraceSampling, clock))
+	if config.PcapWriter != nil {
+		pcap, err := NewPcapWriter(config.PcapWriter)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		config.PacketTrace = combineTraces(config.PacketTrace, pcap.Trace(conn.LocalAddr(), nil))
+	}
+	conn = newTraceConn(conn, config.PacketTrace)
+	if config.ErrorLog == nil {
+		config.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if config.Protocol == 0 {
+		config.Protocol = config.Profile.defaultProtocol()
+	}
+	if config.Magic == ([16]byte{}) {
+		config.Magic = magic
+	}
+	connConfig := connConfig{
+		sendWindow:         config.SendWindow,
+		delayRecordCount:   config.DelayRecordCount,
+		readQueueSize:      config.ReadQueueSize,
+		idleTimeout:        config.IdleTimeout,
+		slowConsumerPolicy: config.SlowConsumerPolicy,
+		maxDatagramSize:    maxMTU(config.MaxMTUSize, maxDatagramSize(config.MaxDatagramSize)),
+		maxSplitCount:      config.MaxSplitCount,
+		maxSplitMemory:     config.MaxSplitMemory,
+		recoverPanics:      config.RecoverPanics,
+		ackLimit:           config.RateLimits.Acks,
+		pathMTUDiscovery:   config.PathMTUDiscovery,
+		writeBatchWindow:   config.WriteBatchWindow,
+		counters:           &counters{},
+		tracer:             config.Tracer,
+		clock:              clock,
+		rand:               config.Rand,
+		onAck:              config.OnAck,
+		chaos:              config.Chaos,
+		profile:            config.Profile,
+		quirks:             config.Quirks,
+	}
+	if connConfig.tracer == nil {
+		connConfig.tracer = nopTracer{}
+	}
+	if config.PathMTUDiscovery {
+		if err := setDontFragment(conn); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("error setting don't fragment flag: %v", err)
+		}
+	}
+	var proxy *proxyConn
+	if len(config.TrustedProxies) > 0 || config.RequireProxyHeader {
+		// The headers are stripped before the datagrams reach the WrapConn, as they are prepended by the
+		// load balancers rather than by the clients.
+		proxy = newProxyConn(conn, config.TrustedProxies, config.RequireProxyHeader, clock)
+		conn = proxy
+	}
+	if config.WrapConn != nil {
+		conn = config.WrapConn(conn)
+	}
+	if config.LowFootprint {
+		connConfig = connConfig.lowFootprint()
+		if config.AcceptBacklog == 0 {
+			config.AcceptBacklog = lowFootprintBacklog
+		}
+	}
+	if config.AcceptBacklog == 0 {
+		config.AcceptBacklog = 128
+	}
+	config.SendWindow, config.DelayRecordCount = connConfig.sendWindow, connConfig.delayRecordCount
+	if config.DelayRecordCount == 0 {
+		config.DelayRecordCount = DelayRecordCount
+	}
+	if connConfig.idleTimeout <= 0 {
+		connConfig.idleTimeout = connTimeout
+	}
+	config.IdleTimeout = connConfig.idleTimeout
+	config.ReadQueueSize = connConfig.readQueueSize
+	if config.ReadQueueSize == 0 {
+		config.ReadQueueSize = defaultReadQueueSize
+	}
+	config.MaxDatagramSize = maxDatagramSize(config.MaxDatagramSize)
+	config.MaxMTUSize = maxMTU(config.MaxMTUSize, config.MaxDatagramSize)
+
+	id, err := randInt63(config.Rand)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error generating listener ID: %v", err)
+	}
+	var security *listenerSecurity
+	var cookies *cookieKey
+	if config.EncryptionKey != nil {
+		if security, err = newListenerSecurity(config.EncryptionKey); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if config.EncryptionKey != nil || config.RequireCookie || config.StatelessHandshake {
+		if cookies, err = newCookieKey(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	listener := &Listener{
-		ErrorLog: log.New(os.Stderr, "", log.LstdFlags),
-		Protocol: MinecraftProtocol,
-		conn:     conn,
-		incoming: make(chan *Conn, 128),
-		closeCtx: ctx,
-		close:    cancel,
-		id:       rand.Int63(),
-		protocol: MinecraftProtocol,
+		ErrorLog:   config.ErrorLog,
+		Logger:     config.Logger,
+		Protocol:   config.Protocol,
+		conn:       conn,
+		incoming:   make(chan *Conn, config.AcceptBacklog),
+		listenDone: make(chan struct{}),
+		closeCtx:   ctx,
+		close:      cancel,
+		id:         id,
+		protocol:   config.Protocol,
+		protocols:  append([]byte{config.Protocol}, config.Protocols...),
+		magic:      config.Magic,
+		security:   security,
+		cookies:    cookies,
+		config:     connConfig,
+		counters:   connConfig.counters,
+
+		listenConfig: config,
+		onReject:     config.OnReject,
+		logSampler:   newSampler(config.LogSampling, clock),
+
+		maxDatagramSize: config.MaxDatagramSize,
+		maxMTUSize:      config.MaxMTUSize,
+		labels:          pprof.WithLabels(context.Background(), pprof.Labels("raknet.listener", conn.LocalAddr().String())),
+	}
+	listener.blackholes.config, listener.blackholes.sources = config.Blackhole, make(map[string]*blackholeSource)
+	listener.offlineLimiter = rateLimiter{
+		global:         config.RateLimits.Offline,
+		perSource:      config.RateLimits.OfflinePerSource,
+		globalDrops:    &listener.counters.rateLimitOffline,
+		perSourceDrops: &listener.counters.rateLimitOfflinePerSource,
+		sources:        make(map[string]*tokenBucket),
+	}
+	listener.connectedLimiter = rateLimiter{
+		global:         config.RateLimits.Connected,
+		perSource:      config.RateLimits.ConnectedPerSource,
+		globalDrops:    &listener.counters.rateLimitConnected,
+		perSourceDrops: &listener.counters.rateLimitConnectedPerSource,
+		sources:        make(map[string]*tokenBucket),
 	}
+	listener.config.events = &listener.events
+	listener.config.goroutines = &listener.goroutines
+	listener.limits.Store(Limits{MaxMemory: config.MaxMemory, MaxConnections: config.MaxConnections, IdleTimeout: connConfig.idleTimeout, SendWindow: connConfig.sendWindow})
 	listener.pongData.Store([]byte{})
-	go listener.listen()
+	if err := listener.loadBans(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if proxy != nil {
+		proxy.reject = func(addr net.Addr, err error) {
+			listener.reject(addr, RejectInvalidProxyHeader, err)
+		}
+	}
+	if config.ExpvarPrefix != "" {
+		if err := listener.publishExpvar(config.ExpvarPrefix); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("error publishing expvars: %v", err)
+		}
+	}
+	listener.spawn(listener.listen)
+	// The memory usage is always checked, as the maximum memory may be set later using SetLimits.
+	listener.spawn(listener.limitMemory)
 
 	return listener, nil
 }
@@ -89,51 +613,115 @@ func (listener *Listener) Accept() (net.Conn, error) {
 accept:
 	conn, ok := <-listener.incoming
 	if !ok {
-		return nil, fmt.Errorf("error accepting connection: listener closed")
+		return nil, opError("accept", nil, listener.Addr(), ErrClosed)
 	}
 	select {
 	case <-listener.closeCtx.Done():
-		return nil, fmt.Errorf("error accepting connection: listener closed")
+		endSpan(conn.connectSpan, fmt.Errorf("listener closed"))
+		return nil, opError("accept", nil, listener.Addr(), ErrClosed)
 	case <-conn.completingSequence.Done():
-		go func() {
+		conn.connectSpan.End()
+		listener.logger().Debug("accepted connection", "remote_addr", conn.addr, "guid", conn.id, "mtu_size", conn.mtuSize)
+		listener.emit(EventAccepted, conn.addr, conn.id, nil)
+		listener.spawn(func() {
 			<-conn.closeCtx.Done()
+			var err error
+			if disconnect, ok := conn.disconnect.Load().(*DisconnectError); ok {
+				err = disconnect
+			}
+			listener.emit(EventClosed, conn.addr, conn.id, err)
 			// Insert the boolean back in the channel so that other readers of the channel also receive
 			// the signal.
-			listener.connections.Delete(conn.addr.String())
-		}()
+			if value, ok := listener.connections.Load(conn.addr.String()); ok && value == conn {
+				// The connection may already have been replaced by a new one from the same address, in
+				// which case we leave it.
+				listener.connections.Delete(conn.addr.String())
+			}
+		})
 		return conn, nil
-	case <-time.After(time.Second * 10):
+	case <-listener.config.clock.After(time.Second * 10):
 		// It took too long to complete this connection. We closeCtx it and go back to accepting.
+		err := fmt.Errorf("connection sequence timed out")
+		listener.handshakeFailed(conn.addr, conn.id, HandshakeTimeout, err)
+		endSpan(conn.connectSpan, err)
+		listener.logger().Info("connection sequence timed out", "remote_addr", conn.addr, "guid", conn.id, "category", categoryHandshake)
+		listener.emit(EventTimedOut, conn.addr, conn.id, nil)
 		_ = conn.Close()
 		goto accept
 	}
 }
 
+// Config returns the ListenConfig that the Listener was created with. Values that were left empty are filled
+// out with the defaults used by the Listener.
+func (listener *Listener) Config() ListenConfig {
+	config, limits := listener.listenConfig, listener.Limits()
+	config.MaxMemory, config.MaxConnections = limits.MaxMemory, limits.MaxConnections
+	config.IdleTimeout, config.SendWindow = limits.IdleTimeout, limits.SendWindow
+	return config
+}
+
 // Addr returns the address the Listener is bound to and listening for connections on.
 func (listener *Listener) Addr() net.Addr {
 	return listener.conn.LocalAddr()
 }
 
-// Close closes the listener so that it may be cleaned up. It makes sure the goroutine handling incoming
-// packets is able to be freed.
+// Close closes the listener and all of its connections so that they may be cleaned up. Close returns once
+// all goroutines started by the listener and its connections have exited, so that none linger after the
+// listener is closed.
 func (listener *Listener) Close() error {
+	listener.goroutineMu.Lock()
+	listener.closing = true
+	listener.goroutineMu.Unlock()
 	listener.close()
 
+	err := listener.closeConnections()
+	if closeErr := listener.conn.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("error closing UDP listener: %v", closeErr)
+	}
+	// Connections may have been created while the connections were being closed, so we close those that
+	// remain once no more packets are being read.
+	<-listener.listenDone
+	if closeErr := listener.closeConnections(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	listener.goroutines.Wait()
+	return err
+}
+
+// Wait blocks until the listener is closed and all goroutines started by the listener and its connections
+// have exited.
+func (listener *Listener) Wait() {
+	<-listener.closeCtx.Done()
+	<-listener.listenDone
+	listener.goroutines.Wait()
+}
+
+// closeConnections closes all connections of the listener.
+func (listener *Listener) closeConnections() error {
 	var err error
 	listener.connections.Range(func(key, value interface{}) bool {
 		conn := value.(*Conn)
-		if closeErr := conn.Close(); err != nil {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
 			err = fmt.Errorf("error closing conn %v: %v", conn.addr, closeErr)
 		}
 		return true
 	})
-	if err != nil {
-		return err
-	}
-	if err := listener.conn.Close(); err != nil {
-		return fmt.Errorf("error closing UDP listener: %v", err)
+	return err
+}
+
+// spawn starts f in a new goroutine that Close waits for, unless the listener is closing, in which case f is
+// not called.
+func (listener *Listener) spawn(f func()) {
+	listener.goroutineMu.Lock()
+	defer listener.goroutineMu.Unlock()
+	if listener.closing {
+		return
 	}
-	return nil
+	listener.goroutines.Add(1)
+	go func() {
+		defer listener.goroutines.Done()
+		f()
+	}()
 }
 
 // PongData sets the pong data that is used to respond with when a client sends a ping. It usually holds game
@@ -156,13 +744,13 @@ func (listener *Listener) HijackPong(address string) error {
 	if _, err := net.ResolveUDPAddr("udp", address); err != nil {
 		return fmt.Errorf("error resolving UDP address: %v", err)
 	}
-	go func() {
+	listener.spawn(func() {
 		ticker := time.NewTicker(time.Second)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				data, err := Dialer{Protocol: listener.Protocol}.Ping(address)
+				data, err := Dialer{Protocol: listener.Protocol, Profile: listener.listenConfig.Profile}.Ping(address)
 				if err != nil {
 					// It's okay if these packets are lost sometimes. There's no need to log this.
 					continue
@@ -187,7 +775,7 @@ func (listener *Listener) HijackPong(address string) error {
 				return
 			}
 		}
-	}()
+	})
 	return nil
 }
 
@@ -197,23 +785,126 @@ func (listener *Listener) ID() int64 {
 	return listener.id
 }
 
+// MemoryUsage returns the combined amount of bytes held in memory by all connections of the listener for
+// packets that are awaiting acknowledgement, reassembly or ordering.
+func (listener *Listener) MemoryUsage() (n int64) {
+	listener.connections.Range(func(key, value interface{}) bool {
+		n += value.(*Conn).MemoryUsage()
+		return true
+	})
+	return n
+}
+
+// limitMemory continuously checks the memory usage of the connections of the listener and closes the
+// connections holding the most memory once the combined usage exceeds the maximum set.
+func (listener *Listener) limitMemory() {
+	ticker := listener.config.clock.NewTicker(time.Second / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			listener.shedMemory()
+		case <-listener.closeCtx.Done():
+			return
+		}
+	}
+}
+
+// shedMemory closes connections of the listener according to its ShedPolicy until the combined memory
+// usage of all connections is below the maximum memory of the listener again.
+func (listener *Listener) shedMemory() {
+	maxMemory := listener.Limits().MaxMemory
+	if maxMemory <= 0 {
+		atomic.StoreInt32(&listener.memoryExceeded, 0)
+		return
+	}
+	type usage struct {
+		conn       *Conn
+		n          int64
+		lastPacket time.Time
+	}
+	var total int64
+	var usages []usage
+	listener.connections.Range(func(key, value interface{}) bool {
+		conn := value.(*Conn)
+		n := conn.MemoryUsage()
+		total += n
+		usages = append(usages, usage{conn: conn, n: n, lastPacket: conn.lastPacketTime.Load().(time.Time)})
+		return true
+	})
+	if total <= maxMemory {
+		if total <= maxMemory/4*3 {
+			atomic.StoreInt32(&listener.memoryExceeded, 0)
+		}
+		return
+	}
+	atomic.StoreInt32(&listener.memoryExceeded, 1)
+	// Sort the connections so that the ones to close first come first.
+	sort.Slice(usages, func(i, j int) bool {
+		if listener.listenConfig.ShedPolicy == ShedLeastActive {
+			return usages[i].lastPacket.Before(usages[j].lastPacket)
+		}
+		return usages[i].n > usages[j].n
+	})
+	for _, u := range usages {
+		if total <= maxMemory {
+			break
+		}
+		listener.logger().Warn("closing connection: memory limit exceeded", "remote_addr", u.conn.addr, "category", categoryMemory, "bytes", u.n)
+		_ = u.conn.Close()
+		listener.connections.Delete(u.conn.addr.String())
+		listener.reject(u.conn.addr, RejectMemoryLimit, fmt.Errorf("memory limit exceeded: connection held %v bytes", u.n))
+		total -= u.n
+	}
+}
+
+// logger returns the Logger of the listener, or a Logger writing to its ErrorLog if it has none.
+func (listener *Listener) logger() Logger {
+	if listener.Logger != nil {
+		return sampleLogger(listener.Logger, listener.logSampler)
+	}
+	return sampleLogger(errorLogLogger{log: listener.ErrorLog}, listener.logSampler)
+}
+
+// framing returns the framing of the primary protocol version of the listener, with its Quirks applied.
+func (listener *Listener) framing() protocolFraming {
+	return listener.config.quirks.apply(listener.config.profile.framing(listener.protocol))
+}
+
 // listen continuously reads from the listener's UDP connection, until closeCtx has a value in it.
 func (listener *Listener) listen() {
 	// Create a buffer with the maximum size a UDP packet sent over RakNet is allowed to have. We can re-use
 	// this buffer for each packet.
-	b := make([]byte, 1500)
+	b := make([]byte, listener.maxDatagramSize)
+	pprof.SetGoroutineLabels(listener.labels)
 	for {
 		n, addr, err := listener.conn.ReadFrom(b)
 		if err != nil {
 			close(listener.incoming)
+			close(listener.listenDone)
 			return
 		}
 		buffer := b[:n]
+		if listener.blackholed(addr) {
+			continue
+		}
+		if _, connected := listener.connections.Load(addr.String()); !listener.allow(addr.String(), connected) {
+			continue
+		}
 
 		// Technically we should not re-use the same byte slice after its ownership has been taken by the
 		// buffer, but we can do this anyway because we copy the data later.
+		var id byte
+		if n > 0 {
+			// The packet ID is stored up front, as handling the packet may overwrite the buffer.
+			id = buffer[0]
+		}
 		if err := listener.handle(bytes.NewBuffer(buffer), addr); err != nil {
-			listener.ErrorLog.Printf("error handling packet (rakAddr = %v): %v\n", addr, err)
+			value, connected := listener.connections.Load(addr.String())
+			if connected {
+				listener.emit(EventErrored, addr, value.(*Conn).id, err)
+			}
+			listener.logger().Warn("error handling packet", "remote_addr", addr, "packet_id", fmt.Sprintf("%#x", id), "category", packetCategory(id, connected), "error", err)
 		}
 	}
 }
@@ -221,7 +912,17 @@ func (listener *Listener) listen() {
 // handle handles an incoming packet in buffer b from the address passed. If not successful, an error is
 // returned describing the issue.
 func (listener *Listener) handle(b *bytes.Buffer, addr net.Addr) error {
+	if ban, ok := listener.bans.ipBan(addr, listener.config.clock); ok {
+		listener.reject(addr, RejectBanned, fmt.Errorf("IP address banned: %v", ban.Reason))
+		return nil
+	}
 	value, found := listener.connections.Load(addr.String())
+	if found && b.Len() > 0 && (b.Bytes()[0] == idOpenConnectionRequest1 || b.Bytes()[0] == idOpenConnectionRequest2) {
+		// Datagrams always have the valid bit set, so this is an open connection request. It is handled as an
+		// offline message, so that a client that lost its state may connect again. The existing connection is
+		// only replaced once the client sends an open connection request 2 that may be trusted.
+		found = false
+	}
 	if !found {
 		// If there was no session yet, it means the packet is an offline message. It is not contained in a
 		// datagram.
@@ -231,35 +932,142 @@ func (listener *Listener) handle(b *bytes.Buffer, addr net.Addr) error {
 		}
 		switch packetID {
 		case idUnconnectedPing:
+			atomic.AddUint64(&listener.counters.unconnectedPings, 1)
+			if ok, err := listener.validMagic(packetID, b.Bytes(), addr); !ok {
+				return err
+			}
 			return listener.handleUnconnectedPing(b, addr)
 		case idOpenConnectionRequest1:
+			atomic.AddUint64(&listener.counters.openConnectionRequests1, 1)
+			if ok, err := listener.validMagic(packetID, b.Bytes(), addr); !ok {
+				return err
+			}
 			return listener.handleOpenConnectionRequest1(b, addr)
 		case idOpenConnectionRequest2:
+			atomic.AddUint64(&listener.counters.openConnectionRequests2, 1)
+			if ok, err := listener.validMagic(packetID, b.Bytes(), addr); !ok {
+				return err
+			}
 			return listener.handleOpenConnectionRequest2(b, addr)
 		default:
+			atomic.AddUint64(&listener.counters.unknownOffline, 1)
 			// In some cases, the client will keep trying to send datagrams while it has already timed out. In
 			// this case, we should not print an error.
 			if packetID&bitFlagValid == 0 {
-				return fmt.Errorf("unknown packet received (%x): %x", packetID, b.Bytes())
+				err := fmt.Errorf("unknown packet received (%x): %x", packetID, b.Bytes())
+				listener.reject(addr, RejectUnknownPacket, err)
+				return err
 			}
 		}
 		return nil
 	}
+	if b.Len() >= listener.maxDatagramSize {
+		// The datagram filled the entire buffer, meaning it was likely truncated. Datagrams sent by a
+		// connection are never bigger than the MTU size, so we drop it. This is also what makes path MTU
+		// probes that are too big fail.
+		listener.reject(addr, RejectOversizedDatagram, nil)
+		return nil
+	}
 	conn := value.(*Conn)
-	return conn.receive(b)
+	pprof.SetGoroutineLabels(conn.labels)
+	defer pprof.SetGoroutineLabels(listener.labels)
+	err := conn.receive(b)
+	var panicErr *PanicError
+	if errors.Is(err, errSplitLimit) {
+		// The connection closed itself, so it is removed like one exceeding the memory limit.
+		listener.connections.Delete(addr.String())
+		listener.reject(addr, RejectSplitLimit, err)
+	} else if errors.As(err, &panicErr) {
+		// The connection was closed, so the event is emitted here before it is removed.
+		listener.emit(EventErrored, addr, conn.id, err)
+		listener.connections.Delete(addr.String())
+		listener.logger().Error("recovered panic handling packet", "remote_addr", addr, "guid", conn.id, "error", err, "stack", string(panicErr.Stack))
+		return nil
+	}
+	return err
 }
 
 // handleOpenConnectionRequest2 handles an open connection request 2 packet stored in buffer b, coming from
 // an address addr.
-func (listener *Listener) handleOpenConnectionRequest2(b *bytes.Buffer, addr net.Addr) error {
-	packet := &openConnectionRequest2{}
+func (listener *Listener) handleOpenConnectionRequest2(b *bytes.Buffer, addr net.Addr) (err error) {
+	ctx, connectSpan := listener.config.tracer.Start(context.Background(), "raknet.Connect", Attribute{Key: "raknet.remote_addr", Value: addr.String()})
+	_, span := listener.config.tracer.Start(ctx, "raknet.OpenConnectionRequest2")
+	var created bool
+	defer func() {
+		endSpan(span, err)
+		if err != nil || !created {
+			endSpan(connectSpan, err)
+		}
+	}()
+
+	packet := &openConnectionRequest2{Secure: listener.cookies != nil}
 	if err := packet.UnmarshalBinary(b.Bytes()); err != nil {
-		return fmt.Errorf("error reading open connection request 2: %v", err)
+		err = fmt.Errorf("error reading open connection request 2: %v", err)
+		listener.handshakeFailed(addr, 0, HandshakeInvalidPacket, err)
+		return err
 	}
 	b.Reset()
+	protocol := listener.protocol
+	if listener.cookies != nil {
+		// The cookie authenticates the protocol version of the open connection request 1, so that it need
+		// not be stored in between both requests.
+		var ok bool
+		if protocol, ok = listener.cookies.verify(packet.Cookie, addr, listener.protocols, listener.config.clock.Now()); !ok {
+			err := fmt.Errorf("error handling open connection request 2: cookie mismatch")
+			listener.handshakeFailed(addr, packet.ClientGUID, HandshakeSecurityFailed, err)
+			return err
+		}
+	}
+	if value, ok := listener.connections.Load(addr.String()); ok && value.(*Conn).id == packet.ClientGUID && value.(*Conn).completingSequence.Err() == nil {
+		// The client sent the request again, because our reply was lost or is still on its way. Its
+		// connection was already created, so we do not create another one.
+		return nil
+	}
+	if listener.cookies == nil && listener.listenConfig.ValidateSource && !listener.mtuProbes.probed(addr.String(), listener.config.clock.Now()) {
+		err := fmt.Errorf("error handling open connection request 2: no open connection request 1 answered")
+		listener.handshakeFailed(addr, packet.ClientGUID, HandshakeSecurityFailed, err)
+		return err
+	}
+	if ban, ok := listener.bans.guidBan(packet.ClientGUID, listener.config.clock.Now()); ok {
+		listener.reject(addr, RejectBanned, fmt.Errorf("GUID %v banned: %v", packet.ClientGUID, ban.Reason))
+		return listener.refuse(b, idConnectionBanned, addr)
+	}
+	resumed := listener.resumable(addr, packet.ClientGUID)
+	if listener.connectedAs(addr, packet.ClientGUID, resumed) {
+		listener.reject(addr, RejectAlreadyConnected, nil)
+		return listener.refuse(b, idAlreadyConnected, addr)
+	}
+	if atomic.LoadInt32(&listener.memoryExceeded) == 1 {
+		listener.reject(addr, RejectMemoryLimit, fmt.Errorf("memory limit exceeded: refusing new connection"))
+		return listener.refuse(b, idNoFreeIncomingConnections, addr)
+	}
+	if !listener.admit(addr, packet.ClientGUID) {
+		listener.reject(addr, RejectServerFull, nil)
+		return listener.refuse(b, idNoFreeIncomingConnections, addr)
+	}
+	if int(packet.MTUSize) > listener.maxMTUSize {
+		// The client attempted to negotiate an MTU size bigger than we allow. We clamp it to our maximum.
+		packet.MTUSize = int16(listener.maxMTUSize)
+	} else if packet.MTUSize < minMTUSize {
+		// The MTU size is an int16, so a client may even claim a negative one.
+		packet.MTUSize = minMTUSize
+	}
+	packet.MTUSize = int16(listener.framing().roundMTU(int(packet.MTUSize)))
+	if !listener.listenConfig.StatelessHandshake {
+		listener.mtuProbes.forget(addr.String())
+	}
 
+	var session *securitySession
 	address := rakAddr(*addr.(*net.UDPAddr))
-	response := &openConnectionReply2{Magic: magic, ServerGUID: listener.id, ClientAddress: &address, MTUSize: packet.MTUSize}
+	response := &openConnectionReply2{Magic: listener.magic, ServerGUID: listener.id, ClientAddress: &address, MTUSize: packet.MTUSize}
+	if listener.security != nil {
+		if session, response.SecurityAnswer, err = listener.security.accept(packet.Challenge); err != nil {
+			err = fmt.Errorf("error handling open connection request 2: %v", err)
+			listener.handshakeFailed(addr, packet.ClientGUID, HandshakeSecurityFailed, err)
+			return err
+		}
+		response.Secure = true
+	}
 	if err := b.WriteByte(idOpenConnectionReply2); err != nil {
 		return fmt.Errorf("error writing open connection reply 2 ID: %v", err)
 	}
@@ -274,30 +1082,66 @@ func (listener *Listener) handleOpenConnectionRequest2(b *bytes.Buffer, addr net
 		return fmt.Errorf("error sending open connection reply 2: %v", err)
 	}
 
-	conn := newConn(listener.conn, addr, packet.MTUSize, packet.ClientGUID)
+	connectSpan.SetAttributes(Attribute{Key: "raknet.guid", Value: packet.ClientGUID}, Attribute{Key: "raknet.mtu_size", Value: int(packet.MTUSize)})
+	config := listener.config
+	config.traceCtx = ctx
+	config.protocol = protocol
+	if listener.cookies == nil {
+		if p, ok := listener.pendingProtocols.take(addr.String(), listener.config.clock.Now()); ok {
+			config.protocol = p
+		}
+	}
+	limits := listener.Limits()
+	config.idleTimeout, config.sendWindow = limits.IdleTimeout, limits.SendWindow
+	config.session = session
+	if resumed != nil {
+		listener.resume(resumed)
+	}
+	conn := newConn(listener.conn, addr, packet.MTUSize, packet.ClientGUID, config)
+	conn.connectSpan, created = connectSpan, true
 	listener.connections.Store(addr.String(), conn)
+	listener.emit(EventHandshakeStarted, addr, packet.ClientGUID, nil)
 
 	// Add the connection to the incoming channel so that a caller of Accept() can receive it.
-	listener.incoming <- conn
+	select {
+	case listener.incoming <- conn:
+	case <-listener.closeCtx.Done():
+		// The listener was closed while the backlog was full.
+		_ = conn.Close()
+	}
 
 	return nil
 }
 
 // handleOpenConnectionRequest1 handles an open connection request 1 packet stored in buffer b, coming from
 // an address addr.
-func (listener *Listener) handleOpenConnectionRequest1(b *bytes.Buffer, addr net.Addr) error {
-	// mtuSize is the total size of the buffer. We already read the packet ID byte, so we need to add that to
-	// the size.
-	mtuSize := len(b.Bytes()) + 1
+func (listener *Listener) handleOpenConnectionRequest1(b *bytes.Buffer, addr net.Addr) (err error) {
+	_, span := listener.config.tracer.Start(context.Background(), "raknet.OpenConnectionRequest1", Attribute{Key: "raknet.remote_addr", Value: addr.String()})
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	// The MTU size probed is the total size of the buffer, plus the size of the UDP/IP header. We already read
+	// the packet ID byte, so we need to add that to the size. The size of the padding is all that matters, so
+	// its content is not checked.
+	mtuSize := probedMTUSize(len(b.Bytes())+1, listener.framing(), listener.maxMTUSize)
 
 	packet := &openConnectionRequest1{}
 	if err := binary.Read(b, binary.BigEndian, packet); err != nil {
-		return fmt.Errorf("error reading open connection request 1: %v", err)
+		err = fmt.Errorf("error reading open connection request 1: %v", err)
+		listener.handshakeFailed(addr, 0, HandshakeInvalidPacket, err)
+		return err
 	}
 	b.Reset()
 
-	if packet.Protocol != listener.protocol {
-		response := &incompatibleProtocolVersion{Magic: magic, ServerGUID: listener.id, ServerProtocol: listener.protocol}
+	span.SetAttributes(Attribute{Key: "raknet.protocol", Value: int(packet.Protocol)}, Attribute{Key: "raknet.mtu_size", Value: mtuSize})
+	if !hasProtocol(listener.protocols, packet.Protocol) {
+		protocolErr := fmt.Errorf("error handling open connection request 1: incompatible protocol version %v (listener protocols = %v)", packet.Protocol, listener.protocols)
+		listener.handshakeFailed(addr, 0, HandshakeIncompatibleProtocol, protocolErr)
+		response := &incompatibleProtocolVersion{Magic: listener.magic, ServerGUID: listener.id, ServerProtocol: listener.protocol}
+		if listener.listenConfig.AdvertiseClosestProtocol {
+			response.ServerProtocol = closestProtocol(listener.protocols, packet.Protocol)
+		}
 		if err := b.WriteByte(idIncompatibleProtocolVersion); err != nil {
 			return fmt.Errorf("error writing incompatible protocol version ID: %v", err)
 		}
@@ -307,16 +1151,43 @@ func (listener *Listener) handleOpenConnectionRequest1(b *bytes.Buffer, addr net
 		if _, err := listener.conn.WriteTo(b.Bytes(), addr); err != nil {
 			return fmt.Errorf("error sending incompatible protocol version: %v", err)
 		}
-		return fmt.Errorf("error handling open connection request 1: incompatible protocol version %v (listener protocol = %v)", packet.Protocol, listener.protocol)
+		return protocolErr
+	}
+
+	now := listener.config.clock.Now()
+	switch {
+	case listener.cookies != nil:
+		// The protocol version is authenticated by the cookie sent, so nothing needs to be remembered.
+	case packet.Protocol != listener.protocol:
+		// The open connection request 2 does not hold the protocol version, so we remember it until the
+		// client sends one.
+		listener.pendingProtocols.put(addr.String(), packet.Protocol, now)
+	default:
+		listener.pendingProtocols.take(addr.String(), now)
 	}
 
-	response := &openConnectionReply1{Magic: magic, ServerGUID: listener.id, MTUSize: int16(mtuSize) + 28}
+	if !listener.listenConfig.StatelessHandshake {
+		// Clients probe with decreasing sizes until one is answered, so a retry after a lost reply is
+		// answered with the largest size that reached us.
+		mtuSize = listener.mtuProbes.observe(addr.String(), mtuSize, now)
+	}
+	response := &openConnectionReply1{Magic: listener.magic, ServerGUID: listener.id, MTUSize: int16(mtuSize)}
+	if listener.cookies != nil {
+		response.Secure, response.Cookie = true, listener.cookies.cookie(addr, packet.Protocol, now)
+	}
+	if listener.security != nil {
+		response.ServerPublicKey = listener.security.key.PublicKey().Bytes()
+	}
 	if err := b.WriteByte(idOpenConnectionReply1); err != nil {
 		return fmt.Errorf("error writing open connection reply 1 ID: %v", err)
 	}
-	if err := binary.Write(b, binary.BigEndian, response); err != nil {
+	data, err := response.MarshalBinary()
+	if err != nil {
 		return fmt.Errorf("error writing open connection reply 1: %v", err)
 	}
+	if _, err := b.Write(data); err != nil {
+		return fmt.Errorf("error writing open connection reply 1 to buffer: %v", err)
+	}
 	if _, err := listener.conn.WriteTo(b.Bytes(), addr); err != nil {
 		return fmt.Errorf("error sending open connection reply 1: %v", err)
 	}
@@ -325,21 +1196,46 @@ func (listener *Listener) handleOpenConnectionRequest1(b *bytes.Buffer, addr net
 
 // handleUnconnectedPing handles an unconnected ping packet stored in buffer b, coming from an address addr.
 func (listener *Listener) handleUnconnectedPing(b *bytes.Buffer, addr net.Addr) error {
+	// The packet ID was already read from the buffer.
+	size := b.Len() + 1
 	packet := &unconnectedPing{}
 	if err := binary.Read(b, binary.BigEndian, packet); err != nil {
 		return fmt.Errorf("error reading unconnected ping: %v", err)
 	}
 	b.Reset()
 
+	if size < listener.listenConfig.MinPingSize {
+		listener.reject(addr, RejectAmplification, fmt.Errorf("unconnected ping of %v bytes is smaller than %v bytes", size, listener.listenConfig.MinPingSize))
+		return nil
+	}
+	if err := listener.writePong(b, packet.SendTimestamp); err != nil {
+		return err
+	}
+	if max := listener.listenConfig.MaxPongAmplification; max > 0 && float64(b.Len()) > float64(size)*max {
+		listener.reject(addr, RejectAmplification, fmt.Errorf("unconnected pong of %v bytes exceeds %vx the %v bytes of the ping", b.Len(), max, size))
+		return nil
+	}
+	if _, err := listener.conn.WriteTo(b.Bytes(), addr); err != nil {
+		return fmt.Errorf("error sending unconnected pong: %v", err)
+	}
+	return nil
+}
+
+// writePong writes an unconnected pong holding the current pong data of the listener and the send timestamp
+// passed to buffer b.
+func (listener *Listener) writePong(b *bytes.Buffer, sendTimestamp int64) error {
 	pongData := listener.pongData.Load().([]byte)
-	response := &unconnectedPong{Magic: magic, ServerGUID: listener.id, SendTimestamp: packet.SendTimestamp}
+	if listener.listenConfig.AdvertiseCapacity {
+		pongData = listener.advertiseCapacity(pongData)
+	}
+	response := &unconnectedPong{Magic: listener.magic, ServerGUID: listener.id, SendTimestamp: sendTimestamp}
 	if err := b.WriteByte(idUnconnectedPong); err != nil {
 		return fmt.Errorf("error writing unconnected pong ID: %v", err)
 	}
 	if err := binary.Write(b, binary.BigEndian, response); err != nil {
 		return fmt.Errorf("error writing unconnected pong: %v", err)
 	}
-	if listener.protocol == MinecraftProtocol {
+	if listener.framing().pongLengthPrefix {
 		if err := binary.Write(b, binary.BigEndian, int16(len(pongData))); err != nil {
 			return fmt.Errorf("error writing unconnected pong data length")
 		}
@@ -347,13 +1243,10 @@ func (listener *Listener) handleUnconnectedPing(b *bytes.Buffer, addr net.Addr)
 	if _, err := b.Write(pongData); err != nil {
 		return fmt.Errorf("error writing pong data to buffer: %v", err)
 	}
-	if _, err := listener.conn.WriteTo(b.Bytes(), addr); err != nil {
-		return fmt.Errorf("error sending unconnected pong: %v", err)
-	}
 	return nil
 }
 
 // timestamp returns a timestamp in milliseconds.
 func timestamp() int64 {
-	return time.Now().UnixNano() / int64(time.Second)
+	return time.Now().UnixNano() / int64(time.Millisecond)
 }
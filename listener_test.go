@@ -0,0 +1,274 @@
+package raknet
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListenerCloseWaits(t *testing.T) {
+	l, err := ListenConfig{MaxMemory: 1 << 20}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = c.Write([]byte{0xfe, 1, 2, 3})
+		}
+	}()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		l.Wait()
+		close(waited)
+	}()
+	if err := l.Close(); err != nil {
+		t.Fatalf("error closing listener: %v", err)
+	}
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatalf("Wait did not return after Close")
+	}
+	buf := make([]byte, 1<<20)
+	stacks := string(buf[:runtime.Stack(buf, true)])
+	if strings.Contains(stacks, "(*Listener).spawn") {
+		t.Fatalf("goroutines of the listener still running after Close:\n%v", stacks)
+	}
+}
+
+func TestDisconnectNotification(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	events, unsubscribe := l.Subscribe(16)
+	defer unsubscribe()
+
+	for _, reason := range [][]byte{nil, []byte("client quit")} {
+		client, err := Dial(l.Addr().String())
+		if err != nil {
+			t.Fatalf("error dialing: %v", err)
+		}
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatalf("error accepting: %v", err)
+		}
+		_ = client.CloseWithReason(reason)
+
+		_ = c.SetReadDeadline(time.Now().Add(time.Second * 2))
+		_, err = c.Read(make([]byte, 1500))
+		var disconnect *DisconnectError
+		if !errors.As(err, &disconnect) || !bytes.Equal(disconnect.Reason, reason) || !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected disconnect error with reason %q, got %v", reason, err)
+		}
+		for e := range events {
+			if e.Type != EventClosed {
+				continue
+			}
+			if !errors.As(e.Err, &disconnect) {
+				t.Fatalf("expected closed event with disconnect error, got %v", e.Err)
+			}
+			break
+		}
+	}
+}
+
+func TestListenerResume(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	events, unsubscribe := l.Subscribe(16)
+	defer unsubscribe()
+
+	d := &sameAddrDialer{raddr: l.Addr().(*net.UDPAddr)}
+	if _, err := d.dial(1); err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	d.loseState()
+
+	// An open connection request 2 holding the GUID of the connection is not trusted if no open connection
+	// request 1 of the address was answered, as it may have been sent from a spoofed address.
+	udp, err := net.DialUDP("udp", d.laddr, d.raddr)
+	if err != nil {
+		t.Fatalf("error dialing UDP: %v", err)
+	}
+	addr := rakAddr(*d.raddr)
+	request, _ := (&openConnectionRequest2{Magic: magic, ServerAddress: &addr, MTUSize: 1400, ClientGUID: c.(*Conn).id}).MarshalBinary()
+	if _, err := udp.Write(append([]byte{idOpenConnectionRequest2}, request...)); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	_ = udp.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := udp.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("expected reply to open connection request 2: %v", err)
+	}
+	_ = udp.Close()
+	if _, err := d.dial(2); !errors.Is(err, ErrAlreadyConnected) {
+		t.Fatalf("expected ErrAlreadyConnected dialing with another GUID, got %v", err)
+	}
+	d.loseState()
+	if c.(*Conn).closed() {
+		t.Fatalf("expected connection not to be closed by requests that may not be trusted")
+	}
+
+	resumed, err := d.dial(1)
+	if err != nil {
+		t.Fatalf("error dialing again: %v", err)
+	}
+	defer resumed.Close()
+	if _, err := l.Accept(); err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	if !c.(*Conn).closed() {
+		t.Fatalf("expected old connection to be closed once resumed")
+	}
+	for {
+		select {
+		case e := <-events:
+			if e.Type != EventResumed {
+				continue
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected resumed event")
+		}
+		break
+	}
+}
+
+func TestListenerResumeRefused(t *testing.T) {
+	l, err := ListenConfig{MaxConnections: 1}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	events, unsubscribe := l.Subscribe(16)
+	defer unsubscribe()
+
+	d := &sameAddrDialer{raddr: l.Addr().(*net.UDPAddr)}
+	if _, err := d.dial(1); err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	d.loseState()
+
+	// The listener is full, so the client is refused and its old connection must be left as it is.
+	if _, err := d.dial(1); !errors.Is(err, ErrServerFull) {
+		t.Fatalf("expected ErrServerFull resuming on a full listener, got %v", err)
+	}
+	d.loseState()
+	if c.(*Conn).closed() {
+		t.Fatalf("expected connection to survive a refused resume")
+	}
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventResumed || e.Type == EventClosed {
+			t.Fatalf("unexpected event %v", e.Type)
+		}
+	}
+}
+
+// sameAddrDialer dials connections over sockets bound to the same local address, so that closing the
+// connection of a client without a disconnect notification makes it lose its state, after which it may
+// connect again from the same address.
+type sameAddrDialer struct {
+	laddr, raddr *net.UDPAddr
+	socket       *net.UDPConn
+	conn         *Conn
+}
+
+// dial dials a connection using a client GUID generated from the seed passed.
+func (d *sameAddrDialer) dial(seed int64) (*Conn, error) {
+	var err error
+	d.conn, err = Dialer{Rand: rand.New(rand.NewSource(seed))}.dial(d.raddr.String(), func() (net.Conn, error) {
+		var err error
+		if d.socket, err = net.DialUDP("udp", d.laddr, d.raddr); err == nil {
+			d.laddr = d.socket.LocalAddr().(*net.UDPAddr)
+		}
+		return d.socket, err
+	})
+	return d.conn, err
+}
+
+// loseState closes the connection and socket of the last client dialed without notifying the listener.
+func (d *sameAddrDialer) loseState() {
+	if d.conn == nil {
+		_ = d.socket.Close()
+		return
+	}
+	// The socket is closed by the Dialer once the connection is closed, which is waited for so that its
+	// address may be bound again.
+	atomic.StoreInt32(&d.conn.disconnectSent, 1)
+	_ = d.conn.Close()
+	for d.socket.SetDeadline(time.Time{}) == nil {
+		time.Sleep(time.Millisecond)
+	}
+	d.conn = nil
+}
+
+func TestLowFootprint(t *testing.T) {
+	l, err := ListenConfig{LowFootprint: true, ReadQueueSize: 100}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	if n := cap(l.incoming); n != lowFootprintBacklog {
+		t.Fatalf("expected accept backlog of %v, got %v", lowFootprintBacklog, n)
+	}
+	client, err := Dialer{LowFootprint: true, SendWindow: 100}.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	server := c.(*Conn)
+
+	// The reduced defaults only apply to the fields left empty.
+	for name, expected := range map[string]struct {
+		conn                      *Conn
+		sendWindow, readQueueSize int
+	}{
+		"client": {client, 100, lowFootprintReadQueueSize},
+		"server": {server, lowFootprintSendWindow, 100},
+	} {
+		conn := expected.conn
+		if conn.sendWindow != expected.sendWindow {
+			t.Errorf("%v: expected send window of %v, got %v", name, expected.sendWindow, conn.sendWindow)
+		}
+		if n := len(conn.recoveryQueue.delays); n != lowFootprintDelayRecordCount {
+			t.Errorf("%v: expected %v delay records, got %v", name, lowFootprintDelayRecordCount, n)
+		}
+		if n := cap(conn.packetChan); n != expected.readQueueSize {
+			t.Errorf("%v: expected read queue size of %v, got %v", name, expected.readQueueSize, n)
+		}
+	}
+}
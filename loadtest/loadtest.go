@@ -0,0 +1,275 @@
+// Package loadtest generates load against a RakNet server by running many simulated clients at once. Each
+// client dials a connection, completing the full connection sequence, and writes packets to it following a
+// configurable pattern, after which the throughput, handshake latency and error rates are reported:
+//
+//	result := loadtest.Run("127.0.0.1:19132", loadtest.Config{Clients: 500, Duration: time.Minute})
+//	fmt.Println(result)
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// Config holds the configuration of a load test.
+type Config struct {
+	// Clients is the amount of clients that connect to the server simultaneously.
+	// Clients is 1 by default.
+	Clients int
+	// Duration is the time each client stays connected and writes packets for after completing its
+	// connection sequence.
+	// Duration is 10 seconds by default.
+	Duration time.Duration
+	// RampUp is the period over which the dialing of clients is spread evenly, so that the server is not hit
+	// by all connection sequences at once.
+	// RampUp is 0 by default, meaning all clients dial at the same time.
+	RampUp time.Duration
+	// Dialer is the raknet.Dialer used to dial the connections of the clients. It may be used to set the
+	// protocol version or to simulate network conditions using its WrapConn field.
+	// If the ErrorLog of the Dialer is nil, errors are discarded.
+	Dialer raknet.Dialer
+	// Pattern is the pattern in which each client sends and receives packets.
+	Pattern Pattern
+}
+
+// Pattern is the pattern in which a client sends and receives packets once connected.
+type Pattern struct {
+	// PacketSize is the size of each packet written. Packets start with 0xfe, the ID of game packets in
+	// Minecraft, so that Minecraft servers do not drop them.
+	// PacketSize is 64 by default.
+	PacketSize int
+	// PacketsPerSecond is the amount of packets written by each client every second. If negative, clients do
+	// not write any packets and only stay connected.
+	// PacketsPerSecond is 20 by default.
+	PacketsPerSecond int
+	// Burst is the amount of packets written at once every time a client writes. The time between bursts is
+	// adapted so that PacketsPerSecond is still met.
+	// Burst is 1 by default.
+	Burst int
+	// Read specifies if clients read the packets the server sends to them, such as echoes of the packets
+	// written. If false, packets sent by the server are left unread, which may cause the connection to be
+	// closed if the server sends many of them.
+	Read bool
+}
+
+// Result holds the results of a load test.
+type Result struct {
+	// Clients is the amount of clients that attempted to connect.
+	Clients int
+	// Connected is the amount of clients that completed their connection sequence.
+	Connected int
+	// HandshakeErrors is the amount of clients that failed to complete their connection sequence.
+	HandshakeErrors int
+	// Disconnects is the amount of connected clients whose connection failed before the end of the test,
+	// for example because the server closed it.
+	Disconnects int
+	// PacketsSent and PacketsReceived are the amount of packets written and read by all clients.
+	PacketsSent, PacketsReceived uint64
+	// BytesSent and BytesReceived are the amount of bytes in the packets written and read by all clients.
+	BytesSent, BytesReceived uint64
+	// HandshakeLatencies holds the time each connected client took to complete its connection sequence,
+	// sorted from short to long.
+	HandshakeLatencies []time.Duration
+	// Duration is the time the entire load test took.
+	Duration time.Duration
+}
+
+// HandshakeErrorRate returns the fraction of clients, from 0 to 1, that failed to complete their connection
+// sequence.
+func (result Result) HandshakeErrorRate() float64 {
+	if result.Clients == 0 {
+		return 0
+	}
+	return float64(result.HandshakeErrors) / float64(result.Clients)
+}
+
+// DisconnectRate returns the fraction of connected clients, from 0 to 1, whose connection failed before the
+// end of the test.
+func (result Result) DisconnectRate() float64 {
+	if result.Connected == 0 {
+		return 0
+	}
+	return float64(result.Disconnects) / float64(result.Connected)
+}
+
+// SendThroughput returns the amount of bytes written by all clients per second.
+func (result Result) SendThroughput() float64 {
+	if result.Duration <= 0 {
+		return 0
+	}
+	return float64(result.BytesSent) / result.Duration.Seconds()
+}
+
+// ReceiveThroughput returns the amount of bytes read by all clients per second.
+func (result Result) ReceiveThroughput() float64 {
+	if result.Duration <= 0 {
+		return 0
+	}
+	return float64(result.BytesReceived) / result.Duration.Seconds()
+}
+
+// HandshakePercentile returns the handshake latency below which the fraction p, from 0 to 1, of the
+// handshakes completed. It returns 0 if no client connected.
+func (result Result) HandshakePercentile(p float64) time.Duration {
+	if len(result.HandshakeLatencies) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(result.HandshakeLatencies)))
+	if i >= len(result.HandshakeLatencies) {
+		i = len(result.HandshakeLatencies) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return result.HandshakeLatencies[i]
+}
+
+// String returns a summary of the result.
+func (result Result) String() string {
+	b := &strings.Builder{}
+	_, _ = fmt.Fprintf(b, "%v/%v clients connected in %v (handshake errors: %.1f%%, disconnects: %.1f%%)\n", result.Connected, result.Clients, result.Duration.Round(time.Millisecond), result.HandshakeErrorRate()*100, result.DisconnectRate()*100)
+	_, _ = fmt.Fprintf(b, "handshake latency: p50 %v, p90 %v, p99 %v\n", result.HandshakePercentile(0.5), result.HandshakePercentile(0.9), result.HandshakePercentile(0.99))
+	_, _ = fmt.Fprintf(b, "sent %v packets (%.0f B/s), received %v packets (%.0f B/s)\n", result.PacketsSent, result.SendThroughput(), result.PacketsReceived, result.ReceiveThroughput())
+	return b.String()
+}
+
+// Run runs a load test against the server with the address passed using the Config passed. It blocks until
+// all clients have finished and returns the Result of the test.
+func Run(addr string, config Config) Result {
+	if config.Clients <= 0 {
+		config.Clients = 1
+	}
+	if config.Duration <= 0 {
+		config.Duration = time.Second * 10
+	}
+	if config.Pattern.PacketSize <= 0 {
+		config.Pattern.PacketSize = 64
+	}
+	if config.Pattern.PacketsPerSecond == 0 {
+		config.Pattern.PacketsPerSecond = 20
+	}
+	if config.Pattern.Burst <= 0 {
+		config.Pattern.Burst = 1
+	}
+	if config.Dialer.ErrorLog == nil {
+		config.Dialer.ErrorLog = log.New(io.Discard, "", 0)
+	}
+
+	r := &run{config: config, addr: addr}
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < config.Clients; i++ {
+		wg.Add(1)
+		go func(delay time.Duration) {
+			defer wg.Done()
+			time.Sleep(delay)
+			r.client()
+		}(config.RampUp * time.Duration(i) / time.Duration(config.Clients))
+	}
+	wg.Wait()
+
+	r.result.Clients = config.Clients
+	r.result.PacketsSent, r.result.PacketsReceived = atomic.LoadUint64(&r.packetsSent), atomic.LoadUint64(&r.packetsReceived)
+	r.result.BytesSent, r.result.BytesReceived = atomic.LoadUint64(&r.bytesSent), atomic.LoadUint64(&r.bytesReceived)
+	r.result.Duration = time.Since(start)
+	sort.Slice(r.result.HandshakeLatencies, func(i, j int) bool {
+		return r.result.HandshakeLatencies[i] < r.result.HandshakeLatencies[j]
+	})
+	return r.result
+}
+
+// run holds the state of a load test that is running.
+type run struct {
+	packetsSent, packetsReceived uint64
+	bytesSent, bytesReceived     uint64
+
+	config Config
+	addr   string
+
+	mu     sync.Mutex
+	result Result
+}
+
+// client runs a single client of the load test until the duration of the test has passed or its connection
+// fails.
+func (r *run) client() {
+	start := time.Now()
+	conn, err := r.config.Dialer.Dial(r.addr)
+	if err != nil {
+		r.mu.Lock()
+		r.result.HandshakeErrors++
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Lock()
+	r.result.Connected++
+	r.result.HandshakeLatencies = append(r.result.HandshakeLatencies, time.Since(start))
+	r.mu.Unlock()
+
+	end := time.After(r.config.Duration)
+	failed := make(chan struct{}, 2)
+	if r.config.Pattern.Read {
+		go func() {
+			r.read(conn)
+			failed <- struct{}{}
+		}()
+	}
+	if r.config.Pattern.PacketsPerSecond > 0 {
+		go func() {
+			if err := r.write(conn); err != nil {
+				failed <- struct{}{}
+			}
+		}()
+	}
+	select {
+	case <-end:
+	case <-failed:
+		r.mu.Lock()
+		r.result.Disconnects++
+		r.mu.Unlock()
+	}
+	_ = conn.Close()
+}
+
+// write writes packets to the connection passed following the pattern of the load test until writing
+// fails, which happens at the latest once the connection is closed.
+func (r *run) write(conn *raknet.Conn) error {
+	pattern := r.config.Pattern
+	packet := make([]byte, pattern.PacketSize)
+	packet[0] = 0xfe
+	interval := time.Second * time.Duration(pattern.Burst) / time.Duration(pattern.PacketsPerSecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for i := 0; i < pattern.Burst; i++ {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			atomic.AddUint64(&r.packetsSent, 1)
+			atomic.AddUint64(&r.bytesSent, uint64(len(packet)))
+		}
+		<-ticker.C
+	}
+}
+
+// read reads packets from the connection passed until reading fails, which happens at the latest once the
+// connection is closed.
+func (r *run) read(conn *raknet.Conn) {
+	b := make([]byte, 1024*1024)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return
+		}
+		atomic.AddUint64(&r.packetsReceived, 1)
+		atomic.AddUint64(&r.bytesReceived, uint64(n))
+	}
+}
@@ -0,0 +1,52 @@
+package loadtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+func TestRun(t *testing.T) {
+	l, err := raknet.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b := make([]byte, 1500)
+				for {
+					n, err := c.Read(b)
+					if err != nil {
+						return
+					}
+					if _, err := c.Write(b[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	result := Run(l.Addr().String(), Config{
+		Clients:  5,
+		Duration: time.Second,
+		RampUp:   time.Millisecond * 100,
+		Pattern:  Pattern{PacketsPerSecond: 50, Read: true},
+	})
+	if result.Connected != 5 || result.HandshakeErrors != 0 || result.Disconnects != 0 {
+		t.Fatalf("expected all clients to connect and stay connected:\n%v", result)
+	}
+	if result.PacketsSent == 0 || result.PacketsReceived == 0 {
+		t.Fatalf("expected packets to be sent and echoed:\n%v", result)
+	}
+	if len(result.HandshakeLatencies) != 5 || result.HandshakePercentile(1) == 0 {
+		t.Fatalf("expected a handshake latency for every client:\n%v", result)
+	}
+}
@@ -0,0 +1,73 @@
+package raknet
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is a leveled, structured logger. Each record has a message and a list of alternating keys and
+// values, such as "remote_addr", addr. A *slog.Logger implements Logger and may be used directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// errorLogLogger is a Logger that writes records to a *log.Logger. Debug and info records are discarded, so
+// that only the records previously written to the ErrorLog of a Listener or Dialer are written.
+type errorLogLogger struct {
+	log *log.Logger
+}
+
+func (errorLogLogger) Debug(string, ...interface{}) {}
+func (errorLogLogger) Info(string, ...interface{})  {}
+
+// Warn writes a warning record to the *log.Logger.
+func (l errorLogLogger) Warn(msg string, args ...interface{}) {
+	l.write("WARN", msg, args)
+}
+
+// Error writes an error record to the *log.Logger.
+func (l errorLogLogger) Error(msg string, args ...interface{}) {
+	l.write("ERROR", msg, args)
+}
+
+// write writes a record with the level, message and arguments passed to the *log.Logger, formatting the
+// arguments as key=value pairs.
+func (l errorLogLogger) write(level, msg string, args []interface{}) {
+	b := &strings.Builder{}
+	b.WriteString(level + " " + msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			_, _ = fmt.Fprintf(b, " !BADKEY=%v", args[i])
+			break
+		}
+		_, _ = fmt.Fprintf(b, " %v=%v", args[i], args[i+1])
+	}
+	l.log.Println(b.String())
+}
+
+// Error categories set on log records, so that records may be filtered by the part of the protocol that
+// caused them.
+const (
+	categoryOffline   = "offline"
+	categoryHandshake = "handshake"
+	categoryDatagram  = "datagram"
+	categoryMemory    = "memory"
+	categoryRead      = "read"
+)
+
+// packetCategory returns the error category of a packet with the ID passed. connected specifies if the
+// packet was received from an established connection.
+func packetCategory(id byte, connected bool) string {
+	switch {
+	case connected:
+		return categoryDatagram
+	case id == idOpenConnectionRequest1 || id == idOpenConnectionRequest2:
+		return categoryHandshake
+	default:
+		return categoryOffline
+	}
+}
@@ -0,0 +1,131 @@
+package raknet
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// lossBuckets is the amount of one second buckets in the sliding window over which the packet loss of a
+	// connection is estimated.
+	lossBuckets = 10
+	// lossMinSamples is the minimum amount of datagrams in the window required to estimate the loss in a
+	// direction. If fewer datagrams were sent or received, the loss in that direction is estimated as 0.
+	lossMinSamples = 10
+)
+
+// lossEstimator estimates the packet loss of a connection in both directions over a sliding window.
+// Outbound loss is estimated from the datagrams that the other end reported missing in NACKs. Inbound loss is
+// estimated from gaps in the sequence numbers of datagrams received.
+type lossEstimator struct {
+	mu      sync.Mutex
+	clock   Clock
+	buckets [lossBuckets]lossBucket
+
+	// highest is the highest sequence number received so far, if received is true.
+	highest  uint24
+	received bool
+}
+
+// lossBucket holds the datagrams counted during one second.
+type lossBucket struct {
+	second                   int64
+	sent, nacked             int
+	received, missing, found int
+}
+
+// bucket returns the bucket for the time passed, clearing it if it held an older second.
+func (e *lossEstimator) bucket(now time.Time) *lossBucket {
+	second := now.Unix()
+	b := &e.buckets[second%lossBuckets]
+	if b.second != second {
+		*b = lossBucket{second: second}
+	}
+	return b
+}
+
+// sent records a datagram sent, either for the first time or as a resend.
+func (e *lossEstimator) sent() {
+	e.mu.Lock()
+	e.bucket(e.clock.Now()).sent++
+	e.mu.Unlock()
+}
+
+// nacked records n datagrams that the other end reported missing in a NACK.
+func (e *lossEstimator) nacked(n int) {
+	e.mu.Lock()
+	e.bucket(e.clock.Now()).nacked += n
+	e.mu.Unlock()
+}
+
+// receivedDatagram records a datagram received with the sequence number passed. Sequence numbers skipped
+// are counted as missing, until a datagram with one of those sequence numbers arrives late.
+func (e *lossEstimator) receivedDatagram(sequenceNumber uint24) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b := e.bucket(e.clock.Now())
+	b.received++
+	if !e.received {
+		e.highest, e.received = sequenceNumber, true
+		return
+	}
+	// Sequence numbers wrap around, so the difference is calculated modulo 2^24. A difference in the upper
+	// half means the sequence number is lower than the highest.
+	diff := (sequenceNumber - e.highest) & 0xffffff
+	if diff == 0 {
+		return
+	}
+	if diff < 1<<23 {
+		b.missing += int(diff) - 1
+		e.highest = sequenceNumber
+		return
+	}
+	// The datagram arrived out of order, so it was not missing after all.
+	b.found++
+}
+
+// rates returns the estimated outbound and inbound loss rates over the window, each between 0 and 1.
+func (e *lossEstimator) rates() (outbound, inbound float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.clock.Now().Unix()
+	var sent, nacked, received, missing int
+	for _, b := range e.buckets {
+		if now-b.second >= lossBuckets {
+			continue
+		}
+		sent += b.sent
+		nacked += b.nacked
+		received += b.received
+		missing += b.missing - b.found
+	}
+	if sent >= lossMinSamples {
+		outbound = clampRate(float64(nacked) / float64(sent))
+	}
+	if received+missing >= lossMinSamples && missing > 0 {
+		inbound = clampRate(float64(missing) / float64(received+missing))
+	}
+	return outbound, inbound
+}
+
+// clampRate clamps the rate passed to the range 0-1.
+func clampRate(rate float64) float64 {
+	if rate < 0 {
+		return 0
+	}
+	if rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// PacketLoss returns the estimated packet loss rate of the connection over the last 10 seconds, between 0
+// and 1. It is the highest of the estimated loss of datagrams sent and of datagrams received, which may be
+// used to warn users about bad connections.
+func (conn *Conn) PacketLoss() float64 {
+	outbound, inbound := conn.loss.rates()
+	if outbound > inbound {
+		return outbound
+	}
+	return inbound
+}
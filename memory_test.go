@@ -0,0 +1,69 @@
+package raknet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShedLeastActive(t *testing.T) {
+	l, err := ListenConfig{ShedPolicy: ShedLeastActive}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	var conns [2]*Conn
+	for i := range conns {
+		client, err := Dial(l.Addr().String())
+		if err != nil {
+			t.Fatalf("error dialing: %v", err)
+		}
+		defer client.Close()
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatalf("error accepting: %v", err)
+		}
+		conns[i] = c.(*Conn)
+		// The packet written is never read, so that it is held in the read queue.
+		if _, err := client.Write(bytes.Repeat([]byte{0xfe}, 600)); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for conns[0].MemoryUsage() < 600 || conns[1].MemoryUsage() < 600 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected unread packets to count towards memory usage, got %v and %v", conns[0].MemoryUsage(), conns[1].MemoryUsage())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	conns[0].lastPacketTime.Store(time.Now().Add(-time.Minute))
+
+	// The 600 bytes left after closing one connection are above three quarters of the maximum, so clients
+	// are still refused after.
+	l.SetLimits(Limits{MaxMemory: 700})
+	l.shedMemory()
+	select {
+	case <-conns[0].closeCtx.Done():
+	default:
+		t.Fatalf("expected least active connection to be closed")
+	}
+	if conns[1].closeCtx.Err() != nil {
+		t.Fatalf("expected active connection to be kept")
+	}
+	if _, err := Dial(l.Addr().String()); !errors.Is(err, ErrServerFull) {
+		t.Fatalf("expected client to be refused while over the memory limit, got %v", err)
+	}
+
+	// Reading the queued packet releases its memory, after which clients are accepted again.
+	if _, err := conns[1].Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	l.shedMemory()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing below the memory limit: %v", err)
+	}
+	_ = client.Close()
+}
@@ -0,0 +1,70 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	// ipv4AddrSize and ipv6AddrSize are the sizes of encoded IPv4 and IPv6 addresses, including the version
+	// byte.
+	ipv4AddrSize = 1 + 4 + 2
+	ipv6AddrSize = 1 + 2 + 2 + 4 + 16 + 4
+	// ipv6Family is the address family written for IPv6 addresses, which is AF_INET6 as defined on Windows.
+	ipv6Family = 23
+)
+
+// writeAddr writes the address passed to buffer b. A nil address is written as the unspecified IPv4 address
+// with port 0.
+func writeAddr(b *bytes.Buffer, addr *net.UDPAddr) {
+	if addr == nil {
+		addr = &net.UDPAddr{IP: net.IPv4zero}
+	}
+	if ip := addr.IP.To4(); ip != nil {
+		b.WriteByte(4)
+		// IPv4 addresses are written with all of their bits flipped.
+		b.Write([]byte{^ip[0], ^ip[1], ^ip[2], ^ip[3]})
+		_ = binary.Write(b, binary.BigEndian, uint16(addr.Port))
+		return
+	}
+	b.WriteByte(6)
+	_ = binary.Write(b, binary.LittleEndian, int16(ipv6Family))
+	_ = binary.Write(b, binary.BigEndian, uint16(addr.Port))
+	// The flow info and the scope ID enclosing the IP are always 0.
+	b.Write(make([]byte, 4))
+	ip := addr.IP.To16()
+	if ip == nil {
+		ip = net.IPv6unspecified
+	}
+	b.Write(ip)
+	b.Write(make([]byte, 4))
+}
+
+// readAddr reads an address from buffer b. An error is returned if the buffer does not hold a full address
+// or if its version is unknown.
+func readAddr(b *bytes.Buffer) (*net.UDPAddr, error) {
+	ver, err := b.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("error reading address version: %v", err)
+	}
+	switch ver {
+	case 4:
+		data := b.Next(ipv4AddrSize - 1)
+		if len(data) != ipv4AddrSize-1 {
+			return nil, fmt.Errorf("error reading ipv4 address: not enough bytes")
+		}
+		ip := net.IPv4(^data[0], ^data[1], ^data[2], ^data[3])
+		return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(data[4:]))}, nil
+	case 6:
+		data := b.Next(ipv6AddrSize - 1)
+		if len(data) != ipv6AddrSize-1 {
+			return nil, fmt.Errorf("error reading ipv6 address: not enough bytes")
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, data[8:24])
+		return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(data[2:]))}, nil
+	}
+	return nil, fmt.Errorf("error reading address: unknown version %v", ver)
+}
@@ -0,0 +1,246 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// systemAddressCount is the amount of system addresses written in a ConnectionRequestAccepted and a
+// NewIncomingConnection by the raknet package. Minecraft uses 20 addresses, whereas RakNet itself uses 10 by
+// default.
+const systemAddressCount = 20
+
+// ConnectedPing is sent by either end of a connection to measure the latency and to keep the connection
+// alive.
+type ConnectedPing struct {
+	PingTimestamp int64
+}
+
+// ID ...
+func (*ConnectedPing) ID() byte { return IDConnectedPing }
+
+// MarshalBinary ...
+func (msg *ConnectedPing) MarshalBinary() ([]byte, error) {
+	b := header(IDConnectedPing)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *ConnectedPing) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDConnectedPing, "connected ping")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding connected ping: %v", err)
+	}
+	return nil
+}
+
+// ConnectedPong is sent in response to a ConnectedPing. It holds the timestamp of the ping and the timestamp
+// at which the pong was sent.
+type ConnectedPong struct {
+	PingTimestamp int64
+	PongTimestamp int64
+}
+
+// ID ...
+func (*ConnectedPong) ID() byte { return IDConnectedPong }
+
+// MarshalBinary ...
+func (msg *ConnectedPong) MarshalBinary() ([]byte, error) {
+	b := header(IDConnectedPong)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *ConnectedPong) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDConnectedPong, "connected pong")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding connected pong: %v", err)
+	}
+	return nil
+}
+
+// ConnectionRequest is sent by a client once the offline connection sequence completed, as the first
+// message over the connection.
+type ConnectionRequest struct {
+	ClientGUID       int64
+	RequestTimestamp int64
+	Secure           bool
+}
+
+// ID ...
+func (*ConnectionRequest) ID() byte { return IDConnectionRequest }
+
+// MarshalBinary ...
+func (msg *ConnectionRequest) MarshalBinary() ([]byte, error) {
+	b := header(IDConnectionRequest)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *ConnectionRequest) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDConnectionRequest, "connection request")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding connection request: %v", err)
+	}
+	return nil
+}
+
+// ConnectionRequestAccepted is sent by a server in response to a ConnectionRequest.
+type ConnectionRequestAccepted struct {
+	ClientAddress *net.UDPAddr
+	SystemIndex   int16
+	// SystemAddresses holds the internal addresses of the server. When encoding, 20 addresses are written,
+	// of which those not set are written as the unspecified IPv4 address. When decoding, as many addresses
+	// are read as there are in the message, as the amount differs between implementations.
+	SystemAddresses   []*net.UDPAddr
+	RequestTimestamp  int64
+	AcceptedTimestamp int64
+}
+
+// ID ...
+func (*ConnectionRequestAccepted) ID() byte { return IDConnectionRequestAccepted }
+
+// MarshalBinary ...
+func (msg *ConnectionRequestAccepted) MarshalBinary() ([]byte, error) {
+	b := header(IDConnectionRequestAccepted)
+	writeAddr(b, msg.ClientAddress)
+	_ = binary.Write(b, binary.BigEndian, msg.SystemIndex)
+	writeSystemAddresses(b, msg.SystemAddresses)
+	_ = binary.Write(b, binary.BigEndian, msg.RequestTimestamp)
+	_ = binary.Write(b, binary.BigEndian, msg.AcceptedTimestamp)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *ConnectionRequestAccepted) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDConnectionRequestAccepted, "connection request accepted")
+	if err != nil {
+		return err
+	}
+	if msg.ClientAddress, err = readAddr(b); err != nil {
+		return fmt.Errorf("error decoding connection request accepted: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.SystemIndex); err != nil {
+		return fmt.Errorf("error decoding connection request accepted: %v", err)
+	}
+	if msg.SystemAddresses, err = readSystemAddresses(b); err != nil {
+		return fmt.Errorf("error decoding connection request accepted: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.RequestTimestamp); err != nil {
+		return fmt.Errorf("error decoding connection request accepted: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.AcceptedTimestamp); err != nil {
+		return fmt.Errorf("error decoding connection request accepted: %v", err)
+	}
+	return nil
+}
+
+// NewIncomingConnection is sent by a client in response to a ConnectionRequestAccepted, after which the
+// connection sequence is complete.
+type NewIncomingConnection struct {
+	ServerAddress *net.UDPAddr
+	// SystemAddresses holds the internal addresses of the client. They are encoded and decoded like the
+	// SystemAddresses of a ConnectionRequestAccepted.
+	SystemAddresses   []*net.UDPAddr
+	RequestTimestamp  int64
+	AcceptedTimestamp int64
+}
+
+// ID ...
+func (*NewIncomingConnection) ID() byte { return IDNewIncomingConnection }
+
+// MarshalBinary ...
+func (msg *NewIncomingConnection) MarshalBinary() ([]byte, error) {
+	b := header(IDNewIncomingConnection)
+	writeAddr(b, msg.ServerAddress)
+	writeSystemAddresses(b, msg.SystemAddresses)
+	_ = binary.Write(b, binary.BigEndian, msg.RequestTimestamp)
+	_ = binary.Write(b, binary.BigEndian, msg.AcceptedTimestamp)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *NewIncomingConnection) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDNewIncomingConnection, "new incoming connection")
+	if err != nil {
+		return err
+	}
+	if msg.ServerAddress, err = readAddr(b); err != nil {
+		return fmt.Errorf("error decoding new incoming connection: %v", err)
+	}
+	if msg.SystemAddresses, err = readSystemAddresses(b); err != nil {
+		return fmt.Errorf("error decoding new incoming connection: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.RequestTimestamp); err != nil {
+		return fmt.Errorf("error decoding new incoming connection: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.AcceptedTimestamp); err != nil {
+		return fmt.Errorf("error decoding new incoming connection: %v", err)
+	}
+	return nil
+}
+
+// DisconnectNotification is sent by either end of a connection when it closes the connection.
+type DisconnectNotification struct {
+	// Reason is appended to the notification by some implementations. The official RakNet library does not
+	// write it, in which case it is nil.
+	Reason []byte
+}
+
+// ID ...
+func (*DisconnectNotification) ID() byte { return IDDisconnectNotification }
+
+// MarshalBinary ...
+func (msg *DisconnectNotification) MarshalBinary() ([]byte, error) {
+	return append([]byte{IDDisconnectNotification}, msg.Reason...), nil
+}
+
+// UnmarshalBinary ...
+func (msg *DisconnectNotification) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDDisconnectNotification, "disconnect notification")
+	if err != nil {
+		return err
+	}
+	msg.Reason = append([]byte(nil), b.Bytes()...)
+	return nil
+}
+
+// writeSystemAddresses writes the system addresses passed to buffer b, filling up the addresses not set so
+// that systemAddressCount addresses are written, or more if more are set.
+func writeSystemAddresses(b *bytes.Buffer, addrs []*net.UDPAddr) {
+	for i := 0; i < systemAddressCount || i < len(addrs); i++ {
+		var addr *net.UDPAddr
+		if i < len(addrs) {
+			addr = addrs[i]
+		}
+		writeAddr(b, addr)
+	}
+}
+
+// readSystemAddresses reads system addresses from buffer b until only the two timestamps following them are
+// left.
+func readSystemAddresses(b *bytes.Buffer) ([]*net.UDPAddr, error) {
+	var addrs []*net.UDPAddr
+	for b.Len() > 16 {
+		addr, err := readAddr(b)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,352 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// FlagValid is set in the first byte of every datagram sent over a connection, which tells datagrams
+	// apart from offline messages.
+	FlagValid byte = 0x80
+	// FlagACK is set in the first byte of datagrams holding an Acknowledgement that is an ACK.
+	FlagACK byte = 0x40
+	// FlagNACK is set in the first byte of datagrams holding an Acknowledgement that is a NACK.
+	FlagNACK byte = 0x20
+)
+
+// Reliability is the reliability that a Packet is sent with. It decides which of the indices of the Packet
+// are present.
+type Reliability byte
+
+const (
+	Unreliable Reliability = iota
+	UnreliableSequenced
+	Reliable
+	ReliableOrdered
+	ReliableSequenced
+	UnreliableWithAckReceipt
+	ReliableWithAckReceipt
+	ReliableOrderedWithAckReceipt
+)
+
+// Reliable checks if packets sent with the Reliability have a message index.
+func (r Reliability) Reliable() bool {
+	switch r {
+	case Reliable, ReliableOrdered, ReliableSequenced, ReliableWithAckReceipt, ReliableOrderedWithAckReceipt:
+		return true
+	}
+	return false
+}
+
+// Sequenced checks if packets sent with the Reliability have a sequence index.
+func (r Reliability) Sequenced() bool {
+	return r == UnreliableSequenced || r == ReliableSequenced
+}
+
+// Ordered checks if packets sent with the Reliability have an order index and an order channel. Sequenced
+// packets are ordered too.
+func (r Reliability) Ordered() bool {
+	return r.Sequenced() || r == ReliableOrdered || r == ReliableOrderedWithAckReceipt
+}
+
+const (
+	// splitFlag is set in the header of a Packet if it is a fragment of a split packet.
+	splitFlag = 0x10
+	// maxContentSize is the maximum size of the content of a Packet, which is limited by the 13 bits that
+	// its length in bits is written with.
+	maxContentSize = 0xffff >> 3
+	// recordRange and recordSingle are the types of an AckRecord, which is either a range of sequence
+	// numbers or a single one.
+	recordRange  = 0
+	recordSingle = 1
+)
+
+// Kind is the kind of a raw datagram read from the network, as told by the flags in its first byte.
+type Kind int
+
+const (
+	// KindOffline is the kind of offline messages, which are decoded using Decode.
+	KindOffline Kind = iota
+	// KindDatagram is the kind of datagrams holding encapsulated packets, which are decoded using a Datagram.
+	KindDatagram
+	// KindAcknowledgement is the kind of ACKs and NACKs, which are decoded using an Acknowledgement.
+	KindAcknowledgement
+)
+
+// KindOf returns the Kind of the raw datagram passed, so that packet inspection middleboxes know how to
+// decode it. Empty datagrams are of KindOffline, which fail to decode.
+func KindOf(b []byte) Kind {
+	switch {
+	case len(b) == 0 || b[0]&FlagValid == 0:
+		return KindOffline
+	case b[0]&(FlagACK|FlagNACK) != 0:
+		return KindAcknowledgement
+	default:
+		return KindDatagram
+	}
+}
+
+// Datagram is a datagram sent over a connection, holding one or more encapsulated packets.
+type Datagram struct {
+	// Flags is the first byte of the datagram. FlagValid is always set when encoding. Besides it, RakNet
+	// implementations may set flags such as the one for continuous sends, which are kept as is.
+	Flags byte
+	// SequenceNumber is the sequence number of the datagram, of which only the lower 24 bits are encoded.
+	SequenceNumber uint32
+	Packets        []Packet
+}
+
+// MarshalBinary ...
+func (d *Datagram) MarshalBinary() ([]byte, error) {
+	if d.Flags&(FlagACK|FlagNACK) != 0 {
+		return nil, fmt.Errorf("error encoding datagram: ACK or NACK flag set")
+	}
+	b := bytes.NewBuffer([]byte{d.Flags | FlagValid})
+	writeUint24(b, d.SequenceNumber)
+	for i, p := range d.Packets {
+		if err := p.write(b); err != nil {
+			return nil, fmt.Errorf("error encoding datagram packet %v: %v", i, err)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (d *Datagram) UnmarshalBinary(data []byte) error {
+	b := bytes.NewBuffer(data)
+	flags, err := b.ReadByte()
+	if err != nil {
+		return fmt.Errorf("error decoding datagram: %v", err)
+	}
+	if flags&FlagValid == 0 || flags&(FlagACK|FlagNACK) != 0 {
+		return fmt.Errorf("error decoding datagram: unexpected flags %#x", flags)
+	}
+	d.Flags = flags
+	if d.SequenceNumber, err = readUint24(b); err != nil {
+		return fmt.Errorf("error decoding datagram: %v", err)
+	}
+	d.Packets = nil
+	for b.Len() > 0 {
+		var p Packet
+		if err := p.read(b); err != nil {
+			return fmt.Errorf("error decoding datagram packet %v: %v", len(d.Packets), err)
+		}
+		d.Packets = append(d.Packets, p)
+	}
+	return nil
+}
+
+// Packet is a packet encapsulated in a Datagram. Its content is either a full message, or a fragment of one
+// if it was split.
+type Packet struct {
+	Reliability Reliability
+	// MessageIndex, SequenceIndex and OrderIndex are encoded as 24-bit integers, and only if the
+	// Reliability of the packet calls for them, like OrderChannel.
+	MessageIndex  uint32
+	SequenceIndex uint32
+	OrderIndex    uint32
+	OrderChannel  byte
+
+	// Split specifies if the packet is a fragment of a split packet, in which case SplitCount, SplitID and
+	// SplitIndex are encoded.
+	Split      bool
+	SplitCount uint32
+	SplitID    uint16
+	SplitIndex uint32
+
+	Content []byte
+}
+
+// Size returns the size of the binary form of the packet, so that packets may be added to a Datagram until
+// it reaches the MTU size of a connection. A Datagram has a header of 4 bytes on top of its packets.
+func (p Packet) Size() int {
+	size := 3 + len(p.Content)
+	if p.Reliability.Reliable() {
+		size += 3
+	}
+	if p.Reliability.Sequenced() {
+		size += 3
+	}
+	if p.Reliability.Ordered() {
+		size += 4
+	}
+	if p.Split {
+		size += 10
+	}
+	return size
+}
+
+// write writes the packet to buffer b.
+func (p Packet) write(b *bytes.Buffer) error {
+	if len(p.Content) == 0 || len(p.Content) > maxContentSize {
+		return fmt.Errorf("invalid content length %v (must be between 1 and %v)", len(p.Content), maxContentSize)
+	}
+	if p.Reliability > ReliableOrderedWithAckReceipt {
+		return fmt.Errorf("invalid reliability %v", p.Reliability)
+	}
+	header := byte(p.Reliability) << 5
+	if p.Split {
+		header |= splitFlag
+	}
+	b.WriteByte(header)
+	_ = binary.Write(b, binary.BigEndian, uint16(len(p.Content))<<3)
+	if p.Reliability.Reliable() {
+		writeUint24(b, p.MessageIndex)
+	}
+	if p.Reliability.Sequenced() {
+		writeUint24(b, p.SequenceIndex)
+	}
+	if p.Reliability.Ordered() {
+		writeUint24(b, p.OrderIndex)
+		b.WriteByte(p.OrderChannel)
+	}
+	if p.Split {
+		_ = binary.Write(b, binary.BigEndian, p.SplitCount)
+		_ = binary.Write(b, binary.BigEndian, p.SplitID)
+		_ = binary.Write(b, binary.BigEndian, p.SplitIndex)
+	}
+	b.Write(p.Content)
+	return nil
+}
+
+// read reads a packet from buffer b.
+func (p *Packet) read(b *bytes.Buffer) (err error) {
+	header, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	p.Reliability = Reliability(header >> 5)
+	p.Split = header&splitFlag != 0
+	var length uint16
+	if err := binary.Read(b, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if length >>= 3; length == 0 {
+		return fmt.Errorf("invalid content length 0")
+	}
+	if p.Reliability.Reliable() {
+		if p.MessageIndex, err = readUint24(b); err != nil {
+			return err
+		}
+	}
+	if p.Reliability.Sequenced() {
+		if p.SequenceIndex, err = readUint24(b); err != nil {
+			return err
+		}
+	}
+	if p.Reliability.Ordered() {
+		if p.OrderIndex, err = readUint24(b); err != nil {
+			return err
+		}
+		if p.OrderChannel, err = b.ReadByte(); err != nil {
+			return err
+		}
+	}
+	if p.Split {
+		if err := binary.Read(b, binary.BigEndian, &p.SplitCount); err != nil {
+			return err
+		}
+		if err := binary.Read(b, binary.BigEndian, &p.SplitID); err != nil {
+			return err
+		}
+		if err := binary.Read(b, binary.BigEndian, &p.SplitIndex); err != nil {
+			return err
+		}
+	}
+	if b.Len() < int(length) {
+		return io.ErrUnexpectedEOF
+	}
+	p.Content = append([]byte(nil), b.Next(int(length))...)
+	return nil
+}
+
+// Acknowledgement is an ACK or a NACK, sent to acknowledge the receipt of datagrams or to report datagrams
+// that were lost.
+type Acknowledgement struct {
+	// NACK specifies if the acknowledgement is a NACK rather than an ACK.
+	NACK    bool
+	Records []AckRecord
+}
+
+// AckRecord is a range of datagram sequence numbers in an Acknowledgement. Records of which First and Last
+// are equal are encoded as a single sequence number.
+type AckRecord struct {
+	First, Last uint32
+}
+
+// MarshalBinary ...
+func (ack *Acknowledgement) MarshalBinary() ([]byte, error) {
+	flags := FlagValid | FlagACK
+	if ack.NACK {
+		flags = FlagValid | FlagNACK
+	}
+	if len(ack.Records) > 0xffff {
+		return nil, fmt.Errorf("error encoding acknowledgement: too many records (%v)", len(ack.Records))
+	}
+	b := bytes.NewBuffer([]byte{flags})
+	_ = binary.Write(b, binary.BigEndian, uint16(len(ack.Records)))
+	for _, record := range ack.Records {
+		if record.First&0xffffff == record.Last&0xffffff {
+			b.WriteByte(recordSingle)
+			writeUint24(b, record.First)
+			continue
+		}
+		b.WriteByte(recordRange)
+		writeUint24(b, record.First)
+		writeUint24(b, record.Last)
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (ack *Acknowledgement) UnmarshalBinary(data []byte) error {
+	b := bytes.NewBuffer(data)
+	flags, err := b.ReadByte()
+	if err != nil {
+		return fmt.Errorf("error decoding acknowledgement: %v", err)
+	}
+	if flags&FlagValid == 0 || flags&(FlagACK|FlagNACK) == 0 {
+		return fmt.Errorf("error decoding acknowledgement: unexpected flags %#x", flags)
+	}
+	ack.NACK = flags&FlagACK == 0
+	var count uint16
+	if err := binary.Read(b, binary.BigEndian, &count); err != nil {
+		return fmt.Errorf("error decoding acknowledgement: %v", err)
+	}
+	ack.Records = nil
+	for i := uint16(0); i < count; i++ {
+		recordType, err := b.ReadByte()
+		if err != nil {
+			return fmt.Errorf("error decoding acknowledgement record: %v", err)
+		}
+		var record AckRecord
+		if record.First, err = readUint24(b); err != nil {
+			return fmt.Errorf("error decoding acknowledgement record: %v", err)
+		}
+		record.Last = record.First
+		if recordType == recordRange {
+			if record.Last, err = readUint24(b); err != nil {
+				return fmt.Errorf("error decoding acknowledgement record: %v", err)
+			}
+		}
+		ack.Records = append(ack.Records, record)
+	}
+	return nil
+}
+
+// readUint24 reads a little endian 24-bit integer from buffer b.
+func readUint24(b *bytes.Buffer) (uint32, error) {
+	data := b.Next(3)
+	if len(data) != 3 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16, nil
+}
+
+// writeUint24 writes the lower 24 bits of v to buffer b in little endian byte order.
+func writeUint24(b *bytes.Buffer, v uint32) {
+	b.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16)})
+}
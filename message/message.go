@@ -0,0 +1,142 @@
+// Package message implements the encoding of the messages of the RakNet protocol: The offline messages sent
+// before a connection is established, such as unconnected pings and open connection requests, and the
+// messages handled by RakNet itself once it is, such as connected pings and connection requests. It also
+// implements the encoding of the datagrams that carry messages once a connection is established: A
+// Datagram holds encapsulated packets, and an Acknowledgement is an ACK or a NACK. Datagrams of connections
+// always have FlagValid set in their first byte, which offline messages never have, and Acknowledgements
+// have FlagACK or FlagNACK set as well. KindOf tells these apart, so that middleboxes inspecting traffic know
+// how to decode a datagram read from the network, and a Reassembler puts split packets back together.
+//
+// Every message implements encoding.BinaryMarshaler and encoding.BinaryUnmarshaler. The binary form of a
+// message includes its ID, so that it may be sent or compared as is. Decoding an encoded message and
+// encoding the result again always produces the same binary form, which makes the package suitable for
+// property based tests:
+//
+//	b, _ := msg.MarshalBinary()
+//	decoded, err := message.Decode(b)
+//	if err != nil {
+//		t.Fatalf("error decoding %T: %v", msg, err)
+//	}
+//	if again, _ := decoded.MarshalBinary(); !bytes.Equal(again, b) {
+//		t.Fatalf("round trip of %T changed its binary form", msg)
+//	}
+//
+// The messages and their fields are part of the stable API of the module: Their binary forms are those
+// sent and received by the raknet package.
+package message
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+)
+
+const (
+	IDConnectedPing byte = 0x00
+	IDConnectedPong byte = 0x03
+
+	IDUnconnectedPing byte = 0x01
+	IDUnconnectedPong byte = 0x1c
+
+	IDOpenConnectionRequest1 byte = 0x05
+	IDOpenConnectionReply1   byte = 0x06
+	IDOpenConnectionRequest2 byte = 0x07
+	IDOpenConnectionReply2   byte = 0x08
+
+	IDConnectionRequest         byte = 0x09
+	IDConnectionRequestAccepted byte = 0x10
+	IDNewIncomingConnection     byte = 0x13
+	IDDisconnectNotification    byte = 0x15
+
+	IDAlreadyConnected          byte = 0x12
+	IDNoFreeIncomingConnections byte = 0x14
+	IDConnectionBanned          byte = 0x17
+
+	IDIncompatibleProtocolVersion byte = 0x19
+)
+
+// Magic is the sequence of bytes found in every offline message, which RakNet uses to tell offline messages
+// apart from other traffic.
+var Magic = [16]byte{
+	0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78,
+}
+
+// Message is a message of the RakNet protocol that may be encoded to and decoded from its binary form.
+type Message interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+	// ID returns the ID of the message, which is the first byte of its binary form.
+	ID() byte
+}
+
+// New returns a new, empty message for the ID passed, or false if no message with that ID exists.
+func New(id byte) (Message, bool) {
+	switch id {
+	case IDConnectedPing:
+		return &ConnectedPing{}, true
+	case IDConnectedPong:
+		return &ConnectedPong{}, true
+	case IDUnconnectedPing:
+		return &UnconnectedPing{}, true
+	case IDUnconnectedPong:
+		return &UnconnectedPong{}, true
+	case IDOpenConnectionRequest1:
+		return &OpenConnectionRequest1{}, true
+	case IDOpenConnectionReply1:
+		return &OpenConnectionReply1{}, true
+	case IDOpenConnectionRequest2:
+		return &OpenConnectionRequest2{}, true
+	case IDOpenConnectionReply2:
+		return &OpenConnectionReply2{}, true
+	case IDConnectionRequest:
+		return &ConnectionRequest{}, true
+	case IDConnectionRequestAccepted:
+		return &ConnectionRequestAccepted{}, true
+	case IDNewIncomingConnection:
+		return &NewIncomingConnection{}, true
+	case IDDisconnectNotification:
+		return &DisconnectNotification{}, true
+	case IDIncompatibleProtocolVersion:
+		return &IncompatibleProtocolVersion{}, true
+	case IDAlreadyConnected:
+		return &AlreadyConnected{}, true
+	case IDNoFreeIncomingConnections:
+		return &NoFreeIncomingConnections{}, true
+	case IDConnectionBanned:
+		return &ConnectionBanned{}, true
+	}
+	return nil, false
+}
+
+// Decode decodes the binary form of a message passed, selecting the message by its ID. An error is returned
+// if the ID is unknown or if the message could not be decoded.
+func Decode(b []byte) (Message, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("error decoding message: no message ID")
+	}
+	msg, ok := New(b[0])
+	if !ok {
+		return nil, fmt.Errorf("error decoding message: unknown message ID %#x", b[0])
+	}
+	if err := msg.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// header writes the ID passed to a new buffer and returns it.
+func header(id byte) *bytes.Buffer {
+	return bytes.NewBuffer([]byte{id})
+}
+
+// readHeader returns a buffer holding b without the ID at the start of it, checking if that ID is equal to
+// the one passed.
+func readHeader(b []byte, id byte, name string) (*bytes.Buffer, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("error decoding %v: no message ID", name)
+	}
+	if b[0] != id {
+		return nil, fmt.Errorf("error decoding %v: unexpected message ID %#x (expected %#x)", name, b[0], id)
+	}
+	return bytes.NewBuffer(b[1:]), nil
+}
@@ -0,0 +1,222 @@
+package message
+
+import (
+	"bytes"
+	"encoding"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// messages returns a message of every type with all of its fields set.
+func messages() []Message {
+	v4 := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 19132}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000}
+	return []Message{
+		&ConnectedPing{PingTimestamp: 1234},
+		&ConnectedPong{PingTimestamp: 1234, PongTimestamp: 5678},
+		&UnconnectedPing{SendTimestamp: 1, Magic: Magic, ClientGUID: -5},
+		&UnconnectedPong{SendTimestamp: 1, ServerGUID: 2, Magic: Magic, Data: []byte("\x00\x04MCPE")},
+		&OpenConnectionRequest1{Magic: Magic, Protocol: 10, Padding: 1400},
+		&OpenConnectionReply1{Magic: Magic, ServerGUID: 3, MTUSize: 1400},
+		&OpenConnectionReply1{Magic: Magic, ServerGUID: 3, Secure: true, Cookie: 0xdeadbeef, MTUSize: 1400},
+		&OpenConnectionReply1{Magic: Magic, ServerGUID: 3, Secure: true, Cookie: 1, ServerPublicKey: bytes.Repeat([]byte{7}, 32), MTUSize: 1400},
+		&OpenConnectionRequest2{Magic: Magic, ServerAddress: v4, MTUSize: 1400, ClientGUID: 4},
+		&OpenConnectionReply2{Magic: Magic, ServerGUID: 5, ClientAddress: v6, MTUSize: 1400, Secure: true},
+		&ConnectionRequest{ClientGUID: 6, RequestTimestamp: 7, Secure: true},
+		&ConnectionRequestAccepted{ClientAddress: v6, SystemIndex: 1, SystemAddresses: []*net.UDPAddr{v4, v6}, RequestTimestamp: 8, AcceptedTimestamp: 9},
+		&NewIncomingConnection{ServerAddress: v4, RequestTimestamp: 10, AcceptedTimestamp: 11},
+		&DisconnectNotification{},
+		&DisconnectNotification{Reason: []byte("server closed")},
+		&IncompatibleProtocolVersion{ServerProtocol: 10, Magic: Magic, ServerGUID: 12},
+		&AlreadyConnected{Magic: Magic, ServerGUID: 13},
+		&NoFreeIncomingConnections{Magic: Magic, ServerGUID: 14},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, msg := range messages() {
+		b, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error encoding %T: %v", msg, err)
+		}
+		if b[0] != msg.ID() {
+			t.Fatalf("%T encoded with ID %#x, expected %#x", msg, b[0], msg.ID())
+		}
+		decoded, err := Decode(b)
+		if err != nil {
+			t.Fatalf("error decoding %T: %v", msg, err)
+		}
+		again, err := decoded.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error encoding decoded %T: %v", msg, err)
+		}
+		if !bytes.Equal(b, again) {
+			t.Fatalf("round trip of %T changed its binary form:\n%x\n%x", msg, b, again)
+		}
+	}
+}
+
+func TestRoundTripFields(t *testing.T) {
+	msg := &OpenConnectionReply2{Magic: Magic, ServerGUID: 5, ClientAddress: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 60000}, MTUSize: 1200}
+	b, _ := msg.MarshalBinary()
+	decoded := &OpenConnectionReply2{}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Fatalf("decoded %+v, expected %+v", decoded, msg)
+	}
+	if err := decoded.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Fatalf("expected error decoding truncated message")
+	}
+	if err := (&ConnectedPing{}).UnmarshalBinary(b); err == nil {
+		t.Fatalf("expected error decoding message with a different ID")
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, msg := range messages() {
+		b, _ := msg.MarshalBinary()
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := Decode(b)
+		if err != nil {
+			return
+		}
+		encoded, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error encoding decoded %T: %v", msg, err)
+		}
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("error decoding encoded %T: %v", msg, err)
+		}
+		again, _ := decoded.MarshalBinary()
+		if !bytes.Equal(encoded, again) {
+			t.Fatalf("round trip of %T changed its binary form:\n%x\n%x", msg, encoded, again)
+		}
+	})
+}
+
+// datagrams returns a datagram and acknowledgements with all of their fields set.
+func datagrams() []encoding.BinaryMarshaler {
+	return []encoding.BinaryMarshaler{
+		&Datagram{Flags: FlagValid | 0x04, SequenceNumber: 0xabcdef, Packets: []Packet{
+			{Reliability: Unreliable, Content: []byte{0xfe, 1}},
+			{Reliability: ReliableOrdered, MessageIndex: 1, OrderIndex: 2, OrderChannel: 3, Content: []byte{0xfe}},
+			{Reliability: UnreliableSequenced, SequenceIndex: 4, OrderIndex: 5, Content: []byte{0xfe}},
+			{Reliability: Reliable, MessageIndex: 6, Split: true, SplitCount: 2, SplitID: 7, SplitIndex: 1, Content: bytes.Repeat([]byte{1}, 100)},
+		}},
+		&Acknowledgement{Records: []AckRecord{{First: 1, Last: 1}, {First: 3, Last: 10}}},
+		&Acknowledgement{NACK: true, Records: []AckRecord{{First: 2, Last: 2}}},
+	}
+}
+
+func TestDatagramRoundTrip(t *testing.T) {
+	for _, d := range datagrams() {
+		b, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error encoding %T: %v", d, err)
+		}
+		decoded := reflect.New(reflect.TypeOf(d).Elem()).Interface().(encoding.BinaryUnmarshaler)
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatalf("error decoding %T: %v", d, err)
+		}
+		if !reflect.DeepEqual(decoded, d) {
+			t.Fatalf("decoded %+v, expected %+v", decoded, d)
+		}
+	}
+	if _, err := (&Datagram{Packets: []Packet{{Content: make([]byte, maxContentSize+1)}}}).MarshalBinary(); err == nil {
+		t.Fatalf("expected error encoding packet with too much content")
+	}
+}
+
+func TestKindAndSize(t *testing.T) {
+	expected := []Kind{KindDatagram, KindAcknowledgement, KindAcknowledgement}
+	for i, d := range datagrams() {
+		b, _ := d.MarshalBinary()
+		if kind := KindOf(b); kind != expected[i] {
+			t.Fatalf("%T: expected kind %v, got %v", d, expected[i], kind)
+		}
+		if datagram, ok := d.(*Datagram); ok {
+			size := 4
+			for _, p := range datagram.Packets {
+				size += p.Size()
+			}
+			if size != len(b) {
+				t.Fatalf("expected packet sizes to add up to %v, got %v", len(b), size)
+			}
+		}
+	}
+	if b, _ := (&UnconnectedPing{Magic: Magic}).MarshalBinary(); KindOf(b) != KindOffline {
+		t.Fatalf("expected unconnected ping to be of offline kind")
+	}
+}
+
+func TestReassembler(t *testing.T) {
+	var r Reassembler
+	if content, ok, _ := r.Add(Packet{Content: []byte{1}}); !ok || !bytes.Equal(content, []byte{1}) {
+		t.Fatalf("expected unsplit packet to be returned as is")
+	}
+	fragments := []Packet{
+		{Split: true, SplitCount: 3, SplitID: 1, SplitIndex: 2, Content: []byte{5, 6}},
+		{Split: true, SplitCount: 3, SplitID: 1, SplitIndex: 0, Content: []byte{1, 2}},
+		{Split: true, SplitCount: 3, SplitID: 1, SplitIndex: 0, Content: []byte{1, 2}},
+		{Split: true, SplitCount: 3, SplitID: 1, SplitIndex: 1, Content: []byte{3, 4}},
+	}
+	for i, p := range fragments {
+		content, ok, err := r.Add(p)
+		if err != nil {
+			t.Fatalf("error adding fragment %v: %v", i, err)
+		}
+		if last := i == len(fragments)-1; ok != last {
+			t.Fatalf("fragment %v: expected ok to be %v", i, last)
+		}
+		if ok && !bytes.Equal(content, []byte{1, 2, 3, 4, 5, 6}) {
+			t.Fatalf("expected reassembled content, got %x", content)
+		}
+	}
+	if r.Pending() != 0 {
+		t.Fatalf("expected no pending split packets, got %v", r.Pending())
+	}
+	if _, _, err := r.Add(Packet{Split: true, SplitCount: 2, SplitIndex: 2, Content: []byte{1}}); err == nil {
+		t.Fatalf("expected error adding fragment with index beyond count")
+	}
+	r.MaxPending = 1
+	_, _, _ = r.Add(Packet{Split: true, SplitCount: 2, SplitID: 2, Content: []byte{1}})
+	if _, _, err := r.Add(Packet{Split: true, SplitCount: 2, SplitID: 3, Content: []byte{1}}); err == nil {
+		t.Fatalf("expected error exceeding maximum pending split packets")
+	}
+}
+
+func FuzzDatagramRoundTrip(f *testing.F) {
+	for _, d := range datagrams() {
+		b, _ := d.MarshalBinary()
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var m interface {
+			encoding.BinaryMarshaler
+			encoding.BinaryUnmarshaler
+		} = &Datagram{}
+		if len(b) > 0 && b[0]&(FlagACK|FlagNACK) != 0 {
+			m = &Acknowledgement{}
+		}
+		if err := m.UnmarshalBinary(b); err != nil {
+			return
+		}
+		encoded, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error encoding decoded %T: %v", m, err)
+		}
+		if err := m.UnmarshalBinary(encoded); err != nil {
+			t.Fatalf("error decoding encoded %T: %v", m, err)
+		}
+		again, _ := m.MarshalBinary()
+		if !bytes.Equal(encoded, again) {
+			t.Fatalf("round trip of %T changed its binary form:\n%x\n%x", m, encoded, again)
+		}
+	})
+}
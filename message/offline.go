@@ -0,0 +1,387 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// UnconnectedPing is sent by a client to a server to query its pong data, without connecting to it.
+type UnconnectedPing struct {
+	SendTimestamp int64
+	Magic         [16]byte
+	ClientGUID    int64
+}
+
+// ID ...
+func (*UnconnectedPing) ID() byte { return IDUnconnectedPing }
+
+// MarshalBinary ...
+func (msg *UnconnectedPing) MarshalBinary() ([]byte, error) {
+	b := header(IDUnconnectedPing)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *UnconnectedPing) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDUnconnectedPing, "unconnected ping")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding unconnected ping: %v", err)
+	}
+	return nil
+}
+
+// UnconnectedPong is sent by a server in response to an UnconnectedPing.
+type UnconnectedPong struct {
+	SendTimestamp int64
+	ServerGUID    int64
+	Magic         [16]byte
+	// Data is the pong data of the server, which holds all bytes following the magic. Minecraft servers
+	// prefix it with its length as a big endian int16, which is included in Data.
+	Data []byte
+}
+
+// ID ...
+func (*UnconnectedPong) ID() byte { return IDUnconnectedPong }
+
+// MarshalBinary ...
+func (msg *UnconnectedPong) MarshalBinary() ([]byte, error) {
+	b := header(IDUnconnectedPong)
+	_ = binary.Write(b, binary.BigEndian, msg.SendTimestamp)
+	_ = binary.Write(b, binary.BigEndian, msg.ServerGUID)
+	b.Write(msg.Magic[:])
+	b.Write(msg.Data)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *UnconnectedPong) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDUnconnectedPong, "unconnected pong")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.SendTimestamp); err != nil {
+		return fmt.Errorf("error decoding unconnected pong: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.ServerGUID); err != nil {
+		return fmt.Errorf("error decoding unconnected pong: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.Magic); err != nil {
+		return fmt.Errorf("error decoding unconnected pong: %v", err)
+	}
+	msg.Data = append([]byte(nil), b.Bytes()...)
+	return nil
+}
+
+// OpenConnectionRequest1 is sent by a client to start the connection sequence. It is padded with zero bytes
+// so that the size of the datagram holding it is equal to the MTU size the client is discovering.
+type OpenConnectionRequest1 struct {
+	Magic    [16]byte
+	Protocol byte
+	// Padding is the amount of bytes following the protocol, which are written as zero bytes.
+	Padding int
+}
+
+// ID ...
+func (*OpenConnectionRequest1) ID() byte { return IDOpenConnectionRequest1 }
+
+// MarshalBinary ...
+func (msg *OpenConnectionRequest1) MarshalBinary() ([]byte, error) {
+	if msg.Padding < 0 {
+		return nil, fmt.Errorf("error encoding open connection request 1: negative padding %v", msg.Padding)
+	}
+	b := header(IDOpenConnectionRequest1)
+	b.Write(msg.Magic[:])
+	b.WriteByte(msg.Protocol)
+	b.Write(make([]byte, msg.Padding))
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *OpenConnectionRequest1) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDOpenConnectionRequest1, "open connection request 1")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.Magic); err != nil {
+		return fmt.Errorf("error decoding open connection request 1: %v", err)
+	}
+	if msg.Protocol, err = b.ReadByte(); err != nil {
+		return fmt.Errorf("error decoding open connection request 1: %v", err)
+	}
+	msg.Padding = b.Len()
+	return nil
+}
+
+// OpenConnectionReply1 is sent by a server in response to an OpenConnectionRequest1 with a protocol that
+// it supports.
+type OpenConnectionReply1 struct {
+	Magic      [16]byte
+	ServerGUID int64
+	// Secure is the "has security" flag. If set, the Cookie follows it, which the client must send back in
+	// its open connection request 2, and optionally the public key of the server, ServerPublicKey.
+	Secure          bool
+	Cookie          uint32
+	ServerPublicKey []byte
+	MTUSize         int16
+}
+
+// ID ...
+func (*OpenConnectionReply1) ID() byte { return IDOpenConnectionReply1 }
+
+// MarshalBinary ...
+func (msg *OpenConnectionReply1) MarshalBinary() ([]byte, error) {
+	b := header(IDOpenConnectionReply1)
+	b.Write(msg.Magic[:])
+	_ = binary.Write(b, binary.BigEndian, msg.ServerGUID)
+	_ = binary.Write(b, binary.BigEndian, msg.Secure)
+	if msg.Secure {
+		_ = binary.Write(b, binary.BigEndian, msg.Cookie)
+		b.Write(msg.ServerPublicKey)
+	}
+	_ = binary.Write(b, binary.BigEndian, msg.MTUSize)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *OpenConnectionReply1) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDOpenConnectionReply1, "open connection reply 1")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.Magic); err != nil {
+		return fmt.Errorf("error decoding open connection reply 1: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.ServerGUID); err != nil {
+		return fmt.Errorf("error decoding open connection reply 1: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.Secure); err != nil {
+		return fmt.Errorf("error decoding open connection reply 1: %v", err)
+	}
+	msg.Cookie, msg.ServerPublicKey = 0, nil
+	if msg.Secure {
+		if err := binary.Read(b, binary.BigEndian, &msg.Cookie); err != nil {
+			return fmt.Errorf("error decoding open connection reply 1: %v", err)
+		}
+		// The public key is not prefixed with its length: Everything between the cookie and the MTU size is
+		// the key.
+		if b.Len() > 2 {
+			msg.ServerPublicKey = append([]byte(nil), b.Next(b.Len()-2)...)
+		}
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.MTUSize); err != nil {
+		return fmt.Errorf("error decoding open connection reply 1: %v", err)
+	}
+	return nil
+}
+
+// OpenConnectionRequest2 is sent by a client in response to an OpenConnectionReply1.
+type OpenConnectionRequest2 struct {
+	Magic         [16]byte
+	ServerAddress *net.UDPAddr
+	MTUSize       int16
+	ClientGUID    int64
+}
+
+// ID ...
+func (*OpenConnectionRequest2) ID() byte { return IDOpenConnectionRequest2 }
+
+// MarshalBinary ...
+func (msg *OpenConnectionRequest2) MarshalBinary() ([]byte, error) {
+	b := header(IDOpenConnectionRequest2)
+	b.Write(msg.Magic[:])
+	writeAddr(b, msg.ServerAddress)
+	_ = binary.Write(b, binary.BigEndian, msg.MTUSize)
+	_ = binary.Write(b, binary.BigEndian, msg.ClientGUID)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *OpenConnectionRequest2) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDOpenConnectionRequest2, "open connection request 2")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.Magic); err != nil {
+		return fmt.Errorf("error decoding open connection request 2: %v", err)
+	}
+	if msg.ServerAddress, err = readAddr(b); err != nil {
+		return fmt.Errorf("error decoding open connection request 2: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.MTUSize); err != nil {
+		return fmt.Errorf("error decoding open connection request 2: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.ClientGUID); err != nil {
+		return fmt.Errorf("error decoding open connection request 2: %v", err)
+	}
+	return nil
+}
+
+// OpenConnectionReply2 is sent by a server in response to an OpenConnectionRequest2, after which the
+// connection is established.
+type OpenConnectionReply2 struct {
+	Magic         [16]byte
+	ServerGUID    int64
+	ClientAddress *net.UDPAddr
+	MTUSize       int16
+	Secure        bool
+}
+
+// ID ...
+func (*OpenConnectionReply2) ID() byte { return IDOpenConnectionReply2 }
+
+// MarshalBinary ...
+func (msg *OpenConnectionReply2) MarshalBinary() ([]byte, error) {
+	b := header(IDOpenConnectionReply2)
+	b.Write(msg.Magic[:])
+	_ = binary.Write(b, binary.BigEndian, msg.ServerGUID)
+	writeAddr(b, msg.ClientAddress)
+	_ = binary.Write(b, binary.BigEndian, msg.MTUSize)
+	_ = binary.Write(b, binary.BigEndian, msg.Secure)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *OpenConnectionReply2) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDOpenConnectionReply2, "open connection reply 2")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.Magic); err != nil {
+		return fmt.Errorf("error decoding open connection reply 2: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.ServerGUID); err != nil {
+		return fmt.Errorf("error decoding open connection reply 2: %v", err)
+	}
+	if msg.ClientAddress, err = readAddr(b); err != nil {
+		return fmt.Errorf("error decoding open connection reply 2: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.MTUSize); err != nil {
+		return fmt.Errorf("error decoding open connection reply 2: %v", err)
+	}
+	if err := binary.Read(b, binary.BigEndian, &msg.Secure); err != nil {
+		return fmt.Errorf("error decoding open connection reply 2: %v", err)
+	}
+	return nil
+}
+
+// IncompatibleProtocolVersion is sent by a server in response to an OpenConnectionRequest1 with a protocol
+// that it does not support.
+type IncompatibleProtocolVersion struct {
+	ServerProtocol byte
+	Magic          [16]byte
+	ServerGUID     int64
+}
+
+// ID ...
+func (*IncompatibleProtocolVersion) ID() byte { return IDIncompatibleProtocolVersion }
+
+// MarshalBinary ...
+func (msg *IncompatibleProtocolVersion) MarshalBinary() ([]byte, error) {
+	b := header(IDIncompatibleProtocolVersion)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *IncompatibleProtocolVersion) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDIncompatibleProtocolVersion, "incompatible protocol version")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding incompatible protocol version: %v", err)
+	}
+	return nil
+}
+
+// AlreadyConnected is sent by a server in response to an OpenConnectionRequest2 from a client of which the
+// address or GUID already has a connection.
+type AlreadyConnected struct {
+	Magic      [16]byte
+	ServerGUID int64
+}
+
+// ID ...
+func (*AlreadyConnected) ID() byte { return IDAlreadyConnected }
+
+// MarshalBinary ...
+func (msg *AlreadyConnected) MarshalBinary() ([]byte, error) {
+	b := header(IDAlreadyConnected)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *AlreadyConnected) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDAlreadyConnected, "already connected")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding already connected: %v", err)
+	}
+	return nil
+}
+
+// NoFreeIncomingConnections is sent by a server in response to an OpenConnectionRequest2 while it holds its
+// maximum amount of connections.
+type NoFreeIncomingConnections struct {
+	Magic      [16]byte
+	ServerGUID int64
+}
+
+// ID ...
+func (*NoFreeIncomingConnections) ID() byte { return IDNoFreeIncomingConnections }
+
+// MarshalBinary ...
+func (msg *NoFreeIncomingConnections) MarshalBinary() ([]byte, error) {
+	b := header(IDNoFreeIncomingConnections)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *NoFreeIncomingConnections) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDNoFreeIncomingConnections, "no free incoming connections")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding no free incoming connections: %v", err)
+	}
+	return nil
+}
+
+// ConnectionBanned is sent by a server in response to an OpenConnectionRequest2 from a client of which the
+// address or GUID is banned.
+type ConnectionBanned struct {
+	Magic      [16]byte
+	ServerGUID int64
+}
+
+// ID ...
+func (*ConnectionBanned) ID() byte { return IDConnectionBanned }
+
+// MarshalBinary ...
+func (msg *ConnectionBanned) MarshalBinary() ([]byte, error) {
+	b := header(IDConnectionBanned)
+	_ = binary.Write(b, binary.BigEndian, msg)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary ...
+func (msg *ConnectionBanned) UnmarshalBinary(data []byte) error {
+	b, err := readHeader(data, IDConnectionBanned, "connection banned")
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(b, binary.BigEndian, msg); err != nil {
+		return fmt.Errorf("error decoding connection banned: %v", err)
+	}
+	return nil
+}
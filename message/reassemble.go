@@ -0,0 +1,81 @@
+package message
+
+import (
+	"fmt"
+)
+
+// MaxSplitCount is the maximum amount of fragments that a Reassembler accepts for a single packet. It is the
+// same limit applied by the raknet package.
+const MaxSplitCount = 8192
+
+// Reassembler reassembles the fragments of split packets found in Datagrams, so that the full content of
+// packets may be inspected. The zero value of a Reassembler is ready to use. A Reassembler holds the
+// fragments of a single connection and is not safe for concurrent use.
+type Reassembler struct {
+	// MaxPending is the maximum amount of split packets of which fragments may be held at once. Fragments of
+	// new split packets beyond it are rejected with an error.
+	// MaxPending is 0 by default, meaning 64 split packets may be pending.
+	MaxPending int
+
+	pending map[uint16]*splitPacket
+}
+
+// splitPacket holds the fragments received of a single split packet.
+type splitPacket struct {
+	fragments [][]byte
+	received  uint32
+}
+
+// Add adds the Packet passed to the Reassembler. If the Packet is not split, its content is returned as
+// is. If it is the last missing fragment of a split packet, the content of the full packet is returned. In
+// any other case, ok is false. An error is returned if the fragment is invalid or conflicts with earlier
+// fragments of the same split packet.
+func (r *Reassembler) Add(p Packet) (content []byte, ok bool, err error) {
+	if !p.Split {
+		return p.Content, true, nil
+	}
+	if p.SplitCount == 0 || p.SplitCount > MaxSplitCount || p.SplitIndex >= p.SplitCount {
+		return nil, false, fmt.Errorf("error reassembling packet: invalid split index %v of count %v", p.SplitIndex, p.SplitCount)
+	}
+	if r.pending == nil {
+		r.pending = make(map[uint16]*splitPacket)
+	}
+	split, found := r.pending[p.SplitID]
+	if !found {
+		max := r.MaxPending
+		if max <= 0 {
+			max = 64
+		}
+		if len(r.pending) >= max {
+			return nil, false, fmt.Errorf("error reassembling packet: too many pending split packets (%v)", len(r.pending))
+		}
+		split = &splitPacket{fragments: make([][]byte, p.SplitCount)}
+		r.pending[p.SplitID] = split
+	}
+	if uint32(len(split.fragments)) != p.SplitCount {
+		return nil, false, fmt.Errorf("error reassembling packet: split count %v of split %v does not match count %v of earlier fragments", p.SplitCount, p.SplitID, len(split.fragments))
+	}
+	if split.fragments[p.SplitIndex] != nil {
+		// The fragment was received before, for example because it was resent.
+		return nil, false, nil
+	}
+	split.fragments[p.SplitIndex] = p.Content
+	if split.received++; split.received != p.SplitCount {
+		return nil, false, nil
+	}
+	delete(r.pending, p.SplitID)
+	size := 0
+	for _, fragment := range split.fragments {
+		size += len(fragment)
+	}
+	content = make([]byte, 0, size)
+	for _, fragment := range split.fragments {
+		content = append(content, fragment...)
+	}
+	return content, true, nil
+}
+
+// Pending returns the amount of split packets of which fragments are held.
+func (r *Reassembler) Pending() int {
+	return len(r.pending)
+}
@@ -0,0 +1,94 @@
+package raknet
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// minMTUSize is the smallest MTU size that may be negotiated. Open connection request 1 packets padded
+	// to less than it, or not padded at all, are answered with it.
+	minMTUSize = 400
+	// maxMTUProbes is the maximum amount of clients of which the largest open connection request 1 is held.
+	// Clients beyond it are answered with the size of each request as it arrives.
+	maxMTUProbes = 4096
+	// mtuProbeTimeout is the time after which the largest open connection request 1 of a client that did
+	// not send an open connection request 2 is forgotten.
+	mtuProbeTimeout = time.Second * 10
+)
+
+// mtuProbes holds the largest open connection request 1 received from each client that is discovering its
+// MTU size. Clients send request 1 packets with decreasing padding until one is answered, so a client whose
+// reply to a large request was lost will retry with a smaller one. The client is still answered with the
+// largest size that reached the Listener, so that the retry does not lower the MTU size negotiated.
+type mtuProbes struct {
+	mu sync.Mutex
+	m  map[string]mtuProbe
+	// swept is the last time expired probes were removed from m to make room. Sweeps happen at most once
+	// per second, so that a flood of requests from many addresses does not make every request scan all
+	// probes.
+	swept time.Time
+}
+
+// mtuProbe is the largest MTU size probed by a single client, held until its expiry.
+type mtuProbe struct {
+	size   int
+	expiry time.Time
+}
+
+// observe records an open connection request 1 of the size passed from the address passed and returns the
+// largest size probed by the address that has not yet expired.
+func (p *mtuProbes) observe(addr string, size int, now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.m == nil {
+		p.m = make(map[string]mtuProbe)
+	}
+	if v, ok := p.m[addr]; ok && !now.After(v.expiry) && v.size > size {
+		size = v.size
+	} else if !ok && len(p.m) >= maxMTUProbes {
+		if now.Sub(p.swept) >= time.Second {
+			p.swept = now
+			for k, v := range p.m {
+				if now.After(v.expiry) {
+					delete(p.m, k)
+				}
+			}
+		}
+		if len(p.m) >= maxMTUProbes {
+			return size
+		}
+	}
+	p.m[addr] = mtuProbe{size: size, expiry: now.Add(mtuProbeTimeout)}
+	return size
+}
+
+// probed checks if the address passed sent an open connection request 1 that has not yet expired.
+func (p *mtuProbes) probed(addr string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.m[addr]
+	return ok && !now.After(v.expiry)
+}
+
+// forget removes the probe held for the address passed, once it sent an open connection request 2.
+func (p *mtuProbes) forget(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.m, addr)
+}
+
+// probedMTUSize returns the MTU size probed by an open connection request 1 of n bytes, packet ID included,
+// using the framing passed. The size is clamped between minMTUSize and the maximum MTU size passed.
+func probedMTUSize(n int, framing protocolFraming, maxMTUSize int) int {
+	size := n + framing.mtuHeaderSize()
+	if size < minMTUSize {
+		size = minMTUSize
+	}
+	if size > maxMTUSize {
+		// The request may have filled the entire read buffer and have been truncated. Either way, no bigger
+		// MTU size can be used.
+		size = maxMTUSize
+	}
+	return framing.roundMTU(size)
+}
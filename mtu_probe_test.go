@@ -0,0 +1,132 @@
+package raknet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMTUProbes(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer client.Close()
+
+	// The client retries with decreasing padding as if the reply to its first request was lost. The retry
+	// must not lower the MTU size, and an unpadded request is answered with the minimum MTU size.
+	for _, test := range []struct {
+		size, expected int
+	}{{1200, 1200}, {576, 1200}, {1300, 1300}} {
+		request := append([]byte{idOpenConnectionRequest1}, magic[:]...)
+		request = append(request, MinecraftProtocol)
+		request = append(request, make([]byte, test.size-len(request)-28)...)
+		if _, err := client.WriteTo(request, l.Addr()); err != nil {
+			t.Fatalf("error writing request: %v", err)
+		}
+		if mtuSize := readReply1(t, client); mtuSize != test.expected {
+			t.Fatalf("request of %v bytes: expected MTU size %v, got %v", test.size, test.expected, mtuSize)
+		}
+	}
+
+	other, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.WriteTo(append(append([]byte{idOpenConnectionRequest1}, magic[:]...), MinecraftProtocol), l.Addr()); err != nil {
+		t.Fatalf("error writing request: %v", err)
+	}
+	if mtuSize := readReply1(t, other); mtuSize != minMTUSize {
+		t.Fatalf("unpadded request: expected MTU size %v, got %v", minMTUSize, mtuSize)
+	}
+}
+
+// readReply1 reads an open connection reply 1 from the conn passed and returns the MTU size it holds.
+func readReply1(t *testing.T, conn net.PacketConn) int {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatalf("error reading reply: %v", err)
+	}
+	if b[0] != idOpenConnectionReply1 {
+		t.Fatalf("expected open connection reply 1, got %x", b[:n])
+	}
+	reply := &openConnectionReply1{}
+	if err := reply.UnmarshalBinary(b[1:n]); err != nil {
+		t.Fatalf("error decoding reply: %v", err)
+	}
+	return int(reply.MTUSize)
+}
+
+func TestMaxMTUSize(t *testing.T) {
+	l, err := ListenConfig{MaxMTUSize: 1200}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	_ = client.Close()
+	if client.MTUSize() != 1200 {
+		t.Fatalf("expected MTU size 1200, got %v", client.MTUSize())
+	}
+
+	// A client claiming an absurd MTU size in its open connection request 2 is given the minimum.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer conn.Close()
+	addr := rakAddr(*l.Addr().(*net.UDPAddr))
+	request, _ := (&openConnectionRequest2{Magic: magic, ServerAddress: &addr, MTUSize: -1, ClientGUID: 1}).MarshalBinary()
+	if _, err := conn.WriteTo(append([]byte{idOpenConnectionRequest2}, request...), l.Addr()); err != nil {
+		t.Fatalf("error writing request: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil || b[0] != idOpenConnectionReply2 {
+		t.Fatalf("expected open connection reply 2, got %x (%v)", b[0], err)
+	}
+	reply := &openConnectionReply2{}
+	if err := reply.UnmarshalBinary(b[1:n]); err != nil {
+		t.Fatalf("error decoding reply: %v", err)
+	}
+	if reply.MTUSize != minMTUSize {
+		t.Fatalf("expected MTU size %v, got %v", minMTUSize, reply.MTUSize)
+	}
+}
+
+func TestMTUProbesSweepThrottled(t *testing.T) {
+	p := &mtuProbes{}
+	now := time.Now()
+	for i := 0; i < maxMTUProbes; i++ {
+		p.observe(fmt.Sprint(i), minMTUSize, now)
+	}
+	now = now.Add(mtuProbeTimeout + time.Millisecond)
+	p.observe("new", minMTUSize, now)
+	if len(p.m) != 1 {
+		t.Fatalf("expected expired probes to be swept, got %v", len(p.m))
+	}
+	for i := 0; i < maxMTUProbes; i++ {
+		p.observe(fmt.Sprint(i), minMTUSize, now.Add(-mtuProbeTimeout))
+	}
+	p.observe("other", minMTUSize, now.Add(time.Millisecond*500))
+	if p.probed("other", now) {
+		t.Fatalf("expected no sweep within a second of the last")
+	}
+	p.observe("other", minMTUSize, now.Add(time.Second))
+	if !p.probed("other", now.Add(time.Second)) {
+		t.Fatalf("expected sweep a second after the last")
+	}
+}
@@ -15,13 +15,32 @@ const (
 	idOpenConnectionRequest2 byte = 0x07
 	idOpenConnectionReply2   byte = 0x08
 
+	idAlreadyConnected          byte = 0x12
+	idNoFreeIncomingConnections byte = 0x14
+	idConnectionBanned          byte = 0x17
+
 	idIncompatibleProtocolVersion byte = 0x19
 )
 
+// magic is the default offline message magic, found in every offline message to tell them apart from other
+// traffic. It may be overridden using ListenConfig.Magic and Dialer.Magic.
 var magic = [16]byte{
 	0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78,
 }
 
+// magicOffset returns the offset of the magic in the offline message with the ID passed, counted from the
+// byte following the ID, and the name of the message. It must only be called for messages sent to a server.
+func magicOffset(id byte) (offset int, name string) {
+	switch id {
+	case idUnconnectedPing:
+		// The magic follows the send timestamp.
+		return 8, "unconnected ping"
+	case idOpenConnectionRequest1:
+		return 0, "open connection request 1"
+	}
+	return 0, "open connection request 2"
+}
+
 type unconnectedPing struct {
 	SendTimestamp int64
 	Magic         [16]byte
@@ -43,7 +62,74 @@ type openConnectionReply1 struct {
 	Magic      [16]byte
 	ServerGUID int64
 	Secure     bool
-	MTUSize    int16
+	// Cookie is only present if Secure is true. ServerPublicKey may follow it, unless the server only uses
+	// the cookie to verify the address of the client.
+	Cookie          uint32
+	ServerPublicKey []byte
+	MTUSize         int16
+}
+
+// MarshalBinary converts an open connection reply 1 to its binary representation.
+func (reply *openConnectionReply1) MarshalBinary() (b []byte, err error) {
+	buffer := bytes.NewBuffer(append([]byte(nil), reply.Magic[:]...))
+	if err := binary.Write(buffer, binary.BigEndian, reply.ServerGUID); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, reply.Secure); err != nil {
+		return nil, err
+	}
+	if reply.Secure {
+		if err := binary.Write(buffer, binary.BigEndian, reply.Cookie); err != nil {
+			return nil, err
+		}
+		if len(reply.ServerPublicKey) != 0 && len(reply.ServerPublicKey) != securityKeySize {
+			return nil, fmt.Errorf("invalid server public key length %v", len(reply.ServerPublicKey))
+		}
+		if _, err := buffer.Write(reply.ServerPublicKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := binary.Write(buffer, binary.BigEndian, reply.MTUSize); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a serialised open connection reply 1 into a struct.
+func (reply *openConnectionReply1) UnmarshalBinary(b []byte) error {
+	buffer := bytes.NewBuffer(b)
+	if err := binary.Read(buffer, binary.BigEndian, &reply.Magic); err != nil {
+		return err
+	}
+	if err := binary.Read(buffer, binary.BigEndian, &reply.ServerGUID); err != nil {
+		return err
+	}
+	if err := binary.Read(buffer, binary.BigEndian, &reply.Secure); err != nil {
+		return err
+	}
+	if reply.Secure {
+		if err := binary.Read(buffer, binary.BigEndian, &reply.Cookie); err != nil {
+			return err
+		}
+		// Only the MTU size follows the cookie if the server sent no public key.
+		if buffer.Len() > 2 {
+			reply.ServerPublicKey = append([]byte(nil), buffer.Next(securityKeySize)...)
+			if len(reply.ServerPublicKey) != securityKeySize {
+				return fmt.Errorf("not enough bytes for server public key")
+			}
+		}
+	}
+	if err := binary.Read(buffer, binary.BigEndian, &reply.MTUSize); err != nil {
+		return err
+	}
+	return nil
+}
+
+// connectionRefused is the layout of both ID_ALREADY_CONNECTED and ID_NO_FREE_INCOMING_CONNECTIONS, which a
+// server sends in response to an open connection request 2 that it refuses.
+type connectionRefused struct {
+	Magic      [16]byte
+	ServerGUID int64
 }
 
 type incompatibleProtocolVersion struct {
@@ -53,7 +139,12 @@ type incompatibleProtocolVersion struct {
 }
 
 type openConnectionRequest2 struct {
-	Magic         [16]byte
+	Magic [16]byte
+	// Secure specifies if the Cookie is present, which may be followed by a Challenge. It is not encoded
+	// itself: It must be set before decoding if the server sent a cookie in its open connection reply 1.
+	Secure        bool
+	Cookie        uint32
+	Challenge     []byte
 	ServerAddress *rakAddr
 	MTUSize       int16
 	ClientGUID    int64
@@ -65,7 +156,31 @@ func (request *openConnectionRequest2) MarshalBinary() (b []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
-	buffer := bytes.NewBuffer(append(magic[:], addrBytes...))
+	buffer := bytes.NewBuffer(append([]byte(nil), request.Magic[:]...))
+	if request.Secure {
+		if err := binary.Write(buffer, binary.BigEndian, request.Cookie); err != nil {
+			return nil, err
+		}
+		// The presence of a challenge is signalled by a boolean preceding it.
+		if len(request.Challenge) == 0 {
+			if err := buffer.WriteByte(0); err != nil {
+				return nil, err
+			}
+		} else {
+			if len(request.Challenge) != securityKeySize {
+				return nil, fmt.Errorf("invalid challenge length %v", len(request.Challenge))
+			}
+			if err := buffer.WriteByte(1); err != nil {
+				return nil, err
+			}
+			if _, err := buffer.Write(request.Challenge); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := buffer.Write(addrBytes); err != nil {
+		return nil, err
+	}
 	if err := binary.Write(buffer, binary.BigEndian, request.MTUSize); err != nil {
 		return nil, err
 	}
@@ -78,8 +193,24 @@ func (request *openConnectionRequest2) MarshalBinary() (b []byte, err error) {
 // UnmarshalBinary parses a binary representation of an open connection request 2.
 func (request *openConnectionRequest2) UnmarshalBinary(b []byte) error {
 	buffer := bytes.NewBuffer(b)
-	// Skip the magic.
-	buffer.Next(16)
+	if err := binary.Read(buffer, binary.BigEndian, &request.Magic); err != nil {
+		return err
+	}
+	if request.Secure {
+		if err := binary.Read(buffer, binary.BigEndian, &request.Cookie); err != nil {
+			return err
+		}
+		var hasChallenge bool
+		if err := binary.Read(buffer, binary.BigEndian, &hasChallenge); err != nil {
+			return err
+		}
+		if hasChallenge {
+			request.Challenge = append([]byte(nil), buffer.Next(securityKeySize)...)
+			if len(request.Challenge) != securityKeySize {
+				return fmt.Errorf("not enough bytes for challenge")
+			}
+		}
+	}
 
 	addr, err := unmarshalAddr(buffer)
 	if err != nil {
@@ -101,11 +232,13 @@ type openConnectionReply2 struct {
 	ClientAddress *rakAddr
 	MTUSize       int16
 	Secure        bool
+	// SecurityAnswer follows Secure if it is true.
+	SecurityAnswer []byte
 }
 
 // MarshalBinary converts an open connection reply 2 to its binary representation.
 func (reply *openConnectionReply2) MarshalBinary() (b []byte, err error) {
-	buffer := bytes.NewBuffer(magic[:])
+	buffer := bytes.NewBuffer(append([]byte(nil), reply.Magic[:]...))
 	if err := binary.Write(buffer, binary.BigEndian, reply.ServerGUID); err != nil {
 		return nil, err
 	}
@@ -126,14 +259,20 @@ func (reply *openConnectionReply2) MarshalBinary() (b []byte, err error) {
 	if err := buffer.WriteByte(secure); err != nil {
 		return nil, err
 	}
+	if reply.Secure {
+		if _, err := buffer.Write(reply.SecurityAnswer); err != nil {
+			return nil, err
+		}
+	}
 	return buffer.Bytes(), nil
 }
 
 // UnmarshalBinary decode a serialised open connection reply 2 into a struct.
 func (reply *openConnectionReply2) UnmarshalBinary(b []byte) error {
 	buffer := bytes.NewBuffer(b)
-	// Skip the magic sequence.
-	buffer.Next(16)
+	if err := binary.Read(buffer, binary.BigEndian, &reply.Magic); err != nil {
+		return err
+	}
 	if err := binary.Read(buffer, binary.BigEndian, &reply.ServerGUID); err != nil {
 		return err
 	}
@@ -149,6 +288,9 @@ func (reply *openConnectionReply2) UnmarshalBinary(b []byte) error {
 	if err := binary.Read(buffer, binary.BigEndian, &reply.Secure); err != nil {
 		return err
 	}
+	if reply.Secure {
+		reply.SecurityAnswer = append([]byte(nil), buffer.Bytes()...)
+	}
 	return nil
 }
 
@@ -5,6 +5,8 @@ import (
 	"time"
 )
 
+// DelayRecordCount is the default amount of delays recorded by an ordered queue to calculate the average
+// delay.
 const DelayRecordCount = 40
 
 // orderedQueue is a queue of byte slices that are taken out in an ordered way. No byte slice may be taken out
@@ -16,14 +18,16 @@ type orderedQueue struct {
 	lowestIndex  uint24
 	highestIndex uint24
 	lastClean    time.Time
+	clock        Clock
 
 	ptr    int
 	delays []time.Duration
 }
 
-// newOrderedQueue returns a new initialised ordered queue.
-func newOrderedQueue() *orderedQueue {
-	return &orderedQueue{queue: make(map[uint24]interface{}), timestamps: make(map[uint24]time.Time), delays: make([]time.Duration, DelayRecordCount)}
+// newOrderedQueue returns a new initialised ordered queue that records the last delayRecordCount delays,
+// measured using the Clock passed.
+func newOrderedQueue(delayRecordCount int, clock Clock) *orderedQueue {
+	return &orderedQueue{queue: make(map[uint24]interface{}), timestamps: make(map[uint24]time.Time), delays: make([]time.Duration, delayRecordCount), clock: clock}
 }
 
 // put puts a value at the index passed. If the index was already occupied once, an error is returned.
@@ -38,19 +42,25 @@ func (queue *orderedQueue) put(index uint24, value interface{}) error {
 		queue.highestIndex = index + 1
 	}
 	queue.queue[index] = value
-	queue.timestamps[index] = time.Now()
+	queue.timestamps[index] = queue.clock.Now()
 	return nil
 }
 
+// inWindow checks if the index passed lies within the window of the size passed, which starts at the lowest
+// index that was not yet taken out.
+func (queue *orderedQueue) inWindow(index, size uint24) bool {
+	return index >= queue.lowestIndex && index-queue.lowestIndex < size
+}
+
 // take fetches a value from the index passed and removes the value from the queue. If the value was found, ok
 // is true.
 func (queue *orderedQueue) take(index uint24) (val interface{}, ok bool) {
 	val, ok = queue.queue[index]
 	if ok {
 		delete(queue.queue, index)
-		queue.delays[queue.ptr] = time.Now().Sub(queue.timestamps[index])
+		queue.delays[queue.ptr] = queue.clock.Now().Sub(queue.timestamps[index])
 		queue.ptr++
-		if queue.ptr == DelayRecordCount {
+		if queue.ptr == len(queue.delays) {
 			queue.ptr = 0
 		}
 		delete(queue.timestamps, index)
@@ -115,7 +125,8 @@ func (queue *orderedQueue) Timestamp(sequenceNumber uint24) time.Time {
 }
 
 // AvgDelay returns the average delay between the putting of the value into the ordered queue and the taking
-// out of it again. It is measured over the last DelayRecordCount values put in.
+// out of it again. It is measured over the last values put in, the amount of which was passed when creating
+// the queue.
 func (queue *orderedQueue) AvgDelay() time.Duration {
 	var average, records time.Duration
 	for _, delay := range queue.delays {
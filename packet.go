@@ -2,8 +2,8 @@ package raknet
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
+	"io"
 	"sort"
 )
 
@@ -19,6 +19,10 @@ const (
 const (
 	idConnectedPing = 0x00
 	idConnectedPong = 0x03
+	// idDetectLostConnections is sent reliably by RakNet when it has not received anything from the other end
+	// for half of its timeout, so that the acknowledgement it forces shows whether the other end is still
+	// there. It holds no data.
+	idDetectLostConnections = 0x04
 
 	idConnectionRequest         = 0x09
 	idConnectionRequestAccepted = 0x10
@@ -46,6 +50,12 @@ const (
 	// splitFlag is set in the header if the packet was split. If so, the encapsulation contains additional
 	// data about the fragment.
 	splitFlag = 0x10
+	// maxSplitCount is the maximum amount of fragments a packet may be split into. It limits the memory
+	// allocated for a split packet when its first fragment arrives.
+	maxSplitCount = 8192
+	// splitSlotSize is the memory taken up by the slot of a single fragment of a split packet that is being
+	// reassembled, regardless of its content: The size of a slice header.
+	splitSlotSize = 24
 )
 
 type connectedPing struct {
@@ -72,6 +82,70 @@ type connectionRequestAccepted struct {
 
 type newIncomingConnection connectionRequestAccepted
 
+// datagramHeaderSize is the size of the header of a datagram: The datagram flags followed by the sequence
+// number.
+const datagramHeaderSize = 1 + 3
+
+// datagramHeader is the header found at the start of every datagram. It is a value type so that it may be
+// encoded and decoded without allocating.
+type datagramHeader struct {
+	// flags is a combination of the bitFlagValid, bitFlagACK and bitFlagNACK flags.
+	flags byte
+	// sequenceNumber is the sequence number of the datagram. It is only present in datagrams that are not
+	// ACKs or NACKs.
+	sequenceNumber uint24
+}
+
+// write writes the datagram header to buffer b.
+func (header datagramHeader) write(b *bytes.Buffer) error {
+	if err := b.WriteByte(header.flags); err != nil {
+		return fmt.Errorf("error writing datagram header: %v", err)
+	}
+	if header.flags&(bitFlagACK|bitFlagNACK) != 0 {
+		return nil
+	}
+	if err := writeUint24(b, header.sequenceNumber); err != nil {
+		return fmt.Errorf("error writing datagram sequence number: %v", err)
+	}
+	return nil
+}
+
+// read reads a datagram header from buffer b.
+func (header *datagramHeader) read(b *bytes.Buffer) (err error) {
+	if header.flags, err = b.ReadByte(); err != nil {
+		return fmt.Errorf("error reading datagram header flags: %v", err)
+	}
+	if header.flags&bitFlagValid == 0 || header.flags&(bitFlagACK|bitFlagNACK) != 0 {
+		return nil
+	}
+	if header.sequenceNumber, err = readUint24(b); err != nil {
+		return fmt.Errorf("error reading datagram sequence number: %v", err)
+	}
+	return nil
+}
+
+// datagram is a collection of packets sent together in a single datagram. Datagrams are kept in the recovery
+// queue until they are acknowledged, so that all packets in it may be resent if the datagram is lost.
+type datagram struct {
+	packets []*packet
+}
+
+// size returns the encoded size of all packets in the datagram, excluding the datagram header.
+func (d *datagram) size() (n int) {
+	for _, p := range d.packets {
+		n += p.size()
+	}
+	return n
+}
+
+// contentSize returns the combined size of the content of all packets in the datagram.
+func (d *datagram) contentSize() (n int) {
+	for _, p := range d.packets {
+		n += len(p.content)
+	}
+	return n
+}
+
 type packet struct {
 	reliability byte
 
@@ -79,6 +153,7 @@ type packet struct {
 	messageIndex  uint24
 	sequenceIndex uint24
 	orderIndex    uint24
+	orderChannel  byte
 
 	split      bool
 	splitCount uint32
@@ -94,7 +169,7 @@ func (packet *packet) write(b *bytes.Buffer) error {
 	if err := b.WriteByte(header); err != nil {
 		return fmt.Errorf("error writing packet header: %v", err)
 	}
-	if err := binary.Write(b, binary.BigEndian, uint16(len(packet.content))<<3); err != nil {
+	if err := writeUint16(b, uint16(len(packet.content))<<3); err != nil {
 		return fmt.Errorf("error writing packet content length: %v", err)
 	}
 	if packet.reliable() {
@@ -115,13 +190,13 @@ func (packet *packet) write(b *bytes.Buffer) error {
 		_ = b.WriteByte(0)
 	}
 	if packet.split {
-		if err := binary.Write(b, binary.BigEndian, packet.splitCount); err != nil {
+		if err := writeUint32(b, packet.splitCount); err != nil {
 			return fmt.Errorf("error writing packet split count: %v", err)
 		}
-		if err := binary.Write(b, binary.BigEndian, packet.splitID); err != nil {
+		if err := writeUint16(b, packet.splitID); err != nil {
 			return fmt.Errorf("error writing packet split ID: %v", err)
 		}
-		if err := binary.Write(b, binary.BigEndian, packet.splitIndex); err != nil {
+		if err := writeUint32(b, packet.splitIndex); err != nil {
 			return fmt.Errorf("error writing packet split index: %v", err)
 		}
 	}
@@ -138,8 +213,8 @@ func (packet *packet) read(b *bytes.Buffer) error {
 	}
 	packet.split = (header & splitFlag) != 0
 	packet.reliability = (header & 224) >> 5
-	var packetLength uint16
-	if err := binary.Read(b, binary.BigEndian, &packetLength); err != nil {
+	packetLength, err := readUint16(b)
+	if err != nil {
 		return fmt.Errorf("error reading packet length: %v", err)
 	}
 	packetLength >>= 3
@@ -166,29 +241,64 @@ func (packet *packet) read(b *bytes.Buffer) error {
 		if err != nil {
 			return fmt.Errorf("error reading packet order index: %v", err)
 		}
-		// Order channel (byte), we don't care about this.
-		b.Next(1)
+		if packet.orderChannel, err = b.ReadByte(); err != nil {
+			return fmt.Errorf("error reading packet order channel: %v", err)
+		}
 	}
 
 	if packet.split {
-		if err := binary.Read(b, binary.BigEndian, &packet.splitCount); err != nil {
+		if packet.splitCount, err = readUint32(b); err != nil {
 			return fmt.Errorf("error reading packet split count: %v", err)
 		}
-		if err := binary.Read(b, binary.BigEndian, &packet.splitID); err != nil {
+		if packet.splitID, err = readUint16(b); err != nil {
 			return fmt.Errorf("error reading packet split ID: %v", err)
 		}
-		if err := binary.Read(b, binary.BigEndian, &packet.splitIndex); err != nil {
+		if packet.splitIndex, err = readUint32(b); err != nil {
 			return fmt.Errorf("error reading packet split index: %v", err)
 		}
+		if packet.splitCount == 0 || packet.splitCount > maxSplitCount || packet.splitIndex >= packet.splitCount {
+			return fmt.Errorf("invalid packet split: index %v of count %v (max count %v)", packet.splitIndex, packet.splitCount, maxSplitCount)
+		}
 	}
 
-	packet.content = make([]byte, packetLength)
-	if _, err := b.Read(packet.content); err != nil {
+	if b.Len() < int(packetLength) {
+		return fmt.Errorf("error reading packet content: %v", io.ErrUnexpectedEOF)
+	}
+	if packet.split {
+		// Split packets are only held until they are reassembled, after which their content is returned to
+		// the buffer pool.
+		packet.content = getBuffer(int(packetLength))
+	} else {
+		packet.content = make([]byte, packetLength)
+	}
+	if n, err := b.Read(packet.content); err != nil {
 		return fmt.Errorf("error reading packet content: %v", err)
+	} else if n != int(packetLength) {
+		return fmt.Errorf("error reading packet content: %v", io.ErrUnexpectedEOF)
 	}
 	return nil
 }
 
+// size returns the encoded size of the packet, including its header.
+func (packet *packet) size() int {
+	// Packet header + packet content length.
+	n := 1 + 2 + len(packet.content)
+	if packet.reliable() {
+		n += 3
+	}
+	if packet.sequenced() {
+		n += 3
+	}
+	if packet.sequencedOrOrdered() {
+		// Order index + order channel.
+		n += 3 + 1
+	}
+	if packet.split {
+		n += splitAdditionalSize
+	}
+	return n
+}
+
 func (packet *packet) reliable() bool {
 	switch packet.reliability {
 	case reliabilityReliable,
@@ -231,136 +341,116 @@ type acknowledgement struct {
 	packets []uint24
 }
 
-// write writes an acknowledgement packet and returns an error if not successful.
-func (ack *acknowledgement) write(b *bytes.Buffer) error {
-	packets := ack.packets
-	if len(packets) == 0 {
-		return binary.Write(b, binary.BigEndian, int16(0))
-	}
-	buffer := bytes.NewBuffer(nil)
-	// Sort packets before encoding to ensure packets are encoded correctly.
-	sort.Slice(packets, func(i, j int) bool {
-		return packets[i] < packets[j]
-	})
-
-	var firstPacketInRange uint24
-	var lastPacketInRange uint24
-	var recordCount int16
-
-	for index, packet := range packets {
-		if index == 0 {
-			// The first packet, set the first and last packet to it.
-			firstPacketInRange = packet
-			lastPacketInRange = packet
-			continue
-		}
-		if packet == lastPacketInRange+1 {
-			// Packet is still part of the current range, as it's sequenced properly with the last packet.
-			// Set the last packet in range to the packet and continue to the next packet.
-			lastPacketInRange = packet
-			continue
-		} else {
-			// We got to the end of a range/single packet. We need to write those down now.
-			if firstPacketInRange == lastPacketInRange {
-				// First packet equals last packet, so we have a single packet record. Write down the packet,
-				// and set the first and last packet to the current packet.
-				if err := buffer.WriteByte(packetSingle); err != nil {
-					return err
-				}
-				if err := writeUint24(buffer, firstPacketInRange); err != nil {
-					return err
-				}
-
-				firstPacketInRange = packet
-				lastPacketInRange = packet
-			} else {
-				// There's a gap between the first and last packet, so we have a range of packets. Write the
-				// first and last packet of the range and set both to the current packet.
-				if err := buffer.WriteByte(packetRange); err != nil {
-					return err
-				}
-				if err := writeUint24(buffer, firstPacketInRange); err != nil {
-					return err
-				}
-				if err := writeUint24(buffer, lastPacketInRange); err != nil {
-					return err
-				}
-
-				firstPacketInRange = packet
-				lastPacketInRange = packet
-			}
-			// Keep track of the amount of records as we need to write that first.
-			recordCount++
-		}
-	}
+// ackRecord is a single record of an acknowledgement packet. It holds either a single packet, if first and
+// last are equal, or a range of packets. It is a value type so that it may be encoded and decoded without
+// allocating.
+type ackRecord struct {
+	first, last uint24
+}
 
-	// Make sure the last single packet/range is written, as we always need to know one packet ahead to know
-	// how we should write the current.
-	if firstPacketInRange == lastPacketInRange {
-		if err := buffer.WriteByte(packetSingle); err != nil {
+// write writes the acknowledgement record to buffer b.
+func (record ackRecord) write(b *bytes.Buffer) error {
+	if record.first == record.last {
+		if err := b.WriteByte(packetSingle); err != nil {
 			return err
 		}
-		if err := writeUint24(buffer, firstPacketInRange); err != nil {
-			return err
-		}
-	} else {
-		if err := buffer.WriteByte(packetRange); err != nil {
-			return err
-		}
-		if err := writeUint24(buffer, firstPacketInRange); err != nil {
-			return err
-		}
-		if err := writeUint24(buffer, lastPacketInRange); err != nil {
+		return writeUint24(b, record.first)
+	}
+	if err := b.WriteByte(packetRange); err != nil {
+		return err
+	}
+	if err := writeUint24(b, record.first); err != nil {
+		return err
+	}
+	return writeUint24(b, record.last)
+}
+
+// read reads an acknowledgement record from buffer b.
+func (record *ackRecord) read(b *bytes.Buffer) (err error) {
+	recordType, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	if record.first, err = readUint24(b); err != nil {
+		return err
+	}
+	record.last = record.first
+	if recordType == packetRange {
+		if record.last, err = readUint24(b); err != nil {
 			return err
 		}
 	}
-	recordCount++
-	if err := binary.Write(b, binary.BigEndian, recordCount); err != nil {
-		return err
+	return nil
+}
+
+// nextAckRecord returns the record formed by the consecutive sequence numbers found at the start of the
+// sorted, non-empty slice of sequence numbers passed, and the sequence numbers remaining after it.
+func nextAckRecord(packets []uint24) (ackRecord, []uint24) {
+	record := ackRecord{first: packets[0], last: packets[0]}
+	i := 1
+	for ; i < len(packets) && packets[i] == record.last+1; i++ {
+		record.last = packets[i]
+	}
+	return record, packets[i:]
+}
+
+// uint24s implements sort.Interface for a slice of uint24s, sorting them in ascending order.
+type uint24s []uint24
+
+func (s uint24s) Len() int           { return len(s) }
+func (s uint24s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint24s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// write writes an acknowledgement packet and returns an error if not successful.
+func (ack *acknowledgement) write(b *bytes.Buffer) error {
+	packets := ack.packets
+	// Sort packets before encoding to ensure packets are encoded correctly.
+	sort.Sort(uint24s(packets))
+
+	// We first count the records, as the amount of records needs to be written before the records
+	// themselves.
+	var recordCount uint16
+	for rest := packets; len(rest) > 0; recordCount++ {
+		_, rest = nextAckRecord(rest)
 	}
-	if _, err := b.Write(buffer.Bytes()); err != nil {
+	if err := writeUint16(b, recordCount); err != nil {
 		return err
 	}
+	for rest := packets; len(rest) > 0; {
+		var record ackRecord
+		record, rest = nextAckRecord(rest)
+		if err := record.write(b); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // read reads an acknowledgement packet and returns an error if not successful.
 func (ack *acknowledgement) read(b *bytes.Buffer) error {
 	const maxAcknowledgementPackets = 512
-	var recordCount int16
-	if err := binary.Read(b, binary.BigEndian, &recordCount); err != nil {
+	recordCount, err := readUint16(b)
+	if err != nil {
 		return err
 	}
-	for i := int16(0); i < recordCount; i++ {
-		recordType, err := b.ReadByte()
-		if err != nil {
+	if recordCount > maxAcknowledgementPackets {
+		// Every valid record holds at least one sequence number, so there can be no more records than
+		// sequence numbers.
+		return fmt.Errorf("maximum amount of records in acknowledgement exceeded: %v", recordCount)
+	}
+	for i := uint16(0); i < recordCount; i++ {
+		var record ackRecord
+		if err := record.read(b); err != nil {
 			return err
 		}
-		switch recordType {
-		case packetRange:
-			start, err := readUint24(b)
-			if err != nil {
-				return err
-			}
-			end, err := readUint24(b)
-			if err != nil {
-				return err
-			}
-			for pack := start; pack <= end; pack++ {
-				ack.packets = append(ack.packets, pack)
-				if len(ack.packets) > maxAcknowledgementPackets {
-					return fmt.Errorf("maximum amount of packets in acknowledgement exceeded")
-				}
-			}
-		case packetSingle:
-			packet, err := readUint24(b)
-			if err != nil {
-				return err
-			}
-			ack.packets = append(ack.packets, packet)
-			if len(ack.packets) > maxAcknowledgementPackets {
-				return fmt.Errorf("maximum amount of packets in acknowledgement exceeded")
-			}
+		if record.first > record.last {
+			return fmt.Errorf("invalid acknowledgement record: first %v is above last %v", record.first, record.last)
+		}
+		if record.last-record.first >= maxAcknowledgementPackets-uint24(len(ack.packets)) {
+			return fmt.Errorf("maximum amount of packets in acknowledgement exceeded")
+		}
+		for pack := record.first; pack <= record.last; pack++ {
+			ack.packets = append(ack.packets, pack)
 		}
 	}
 	return nil
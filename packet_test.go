@@ -0,0 +1,168 @@
+package raknet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPacket(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	p := &packet{
+		reliability: reliabilityReliableOrdered,
+		content:     []byte{1, 2, 3, 4, 5},
+		orderIndex:  123,
+		split:       true,
+		splitCount:  3,
+		splitIndex:  2,
+		splitID:     7,
+	}
+	if err := p.write(b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != p.size() {
+		t.Errorf("expected encoded packet size %v, but got %v", p.size(), b.Len())
+	}
+	read := &packet{}
+	if err := read.read(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, read) {
+		t.Errorf("decoded packet %+v was not equal to encoded packet %+v", read, p)
+	}
+}
+
+func TestAcknowledgement(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	ack := &acknowledgement{packets: []uint24{9, 1, 2, 3, 5, 7, 8}}
+	if err := ack.write(b); err != nil {
+		t.Fatal(err)
+	}
+	read := &acknowledgement{}
+	if err := read.read(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.packets, []uint24{1, 2, 3, 5, 7, 8, 9}) {
+		t.Errorf("unexpected packets decoded from acknowledgement: %v", read.packets)
+	}
+}
+
+func TestAcknowledgementLimits(t *testing.T) {
+	encode := func(count uint16, records ...ackRecord) *bytes.Buffer {
+		b := bytes.NewBuffer(nil)
+		_ = writeUint16(b, count)
+		for _, record := range records {
+			_ = record.write(b)
+		}
+		return b
+	}
+	for name, tc := range map[string]struct {
+		b  *bytes.Buffer
+		ok bool
+	}{
+		"maximum span":     {encode(2, ackRecord{0, 255}, ackRecord{1000, 1255}), true},
+		"span exceeded":    {encode(2, ackRecord{0, 255}, ackRecord{1000, 1256}), false},
+		"huge range":       {encode(1, ackRecord{0, 0xffffff}), false},
+		"reversed range":   {encode(1, ackRecord{10, 5}), false},
+		"too many records": {encode(513), false},
+		"missing records":  {encode(2, ackRecord{0, 0}), false},
+		"single":           {encode(1, ackRecord{7, 7}), true},
+	} {
+		err := (&acknowledgement{}).read(tc.b)
+		if tc.ok && err != nil {
+			t.Errorf("%v: unexpected error: %v", name, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+	}
+}
+
+// TestAllocations makes sure the encoding and decoding of the datagram header, packets and acknowledgement
+// records does not allocate, so that they keep from escaping to the heap.
+func TestAllocations(t *testing.T) {
+	b := bytes.NewBuffer(make([]byte, 0, 1500))
+	p := &packet{reliability: reliabilityReliableOrdered, content: make([]byte, 1000)}
+	tests := map[string]func(){
+		"datagramHeader": func() {
+			b.Reset()
+			_ = datagramHeader{flags: bitFlagValid, sequenceNumber: 10}.write(b)
+			var header datagramHeader
+			_ = header.read(b)
+		},
+		"ackRecord": func() {
+			b.Reset()
+			_ = ackRecord{first: 10, last: 20}.write(b)
+			var record ackRecord
+			_ = record.read(b)
+		},
+		"packet": func() {
+			b.Reset()
+			_ = p.write(b)
+		},
+	}
+	for name, f := range tests {
+		if n := testing.AllocsPerRun(100, f); n != 0 {
+			t.Errorf("%v: expected no allocations, but got %v", name, n)
+		}
+	}
+}
+
+func BenchmarkDatagramHeader(b *testing.B) {
+	buf := bytes.NewBuffer(make([]byte, 0, 4))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		_ = datagramHeader{flags: bitFlagValid, sequenceNumber: uint24(i)}.write(buf)
+		var header datagramHeader
+		_ = header.read(buf)
+	}
+}
+
+func BenchmarkPacketWrite(b *testing.B) {
+	buf := bytes.NewBuffer(make([]byte, 0, 1500))
+	p := &packet{reliability: reliabilityReliableOrdered, content: make([]byte, 1000)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		_ = p.write(buf)
+	}
+}
+
+func BenchmarkPacketRead(b *testing.B) {
+	buf := bytes.NewBuffer(make([]byte, 0, 1500))
+	p := &packet{reliability: reliabilityReliableOrdered, content: make([]byte, 1000)}
+	_ = p.write(buf)
+	data := buf.Bytes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = p.read(bytes.NewBuffer(data))
+	}
+}
+
+func BenchmarkAcknowledgementWrite(b *testing.B) {
+	buf := bytes.NewBuffer(make([]byte, 0, 1500))
+	ack := &acknowledgement{}
+	for i := uint24(0); i < 100; i += 3 {
+		ack.packets = append(ack.packets, i, i+1)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		_ = ack.write(buf)
+	}
+}
+
+func BenchmarkAcknowledgementRead(b *testing.B) {
+	buf := bytes.NewBuffer(make([]byte, 0, 1500))
+	ack := &acknowledgement{}
+	for i := uint24(0); i < 100; i += 3 {
+		ack.packets = append(ack.packets, i, i+1)
+	}
+	_ = ack.write(buf)
+	data := buf.Bytes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ack.packets = ack.packets[:0]
+		_ = ack.read(bytes.NewBuffer(data))
+	}
+}
@@ -0,0 +1,105 @@
+package raknet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Direction is the direction in which a datagram traced by a PacketTraceFunc travelled.
+type Direction int
+
+const (
+	// Inbound is the direction of datagrams received.
+	Inbound Direction = iota
+	// Outbound is the direction of datagrams sent.
+	Outbound
+)
+
+// String returns "in" or "out", depending on the direction.
+func (direction Direction) String() string {
+	if direction == Inbound {
+		return "in"
+	}
+	return "out"
+}
+
+// PacketTraceFunc is a function called for every raw datagram received or sent, including those of the
+// connection sequence and those that are not part of any connection. addr is the address that the datagram
+// was received from or sent to. The byte slice b must not be modified or retained after the function returns.
+// A PacketTraceFunc may be called from multiple goroutines simultaneously.
+type PacketTraceFunc func(direction Direction, addr net.Addr, b []byte)
+
+// HexDumpTrace returns a PacketTraceFunc that writes a hex dump of every datagram to the writer passed,
+// preceded by a line holding the direction, address and length of the datagram.
+func HexDumpTrace(w io.Writer) PacketTraceFunc {
+	var mu sync.Mutex
+	return func(direction Direction, addr net.Addr, b []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = fmt.Fprintf(w, "%v %v (%v bytes)\n%v", direction, addr, len(b), hex.Dump(b))
+	}
+}
+
+// combineTraces returns a PacketTraceFunc that calls both PacketTraceFuncs passed. Either of them may be nil.
+func combineTraces(a, b PacketTraceFunc) PacketTraceFunc {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return func(direction Direction, addr net.Addr, data []byte) {
+		a(direction, addr, data)
+		b(direction, addr, data)
+	}
+}
+
+// traceConn wraps around a UDP connection and calls a PacketTraceFunc for every datagram read from or written
+// to it.
+type traceConn struct {
+	*net.UDPConn
+	trace PacketTraceFunc
+}
+
+// newTraceConn wraps the connection passed so that the PacketTraceFunc passed is called for every datagram.
+// If trace is nil or the connection is not a UDP connection, the connection is returned as is.
+func newTraceConn(conn net.PacketConn, trace PacketTraceFunc) net.PacketConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if trace == nil || !ok {
+		return conn
+	}
+	return &traceConn{UDPConn: udpConn, trace: trace}
+}
+
+// ReadFrom reads a datagram from the connection and traces it.
+func (conn *traceConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	n, addr, err = conn.UDPConn.ReadFrom(b)
+	if err == nil {
+		conn.trace(Inbound, addr, b[:n])
+	}
+	return n, addr, err
+}
+
+// WriteTo traces a datagram and writes it to the address passed.
+func (conn *traceConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	conn.trace(Outbound, addr, b)
+	return conn.UDPConn.WriteTo(b, addr)
+}
+
+// Read reads a datagram from a connected connection and traces it.
+func (conn *traceConn) Read(b []byte) (n int, err error) {
+	n, err = conn.UDPConn.Read(b)
+	if err == nil {
+		conn.trace(Inbound, conn.RemoteAddr(), b[:n])
+	}
+	return n, err
+}
+
+// Write traces a datagram and writes it to a connected connection.
+func (conn *traceConn) Write(b []byte) (n int, err error) {
+	conn.trace(Outbound, conn.RemoteAddr(), b)
+	return conn.UDPConn.Write(b)
+}
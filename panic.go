@@ -0,0 +1,30 @@
+package raknet
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error returned for a packet of which the handling panicked, if RecoverPanics is set in
+// the ListenConfig or Dialer of the connection. The connection is closed when it is returned. A Listener
+// emits an EventErrored holding it.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+// Error ...
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic handling packet: %v", err.Value)
+}
+
+// recoverPanic recovers a panic in the handling of a packet, if any, closing the connection and setting the
+// error pointed to by err to a *PanicError. It must be deferred directly.
+func (conn *Conn) recoverPanic(err *error) {
+	if v := recover(); v != nil {
+		_ = conn.Close()
+		*err = &PanicError{Value: v, Stack: debug.Stack()}
+	}
+}
@@ -0,0 +1,45 @@
+package raknet
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	onAck := func(event AckEvent) {
+		if event.Direction == Inbound {
+			panic("malformed ACK")
+		}
+	}
+	l, err := ListenConfig{RecoverPanics: true, OnAck: onAck}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	events, unsubscribe := l.Subscribe(16)
+	defer unsubscribe()
+
+	if conn, err := (Dialer{}).Dial(l.Addr().String()); err == nil {
+		defer conn.Close()
+	}
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case event := <-events:
+			var panicErr *PanicError
+			if event.Type != EventErrored || !errors.As(event.Err, &panicErr) {
+				continue
+			}
+			if panicErr.Value != "malformed ACK" || len(panicErr.Stack) == 0 {
+				t.Fatalf("unexpected panic error %v", panicErr)
+			}
+			if _, err := (Dialer{}).Ping(l.Addr().String()); err != nil {
+				t.Fatalf("expected listener to keep running after panic: %v", err)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("expected panic to be recovered")
+		}
+	}
+}
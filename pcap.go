@@ -0,0 +1,357 @@
+package raknet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// pcapngSectionHeader, pcapngInterfaceDescription and pcapngEnhancedPacket are the block types of the
+	// pcapng blocks written by a PcapWriter.
+	pcapngSectionHeader        = 0x0a0d0d0a
+	pcapngInterfaceDescription = 0x00000001
+	pcapngEnhancedPacket       = 0x00000006
+	// pcapngLinkTypeRaw is the link type of packets that start with an IPv4 or IPv6 header directly.
+	// pcapngLinkTypeEthernet is the link type of packets that start with an Ethernet header, as captured by
+	// tools such as tcpdump.
+	pcapngLinkTypeRaw      = 101
+	pcapngLinkTypeEthernet = 1
+)
+
+// PcapWriter writes datagrams to a pcapng file, with synthesized IP and UDP headers, so that captured
+// traffic may be opened in Wireshark and inspected with its RakNet dissector. A PcapWriter is safe for
+// concurrent use.
+type PcapWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewPcapWriter returns a PcapWriter that writes to the writer passed. The pcapng section header and
+// interface description are written immediately. If writing them fails, an error is returned.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	writer := &PcapWriter{w: w}
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb, pcapngSectionHeader)
+	binary.LittleEndian.PutUint32(shb[4:], 28)
+	binary.LittleEndian.PutUint32(shb[8:], 0x1a2b3c4d)
+	binary.LittleEndian.PutUint16(shb[12:], 1)
+	// The section length is unknown, which is written as -1.
+	binary.LittleEndian.PutUint64(shb[16:], ^uint64(0))
+	binary.LittleEndian.PutUint32(shb[24:], 28)
+
+	idb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(idb, pcapngInterfaceDescription)
+	binary.LittleEndian.PutUint32(idb[4:], 20)
+	binary.LittleEndian.PutUint16(idb[8:], pcapngLinkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[16:], 20)
+
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, fmt.Errorf("error writing pcapng header: %v", err)
+	}
+	return writer, nil
+}
+
+// WritePacket writes a datagram travelling in the direction passed between the local and remote address
+// passed. Both addresses must be UDP addresses.
+func (writer *PcapWriter) WritePacket(direction Direction, local, remote net.Addr, b []byte) error {
+	src, srcOK := local.(*net.UDPAddr)
+	dst, dstOK := remote.(*net.UDPAddr)
+	if !srcOK || !dstOK {
+		return fmt.Errorf("error writing packet: addresses %v and %v must be UDP addresses", local, remote)
+	}
+	if direction == Inbound {
+		src, dst = dst, src
+	}
+	packet := ipPacket(src, dst, b)
+
+	padded := (len(packet) + 3) &^ 3
+	block := make([]byte, 28+padded+4)
+	binary.LittleEndian.PutUint32(block, pcapngEnhancedPacket)
+	binary.LittleEndian.PutUint32(block[4:], uint32(len(block)))
+	// The interface ID at block[8:] is always 0. Timestamps are in microseconds by default.
+	ts := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	binary.LittleEndian.PutUint32(block[12:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(block[16:], uint32(ts))
+	binary.LittleEndian.PutUint32(block[20:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(block[24:], uint32(len(packet)))
+	copy(block[28:], packet)
+	binary.LittleEndian.PutUint32(block[28+padded:], uint32(len(block)))
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if writer.err != nil {
+		return writer.err
+	}
+	if _, err := writer.w.Write(block); err != nil {
+		writer.err = fmt.Errorf("error writing pcapng packet: %v", err)
+		return writer.err
+	}
+	return nil
+}
+
+// Trace returns a PacketTraceFunc that writes every datagram traced to the PcapWriter. local is the local
+// address of the connection traced. If remote is not nil, only datagrams sent to or received from that
+// address are written, so that the traffic of a single Conn of a Listener may be captured. Once writing a
+// datagram fails, no more datagrams are written.
+func (writer *PcapWriter) Trace(local, remote net.Addr) PacketTraceFunc {
+	return func(direction Direction, addr net.Addr, b []byte) {
+		if remote != nil && addr.String() != remote.String() {
+			return
+		}
+		_ = writer.WritePacket(direction, local, addr, b)
+	}
+}
+
+// SetPcapWriter makes the connection write all datagrams it sends and receives from then on to the writer
+// passed in the pcapng format, like ListenConfig.PcapWriter, so that the traffic of a single connection
+// accepted by a Listener may be captured. The pcapng header is written immediately, and an error is returned
+// if that fails. Datagrams of an encrypted connection are written as they are before encryption and after
+// decryption. Passing nil stops the capture.
+func (conn *Conn) SetPcapWriter(w io.Writer) error {
+	var writer *PcapWriter
+	if w != nil {
+		var err error
+		if writer, err = NewPcapWriter(w); err != nil {
+			return err
+		}
+	}
+	conn.pcap.Store(writer)
+	return nil
+}
+
+// capture writes the datagram passed to the PcapWriter set using SetPcapWriter, if any.
+func (conn *Conn) capture(direction Direction, b []byte) {
+	if writer, _ := conn.pcap.Load().(*PcapWriter); writer != nil {
+		_ = writer.WritePacket(direction, conn.LocalAddr(), conn.addr, b)
+	}
+}
+
+// ipPacket returns an IP packet holding a UDP packet with the payload passed sent from src to dst. An IPv4
+// packet is returned if both addresses are IPv4 addresses, and an IPv6 packet otherwise.
+func ipPacket(src, dst *net.UDPAddr, payload []byte) []byte {
+	udpLength := 8 + len(payload)
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); (src4 != nil || src.IP == nil) && (dst4 != nil || dst.IP == nil) {
+		b := make([]byte, 20+udpLength)
+		b[0] = 0x45
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		b[8] = 64
+		b[9] = 17
+		copy(b[12:16], src4)
+		copy(b[16:20], dst4)
+		binary.BigEndian.PutUint16(b[10:], ^uint16(checksum(0, b[:20])))
+		// The UDP checksum is optional over IPv4, so it is left as 0.
+		writeUDPHeader(b[20:], src, dst, udpLength)
+		copy(b[28:], payload)
+		return b
+	}
+	b := make([]byte, 40+udpLength)
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:], uint16(udpLength))
+	b[6] = 17
+	b[7] = 64
+	copy(b[8:24], src.IP.To16())
+	copy(b[24:40], dst.IP.To16())
+	writeUDPHeader(b[40:], src, dst, udpLength)
+	copy(b[48:], payload)
+
+	// The UDP checksum is mandatory over IPv6. It is calculated over a pseudo header holding the addresses,
+	// the UDP length and the protocol, and the UDP packet itself.
+	pseudo := make([]byte, 8)
+	binary.BigEndian.PutUint32(pseudo, uint32(udpLength))
+	pseudo[7] = 17
+	sum := ^uint16(checksum(checksum(checksum(0, b[8:40]), pseudo), b[40:]))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(b[46:], sum)
+	return b
+}
+
+// writeUDPHeader writes a UDP header without checksum to b.
+func writeUDPHeader(b []byte, src, dst *net.UDPAddr, length int) {
+	binary.BigEndian.PutUint16(b, uint16(src.Port))
+	binary.BigEndian.PutUint16(b[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(b[4:], uint16(length))
+}
+
+// checksum adds the data passed to the ones' complement sum passed and returns the new sum, folded into 16
+// bits.
+func checksum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return sum
+}
+
+// CapturedPacket is a UDP datagram read from a pcapng file by a PcapReader.
+type CapturedPacket struct {
+	// Time is the time at which the datagram was captured.
+	Time time.Time
+	// Src and Dst are the addresses that the datagram was sent from and to.
+	Src, Dst *net.UDPAddr
+	// Data is the payload of the datagram.
+	Data []byte
+}
+
+// PcapReader reads the UDP datagrams from a pcapng file, such as one written by a PcapWriter, so that
+// captured sessions may be replayed. Besides the raw IP packets written by a PcapWriter, packets captured
+// with Ethernet headers, such as those captured by tcpdump, may be read. Packets that are not UDP datagrams
+// are skipped.
+type PcapReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	// linkTypes holds the link types of the interfaces described in the current section, indexed by their
+	// interface ID.
+	linkTypes []uint16
+}
+
+// NewPcapReader returns a PcapReader that reads from the reader passed. The pcapng section header is read
+// immediately. If it is invalid, an error is returned.
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	reader := &PcapReader{r: r}
+	blockType, _, err := reader.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if blockType != pcapngSectionHeader {
+		return nil, fmt.Errorf("error reading pcapng header: unexpected block type %#x", blockType)
+	}
+	return reader, nil
+}
+
+// ReadPacket reads the next UDP datagram from the pcapng file. io.EOF is returned once the end of the file is
+// reached.
+func (reader *PcapReader) ReadPacket() (CapturedPacket, error) {
+	for {
+		blockType, body, err := reader.readBlock()
+		if err != nil {
+			return CapturedPacket{}, err
+		}
+		switch blockType {
+		case pcapngSectionHeader:
+			reader.linkTypes = nil
+		case pcapngInterfaceDescription:
+			if len(body) < 2 {
+				return CapturedPacket{}, fmt.Errorf("error reading pcapng interface description: block too short")
+			}
+			reader.linkTypes = append(reader.linkTypes, reader.order.Uint16(body))
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return CapturedPacket{}, fmt.Errorf("error reading pcapng packet: block too short")
+			}
+			iface, capLen := reader.order.Uint32(body), reader.order.Uint32(body[12:])
+			if int(iface) >= len(reader.linkTypes) || uint32(len(body)-20) < capLen {
+				return CapturedPacket{}, fmt.Errorf("error reading pcapng packet: invalid interface or length")
+			}
+			ts := uint64(reader.order.Uint32(body[4:]))<<32 | uint64(reader.order.Uint32(body[8:]))
+			packet, ok := udpPacket(reader.linkTypes[iface], body[20:20+capLen])
+			if !ok {
+				continue
+			}
+			packet.Time = time.Unix(0, int64(ts)*int64(time.Microsecond))
+			return packet, nil
+		}
+	}
+}
+
+// readBlock reads the next pcapng block and returns its type and body. The byte order of the section is
+// taken from section header blocks.
+func (reader *PcapReader) readBlock() (blockType uint32, body []byte, err error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("error reading pcapng block: %v", err)
+	}
+	if binary.LittleEndian.Uint32(header) == pcapngSectionHeader {
+		// The byte order of a section is found in the byte-order magic of its header, which follows the block
+		// length.
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(reader.r, magic); err != nil {
+			return 0, nil, fmt.Errorf("error reading pcapng byte-order magic: %v", err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == 0x1a2b3c4d:
+			reader.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == 0x1a2b3c4d:
+			reader.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("error reading pcapng section header: invalid byte-order magic %x", magic)
+		}
+		header = append(header, magic...)
+	}
+	if reader.order == nil {
+		return 0, nil, fmt.Errorf("error reading pcapng block: file does not start with a section header")
+	}
+	length := reader.order.Uint32(header[4:])
+	if length < 12 || length%4 != 0 || length > 1<<24 {
+		return 0, nil, fmt.Errorf("error reading pcapng block: invalid block length %v", length)
+	}
+	block := make([]byte, length)
+	copy(block, header)
+	if _, err := io.ReadFull(reader.r, block[len(header):]); err != nil {
+		return 0, nil, fmt.Errorf("error reading pcapng block: %v", err)
+	}
+	return reader.order.Uint32(block), block[8 : length-4], nil
+}
+
+// udpPacket parses the packet passed with the link type passed and returns the UDP datagram it holds. If it
+// is not a UDP datagram, false is returned.
+func udpPacket(linkType uint16, b []byte) (CapturedPacket, bool) {
+	switch linkType {
+	case pcapngLinkTypeEthernet:
+		if len(b) < 14 {
+			return CapturedPacket{}, false
+		}
+		if etherType := binary.BigEndian.Uint16(b[12:]); etherType != 0x0800 && etherType != 0x86dd {
+			return CapturedPacket{}, false
+		}
+		b = b[14:]
+	case pcapngLinkTypeRaw:
+	default:
+		return CapturedPacket{}, false
+	}
+	if len(b) == 0 {
+		return CapturedPacket{}, false
+	}
+	var src, dst net.IP
+	switch b[0] >> 4 {
+	case 4:
+		headerLength := int(b[0]&0x0f) * 4
+		if headerLength < 20 || len(b) < headerLength+8 || b[9] != 17 {
+			return CapturedPacket{}, false
+		}
+		src, dst = net.IP(append([]byte(nil), b[12:16]...)), net.IP(append([]byte(nil), b[16:20]...))
+		b = b[headerLength:]
+	case 6:
+		// Extension headers are not supported: Only UDP datagrams directly following the IPv6 header are read.
+		if len(b) < 48 || b[6] != 17 {
+			return CapturedPacket{}, false
+		}
+		src, dst = net.IP(append([]byte(nil), b[8:24]...)), net.IP(append([]byte(nil), b[24:40]...))
+		b = b[40:]
+	default:
+		return CapturedPacket{}, false
+	}
+	length := int(binary.BigEndian.Uint16(b[4:]))
+	if length < 8 || length > len(b) {
+		return CapturedPacket{}, false
+	}
+	return CapturedPacket{
+		Src:  &net.UDPAddr{IP: src, Port: int(binary.BigEndian.Uint16(b))},
+		Dst:  &net.UDPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(b[2:]))},
+		Data: append([]byte(nil), b[8:length]...),
+	}, true
+}
@@ -0,0 +1,84 @@
+package raknet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnPcapWriter(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	var clients, accepted [2]*Conn
+	for i := range clients {
+		if clients[i], err = Dial(l.Addr().String()); err != nil {
+			t.Fatalf("error dialing: %v", err)
+		}
+		defer clients[i].Close()
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatalf("error accepting: %v", err)
+		}
+		accepted[i] = c.(*Conn)
+	}
+
+	// Only the traffic of the first connection is captured, even though both connections share the socket
+	// of the listener.
+	b := bytes.NewBuffer(nil)
+	if err := accepted[0].SetPcapWriter(b); err != nil {
+		t.Fatalf("error setting pcap writer: %v", err)
+	}
+	writer := accepted[0].pcap.Load().(*PcapWriter)
+	for i, client := range clients {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		_ = accepted[i].SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := accepted[i].Read(make([]byte, 1500)); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if _, err := accepted[i].Write([]byte("world")); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+	if err := accepted[0].SetPcapWriter(nil); err != nil {
+		t.Fatalf("error stopping capture: %v", err)
+	}
+
+	writer.mu.Lock()
+	data := append([]byte(nil), b.Bytes()...)
+	writer.mu.Unlock()
+	reader, err := NewPcapReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error reading pcap: %v", err)
+	}
+	var in, out int
+	for {
+		packet, err := reader.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("error reading packet: %v", err)
+		}
+		client := clients[0].LocalAddr().(*net.UDPAddr)
+		switch {
+		case packet.Src.String() == client.String():
+			in++
+		case packet.Dst.String() == client.String():
+			out++
+		default:
+			t.Fatalf("captured packet of another connection: %v -> %v", packet.Src, packet.Dst)
+		}
+	}
+	if in == 0 || out == 0 {
+		t.Fatalf("expected datagrams in both directions to be captured, got %v inbound and %v outbound", in, out)
+	}
+}
@@ -0,0 +1,174 @@
+package raknet
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// pipeQueueSize is the amount of datagrams that may be in transit in one direction of a Pipe. Datagrams
+// written while the queue is full are dropped, like they would be by a full socket buffer.
+const pipeQueueSize = 1024
+
+// Pipe returns two connected *Conns that are backed by an in-memory transport rather than by UDP sockets, so
+// that protocols built on top of this package may be tested without opening ports. Datagrams written to one
+// end are delivered to the other end as they would be over the network, with acknowledgements, resends,
+// splitting and ordering, but the connection sequence is skipped.
+// Closing either end closes both of them, so that a Read on the other end returns an error, like with
+// net.Pipe.
+// Pipe is equivalent to PipeConfig{}.Pipe().
+func Pipe() (*Conn, *Conn) {
+	return PipeConfig{}.Pipe()
+}
+
+// PipeConfig may be used to create a Pipe of which the ends are configured specifically.
+type PipeConfig struct {
+	// ClockA and ClockB are the Clocks used by the first and second end of the Pipe respectively. Passing
+	// different Clocks, for example using SkewClock, makes the ends disagree on the time, like a server and
+	// a client with a skewed clock would.
+	// ClockA and ClockB are nil by default, meaning SystemClock is used.
+	ClockA, ClockB Clock
+	// Conditions are the conditions of the network simulated between both ends, applied in both directions,
+	// such as latency, loss and limited bandwidth. If the Clock of the Conditions is nil, each direction uses
+	// the Clock of the end that writes.
+	// Conditions is nil by default, meaning datagrams are delivered immediately.
+	Conditions *NetworkConditions
+}
+
+// Pipe returns two connected *Conns backed by an in-memory transport, like the Pipe function, configured
+// according to the PipeConfig.
+func (config PipeConfig) Pipe() (*Conn, *Conn) {
+	a, b := newPipeConn("pipe-a"), newPipeConn("pipe-b")
+	a.peer, b.peer = b, a
+
+	idA, _ := randInt63(nil)
+	idB, _ := randInt63(nil)
+	connA := newConn(config.simulate(a, config.ClockA), b.local, defaultMaxDatagramSize, idB, connConfig{clock: config.ClockA})
+	connB := newConn(config.simulate(b, config.ClockB), a.local, defaultMaxDatagramSize, idA, connConfig{clock: config.ClockB})
+	for _, c := range [...]struct {
+		conn, other *Conn
+		pc          *pipeConn
+	}{{conn: connA, other: connB, pc: a}, {conn: connB, other: connA, pc: b}} {
+		c.conn.finishSequence()
+		go pipeListen(c.conn, c.pc)
+		go func(conn, other *Conn) {
+			<-conn.closeCtx.Done()
+			_ = a.Close()
+			_ = b.Close()
+			_ = other.Close()
+		}(c.conn, c.other)
+	}
+	return connA, connB
+}
+
+// simulate wraps the pipeConn passed to apply the Conditions of the PipeConfig, if any, using the Clock
+// passed if the Conditions do not have a Clock.
+func (config PipeConfig) simulate(pc *pipeConn, clock Clock) net.PacketConn {
+	if config.Conditions == nil {
+		return pc
+	}
+	conditions := *config.Conditions
+	if conditions.Clock == nil {
+		conditions.Clock = clock
+	}
+	return SimulateNetwork(pc, conditions)
+}
+
+// pipeListen passes the datagrams received by the pipeConn passed on to the Conn passed until the pipeConn
+// is closed.
+func pipeListen(conn *Conn, pc *pipeConn) {
+	for {
+		select {
+		case b := <-pc.in:
+			// Errors are ignored, as the other end of a Pipe only ever sends valid datagrams.
+			_ = conn.receive(bytes.NewBuffer(b))
+		case <-pc.closed:
+			return
+		}
+	}
+}
+
+// pipeAddr is the net.Addr of one end of a Pipe.
+type pipeAddr string
+
+// Network ...
+func (addr pipeAddr) Network() string {
+	return "pipe"
+}
+
+// String ...
+func (addr pipeAddr) String() string {
+	return string(addr)
+}
+
+// pipeConn is one end of the in-memory transport of a Pipe. It implements net.PacketConn.
+type pipeConn struct {
+	local pipeAddr
+	peer  *pipeConn
+	// in holds the datagrams written by the peer that are yet to be read.
+	in chan []byte
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+// newPipeConn returns a pipeConn with the local address passed. Its peer must be set before it is used.
+func newPipeConn(addr pipeAddr) *pipeConn {
+	return &pipeConn{local: addr, in: make(chan []byte, pipeQueueSize), closed: make(chan struct{})}
+}
+
+// ReadFrom reads the next datagram written by the peer of the pipeConn.
+func (conn *pipeConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	select {
+	case data := <-conn.in:
+		return copy(b, data), conn.peer.local, nil
+	case <-conn.closed:
+		return 0, nil, opError("read", conn.local, nil, ErrClosed)
+	}
+}
+
+// WriteTo writes a copy of the datagram passed to the peer of the pipeConn. The datagram is dropped if the
+// peer has too many datagrams waiting to be read.
+func (conn *pipeConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	select {
+	case <-conn.closed:
+		return 0, opError("write", conn.local, addr, ErrClosed)
+	default:
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	select {
+	case conn.peer.in <- data:
+	default:
+	}
+	return len(b), nil
+}
+
+// Close closes the pipeConn. Reads that are blocked return an error.
+func (conn *pipeConn) Close() error {
+	conn.once.Do(func() {
+		close(conn.closed)
+	})
+	return nil
+}
+
+// LocalAddr ...
+func (conn *pipeConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+// SetDeadline is a no-op: Deadlines are handled by the Conn using the pipeConn.
+func (conn *pipeConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is a no-op: Deadlines are handled by the Conn using the pipeConn.
+func (conn *pipeConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is a no-op: Deadlines are handled by the Conn using the pipeConn.
+func (conn *pipeConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
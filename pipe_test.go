@@ -0,0 +1,56 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+
+	large := bytes.Repeat([]byte{0xfe, 1, 2, 3}, 2000)
+	for _, payload := range [][]byte{{0xfe, 1}, large} {
+		if _, err := a.Write(payload); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		buf := make([]byte, 10000)
+		_ = b.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(buf[:n], payload) {
+			t.Fatalf("read %v bytes, expected the %v bytes written", n, len(payload))
+		}
+	}
+
+	_ = b.Close()
+	_ = a.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := a.Read(make([]byte, 10)); !ErrConnectionClosed(err) {
+		t.Fatalf("expected closed error after closing other end, got %v", err)
+	}
+}
+
+func TestPipeSkewedClock(t *testing.T) {
+	a, b := PipeConfig{ClockB: SkewClock(SystemClock, -time.Hour, 0.5)}.Pipe()
+	defer a.Close()
+
+	for _, c := range [...]struct{ from, to *Conn }{{a, b}, {b, a}} {
+		if _, err := c.from.Write([]byte{0xfe, 1}); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		_ = c.to.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if _, err := c.to.Read(make([]byte, 10)); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		c.from.Ping()
+	}
+	time.Sleep(time.Millisecond * 100)
+	for _, conn := range []*Conn{a, b} {
+		if rtt := conn.Stats().RTT; rtt.Count() == 0 || rtt.Max() > time.Second {
+			t.Fatalf("expected a pong measured with the clock of the same end, got %v pongs with max %v", rtt.Count(), rtt.Max())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package raknet
+
+import "time"
+
+const (
+	// pmtuSearchInterval is the interval at which path MTU probes are sent while the path MTU of a connection
+	// is being searched.
+	pmtuSearchInterval = time.Second
+	// pmtuValidateInterval is the interval at which the path MTU of a connection is validated and searched
+	// again once a search has completed.
+	pmtuValidateInterval = time.Second * 30
+	// pmtuMinStep is the smallest difference between the path MTU and the highest MTU that could still work
+	// for which a new probe is sent. Once the difference is smaller, the search is complete.
+	pmtuMinStep = 16
+)
+
+// pmtuState holds the state of the path MTU discovery of a Conn. Once the connection is established, it
+// periodically sends probes of increasing size with the don't fragment flag set. The path MTU is raised when
+// a probe is acknowledged, and lowered again when a probe of the current path MTU is lost.
+// pmtuState is guarded by the write lock of the Conn that it belongs to.
+type pmtuState struct {
+	// enabled specifies if path MTU discovery is enabled for the connection.
+	enabled bool
+	// max is the highest MTU size that may be probed, which is the maximum datagram size of the connection.
+	max int
+	// ceiling is the MTU size above which probes are known to fail.
+	ceiling int
+
+	// probing specifies if a probe is currently awaiting acknowledgement. probeSeq is the sequence number of
+	// the datagram of the probe, probeSize the MTU size probed and probeTime the time it was sent.
+	probing   bool
+	probeSeq  uint24
+	probeSize int
+	probeTime time.Time
+	// nextProbe is the time at which the next probe should be sent.
+	nextProbe time.Time
+}
+
+// tickPMTU sends a new path MTU probe if one is due, or marks the current probe as failed if it was not
+// acknowledged in time.
+// tickPMTU must be called while holding the write lock.
+func (conn *Conn) tickPMTU(now time.Time) error {
+	state := &conn.pmtu
+	if !state.enabled {
+		return nil
+	}
+	if state.probing {
+		timeout := time.Duration(conn.Latency()*8) * time.Millisecond
+		if timeout < time.Second {
+			timeout = time.Second
+		}
+		if now.Sub(state.probeTime) < timeout {
+			return nil
+		}
+		conn.pmtuProbeFailed(now)
+	}
+	if now.Before(state.nextProbe) {
+		return nil
+	}
+	pathMTU := int(conn.pathMTU)
+	if state.ceiling-pathMTU < pmtuMinStep {
+		// The search has completed. We start a new one, in case the path now supports a bigger MTU, and
+		// validate that the current path MTU still works if it was raised by an earlier search.
+		state.ceiling = state.max
+		if pathMTU > int(conn.mtuSize) {
+			return conn.sendPMTUProbe(pathMTU, now)
+		}
+		if state.ceiling-pathMTU < pmtuMinStep {
+			state.nextProbe = now.Add(pmtuValidateInterval)
+			return nil
+		}
+	}
+	return conn.sendPMTUProbe((pathMTU+state.ceiling+1)/2, now)
+}
+
+// sendPMTUProbe sends a path MTU probe of the size passed. The probe is a detect lost connections packet
+// padded with zeroes, which the other end ignores, sent as an unreliable packet in its own datagram so that
+// it is never resent if it is lost.
+// sendPMTUProbe must be called while holding the write lock.
+func (conn *Conn) sendPMTUProbe(size int, now time.Time) error {
+	state := &conn.pmtu
+	// The content of the probe fills the datagram up to the size probed, leaving room for the header of an
+	// unreliable packet: Its flags and the content length.
+	content := make([]byte, conn.maxDatagramSize(size)-1-2)
+	content[0] = idDetectLostConnections
+
+	sequenceNumber := conn.sendSequenceNumber
+	conn.sendSequenceNumber++
+	state.probing, state.probeSeq, state.probeSize, state.probeTime = true, sequenceNumber, size, now
+
+	d := &datagram{packets: []*packet{{reliability: reliabilityUnreliable, content: content}}}
+	if err := conn.writeDatagram(sequenceNumber, d); err != nil {
+		// The datagram could not be sent, most likely because it exceeds an MTU size known locally. We treat
+		// it as a lost probe.
+		conn.pmtuProbeFailed(now)
+	}
+	return nil
+}
+
+// pmtuProbeAcknowledged checks if the sequence number passed is that of the current path MTU probe. If so,
+// the path MTU is raised to the size of the probe and true is returned.
+// pmtuProbeAcknowledged must be called while holding the write lock.
+func (conn *Conn) pmtuProbeAcknowledged(sequenceNumber uint24) bool {
+	state := &conn.pmtu
+	if !state.probing || state.probeSeq != sequenceNumber {
+		return false
+	}
+	state.probing = false
+	if state.probeSize > int(conn.pathMTU) {
+		conn.pathMTU = int16(state.probeSize)
+	}
+	state.nextProbe = conn.clock.Now().Add(pmtuSearchInterval)
+	if state.ceiling-int(conn.pathMTU) < pmtuMinStep {
+		state.nextProbe = conn.clock.Now().Add(pmtuValidateInterval)
+	}
+	return true
+}
+
+// pmtuProbeLost checks if the sequence number passed is that of the current path MTU probe. If so, the probe
+// is marked as failed and true is returned.
+// pmtuProbeLost must be called while holding the write lock.
+func (conn *Conn) pmtuProbeLost(sequenceNumber uint24) bool {
+	state := &conn.pmtu
+	if !state.probing || state.probeSeq != sequenceNumber {
+		return false
+	}
+	conn.pmtuProbeFailed(conn.clock.Now())
+	return true
+}
+
+// pmtuProbeFailed marks the current path MTU probe as failed. If the probe was sent to validate the current
+// path MTU, the path MTU is lowered back to the MTU size negotiated when the connection was established.
+// pmtuProbeFailed must be called while holding the write lock.
+func (conn *Conn) pmtuProbeFailed(now time.Time) {
+	state := &conn.pmtu
+	state.probing = false
+	state.ceiling = state.probeSize - 1
+	if state.probeSize <= int(conn.pathMTU) {
+		conn.pathMTU = conn.mtuSize
+	}
+	state.nextProbe = now.Add(pmtuSearchInterval)
+}
@@ -0,0 +1,33 @@
+package raknet
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the don't fragment flag on all datagrams sent over the connection passed, so that
+// datagrams exceeding the path MTU are dropped rather than fragmented. The path MTU cached by the kernel is
+// ignored, so that probes bigger than it may still be sent.
+func setDontFragment(conn net.PacketConn) error {
+	c, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		// The connection may be either an IPv4 or an IPv6 connection, of which only one of the options below
+		// is available. We only return an error if neither of the options could be set.
+		err4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		err6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+		if err4 != nil && err6 != nil {
+			sockErr = err4
+		}
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package raknet
+
+import (
+	"net"
+)
+
+// setDontFragment is a no-op on platforms other than Linux. Path MTU probes are sent without the don't
+// fragment flag set, so the path MTU found is limited only by the maximum datagram size of the other end.
+func setDontFragment(net.PacketConn) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package raknet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// pathLimitConn is a net.PacketConn that drops datagrams that would exceed the MTU of the path it simulates.
+type pathLimitConn struct {
+	net.PacketConn
+	mtu int
+}
+
+// WriteTo drops the datagram passed if it, including the UDP/IP header, exceeds the MTU of the path.
+func (conn *pathLimitConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b)+28 > conn.mtu {
+		return len(b), nil
+	}
+	return conn.PacketConn.WriteTo(b, addr)
+}
+
+func TestPathMTUDiscovery(t *testing.T) {
+	p := NewSyncPipe(time.Now())
+	defer p.Close()
+
+	a := p.A()
+	path := &pathLimitConn{PacketConn: a.conn, mtu: 1400}
+	a.writeLock.Lock()
+	a.conn, a.mtuSize, a.pathMTU = path, 576, 576
+	a.pmtu = pmtuState{enabled: true, max: 1500, ceiling: 1500, nextProbe: p.Now()}
+	a.writeLock.Unlock()
+	pathMTU := func() int {
+		a.writeLock.Lock()
+		defer a.writeLock.Unlock()
+		return int(a.pathMTU)
+	}
+
+	// The first probe is acknowledged, which raises the path MTU to its size.
+	p.Advance(tickInterval * 2)
+	if mtu := pathMTU(); mtu != (576+1500+1)/2 {
+		t.Fatalf("expected path MTU to be raised to %v by an acknowledged probe, got %v", (576+1500+1)/2, mtu)
+	}
+	// Probes bigger than the path are lost, so the search settles just below its MTU.
+	p.Advance(time.Second * 20)
+	if mtu := pathMTU(); mtu > 1400 || mtu <= 1400-pmtuMinStep {
+		t.Fatalf("expected path MTU to settle below 1400, got %v", mtu)
+	}
+
+	// Once the path MTU drops, the probe validating the path MTU is lost and the path MTU is lowered back to
+	// the MTU size negotiated, after which the search starts over.
+	path.mtu = 1000
+	p.Advance(pmtuValidateInterval + time.Second*20)
+	if mtu := pathMTU(); mtu > 1000 || mtu <= 1000-pmtuMinStep {
+		t.Fatalf("expected path MTU to settle below 1000, got %v", mtu)
+	}
+}
+
+func TestPathMTUProbeOversized(t *testing.T) {
+	rejections := make(chan Rejection, 16)
+	l, err := ListenConfig{MaxDatagramSize: 1200, OnReject: func(r Rejection) { rejections <- r }}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	if _, err := l.Accept(); err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+
+	// The probe fills the read buffer of the listener entirely, so it is dropped rather than acknowledged.
+	now := time.Now()
+	client.writeLock.Lock()
+	mtuSize := client.pathMTU
+	client.pmtu = pmtuState{enabled: true, max: 1500, ceiling: 1500}
+	_ = client.sendPMTUProbe(1500, now)
+	client.writeLock.Unlock()
+	select {
+	case r := <-rejections:
+		if r.Reason != RejectOversizedDatagram {
+			t.Fatalf("expected oversized datagram rejection, got %v", r.Reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected probe to be rejected")
+	}
+
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	client.pmtu.nextProbe = now.Add(time.Hour)
+	_ = client.tickPMTU(now.Add(time.Second * 2))
+	if client.pmtu.probing || client.pmtu.ceiling != 1499 || client.pathMTU != mtuSize {
+		t.Fatalf("expected probe to fail without changing the path MTU, got ceiling %v and path MTU %v", client.pmtu.ceiling, client.pathMTU)
+	}
+}
+
+// sizeConn is a net.PacketConn that records the size of the last datagram written.
+type sizeConn struct {
+	net.PacketConn
+	last int
+}
+
+// WriteTo records the size of the datagram passed and writes it.
+func (conn *sizeConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	conn.last = len(b)
+	return conn.PacketConn.WriteTo(b, addr)
+}
+
+func TestPathMTUProbeSize(t *testing.T) {
+	p := NewSyncPipe(time.Now())
+	defer p.Close()
+
+	a, b := p.A(), p.B()
+	sizes := &sizeConn{PacketConn: a.conn}
+	a.writeLock.Lock()
+	a.conn = sizes
+	a.pmtu = pmtuState{enabled: true, max: 1500, ceiling: 1500}
+	_ = a.sendPMTUProbe(1200, p.Now())
+	a.writeLock.Unlock()
+
+	// The datagram of the probe is exactly as big as the MTU size probed, without the UDP/IP header.
+	if expected := 1200 - a.framing.mtuHeaderSize(); sizes.last != expected {
+		t.Fatalf("expected probe datagram of %v bytes, got %v", expected, sizes.last)
+	}
+	// The probe is padding that the other end ignores: It is neither answered nor read.
+	p.Step()
+	if n := b.Stats().ConnectedPings; n != 0 {
+		t.Fatalf("expected probe not to be handled as a connected ping, got %v pings", n)
+	}
+	if n := len(b.packetChan); n != 0 {
+		t.Fatalf("expected probe not to be delivered, got %v packets", n)
+	}
+}
@@ -0,0 +1,62 @@
+package raknet
+
+// TryRead reads a packet from the read queue of the connection into b without blocking, so that connections
+// may be read from a single-threaded loop, such as a game loop, rather than from a goroutine per connection.
+// ok is false if no packet was queued. If the connection is closed and no packets remain queued, an error is
+// returned.
+// Use Readable to find out when to call TryRead again.
+func (conn *Conn) TryRead(b []byte) (n int, ok bool, err error) {
+	select {
+	case packet := <-conn.packetChan:
+		if len(b) < packet.Len() {
+			err = opError("read", conn.LocalAddr(), conn.addr, errMessageTooLarge)
+		}
+		n = copy(b, packet.Bytes())
+		conn.meter.add(UserMessageBytesReceivedProcessed, packet.Len())
+		conn.addMemory(-packet.Len())
+		// The packet was copied into b, so its content may be re-used.
+		putBuffer(packet.Bytes())
+		return n, true, err
+	default:
+	}
+	select {
+	case <-conn.closeCtx.Done():
+		return 0, false, opError("read", conn.LocalAddr(), conn.addr, conn.closeErr())
+	default:
+		return 0, false, nil
+	}
+}
+
+// Poll returns the next packet in the read queue of the connection without blocking. ok is false if no
+// packet was queued or if the connection is closed. Unlike TryRead, Poll allocates a new slice for every
+// packet returned.
+func (conn *Conn) Poll() (packet []byte, ok bool) {
+	select {
+	case p := <-conn.packetChan:
+		packet = append([]byte(nil), p.Bytes()...)
+		conn.meter.add(UserMessageBytesReceivedProcessed, len(packet))
+		conn.addMemory(-len(packet))
+		putBuffer(p.Bytes())
+		return packet, true
+	default:
+		return nil, false
+	}
+}
+
+// Readable returns a channel that receives a value when a packet is added to the read queue of the
+// connection and when the connection is closed. Values are coalesced: A single value may stand for multiple
+// packets, so TryRead or Poll should be called until no packet is left after receiving from the channel.
+// Multiple connections may be waited on in a single select statement, or the channel may be checked once
+// every tick of a loop.
+func (conn *Conn) Readable() <-chan struct{} {
+	return conn.readable
+}
+
+// notify sends a value on the channel passed without blocking, so that a value already pending is not
+// duplicated.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,53 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTryRead(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+
+	buf := make([]byte, 1500)
+	if _, ok, err := b.TryRead(buf); ok || err != nil {
+		t.Fatalf("expected no packet without error before writing, got ok=%v, err=%v", ok, err)
+	}
+	for _, payload := range [][]byte{{0xfe, 1}, {0xfe, 2}} {
+		if _, err := a.Write(payload); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	var read [][]byte
+	for len(read) < 2 {
+		select {
+		case <-b.Readable():
+		case <-time.After(time.Second * 5):
+			t.Fatalf("connection did not become readable")
+		}
+		for {
+			n, ok, err := b.TryRead(buf)
+			if err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if !ok {
+				break
+			}
+			read = append(read, append([]byte(nil), buf[:n]...))
+		}
+	}
+	if !bytes.Equal(read[0], []byte{0xfe, 1}) || !bytes.Equal(read[1], []byte{0xfe, 2}) {
+		t.Fatalf("expected packets in order, got %x", read)
+	}
+
+	_ = b.Close()
+	select {
+	case <-b.Readable():
+	case <-time.After(time.Second * 5):
+		t.Fatalf("connection did not become readable after closing")
+	}
+	if _, ok, err := b.TryRead(buf); ok || !ErrConnectionClosed(err) {
+		t.Fatalf("expected closed error after closing, got ok=%v, err=%v", ok, err)
+	}
+}
@@ -0,0 +1,91 @@
+package raknet
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// PongFormat is the format of the pong data of a server, as detected by ParsePong.
+type PongFormat int
+
+const (
+	// PongRaw is the format of pong data that is not of a known game. Only Pong.Raw is set for it.
+	PongRaw PongFormat = iota
+	// PongMCPE is the format of the pong data of Minecraft: Bedrock Edition servers, starting with "MCPE;".
+	PongMCPE
+	// PongMCEE is the format of the pong data of Minecraft: Education Edition servers, starting with
+	// "MCEE;". It has the same fields as PongMCPE.
+	PongMCEE
+)
+
+// String returns the name of the format, such as "MCPE".
+func (format PongFormat) String() string {
+	switch format {
+	case PongMCPE:
+		return "MCPE"
+	case PongMCEE:
+		return "MCEE"
+	}
+	return "raw"
+}
+
+// Pong is pong data decoded by ParsePong. Fields that are not present in the pong data, or that could not be
+// parsed, are left as their zero values.
+type Pong struct {
+	// Format is the format detected.
+	Format PongFormat
+	// MOTD and SubMOTD are the two lines of the message of the day of the server.
+	MOTD, SubMOTD string
+	// ProtocolVersion is the version of the game protocol of the server, and Version the version of the game
+	// that it runs, such as "1.20.0".
+	ProtocolVersion int
+	Version         string
+	// Players is the amount of players online and MaxPlayers the maximum amount of players.
+	Players, MaxPlayers int
+	// ServerGUID is the GUID that the server holds in its pong data. It is usually the GUID of the RakNet
+	// server, but proxies may put another one in there.
+	ServerGUID int64
+	// GameMode is the name of the default game mode of the server, such as "Survival", and GameModeID its
+	// numeric ID.
+	GameMode   string
+	GameModeID int
+	// PortV4 and PortV6 are the ports that the server listens on for IPv4 and IPv6 connections.
+	PortV4, PortV6 int
+	// Raw is the pong data that was parsed.
+	Raw []byte
+}
+
+// ParsePong parses pong data, such as that returned by Dialer.Ping, detecting its format. Pong data of a
+// format that is not known is returned as a Pong with the PongRaw format, so that ParsePong never fails. Like
+// clients of the games do, ParsePong is lenient: Missing or malformed fields are left empty.
+func ParsePong(data []byte) Pong {
+	pong := Pong{Raw: data}
+	switch {
+	case bytes.HasPrefix(data, []byte("MCPE;")):
+		pong.Format = PongMCPE
+	case bytes.HasPrefix(data, []byte("MCEE;")):
+		pong.Format = PongMCEE
+	default:
+		return pong
+	}
+	fields := bytes.Split(data, []byte{';'})
+	field := func(i int) string {
+		if i >= len(fields) {
+			return ""
+		}
+		return string(fields[i])
+	}
+	number := func(i int) int {
+		n, _ := strconv.Atoi(field(i))
+		return n
+	}
+	pong.MOTD = field(1)
+	pong.ProtocolVersion = number(2)
+	pong.Version = field(3)
+	pong.Players, pong.MaxPlayers = number(4), number(5)
+	pong.ServerGUID, _ = strconv.ParseInt(field(6), 10, 64)
+	pong.SubMOTD = field(7)
+	pong.GameMode, pong.GameModeID = field(8), number(9)
+	pong.PortV4, pong.PortV6 = number(10), number(11)
+	return pong
+}
@@ -0,0 +1,21 @@
+package raknet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePong(t *testing.T) {
+	pong := ParsePong([]byte("MCEE;Classroom;390;1.14.60;3;30;12345;Lesson;Creative;1;19132;19133;"))
+	expected := Pong{Format: PongMCEE, MOTD: "Classroom", SubMOTD: "Lesson", ProtocolVersion: 390, Version: "1.14.60", Players: 3, MaxPlayers: 30, ServerGUID: 12345, GameMode: "Creative", GameModeID: 1, PortV4: 19132, PortV6: 19133}
+	expected.Raw = pong.Raw
+	if !reflect.DeepEqual(pong, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, pong)
+	}
+	if pong := ParsePong([]byte("MCPE;Short;abc")); pong.Format != PongMCPE || pong.MOTD != "Short" || pong.ProtocolVersion != 0 {
+		t.Fatalf("expected short pong data to be parsed leniently, got %+v", pong)
+	}
+	if pong := ParsePong([]byte{0, 1, 2}); pong.Format != PongRaw || len(pong.Raw) != 3 {
+		t.Fatalf("expected unknown pong data to be raw, got %+v", pong)
+	}
+}
@@ -0,0 +1,147 @@
+// Package prometheus implements a Prometheus collector for the statistics of a raknet.Listener, so that
+// servers may have their RakNet health scraped by Prometheus without writing custom glue. A Collector is
+// registered with a prometheus.Registerer like any other collector, next to the other metrics of the
+// application:
+//
+//	prometheus.MustRegister(raknetprometheus.NewCollector(listener))
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sandertv/go-raknet"
+)
+
+// Collector is a prometheus.Collector that collects the statistics of a raknet.Listener and its
+// connections. The statistics are read using Listener.Stats and Listener.ConnStats each time the Collector
+// is collected.
+type Collector struct {
+	listener      *raknet.Listener
+	perConnection bool
+
+	connections, datagramsSent, datagramsReceived, datagramsResent, spuriousResends     *prometheus.Desc
+	duplicateDatagrams, slowConsumerDrops, handshakeFailures, handshakeFailuresByReason *prometheus.Desc
+	rateLimitDrops, blackholeDrops, packetsReceived, rtt                                *prometheus.Desc
+	connLatency, connDatagramsSent, connDatagramsReceived, connDatagramsResent          *prometheus.Desc
+}
+
+// CollectorOpts holds the options of a Collector.
+type CollectorOpts struct {
+	// Namespace is the prefix of the names of all metrics collected.
+	// Namespace is "raknet" by default.
+	Namespace string
+	// PerConnection specifies if the statistics of each individual connection should be collected, labelled
+	// with the remote address of the connection. As this produces a time series for every connection, it
+	// should only be enabled on servers with a limited amount of connections.
+	PerConnection bool
+}
+
+// NewCollector returns a new Collector that collects the statistics of the listener passed using the
+// default CollectorOpts.
+func NewCollector(listener *raknet.Listener) *Collector {
+	return CollectorOpts{}.NewCollector(listener)
+}
+
+// NewCollector returns a new Collector that collects the statistics of the listener passed using the
+// CollectorOpts.
+func (opts CollectorOpts) NewCollector(listener *raknet.Listener) *Collector {
+	if opts.Namespace == "" {
+		opts.Namespace = "raknet"
+	}
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, "", name), help, labels, nil)
+	}
+	return &Collector{
+		listener:                  listener,
+		perConnection:             opts.PerConnection,
+		connections:               desc("connections", "Amount of connections currently open."),
+		datagramsSent:             desc("datagrams_sent_total", "Amount of datagrams sent, including resent datagrams."),
+		datagramsReceived:         desc("datagrams_received_total", "Amount of datagrams received."),
+		datagramsResent:           desc("datagrams_resent_total", "Amount of datagrams resent."),
+		spuriousResends:           desc("spurious_resends_total", "Amount of datagrams resent while the original datagram arrived."),
+		duplicateDatagrams:        desc("duplicate_datagrams_total", "Amount of datagrams received more than once."),
+		slowConsumerDrops:         desc("slow_consumer_drops_total", "Amount of packets dropped because they were not read in time."),
+		handshakeFailures:         desc("handshake_failures_total", "Amount of connection attempts that failed."),
+		handshakeFailuresByReason: desc("handshake_failures_by_reason_total", "Amount of connection attempts that failed per reason.", "reason"),
+		rateLimitDrops:            desc("rate_limit_drops_total", "Amount of datagrams dropped per rate limit.", "limit"),
+		blackholeDrops:            desc("blackhole_drops_total", "Amount of datagrams dropped because their address was blackholed."),
+		packetsReceived:           desc("packets_received_total", "Amount of packets received per type of packet.", "type"),
+		rtt:                       desc("rtt_seconds", "Round-trip times measured over all connections."),
+		connLatency:               desc("connection_latency_seconds", "Last measured latency of a connection.", "remote_addr"),
+		connDatagramsSent:         desc("connection_datagrams_sent_total", "Amount of datagrams sent by a connection.", "remote_addr"),
+		connDatagramsReceived:     desc("connection_datagrams_received_total", "Amount of datagrams received by a connection.", "remote_addr"),
+		connDatagramsResent:       desc("connection_datagrams_resent_total", "Amount of datagrams resent by a connection.", "remote_addr"),
+	}
+}
+
+// Describe sends the descriptors of all metrics collected by the Collector to the channel passed.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.connections, c.datagramsSent, c.datagramsReceived, c.datagramsResent, c.spuriousResends,
+		c.duplicateDatagrams, c.slowConsumerDrops, c.handshakeFailures, c.handshakeFailuresByReason,
+		c.rateLimitDrops, c.blackholeDrops, c.packetsReceived, c.rtt,
+	} {
+		ch <- d
+	}
+	if c.perConnection {
+		ch <- c.connLatency
+		ch <- c.connDatagramsSent
+		ch <- c.connDatagramsReceived
+		ch <- c.connDatagramsResent
+	}
+}
+
+// Collect sends the current statistics of the listener to the channel passed.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.listener.Stats()
+	gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(d *prometheus.Desc, v uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+
+	gauge(c.connections, float64(stats.Connections))
+	counter(c.datagramsSent, stats.DatagramsSent)
+	counter(c.datagramsReceived, stats.DatagramsReceived)
+	counter(c.datagramsResent, stats.DatagramsResent)
+	counter(c.spuriousResends, stats.SpuriousResends)
+	counter(c.duplicateDatagrams, stats.DuplicateDatagrams)
+	counter(c.slowConsumerDrops, stats.SlowConsumerDrops)
+	counter(c.handshakeFailures, stats.HandshakeFailures)
+	counter(c.handshakeFailuresByReason, stats.HandshakeFailureReasons.InvalidPacket, "invalid_packet")
+	counter(c.handshakeFailuresByReason, stats.HandshakeFailureReasons.IncompatibleProtocol, "incompatible_protocol")
+	counter(c.handshakeFailuresByReason, stats.HandshakeFailureReasons.Timeout, "timeout")
+	counter(c.handshakeFailuresByReason, stats.HandshakeFailureReasons.SecurityFailed, "security_failed")
+	counter(c.rateLimitDrops, stats.RateLimitDrops.Offline, "offline")
+	counter(c.rateLimitDrops, stats.RateLimitDrops.OfflinePerSource, "offline_per_source")
+	counter(c.rateLimitDrops, stats.RateLimitDrops.Connected, "connected")
+	counter(c.rateLimitDrops, stats.RateLimitDrops.ConnectedPerSource, "connected_per_source")
+	counter(c.rateLimitDrops, stats.RateLimitDrops.Acks, "acks")
+	counter(c.blackholeDrops, stats.BlackholeDrops)
+	counter(c.packetsReceived, stats.Packets.UnconnectedPings, "unconnected_ping")
+	counter(c.packetsReceived, stats.Packets.OpenConnectionRequests1, "open_connection_request_1")
+	counter(c.packetsReceived, stats.Packets.OpenConnectionRequests2, "open_connection_request_2")
+	counter(c.packetsReceived, stats.Packets.UnknownOffline, "unknown_offline")
+	counter(c.packetsReceived, stats.Packets.Datagrams, "datagram")
+	counter(c.packetsReceived, stats.Packets.ACKs, "ack")
+	counter(c.packetsReceived, stats.Packets.NACKs, "nack")
+	counter(c.packetsReceived, stats.Packets.ConnectedPings, "connected_ping")
+
+	quantiles := make(map[float64]float64, 4)
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		quantiles[q] = stats.RTT.Percentile(q * 100).Seconds()
+	}
+	count := stats.RTT.Count()
+	ch <- prometheus.MustNewConstSummary(c.rtt, uint64(count), stats.RTT.Mean().Seconds()*float64(count), quantiles)
+
+	if !c.perConnection {
+		return
+	}
+	for _, s := range c.listener.ConnStats() {
+		addr := s.RemoteAddr.String()
+		gauge(c.connLatency, s.Latency.Seconds(), addr)
+		counter(c.connDatagramsSent, s.DatagramsSent, addr)
+		counter(c.connDatagramsReceived, s.DatagramsReceived, addr)
+		counter(c.connDatagramsResent, s.DatagramsResent, addr)
+	}
+}
@@ -0,0 +1,61 @@
+package prometheus
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sandertv/go-raknet"
+)
+
+func TestCollector(t *testing.T) {
+	listener, err := raknet.ListenConfig{ErrorLog: log.New(ioutil.Discard, "", 0)}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// The Collector is registered next to other metrics, as an application would.
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(CollectorOpts{PerConnection: true}.NewCollector(listener), prometheus.NewGoCollector())
+
+	client, err := raknet.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := listener.Accept(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP raknet_connections Amount of connections currently open.
+# TYPE raknet_connections gauge
+raknet_connections 1
+# HELP raknet_handshake_failures_by_reason_total Amount of connection attempts that failed per reason.
+# TYPE raknet_handshake_failures_by_reason_total counter
+raknet_handshake_failures_by_reason_total{reason="incompatible_protocol"} 0
+raknet_handshake_failures_by_reason_total{reason="invalid_packet"} 0
+raknet_handshake_failures_by_reason_total{reason="security_failed"} 0
+raknet_handshake_failures_by_reason_total{reason="timeout"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "raknet_connections", "raknet_handshake_failures_by_reason_total"); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	for _, name := range []string{"raknet_rtt_seconds", "raknet_connection_latency_seconds", "raknet_packets_received_total", "go_goroutines"} {
+		if !names[name] {
+			t.Errorf("expected metric %v to be gathered", name)
+		}
+	}
+}
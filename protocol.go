@@ -0,0 +1,242 @@
+package raknet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// protocolFraming holds the parts of the framing of messages that differ between RakNet protocol versions.
+type protocolFraming struct {
+	// systemAddresses is the amount of system addresses written in connection request accepted and new
+	// incoming connection packets.
+	systemAddresses int
+	// pongLengthPrefix specifies if the pong data of unconnected pongs is prefixed with its length.
+	pongLengthPrefix bool
+	// mtuExcludesHeaders specifies if the MTU size is the size of the UDP payload, rather than that of the
+	// UDP payload plus the UDP and IP headers.
+	mtuExcludesHeaders bool
+	// headerSize is the size of the UDP and IP headers counted in MTU sizes if they are included, or 0 if
+	// the default of 28 bytes is used.
+	headerSize int
+	// mtuMultiple is the multiple that MTU sizes negotiated are rounded down to, or 0 if they are not.
+	mtuMultiple int
+}
+
+// mtuHeaderSize returns the size of the UDP and IP headers counted in MTU sizes, which is 0 if the MTU size
+// excludes them.
+func (f protocolFraming) mtuHeaderSize() int {
+	if f.mtuExcludesHeaders {
+		return 0
+	}
+	if f.headerSize > 0 {
+		return f.headerSize
+	}
+	return 28
+}
+
+// roundMTU rounds the MTU size passed down to the MTU multiple of the framing, if any.
+func (f protocolFraming) roundMTU(mtuSize int) int {
+	if f.mtuMultiple <= 0 {
+		return mtuSize
+	}
+	return mtuSize - mtuSize%f.mtuMultiple
+}
+
+// Profile selects the flavour of RakNet spoken by a Listener or Dialer. Minecraft deviates from the official
+// RakNet library in several ways, such as the length prefix of pong data, which the Profile decides on, so
+// that games other than Minecraft may use the package unmodified.
+type Profile int
+
+const (
+	// ProfileAuto selects the profile from the protocol version used: ProfileMinecraft for versions 8
+	// through 11, which are those used by Minecraft, and ProfileVanilla for other versions.
+	ProfileAuto Profile = iota
+	// ProfileMinecraft speaks RakNet the way Minecraft does: Pong data is prefixed with its length, 20 system
+	// addresses are written during the connection sequence and the protocol version is MinecraftProtocol
+	// unless set otherwise.
+	ProfileMinecraft
+	// ProfileVanilla speaks RakNet the way the official RakNet library does: Pong data is appended as is, 10
+	// system addresses are written during the connection sequence and the protocol version is
+	// OfficialProtocol unless set otherwise.
+	ProfileVanilla
+	// ProfileJRakNet speaks RakNet the way Java implementations such as JRakNet and the one of NukkitX do when
+	// serving Minecraft: Pong data is prefixed with its length and the protocol version is MinecraftProtocol
+	// unless set otherwise, but only 10 system addresses are written during the connection sequence. The
+	// other deviations of these implementations differ between their versions, so they may be toggled using
+	// Quirks.
+	ProfileJRakNet
+)
+
+// Quirks toggles deviations from RakNet made by other implementations, so that the package interoperates
+// with them without patches. The zero value of each field keeps the behaviour of the Profile used.
+type Quirks struct {
+	// SystemAddresses is the amount of system addresses written in the connection request accepted and new
+	// incoming connection packets of the connection sequence. Implementations that read a fixed amount of
+	// addresses fail to decode these packets if it differs from theirs. System addresses read are never
+	// counted, so any amount is accepted from the other end.
+	SystemAddresses int
+	// MTUExcludesHeaders makes the MTU size the size of the UDP payload, rather than the size of the UDP
+	// payload plus the 28 bytes of the UDP and IP headers. Open connection request 1 packets are then padded
+	// up to the full MTU size, and a Listener takes the MTU size of a client from the size of its request
+	// without adding the headers.
+	MTUExcludesHeaders bool
+	// MTUHeaderSize is the size of the UDP and IP headers counted in MTU sizes, which is 28 bytes by default,
+	// the size of the IPv4 and UDP headers. Older clients that assume different headers, such as 48 bytes
+	// for IPv6, compute MTU sizes that do not match the size of the datagrams they can receive, so that
+	// datagrams sent to them would be fragmented. Setting it to the size assumed by those clients makes the
+	// MTU size taken from their open connection request 1, and the size of the datagrams sent, match theirs.
+	// MTUHeaderSize has no effect if MTUExcludesHeaders is set.
+	MTUHeaderSize int
+	// MTUMultiple rounds the MTU sizes negotiated down to a multiple of it, for implementations that only
+	// accept MTU sizes that are a multiple of a fixed size. As MTU sizes are rounded down, it may also be used
+	// to leave headroom for clients that overestimate their MTU size.
+	MTUMultiple int
+}
+
+// apply returns the protocolFraming passed with the Quirks applied to it.
+func (q Quirks) apply(f protocolFraming) protocolFraming {
+	if q.SystemAddresses > 0 {
+		f.systemAddresses = q.SystemAddresses
+	}
+	if q.MTUExcludesHeaders {
+		f.mtuExcludesHeaders = true
+	}
+	if q.MTUHeaderSize > 0 {
+		f.headerSize = q.MTUHeaderSize
+	}
+	if q.MTUMultiple > 0 {
+		f.mtuMultiple = q.MTUMultiple
+	}
+	return f
+}
+
+// String returns the name of the profile, such as "minecraft".
+func (profile Profile) String() string {
+	switch profile {
+	case ProfileAuto:
+		return "auto"
+	case ProfileMinecraft:
+		return "minecraft"
+	case ProfileVanilla:
+		return "vanilla"
+	case ProfileJRakNet:
+		return "jraknet"
+	}
+	return fmt.Sprintf("Profile(%d)", int(profile))
+}
+
+// defaultProtocol returns the protocol version used with the profile if none is set.
+func (profile Profile) defaultProtocol() byte {
+	if profile == ProfileVanilla {
+		return OfficialProtocol
+	}
+	return MinecraftProtocol
+}
+
+// framing returns the protocolFraming of the profile for the RakNet protocol version passed.
+func (profile Profile) framing(protocol byte) protocolFraming {
+	if profile == ProfileAuto {
+		profile = ProfileVanilla
+		if protocol >= 8 && protocol <= 11 {
+			profile = ProfileMinecraft
+		}
+	}
+	switch profile {
+	case ProfileMinecraft:
+		return protocolFraming{systemAddresses: 20, pongLengthPrefix: true}
+	case ProfileJRakNet:
+		return protocolFraming{systemAddresses: 10, pongLengthPrefix: true}
+	}
+	return protocolFraming{systemAddresses: 10}
+}
+
+// hasProtocol checks if the protocol version passed is found in the list of protocols passed.
+func hasProtocol(protocols []byte, protocol byte) bool {
+	for _, p := range protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// closestProtocol returns the protocol version out of the protocols passed that is closest to the protocol
+// version passed. If two versions are equally close, the higher one is returned, as newer clients generally
+// handle older versions better than the other way around. protocols must not be empty.
+func closestProtocol(protocols []byte, protocol byte) byte {
+	closest := protocols[0]
+	for _, p := range protocols[1:] {
+		d, closestD := protocolDistance(p, protocol), protocolDistance(closest, protocol)
+		if d < closestD || (d == closestD && p > closest) {
+			closest = p
+		}
+	}
+	return closest
+}
+
+// protocolDistance returns the absolute difference between protocol versions a and b.
+func protocolDistance(a, b byte) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+const (
+	// maxPendingProtocols is the maximum amount of clients of which the protocol version is held between
+	// their open connection request 1 and 2. Clients beyond it connect using the primary protocol version
+	// of the Listener.
+	maxPendingProtocols = 4096
+	// pendingProtocolTimeout is the time after which the protocol version of a client that did not send an
+	// open connection request 2 is forgotten.
+	pendingProtocolTimeout = time.Second * 10
+)
+
+// pendingProtocols holds the protocol versions of clients that sent an open connection request 1 using a
+// version other than the primary version of a Listener, until they send an open connection request 2, which
+// does not hold the version.
+type pendingProtocols struct {
+	mu sync.Mutex
+	m  map[string]pendingProtocol
+}
+
+// pendingProtocol is the protocol version of a single client, held until its expiry.
+type pendingProtocol struct {
+	protocol byte
+	expiry   time.Time
+}
+
+// put stores the protocol version passed for the address passed. If the maximum amount of versions is
+// already held, expired versions are removed first. If none expired, the version is not stored.
+func (p *pendingProtocols) put(addr string, protocol byte, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.m == nil {
+		p.m = make(map[string]pendingProtocol)
+	}
+	if _, ok := p.m[addr]; !ok && len(p.m) >= maxPendingProtocols {
+		for k, v := range p.m {
+			if now.After(v.expiry) {
+				delete(p.m, k)
+			}
+		}
+		if len(p.m) >= maxPendingProtocols {
+			return
+		}
+	}
+	p.m[addr] = pendingProtocol{protocol: protocol, expiry: now.Add(pendingProtocolTimeout)}
+}
+
+// take removes the protocol version stored for the address passed and returns it. If none is stored or if
+// it expired, false is returned.
+func (p *pendingProtocols) take(addr string, now time.Time) (byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.m[addr]
+	if !ok {
+		return 0, false
+	}
+	delete(p.m, addr)
+	return v.protocol, !now.After(v.expiry)
+}
@@ -0,0 +1,191 @@
+package raknet
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+	l, err := ListenConfig{Protocol: MinecraftProtocol, Protocols: []byte{10, 11}}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	for _, test := range []struct {
+		dialer   Dialer
+		expected byte
+	}{
+		{Dialer{Protocol: 11}, 11},
+		{Dialer{}, MinecraftProtocol},
+		// The listener does not support 8, so the dialer falls back to the primary version of the listener.
+		{Dialer{Protocol: 8, Protocols: []byte{10, MinecraftProtocol}}, MinecraftProtocol},
+	} {
+		client, err := test.dialer.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatalf("error dialing with protocol %v: %v", test.dialer.Protocol, err)
+		}
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatalf("error accepting: %v", err)
+		}
+		if client.Protocol() != test.expected || c.(*Conn).Protocol() != test.expected {
+			t.Fatalf("expected protocol %v on both ends, got %v (client) and %v (server)", test.expected, client.Protocol(), c.(*Conn).Protocol())
+		}
+		_ = client.Close()
+		_ = c.Close()
+	}
+
+	_, err = Dialer{Protocol: 8, Protocols: []byte{7}}.Dial(l.Addr().String())
+	if !errors.Is(err, ErrIncompatibleProtocol) {
+		t.Fatalf("expected dial without a common protocol to fail with ErrIncompatibleProtocol, got %v", err)
+	}
+}
+
+func TestPendingProtocols(t *testing.T) {
+	var p pendingProtocols
+	now := time.Unix(0, 0)
+	p.put("a", 10, now)
+	if protocol, ok := p.take("a", now.Add(time.Second)); !ok || protocol != 10 {
+		t.Fatalf("expected protocol 10 to be pending, got %v (%v)", protocol, ok)
+	}
+	if _, ok := p.take("a", now); ok {
+		t.Fatalf("expected pending protocol to be removed once taken")
+	}
+	p.put("b", 10, now)
+	if _, ok := p.take("b", now.Add(pendingProtocolTimeout+time.Second)); ok {
+		t.Fatalf("expected pending protocol to expire")
+	}
+}
+
+func TestProfileVanilla(t *testing.T) {
+	l, err := ListenConfig{Profile: ProfileVanilla}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	l.PongData([]byte("vanilla pong"))
+
+	dialer := Dialer{Profile: ProfileVanilla}
+	data, err := dialer.Ping(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error pinging: %v", err)
+	}
+	if string(data) != "vanilla pong" {
+		t.Fatalf("expected pong data without length prefix, got %q", data)
+	}
+	client, err := dialer.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	if client.Protocol() != OfficialProtocol || client.framing.systemAddresses != 10 {
+		t.Fatalf("expected official protocol with 10 system addresses, got protocol %v with %v", client.Protocol(), client.framing.systemAddresses)
+	}
+}
+
+func TestProfileFraming(t *testing.T) {
+	for _, test := range []struct {
+		profile  Profile
+		protocol byte
+		prefix   bool
+	}{
+		{ProfileAuto, MinecraftProtocol, true},
+		{ProfileAuto, 11, true},
+		{ProfileAuto, OfficialProtocol, false},
+		{ProfileMinecraft, OfficialProtocol, true},
+		{ProfileVanilla, MinecraftProtocol, false},
+		{ProfileJRakNet, OfficialProtocol, true},
+	} {
+		if f := test.profile.framing(test.protocol); f.pongLengthPrefix != test.prefix {
+			t.Errorf("%v profile with protocol %v: expected pong length prefix %v", test.profile, test.protocol, test.prefix)
+		}
+	}
+}
+
+func TestQuirks(t *testing.T) {
+	quirks := Quirks{SystemAddresses: 12, MTUExcludesHeaders: true, MTUMultiple: 100}
+	l, err := ListenConfig{Profile: ProfileJRakNet, Quirks: quirks}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			_, _ = c.Write([]byte{0xfe, 1, 2, 3})
+		}
+	}()
+
+	client, err := Dialer{Profile: ProfileJRakNet, Quirks: quirks}.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	if client.Protocol() != MinecraftProtocol || client.framing.systemAddresses != 12 {
+		t.Fatalf("expected minecraft protocol with 12 system addresses, got protocol %v with %v", client.Protocol(), client.framing.systemAddresses)
+	}
+	// The open connection request 1 is padded to the full 1492 bytes discovered, which is rounded down to 1400.
+	if client.MTUSize() != 1400 {
+		t.Fatalf("expected MTU size 1400, got %v", client.MTUSize())
+	}
+	if _, err := client.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+}
+
+func TestMTUHeaderSize(t *testing.T) {
+	l, err := ListenConfig{Quirks: Quirks{MTUHeaderSize: 48, MTUMultiple: 100}}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer client.Close()
+
+	// A request of 1200 bytes is answered with an MTU size of 1248, which is rounded down to 1200.
+	request := append([]byte{idOpenConnectionRequest1}, magic[:]...)
+	request = append(request, MinecraftProtocol)
+	request = append(request, make([]byte, 1200-len(request))...)
+	if _, err := client.WriteTo(request, l.Addr()); err != nil {
+		t.Fatalf("error writing request: %v", err)
+	}
+	if mtuSize := readReply1(t, client); mtuSize != 1200 {
+		t.Fatalf("expected MTU size 1200, got %v", mtuSize)
+	}
+}
+
+func TestAdvertiseClosestProtocol(t *testing.T) {
+	if p := closestProtocol([]byte{10, 6, 11}, 9); p != 10 {
+		t.Fatalf("expected closest protocol 10, got %v", p)
+	}
+	if p := closestProtocol([]byte{8, 10}, 9); p != 10 {
+		t.Fatalf("expected higher protocol to be preferred when equally close, got %v", p)
+	}
+
+	for _, advertise := range []bool{false, true} {
+		l, err := ListenConfig{Protocol: 11, Protocols: []byte{7}, AdvertiseClosestProtocol: advertise}.Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		// The dialer does not support the primary version of the listener, so it can only connect if the
+		// listener advertises the closest version.
+		client, err := Dialer{Protocol: 6, Protocols: []byte{7}}.Dial(l.Addr().String())
+		if advertise {
+			if err != nil {
+				t.Fatalf("error dialing with closest protocol advertised: %v", err)
+			}
+			if client.Protocol() != 7 {
+				t.Fatalf("expected protocol 7 to be negotiated, got %v", client.Protocol())
+			}
+			_ = client.Close()
+		} else if !errors.Is(err, ErrIncompatibleProtocol) {
+			t.Fatalf("expected dial to fail with ErrIncompatibleProtocol without closest protocol advertised, got %v", err)
+		}
+		_ = l.Close()
+	}
+}
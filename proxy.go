@@ -0,0 +1,237 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// proxySignature is the signature that every PROXY protocol v2 header starts with.
+var proxySignature = [12]byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+
+const (
+	// proxyHeaderSize is the size of the fixed part of a PROXY protocol v2 header: The signature, the version
+	// and command, the address family and protocol and the length of the rest of the header.
+	proxyHeaderSize = 16
+	// proxyMaxHeaderSize is the maximum size of a PROXY protocol v2 header read by a Listener, which leaves
+	// room for the addresses and some TLVs.
+	proxyMaxHeaderSize = 256
+	// proxyMappingTimeout is the time after which the mapping of a client address to the address of the load
+	// balancer that it was last seen through is forgotten, if no datagrams of the client were received.
+	proxyMappingTimeout = time.Minute
+)
+
+// parseProxyHeader parses the PROXY protocol v2 header at the start of datagram b. It returns the source
+// address carried in the header and the size of the header. The address returned is nil if the header is
+// one of the LOCAL command, which load balancers send for their own datagrams, such as health checks.
+func parseProxyHeader(b []byte) (*net.UDPAddr, int, error) {
+	if len(b) < proxyHeaderSize || !bytes.Equal(b[:12], proxySignature[:]) {
+		return nil, 0, fmt.Errorf("datagram does not start with a PROXY protocol v2 header")
+	}
+	if b[12]>>4 != 2 {
+		return nil, 0, fmt.Errorf("unsupported PROXY protocol version %v", b[12]>>4)
+	}
+	n := proxyHeaderSize + int(binary.BigEndian.Uint16(b[14:16]))
+	if n > len(b) {
+		return nil, 0, fmt.Errorf("PROXY protocol header length %v exceeds datagram size %v", n, len(b))
+	}
+	switch b[12] & 0x0f {
+	case 0x0:
+		return nil, n, nil
+	case 0x1:
+	default:
+		return nil, 0, fmt.Errorf("unknown PROXY protocol command %#x", b[12]&0x0f)
+	}
+	addresses := b[proxyHeaderSize:n]
+	switch b[13] >> 4 {
+	case 0x1:
+		if len(addresses) < 12 {
+			return nil, 0, fmt.Errorf("not enough bytes for PROXY protocol IPv4 addresses")
+		}
+		ip := make(net.IP, 4)
+		copy(ip, addresses[:4])
+		return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(addresses[8:]))}, n, nil
+	case 0x2:
+		if len(addresses) < 36 {
+			return nil, 0, fmt.Errorf("not enough bytes for PROXY protocol IPv6 addresses")
+		}
+		ip := make(net.IP, 16)
+		copy(ip, addresses[:16])
+		return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(addresses[32:]))}, n, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported PROXY protocol address family %#x", b[13]>>4)
+}
+
+// appendProxyHeader appends a PROXY protocol v2 header for a datagram sent from src to dst to b. Both
+// addresses must be of the same IP version.
+func appendProxyHeader(b []byte, src, dst *net.UDPAddr) []byte {
+	b = append(b, proxySignature[:]...)
+	srcIP, dstIP, family := src.IP.To4(), dst.IP.To4(), byte(0x12)
+	if srcIP == nil || dstIP == nil {
+		srcIP, dstIP, family = src.IP.To16(), dst.IP.To16(), 0x22
+	}
+	b = append(b, 0x21, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(srcIP)*2+4))
+	b = append(append(b, srcIP...), dstIP...)
+	b = binary.BigEndian.AppendUint16(b, uint16(src.Port))
+	return binary.BigEndian.AppendUint16(b, uint16(dst.Port))
+}
+
+// proxyMapping is the mapping of the address of a client to the address of the load balancer that its
+// datagrams were last received through.
+type proxyMapping struct {
+	client   *net.UDPAddr
+	proxy    net.Addr
+	lastSeen time.Time
+}
+
+// proxyConn is a net.PacketConn that strips the PROXY protocol v2 headers prepended to datagrams by trusted
+// load balancers. Datagrams read are returned with the client address carried in their header, and
+// datagrams written to a client address are sent to the load balancer that the client was last seen
+// through.
+type proxyConn struct {
+	net.PacketConn
+	trusted  []*net.IPNet
+	required bool
+	clock    Clock
+	// reject is called for every datagram dropped because of a missing or invalid header.
+	reject func(addr net.Addr, err error)
+
+	// buf is the buffer datagrams are read into, which is bigger than the buffer passed to ReadFrom so that
+	// the header fits in it too.
+	buf []byte
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// clients holds the mappings by client address and proxies by load balancer address. The latter is used
+	// for load balancers that only prepend a header to the first datagram of a client.
+	clients   map[string]*proxyMapping
+	proxies   map[string]*proxyMapping
+	lastSweep time.Time
+}
+
+// newProxyConn returns a proxyConn that reads datagrams from the connection passed. Headers are only
+// accepted from addresses in one of the trusted networks. If required is true, datagrams without a valid
+// header are dropped.
+func newProxyConn(conn net.PacketConn, trusted []*net.IPNet, required bool, clock Clock) *proxyConn {
+	return &proxyConn{
+		PacketConn: conn,
+		trusted:    trusted,
+		required:   required,
+		clock:      clock,
+		clients:    make(map[string]*proxyMapping),
+		proxies:    make(map[string]*proxyMapping),
+		lastSweep:  clock.Now(),
+	}
+}
+
+// ReadFrom reads a datagram from the connection and strips its PROXY protocol header, if any. The address
+// returned is the client address carried in the header. Datagrams without a valid header are dropped if a
+// header is required.
+func (conn *proxyConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	if len(conn.buf) < len(b)+proxyMaxHeaderSize {
+		conn.buf = make([]byte, len(b)+proxyMaxHeaderSize)
+	}
+	for {
+		n, addr, err = conn.PacketConn.ReadFrom(conn.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		client, payload, err := conn.resolve(conn.buf[:n], addr)
+		if err != nil {
+			if conn.reject != nil {
+				conn.reject(addr, err)
+			}
+			continue
+		}
+		return copy(b, payload), client, nil
+	}
+}
+
+// resolve returns the client address and the payload of datagram b received from the address passed.
+func (conn *proxyConn) resolve(b []byte, addr net.Addr) (net.Addr, []byte, error) {
+	if !conn.trusts(addr) {
+		if conn.required {
+			return nil, nil, fmt.Errorf("datagram received from untrusted address without PROXY protocol header")
+		}
+		// Datagrams from other addresses are never parsed, as the clients sending them could otherwise spoof
+		// their address.
+		return addr, b, nil
+	}
+	now := conn.clock.Now()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if now.Sub(conn.lastSweep) > proxyMappingTimeout {
+		conn.sweep(now)
+	}
+	if len(b) >= len(proxySignature) && bytes.Equal(b[:12], proxySignature[:]) {
+		client, n, err := parseProxyHeader(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if client == nil {
+			return addr, b[n:], nil
+		}
+		mapping, ok := conn.clients[client.String()]
+		if !ok {
+			mapping = &proxyMapping{client: client}
+			conn.clients[client.String()] = mapping
+		}
+		mapping.proxy, mapping.lastSeen = addr, now
+		conn.proxies[addr.String()] = mapping
+		return client, b[n:], nil
+	}
+	if mapping, ok := conn.proxies[addr.String()]; ok {
+		mapping.lastSeen = now
+		return mapping.client, b, nil
+	}
+	if conn.required {
+		return nil, nil, fmt.Errorf("datagram received from load balancer without PROXY protocol header")
+	}
+	return addr, b, nil
+}
+
+// sweep forgets all mappings that have not been used since proxyMappingTimeout before the time passed.
+func (conn *proxyConn) sweep(now time.Time) {
+	conn.lastSweep = now
+	for key, mapping := range conn.clients {
+		if now.Sub(mapping.lastSeen) > proxyMappingTimeout {
+			delete(conn.clients, key)
+		}
+	}
+	for key, mapping := range conn.proxies {
+		if now.Sub(mapping.lastSeen) > proxyMappingTimeout {
+			delete(conn.proxies, key)
+		}
+	}
+}
+
+// trusts checks if the address passed is in one of the trusted networks of the connection.
+func (conn *proxyConn) trusts(addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range conn.trusted {
+		if network.Contains(udpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTo writes a datagram to the address passed. If the address is that of a client seen through a load
+// balancer, the datagram is sent to the load balancer instead.
+func (conn *proxyConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	conn.mu.Lock()
+	mapping, ok := conn.clients[addr.String()]
+	if ok {
+		addr = mapping.proxy
+	}
+	conn.mu.Unlock()
+	return conn.PacketConn.WriteTo(b, addr)
+}
@@ -0,0 +1,118 @@
+package raknet
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	l, err := ListenConfig{TrustedProxies: []*net.IPNet{loopback}, RequireProxyHeader: true}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	// The load balancer forwards the datagrams of a single client, prepending a header with the address
+	// claimed below to each of them.
+	claimed := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 4000}
+	lb, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening for load balancer: %v", err)
+	}
+	defer lb.Close()
+	upstream, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing listener: %v", err)
+	}
+	defer upstream.Close()
+	var client atomic.Value
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, addr, err := lb.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			client.Store(addr)
+			_, _ = upstream.Write(append(appendProxyHeader(nil, claimed, lb.LocalAddr().(*net.UDPAddr)), b[:n]...))
+		}
+	}()
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, err := upstream.Read(b)
+			if err != nil {
+				return
+			}
+			if addr, ok := client.Load().(net.Addr); ok {
+				_, _ = lb.WriteTo(b[:n], addr)
+			}
+		}
+	}()
+
+	c, err := Dial(lb.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("error dialing through load balancer: %v", err)
+	}
+	defer c.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	if server.RemoteAddr().String() != claimed.String() {
+		t.Fatalf("expected remote address %v, got %v", claimed, server.RemoteAddr())
+	}
+	if _, err := server.Write([]byte{0xfe, 1, 2, 3}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if _, err := c.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+}
+
+func TestRequireProxyHeader(t *testing.T) {
+	rejections := make(chan Rejection, 4)
+	l, err := ListenConfig{RequireProxyHeader: true, OnReject: func(r Rejection) { rejections <- r }}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing UDP: %v", err)
+	}
+	defer conn.Close()
+	// The address is not trusted, so even a datagram with a header is dropped.
+	header := appendProxyHeader(nil, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 4000}, conn.RemoteAddr().(*net.UDPAddr))
+	if _, err := conn.Write(append(header, idUnconnectedPing)); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	select {
+	case r := <-rejections:
+		if r.Reason != RejectInvalidProxyHeader {
+			t.Fatalf("expected invalid proxy header rejection, got %v", r.Reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnReject not called")
+	}
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 19132}
+	dst := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 19133}
+	b := append(appendProxyHeader(nil, src, dst), 0x84)
+	addr, n, err := parseProxyHeader(b)
+	if err != nil {
+		t.Fatalf("error parsing header: %v", err)
+	}
+	if addr.String() != src.String() || n != len(b)-1 {
+		t.Fatalf("expected %v with header size %v, got %v with %v", src, len(b)-1, addr, n)
+	}
+	if _, _, err := parseProxyHeader(b[:20]); err == nil {
+		t.Fatalf("expected error parsing truncated header")
+	}
+}
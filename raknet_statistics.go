@@ -0,0 +1,252 @@
+package raknet
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RakNetMetric is a metric of which RakNetStatistics holds the value over the last second and the running
+// total. The metrics and their order are the same as those of the RNSPerSecondMetrics enum of C++ RakNet.
+type RakNetMetric int
+
+const (
+	// UserMessageBytesPushed is the amount of bytes of messages passed to Write and WriteReliability.
+	UserMessageBytesPushed RakNetMetric = iota
+	// UserMessageBytesSent is the amount of bytes of messages sent for the first time.
+	UserMessageBytesSent
+	// UserMessageBytesResent is the amount of bytes of messages resent.
+	UserMessageBytesResent
+	// UserMessageBytesReceivedProcessed is the amount of bytes of messages received and returned by Read.
+	UserMessageBytesReceivedProcessed
+	// UserMessageBytesReceivedIgnored is the amount of bytes of messages received that were discarded,
+	// either because they were received before or because the read queue of the connection was full.
+	UserMessageBytesReceivedIgnored
+	// ActualBytesSent is the amount of bytes written to the socket, including datagram headers, ACKs and
+	// NACKs.
+	ActualBytesSent
+	// ActualBytesReceived is the amount of bytes read from the socket, including datagram headers, ACKs and
+	// NACKs.
+	ActualBytesReceived
+	// RakNetMetricCount is the amount of metrics. It is not a metric itself.
+	RakNetMetricCount
+)
+
+// Priority is the priority of a message in C++ RakNet. Messages written to a Conn do not have a priority, so
+// all of them are reported as MediumPriority in RakNetStatistics.
+type Priority int
+
+const (
+	ImmediatePriority Priority = iota
+	HighPriority
+	MediumPriority
+	LowPriority
+	// NumberOfPriorities is the amount of priorities. It is not a priority itself.
+	NumberOfPriorities
+)
+
+// RakNetStatistics holds statistics of a Conn in the same layout as the RakNetStatistics struct of C++
+// RakNet, so that the statistics may be compared side by side with those of RakNet peers or fed to tooling
+// built for RakNet. It is obtained using Conn.RakNetStatistics and may be rendered in the format of
+// RakNet's StatisticsToString using the StatisticsToString method.
+type RakNetStatistics struct {
+	// ValueOverLastSecond holds the value of each metric over the last full second.
+	ValueOverLastSecond [RakNetMetricCount]uint64
+	// RunningTotal holds the value of each metric since the connection was created.
+	RunningTotal [RakNetMetricCount]uint64
+
+	// ConnectionStartTime is the time at which the connection was created.
+	ConnectionStartTime time.Time
+
+	// IsLimitedByCongestionControl specifies if datagrams are held back because the send window of the
+	// connection is full. BPSLimitByCongestionControl is always 0, as the send window limits the amount of
+	// datagrams in flight rather than the bytes per second.
+	IsLimitedByCongestionControl bool
+	BPSLimitByCongestionControl  uint64
+	// IsLimitedByOutgoingBandwidthLimit and BPSLimitByOutgoingBandwidthLimit are always false and 0, as
+	// connections have no outgoing bandwidth limit.
+	IsLimitedByOutgoingBandwidthLimit bool
+	BPSLimitByOutgoingBandwidthLimit  uint64
+
+	// MessageInSendBuffer and BytesInSendBuffer hold the amount of messages and bytes of messages waiting
+	// to be sent, per priority.
+	MessageInSendBuffer [NumberOfPriorities]uint32
+	BytesInSendBuffer   [NumberOfPriorities]float64
+	// MessagesInResendBuffer and BytesInResendBuffer hold the amount of messages and bytes of messages sent
+	// that have not yet been acknowledged.
+	MessagesInResendBuffer uint32
+	BytesInResendBuffer    uint64
+
+	// PacketLossLastSecond and PacketLossTotal are the ratio of message bytes resent to message bytes sent
+	// over the last second and since the connection was created, computed like C++ RakNet does.
+	PacketLossLastSecond float32
+	PacketLossTotal      float32
+
+	// now is the time at which the statistics were taken, used to calculate the elapsed connection time.
+	now time.Time
+}
+
+// RakNetStatistics returns the current statistics of the connection in the layout of the RakNetStatistics
+// struct of C++ RakNet.
+func (conn *Conn) RakNetStatistics() RakNetStatistics {
+	stats := conn.meter.statistics()
+
+	conn.writeLock.Lock()
+	stats.IsLimitedByCongestionControl = len(conn.windowQueue) > 0
+	for _, d := range conn.windowQueue {
+		stats.MessageInSendBuffer[MediumPriority] += uint32(len(d.packets))
+		stats.BytesInSendBuffer[MediumPriority] += float64(d.contentSize())
+	}
+	stats.MessageInSendBuffer[MediumPriority] += uint32(len(conn.sendDatagram.packets))
+	stats.BytesInSendBuffer[MediumPriority] += float64(conn.sendDatagram.contentSize())
+	for _, val := range conn.recoveryQueue.queue {
+		d := val.(*datagram)
+		stats.MessagesInResendBuffer += uint32(len(d.packets))
+		stats.BytesInResendBuffer += uint64(d.contentSize())
+	}
+	conn.writeLock.Unlock()
+	return stats
+}
+
+// StatisticsToString renders the statistics in the format of the StatisticsToString function of C++
+// RakNet. A verbosity of 0 renders only the bytes per second and packet loss, 1 adds the totals and elapsed
+// connection time, and 2 or higher renders all statistics.
+func (s RakNetStatistics) StatisticsToString(verbosity int) string {
+	last, total := s.ValueOverLastSecond, s.RunningTotal
+	elapsed := uint64(s.now.Sub(s.ConnectionStartTime) / time.Second)
+	b := &strings.Builder{}
+	switch {
+	case verbosity <= 0:
+		fmt.Fprintf(b, "Bytes per second sent     %d\n"+
+			"Bytes per second received %d\n"+
+			"Current packetloss        %.1f%%\n",
+			last[ActualBytesSent], last[ActualBytesReceived], s.PacketLossLastSecond*100)
+		return b.String()
+	case verbosity == 1:
+		fmt.Fprintf(b, "Actual bytes per second sent       %d\n"+
+			"Actual bytes per second received   %d\n"+
+			"Message bytes per second pushed    %d\n"+
+			"Total actual bytes sent            %d\n"+
+			"Total actual bytes received        %d\n"+
+			"Total message bytes pushed         %d\n"+
+			"Current packetloss                 %.1f%%\n"+
+			"Average packetloss                 %.1f%%\n"+
+			"Elapsed connection time in seconds %d\n",
+			last[ActualBytesSent], last[ActualBytesReceived], last[UserMessageBytesPushed],
+			total[ActualBytesSent], total[ActualBytesReceived], total[UserMessageBytesPushed],
+			s.PacketLossLastSecond*100, s.PacketLossTotal*100, elapsed)
+	default:
+		// The tabs in the lines of returned bytes are also found in the output of C++ RakNet.
+		fmt.Fprintf(b, "Actual bytes per second sent         %d\n"+
+			"Actual bytes per second received     %d\n"+
+			"Message bytes per second sent        %d\n"+
+			"Message bytes per second resent      %d\n"+
+			"Message bytes per second pushed      %d\n"+
+			"Message bytes per second returned\t  %d\n"+
+			"Message bytes per second ignored     %d\n"+
+			"Total bytes sent                     %d\n"+
+			"Total bytes received                 %d\n"+
+			"Total message bytes sent             %d\n"+
+			"Total message bytes resent           %d\n"+
+			"Total message bytes pushed           %d\n"+
+			"Total message bytes returned\t\t  %d\n"+
+			"Total message bytes ignored          %d\n"+
+			"Messages in send buffer, by priority %d,%d,%d,%d\n"+
+			"Bytes in send buffer, by priority    %d,%d,%d,%d\n"+
+			"Messages in resend buffer            %d\n"+
+			"Bytes in resend buffer               %d\n"+
+			"Current packetloss                   %.1f%%\n"+
+			"Average packetloss                   %.1f%%\n"+
+			"Elapsed connection time in seconds   %d\n",
+			last[ActualBytesSent], last[ActualBytesReceived], last[UserMessageBytesSent],
+			last[UserMessageBytesResent], last[UserMessageBytesPushed], last[UserMessageBytesReceivedProcessed],
+			last[UserMessageBytesReceivedIgnored],
+			total[ActualBytesSent], total[ActualBytesReceived], total[UserMessageBytesSent],
+			total[UserMessageBytesResent], total[UserMessageBytesPushed], total[UserMessageBytesReceivedProcessed],
+			total[UserMessageBytesReceivedIgnored],
+			s.MessageInSendBuffer[ImmediatePriority], s.MessageInSendBuffer[HighPriority],
+			s.MessageInSendBuffer[MediumPriority], s.MessageInSendBuffer[LowPriority],
+			int(s.BytesInSendBuffer[ImmediatePriority]), int(s.BytesInSendBuffer[HighPriority]),
+			int(s.BytesInSendBuffer[MediumPriority]), int(s.BytesInSendBuffer[LowPriority]),
+			s.MessagesInResendBuffer, s.BytesInResendBuffer,
+			s.PacketLossLastSecond*100, s.PacketLossTotal*100, elapsed)
+	}
+	if s.BPSLimitByCongestionControl != 0 {
+		fmt.Fprintf(b, "Send capacity                    %d bytes per second (%.0f%%)\n",
+			s.BPSLimitByCongestionControl, 100*float64(last[ActualBytesSent])/float64(s.BPSLimitByCongestionControl))
+	}
+	if s.BPSLimitByOutgoingBandwidthLimit != 0 {
+		fmt.Fprintf(b, "Send limit                       %d (%.0f%%)\n",
+			s.BPSLimitByOutgoingBandwidthLimit, 100*float64(last[ActualBytesSent])/float64(s.BPSLimitByOutgoingBandwidthLimit))
+	}
+	return b.String()
+}
+
+// String renders the statistics like StatisticsToString with a verbosity of 1, which is the default of C++
+// RakNet.
+func (s RakNetStatistics) String() string {
+	return s.StatisticsToString(1)
+}
+
+// metricMeter counts the RakNetMetrics of a connection, both in total and per second.
+type metricMeter struct {
+	mu    sync.Mutex
+	clock Clock
+	start time.Time
+
+	total [RakNetMetricCount]uint64
+	// second is the second that current holds the values of, and previous those of the second before it.
+	second            int64
+	current, previous [RakNetMetricCount]uint64
+}
+
+// newMetricMeter returns a metricMeter measuring from the current time of the Clock passed.
+func newMetricMeter(clock Clock) *metricMeter {
+	now := clock.Now()
+	return &metricMeter{clock: clock, start: now, second: now.Unix()}
+}
+
+// advance moves the per second values forward to the second passed.
+func (m *metricMeter) advance(second int64) {
+	switch second - m.second {
+	case 0:
+		return
+	case 1:
+		m.previous = m.current
+	default:
+		m.previous = [RakNetMetricCount]uint64{}
+	}
+	m.current = [RakNetMetricCount]uint64{}
+	m.second = second
+}
+
+// add adds n to the metric passed.
+func (m *metricMeter) add(metric RakNetMetric, n int) {
+	m.mu.Lock()
+	m.advance(m.clock.Now().Unix())
+	m.total[metric] += uint64(n)
+	m.current[metric] += uint64(n)
+	m.mu.Unlock()
+}
+
+// statistics returns RakNetStatistics holding the metrics counted and the packet loss derived from them.
+func (m *metricMeter) statistics() RakNetStatistics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	m.advance(now.Unix())
+	s := RakNetStatistics{
+		ValueOverLastSecond: m.previous,
+		RunningTotal:        m.total,
+		ConnectionStartTime: m.start,
+		now:                 now,
+	}
+	if sent := s.ValueOverLastSecond[UserMessageBytesSent]; sent != 0 {
+		s.PacketLossLastSecond = float32(s.ValueOverLastSecond[UserMessageBytesResent]) / float32(sent)
+	}
+	if sent := s.RunningTotal[UserMessageBytesSent]; sent != 0 {
+		s.PacketLossTotal = float32(s.RunningTotal[UserMessageBytesResent]) / float32(sent)
+	}
+	return s
+}
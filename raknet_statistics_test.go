@@ -0,0 +1,52 @@
+package raknet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRakNetStatistics(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+
+	payload := []byte{0xfe, 1, 2, 3, 4}
+	if _, err := a.Write(payload); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	buf := make([]byte, 1500)
+	_ = b.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := b.Read(buf); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+
+	sent, received := a.RakNetStatistics(), b.RakNetStatistics()
+	if sent.RunningTotal[UserMessageBytesPushed] != uint64(len(payload)) {
+		t.Errorf("expected %v message bytes pushed, got %v", len(payload), sent.RunningTotal[UserMessageBytesPushed])
+	}
+	if sent.RunningTotal[UserMessageBytesSent] != uint64(len(payload)) {
+		t.Errorf("expected %v message bytes sent, got %v", len(payload), sent.RunningTotal[UserMessageBytesSent])
+	}
+	if sent.RunningTotal[ActualBytesSent] <= uint64(len(payload)) {
+		t.Errorf("expected more actual bytes sent than message bytes, got %v", sent.RunningTotal[ActualBytesSent])
+	}
+	if received.RunningTotal[UserMessageBytesReceivedProcessed] != uint64(len(payload)) {
+		t.Errorf("expected %v message bytes returned, got %v", len(payload), received.RunningTotal[UserMessageBytesReceivedProcessed])
+	}
+	if received.RunningTotal[ActualBytesReceived] == 0 {
+		t.Errorf("expected actual bytes received")
+	}
+	if sent.PacketLossTotal != 0 {
+		t.Errorf("expected no packet loss, got %v", sent.PacketLossTotal)
+	}
+
+	for verbosity, line := range []string{
+		"Bytes per second sent     ",
+		"Total message bytes pushed         5\n",
+		"Total message bytes pushed           5\n",
+	} {
+		if s := sent.StatisticsToString(verbosity); !strings.Contains(s, line) {
+			t.Errorf("expected verbosity %v to contain %q, got:\n%v", verbosity, line, s)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package raknet
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// randInt63 returns a random non-negative int64 read from the io.Reader passed. If r is nil, crypto/rand is
+// used, so that identifiers such as GUIDs generated with it cannot be predicted.
+func randInt63(r io.Reader) (int64, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) &^ (1 << 63)), nil
+}
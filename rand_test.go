@@ -0,0 +1,41 @@
+package raknet
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestListenRand(t *testing.T) {
+	var ids [2]int64
+	for i := range ids {
+		l, err := ListenConfig{Rand: rand.New(rand.NewSource(1))}.Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		ids[i] = l.ID()
+		_ = l.Close()
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("listener IDs generated from the same seed differ: %v != %v", ids[0], ids[1])
+	}
+	if ids[0] < 0 {
+		t.Errorf("listener ID %v is negative", ids[0])
+	}
+}
+
+func TestListenCryptoRand(t *testing.T) {
+	// Listeners created at the same time used to share an ID because the global source was seeded with the
+	// current time.
+	ids := make(map[int64]bool)
+	for i := 0; i < 8; i++ {
+		l, err := ListenConfig{}.Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		if ids[l.ID()] || l.ID() < 0 {
+			t.Errorf("unexpected listener ID %v", l.ID())
+		}
+		ids[l.ID()] = true
+		_ = l.Close()
+	}
+}
@@ -0,0 +1,153 @@
+package raknet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// maxRateLimitSources is the maximum amount of addresses of which a Listener holds a token bucket at once.
+// Datagrams from addresses beyond it are only subject to the global rate limits.
+const maxRateLimitSources = 65536
+
+// RateLimit is a token bucket limiting the rate at which datagrams are processed. Every datagram takes a token
+// from the bucket, which is refilled at Rate tokens per second up to Burst tokens. Datagrams arriving while
+// the bucket is empty are dropped.
+type RateLimit struct {
+	// Rate is the amount of datagrams per second allowed on average. If 0, no limit is enforced.
+	Rate float64
+	// Burst is the maximum amount of datagrams allowed at once. If 0, Burst is Rate, rounded up.
+	Burst int
+}
+
+// burst returns the size of the bucket of the RateLimit.
+func (limit RateLimit) burst() float64 {
+	if limit.Burst > 0 {
+		return float64(limit.Burst)
+	}
+	if limit.Rate < 1 {
+		return 1
+	}
+	return float64(int(limit.Rate + 0.999999))
+}
+
+// RateLimits holds the rate limits that a Listener applies to the datagrams it reads, before processing
+// them in any way, so that the read loop keeps up during floods. Offline messages, such as unconnected pings
+// and open connection requests, are limited separately from the datagrams of established connections, so
+// that a flood of connection attempts does not starve the connections already open, and the other way
+// around.
+type RateLimits struct {
+	// Offline limits the offline messages received from all addresses combined.
+	Offline RateLimit
+	// OfflinePerSource limits the offline messages received from a single address.
+	OfflinePerSource RateLimit
+	// Connected limits the datagrams received by all connections combined.
+	Connected RateLimit
+	// ConnectedPerSource limits the datagrams received by a single connection.
+	ConnectedPerSource RateLimit
+	// Acks limits the ACK and NACK datagrams processed per connection. Acknowledgements beyond it are dropped
+	// before they are decoded, so that a flood of them cannot make the connection scan its recovery queue
+	// over and over. An honest peer sends at most 100 of each per second.
+	Acks RateLimit
+}
+
+// RateLimitStats holds the amount of datagrams dropped by each of the RateLimits of a Listener.
+type RateLimitStats struct {
+	Offline            uint64
+	OfflinePerSource   uint64
+	Connected          uint64
+	ConnectedPerSource uint64
+	Acks               uint64
+}
+
+// tokenBucket is the state of a RateLimit.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket at the time passed, after refilling it according to the RateLimit
+// passed. If the bucket is empty, false is returned.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	burst := limit.burst()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * limit.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full checks if the bucket is refilled entirely at the time passed, in which case it is equivalent to a
+// bucket that was never used.
+func (b *tokenBucket) full(limit RateLimit, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= limit.burst()
+}
+
+// rateLimiter applies a global and a per source RateLimit. It is only used by the goroutine reading
+// datagrams, so it is not safe for concurrent use.
+type rateLimiter struct {
+	global, perSource RateLimit
+	// globalDrops and perSourceDrops point to the counters of datagrams dropped by either limit.
+	globalDrops, perSourceDrops *uint64
+
+	bucket  tokenBucket
+	sources map[string]*tokenBucket
+	// swept is the last time buckets were removed from sources to make room. Sweeps happen at most once
+	// per second, so that a flood from many addresses does not make every datagram scan all buckets.
+	swept time.Time
+}
+
+// enabled checks if either RateLimit of the rateLimiter is enforced.
+func (l *rateLimiter) enabled() bool {
+	return l.global.Rate > 0 || l.perSource.Rate > 0
+}
+
+// allow checks if a datagram from the address passed may be processed at the time passed. If not, the drop
+// counter of the limit that was exceeded is incremented.
+func (l *rateLimiter) allow(addr string, now time.Time) bool {
+	if l.perSource.Rate > 0 {
+		b, ok := l.sources[addr]
+		if !ok && len(l.sources) >= maxRateLimitSources && now.Sub(l.swept) >= time.Second {
+			// Buckets that refilled entirely hold no information, so they are removed to make room.
+			l.swept = now
+			for k, v := range l.sources {
+				if v.full(l.perSource, now) {
+					delete(l.sources, k)
+				}
+			}
+		}
+		if !ok && len(l.sources) < maxRateLimitSources {
+			b = &tokenBucket{}
+			l.sources[addr] = b
+		}
+		if b != nil && !b.take(l.perSource, now) {
+			atomic.AddUint64(l.perSourceDrops, 1)
+			return false
+		}
+	}
+	if l.global.Rate > 0 && !l.bucket.take(l.global, now) {
+		atomic.AddUint64(l.globalDrops, 1)
+		return false
+	}
+	return true
+}
+
+// allow checks if the datagram from the address passed may be processed according to the RateLimits of the
+// listener. connected specifies if the address has a connection.
+func (listener *Listener) allow(addr string, connected bool) bool {
+	if !listener.offlineLimiter.enabled() && !listener.connectedLimiter.enabled() {
+		return true
+	}
+	if connected {
+		return listener.connectedLimiter.allow(addr, listener.config.clock.Now())
+	}
+	return listener.offlineLimiter.allow(addr, listener.config.clock.Now())
+}
@@ -0,0 +1,79 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimits(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	l, err := ListenConfig{Clock: clock, RateLimits: RateLimits{OfflinePerSource: RateLimit{Rate: 1, Burst: 2}, Offline: RateLimit{Rate: 3}}}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	ping := bytes.NewBuffer([]byte{idUnconnectedPing})
+	_ = binary.Write(ping, binary.BigEndian, &unconnectedPing{SendTimestamp: 1, Magic: magic, ClientGUID: 2})
+	pings := func(conn net.PacketConn, n int) (pongs int) {
+		for i := 0; i < n; i++ {
+			if _, err := conn.WriteTo(ping.Bytes(), l.Addr()); err != nil {
+				t.Fatalf("error writing ping: %v", err)
+			}
+		}
+		b := make([]byte, 1500)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+			if _, _, err := conn.ReadFrom(b); err != nil {
+				return pongs
+			}
+			pongs++
+		}
+	}
+	a, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer a.Close()
+	b, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer b.Close()
+
+	// The first client is limited by its own bucket, after which the second exhausts the global bucket.
+	if pongs := pings(a, 5); pongs != 2 {
+		t.Fatalf("expected 2 pongs within the per source burst, got %v", pongs)
+	}
+	if pongs := pings(b, 2); pongs != 1 {
+		t.Fatalf("expected 1 pong within the global burst, got %v", pongs)
+	}
+	if drops := l.Stats().RateLimitDrops; drops.OfflinePerSource != 3 || drops.Offline != 1 || drops.Connected != 0 {
+		t.Fatalf("unexpected drops %+v", drops)
+	}
+	clock.Advance(time.Second)
+	if pongs := pings(a, 1); pongs != 1 {
+		t.Fatalf("expected a pong once the buckets refilled, got %v", pongs)
+	}
+}
+
+func TestAckRateLimit(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	conn := p.B()
+	conn.ackLimit = RateLimit{Rate: 1, Burst: 2}
+	for i := 0; i < 3; i++ {
+		b := bytes.NewBuffer(nil)
+		_ = (datagramHeader{flags: bitFlagValid | bitFlagACK}).write(b)
+		_ = (&acknowledgement{packets: []uint24{uint24(i)}}).write(b)
+		if err := conn.receive(b); err != nil {
+			t.Fatalf("error receiving ACK: %v", err)
+		}
+	}
+	if acks, drops := conn.counters.acksReceived, conn.counters.rateLimitAcks; acks != 2 || drops != 1 {
+		t.Fatalf("expected 2 ACKs processed and 1 dropped, got %v and %v", acks, drops)
+	}
+}
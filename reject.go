@@ -0,0 +1,150 @@
+package raknet
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// RejectReason is the reason that a Listener rejected a packet or connection.
+type RejectReason int
+
+const (
+	// RejectInvalidPacket means a handshake packet could not be decoded.
+	RejectInvalidPacket RejectReason = iota
+	// RejectIncompatibleProtocol means a client attempted to connect using an incompatible protocol version.
+	RejectIncompatibleProtocol
+	// RejectHandshakeTimeout means a client did not complete the connection sequence in time.
+	RejectHandshakeTimeout
+	// RejectUnknownPacket means an offline packet with an unknown ID was received.
+	RejectUnknownPacket
+	// RejectOversizedDatagram means a datagram was received that was too big for the listener to read in
+	// full.
+	RejectOversizedDatagram
+	// RejectMemoryLimit means a connection was closed because the memory held by the connections of the
+	// listener exceeded the maximum memory.
+	RejectMemoryLimit
+	// RejectInvalidMagic means an offline packet was received that did not hold the offline message magic
+	// of the listener.
+	RejectInvalidMagic
+	// RejectSecurityFailed means a client did not complete the key exchange of a secured connection.
+	RejectSecurityFailed
+	// RejectInvalidProxyHeader means a datagram was dropped because it did not hold a valid PROXY protocol
+	// header while one was required.
+	RejectInvalidProxyHeader
+	// RejectAlreadyConnected means a client attempted to connect while its address or GUID already had a
+	// connection with the listener.
+	RejectAlreadyConnected
+	// RejectServerFull means a client attempted to connect while the listener held its maximum amount of
+	// connections, or was not admitted by ListenConfig.Admit.
+	RejectServerFull
+	// RejectSplitLimit means a connection was closed because it sent a split packet with more fragments than
+	// allowed, or because the split packets it was reassembling held more memory than allowed.
+	RejectSplitLimit
+	// RejectBanned means a datagram was dropped because its IP address was banned, or a client was refused
+	// because its GUID was banned.
+	RejectBanned
+	// RejectAmplification means an unconnected ping was not answered because it was smaller than the
+	// minimum ping size, or because the pong would exceed the maximum pong amplification.
+	RejectAmplification
+)
+
+// String returns a short reason code, such as "incompatible_protocol", that is suitable for log files that
+// are parsed by other tools.
+func (reason RejectReason) String() string {
+	switch reason {
+	case RejectInvalidPacket:
+		return "invalid_packet"
+	case RejectIncompatibleProtocol:
+		return "incompatible_protocol"
+	case RejectHandshakeTimeout:
+		return "handshake_timeout"
+	case RejectUnknownPacket:
+		return "unknown_packet"
+	case RejectOversizedDatagram:
+		return "oversized_datagram"
+	case RejectMemoryLimit:
+		return "memory_limit"
+	case RejectInvalidMagic:
+		return "invalid_magic"
+	case RejectSecurityFailed:
+		return "security_failed"
+	case RejectInvalidProxyHeader:
+		return "invalid_proxy_header"
+	case RejectAlreadyConnected:
+		return "already_connected"
+	case RejectServerFull:
+		return "server_full"
+	case RejectSplitLimit:
+		return "split_limit"
+	case RejectBanned:
+		return "banned"
+	case RejectAmplification:
+		return "amplification"
+	}
+	return "unknown"
+}
+
+// Rejection describes a packet or connection that was rejected by a Listener.
+type Rejection struct {
+	// Reason is the reason that the packet or connection was rejected.
+	Reason RejectReason
+	// Time is the time at which the packet or connection was rejected.
+	Time time.Time
+	// Addr is the address of the client that the packet or connection was rejected from.
+	Addr net.Addr
+	// Err is the error describing the rejection, if any.
+	Err error
+}
+
+// handshakeRejectReasons maps the reasons that a handshake may fail to the reasons a rejection is reported
+// with.
+var handshakeRejectReasons = map[HandshakeFailureReason]RejectReason{
+	HandshakeInvalidPacket:        RejectInvalidPacket,
+	HandshakeIncompatibleProtocol: RejectIncompatibleProtocol,
+	HandshakeTimeout:              RejectHandshakeTimeout,
+	HandshakeSecurityFailed:       RejectSecurityFailed,
+}
+
+// reject calls the OnReject function of the listener, if set, for a packet or connection from the address
+// passed that was rejected for the reason passed.
+func (listener *Listener) reject(addr net.Addr, reason RejectReason, err error) {
+	listener.strike(addr, reason)
+	if listener.onReject == nil {
+		return
+	}
+	listener.onReject(Rejection{Reason: reason, Time: listener.config.clock.Now(), Addr: addr, Err: err})
+}
+
+// validMagic checks if the offline message with the ID passed, of which b holds the content following the ID,
+// holds the offline message magic of the listener, before the message is decoded. If not, false is
+// returned along with an error describing the rejection, which is nil if the listener drops such messages
+// silently. Messages too short to hold a magic are left to fail decoding, unless the magic is strict.
+func (listener *Listener) validMagic(id byte, b []byte, addr net.Addr) (bool, error) {
+	offset, name := magicOffset(id)
+	if len(b) < offset+len(listener.magic) {
+		if listener.listenConfig.StrictMagic {
+			listener.strike(addr, RejectInvalidMagic)
+			return false, nil
+		}
+		return true, nil
+	}
+	var m [16]byte
+	copy(m[:], b[offset:])
+	if m == listener.magic {
+		return true, nil
+	}
+	if listener.listenConfig.StrictMagic {
+		listener.strike(addr, RejectInvalidMagic)
+		return false, nil
+	}
+	return false, listener.invalidMagic(addr, name, m)
+}
+
+// invalidMagic rejects an offline packet with the name passed from the address passed, which held the magic
+// passed rather than the magic of the listener. It returns an error describing the rejection.
+func (listener *Listener) invalidMagic(addr net.Addr, name string, m [16]byte) error {
+	err := fmt.Errorf("error handling %v: invalid offline message magic %x", name, m)
+	listener.reject(addr, RejectInvalidMagic, err)
+	return err
+}
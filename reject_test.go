@@ -0,0 +1,110 @@
+package raknet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOnRejectUnknownPacket(t *testing.T) {
+	rejections := make(chan Rejection, 4)
+	l, err := ListenConfig{OnReject: func(r Rejection) { rejections <- r }}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing UDP: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{0x7f, 1, 2, 3}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	select {
+	case r := <-rejections:
+		if r.Reason != RejectUnknownPacket || r.Addr.String() != conn.LocalAddr().String() {
+			t.Fatalf("expected unknown packet rejection from %v, got %v from %v", conn.LocalAddr(), r.Reason, r.Addr)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnReject not called")
+	}
+}
+
+func TestOnRejectInvalidMagic(t *testing.T) {
+	rejections := make(chan Rejection, 16)
+	custom := [16]byte{0xde, 0xad, 0xbe, 0xef}
+	l, err := ListenConfig{Magic: custom, OnReject: func(r Rejection) { rejections <- r }}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	if _, err := (Dialer{Magic: custom}).Ping(l.Addr().String()); err != nil {
+		t.Fatalf("error pinging with the magic of the listener: %v", err)
+	}
+	conn, err := Dialer{Magic: custom}.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing with the magic of the listener: %v", err)
+	}
+	_ = conn.Close()
+
+	udp, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing UDP: %v", err)
+	}
+	defer udp.Close()
+	ping := append([]byte{idUnconnectedPing, 0, 0, 0, 0, 0, 0, 0, 1}, append(magic[:], 0, 0, 0, 0, 0, 0, 0, 1)...)
+	if _, err := udp.Write(ping); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	select {
+	case r := <-rejections:
+		if r.Reason != RejectInvalidMagic {
+			t.Fatalf("expected invalid magic rejection, got %v: %v", r.Reason, r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnReject not called")
+	}
+}
+
+func TestStrictMagic(t *testing.T) {
+	rejections := make(chan Rejection, 16)
+	l, err := ListenConfig{StrictMagic: true, OnReject: func(r Rejection) { rejections <- r }}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	udp, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing UDP: %v", err)
+	}
+	defer udp.Close()
+	wrong := [16]byte{0xde, 0xad, 0xbe, 0xef}
+	for _, b := range [][]byte{
+		append([]byte{idUnconnectedPing, 0, 0, 0, 0, 0, 0, 0, 1}, append(wrong[:], 0, 0, 0, 0, 0, 0, 0, 1)...),
+		append(append([]byte{idOpenConnectionRequest1}, wrong[:]...), MinecraftProtocol),
+		{idOpenConnectionRequest2, 1, 2, 3},
+	} {
+		if _, err := udp.Write(b); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	_ = udp.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if _, err := udp.Read(make([]byte, 1500)); err == nil {
+		t.Fatalf("expected no reply to offline messages with an invalid magic")
+	}
+	select {
+	case r := <-rejections:
+		t.Fatalf("expected offline messages with an invalid magic to be dropped silently, got %v: %v", r.Reason, r.Err)
+	default:
+	}
+	if failures := l.Stats().HandshakeFailures; failures != 0 {
+		t.Fatalf("expected no handshake failures, got %v", failures)
+	}
+	if _, err := Ping(l.Addr().String()); err != nil {
+		t.Fatalf("error pinging with the magic of the listener: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package raknet
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RelayConfig may be used to pass additional configuration to a Relay. The zero value of RelayConfig is
+// valid and is used by the ListenRelay function.
+type RelayConfig struct {
+	// IdleTimeout is the time after which the mapping of a client is removed if no datagrams were relayed
+	// for it in either direction. A client sending datagrams after that gets a new mapping.
+	// IdleTimeout is 30 seconds by default.
+	IdleTimeout time.Duration
+	// MaxMappings is the maximum amount of clients relayed at the same time. Datagrams of new clients are
+	// dropped while the maximum is reached.
+	// MaxMappings is 0 by default, meaning the amount of clients is not limited.
+	MaxMappings int
+	// Filter is called for every datagram received from a client before it is relayed. If it returns false,
+	// the datagram is dropped. Filter is called from the goroutine reading datagrams and must therefore not
+	// block.
+	// Filter is nil by default, meaning all datagrams are relayed.
+	Filter func(addr net.Addr, b []byte) bool
+	// ProxyHeader makes the Relay prepend a PROXY protocol v2 header with the address of the client to every
+	// datagram relayed to the backend, so that a Listener with the Relay in its
+	// ListenConfig.TrustedProxies sees the addresses of the clients rather than that of the Relay.
+	// ProxyHeader is false by default, meaning datagrams are relayed as they are.
+	ProxyHeader bool
+	// Clock is the Clock used to expire idle mappings.
+	// Clock is nil by default, meaning SystemClock is used.
+	Clock Clock
+}
+
+// Relay forwards raw datagrams between clients and a backend address without terminating RakNet, for
+// example to run a frontend that filters traffic before it reaches a server. Each client is mapped to a
+// socket of its own that datagrams are sent to the backend from, so that the backend can tell clients
+// apart, much like a NAT does.
+type Relay struct {
+	conn    net.PacketConn
+	backend *net.UDPAddr
+	config  RelayConfig
+
+	mu       sync.Mutex
+	mappings map[string]*relayMapping
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// RelayMapping holds the statistics of the mapping of a client relayed by a Relay.
+type RelayMapping struct {
+	// ClientAddr is the address of the client.
+	ClientAddr net.Addr
+	// LocalAddr is the address of the socket that datagrams of the client are sent to the backend from.
+	LocalAddr net.Addr
+	// DatagramsIn and BytesIn are the amount of datagrams and bytes relayed from the client to the backend.
+	DatagramsIn, BytesIn uint64
+	// DatagramsOut and BytesOut are the amount of datagrams and bytes relayed from the backend to the client.
+	DatagramsOut, BytesOut uint64
+	// LastActive is the time at which a datagram was last relayed in either direction.
+	LastActive time.Time
+}
+
+// relayMapping is the mapping of a client to the socket that its datagrams are relayed to the backend from.
+type relayMapping struct {
+	client   net.Addr
+	upstream *net.UDPConn
+
+	datagramsIn, bytesIn   uint64
+	datagramsOut, bytesOut uint64
+	// lastActive is the time of the last datagram relayed, in nanoseconds since the Unix epoch.
+	lastActive int64
+}
+
+// ListenRelay listens on the address passed and relays all datagrams received to the backend address passed,
+// and datagrams sent back by the backend to the clients. If not successful, an error is returned.
+func ListenRelay(address, backend string) (*Relay, error) {
+	return RelayConfig{}.Listen(address, backend)
+}
+
+// Listen listens on the address passed and relays all datagrams received to the backend address passed,
+// and datagrams sent back by the backend to the clients. If not successful, an error is returned.
+// Listen fills out any values of the RelayConfig left as their empty values with the default values of those
+// fields.
+func (config RelayConfig) Listen(address, backend string) (*Relay, error) {
+	backendAddr, err := net.ResolveUDPAddr("udp", backend)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving backend address: %v", err)
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error creating UDP listener: %v", err)
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = time.Second * 30
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	relay := &Relay{
+		conn:     conn,
+		backend:  backendAddr,
+		config:   config,
+		mappings: make(map[string]*relayMapping),
+		closed:   make(chan struct{}),
+	}
+	relay.wg.Add(2)
+	go relay.listen()
+	go relay.expire()
+	return relay, nil
+}
+
+// Addr returns the address that the Relay listens on for clients.
+func (relay *Relay) Addr() net.Addr {
+	return relay.conn.LocalAddr()
+}
+
+// Mappings returns the statistics of all clients currently relayed.
+func (relay *Relay) Mappings() []RelayMapping {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	mappings := make([]RelayMapping, 0, len(relay.mappings))
+	for _, m := range relay.mappings {
+		mappings = append(mappings, RelayMapping{
+			ClientAddr:   m.client,
+			LocalAddr:    m.upstream.LocalAddr(),
+			DatagramsIn:  atomic.LoadUint64(&m.datagramsIn),
+			BytesIn:      atomic.LoadUint64(&m.bytesIn),
+			DatagramsOut: atomic.LoadUint64(&m.datagramsOut),
+			BytesOut:     atomic.LoadUint64(&m.bytesOut),
+			LastActive:   time.Unix(0, atomic.LoadInt64(&m.lastActive)),
+		})
+	}
+	return mappings
+}
+
+// Close closes the Relay and the sockets of all of its mappings, and waits for its goroutines to exit.
+func (relay *Relay) Close() error {
+	var err error
+	relay.closeOnce.Do(func() {
+		close(relay.closed)
+		err = relay.conn.Close()
+
+		relay.mu.Lock()
+		for key, m := range relay.mappings {
+			_ = m.upstream.Close()
+			delete(relay.mappings, key)
+		}
+		relay.mu.Unlock()
+		relay.wg.Wait()
+	})
+	return err
+}
+
+// listen reads datagrams from clients and relays them to the backend until the Relay is closed.
+func (relay *Relay) listen() {
+	defer relay.wg.Done()
+	b := make([]byte, maxMTUSize+proxyMaxHeaderSize)
+	for {
+		n, addr, err := relay.conn.ReadFrom(b[proxyMaxHeaderSize:])
+		if err != nil {
+			return
+		}
+		data := b[proxyMaxHeaderSize : proxyMaxHeaderSize+n]
+		if relay.config.Filter != nil && !relay.config.Filter(addr, data) {
+			continue
+		}
+		m := relay.mapping(addr)
+		if m == nil {
+			continue
+		}
+		if relay.config.ProxyHeader {
+			// The header is written in front of the datagram, in the space left at the start of the buffer.
+			header := appendProxyHeader(make([]byte, 0, 52), addr.(*net.UDPAddr), relay.conn.LocalAddr().(*net.UDPAddr))
+			data = b[proxyMaxHeaderSize-len(header) : proxyMaxHeaderSize+n]
+			copy(data, header)
+		}
+		if _, err := m.upstream.Write(data); err != nil {
+			continue
+		}
+		atomic.AddUint64(&m.datagramsIn, 1)
+		atomic.AddUint64(&m.bytesIn, uint64(n))
+		atomic.StoreInt64(&m.lastActive, relay.config.Clock.Now().UnixNano())
+	}
+}
+
+// mapping returns the mapping of the client with the address passed, creating one if it does not yet exist.
+// It returns nil if no mapping could be created.
+func (relay *Relay) mapping(addr net.Addr) *relayMapping {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	if m, ok := relay.mappings[addr.String()]; ok {
+		return m
+	}
+	if relay.config.MaxMappings > 0 && len(relay.mappings) >= relay.config.MaxMappings {
+		return nil
+	}
+	select {
+	case <-relay.closed:
+		return nil
+	default:
+	}
+	upstream, err := net.DialUDP("udp", nil, relay.backend)
+	if err != nil {
+		return nil
+	}
+	m := &relayMapping{client: addr, upstream: upstream, lastActive: relay.config.Clock.Now().UnixNano()}
+	relay.mappings[addr.String()] = m
+	relay.wg.Add(1)
+	go relay.relayBack(m)
+	return m
+}
+
+// relayBack reads datagrams sent by the backend to the socket of the mapping passed and relays them to its
+// client, until the socket is closed.
+func (relay *Relay) relayBack(m *relayMapping) {
+	defer relay.wg.Done()
+	b := make([]byte, maxMTUSize)
+	for {
+		n, err := m.upstream.Read(b)
+		if err != nil {
+			return
+		}
+		if _, err := relay.conn.WriteTo(b[:n], m.client); err != nil {
+			continue
+		}
+		atomic.AddUint64(&m.datagramsOut, 1)
+		atomic.AddUint64(&m.bytesOut, uint64(n))
+		atomic.StoreInt64(&m.lastActive, relay.config.Clock.Now().UnixNano())
+	}
+}
+
+// expire removes mappings that have been idle for longer than the idle timeout, until the Relay is closed.
+func (relay *Relay) expire() {
+	defer relay.wg.Done()
+	ticker := relay.config.Clock.NewTicker(relay.config.IdleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-relay.closed:
+			return
+		case <-ticker.C():
+			now := relay.config.Clock.Now()
+			relay.mu.Lock()
+			for key, m := range relay.mappings {
+				if now.Sub(time.Unix(0, atomic.LoadInt64(&m.lastActive))) > relay.config.IdleTimeout {
+					_ = m.upstream.Close()
+					delete(relay.mappings, key)
+				}
+			}
+			relay.mu.Unlock()
+		}
+	}
+}
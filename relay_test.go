@@ -0,0 +1,61 @@
+package raknet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	l, err := ListenConfig{TrustedProxies: []*net.IPNet{loopback}, RequireProxyHeader: true}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	clock := NewManualClock(time.Now())
+	relay, err := RelayConfig{ProxyHeader: true, IdleTimeout: time.Minute, Clock: clock}.Listen("127.0.0.1:0", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error listening for relay: %v", err)
+	}
+	defer relay.Close()
+
+	c, err := Dial(relay.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing through relay: %v", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	if server.RemoteAddr().String() != c.LocalAddr().String() {
+		t.Fatalf("expected remote address %v, got %v", c.LocalAddr(), server.RemoteAddr())
+	}
+	_ = c.Close()
+
+	mappings := relay.Mappings()
+	if len(mappings) != 1 || mappings[0].DatagramsIn == 0 || mappings[0].DatagramsOut == 0 {
+		t.Fatalf("expected a single mapping with datagrams relayed both ways, got %+v", mappings)
+	}
+	// Datagrams of the closed client may still arrive and create a new mapping, so we only check that the
+	// socket of the idle mapping is no longer used.
+	local := mappings[0].LocalAddr.String()
+	clock.Advance(time.Minute * 2)
+	deadline := time.Now().Add(time.Second)
+	for hasRelayMapping(relay, local) {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle mapping was not removed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// hasRelayMapping checks if the relay passed has a mapping with the local address passed.
+func hasRelayMapping(relay *Relay, local string) bool {
+	for _, m := range relay.Mappings() {
+		if m.LocalAddr.String() == local {
+			return true
+		}
+	}
+	return false
+}
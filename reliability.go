@@ -0,0 +1,46 @@
+package raknet
+
+import "fmt"
+
+// Reliability specifies the guarantees with which a message written using Conn.WriteReliability is
+// delivered to the other end of the connection.
+type Reliability byte
+
+const (
+	// Unreliable messages may be lost, duplicated or arrive out of order. They are never resent, which makes
+	// them suitable for high frequency messages that are outdated quickly.
+	Unreliable = Reliability(reliabilityUnreliable)
+	// UnreliableSequenced messages may be lost, but are never handled after a sequenced message written
+	// after them: Messages that arrive late are dropped.
+	UnreliableSequenced = Reliability(reliabilityUnreliableSequenced)
+	// Reliable messages are resent until they arrive, but may arrive out of order.
+	Reliable = Reliability(reliabilityReliable)
+	// ReliableOrdered messages are resent until they arrive and are handled once, in the order in which they
+	// were written. It is the reliability used by Conn.Write.
+	ReliableOrdered = Reliability(reliabilityReliableOrdered)
+	// ReliableSequenced messages are resent until they arrive, but are never handled after a sequenced
+	// message written after them: Messages that arrive late are dropped.
+	ReliableSequenced = Reliability(reliabilityReliableSequenced)
+)
+
+// String returns the name of the reliability, such as "ReliableOrdered".
+func (r Reliability) String() string {
+	switch r {
+	case Unreliable:
+		return "Unreliable"
+	case UnreliableSequenced:
+		return "UnreliableSequenced"
+	case Reliable:
+		return "Reliable"
+	case ReliableOrdered:
+		return "ReliableOrdered"
+	case ReliableSequenced:
+		return "ReliableSequenced"
+	}
+	return fmt.Sprintf("Reliability(%d)", byte(r))
+}
+
+// reliable checks if messages with the reliability are resent until they arrive.
+func (r Reliability) reliable() bool {
+	return byte(r) >= reliabilityReliable
+}
@@ -0,0 +1,123 @@
+package raknet
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentWrite writes messages of various reliabilities and sizes to a Conn from many goroutines at
+// once and checks that every reliable message arrives intact and that the ordered messages of each goroutine
+// arrive in the order in which it wrote them. It is meant to be run with -race.
+func TestConcurrentWrite(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+
+	// The amount of messages written is kept below the size of the read queue, so that the reader never
+	// falls behind far enough to be disconnected as a slow consumer.
+	const writers, messages = 8, 50
+	wg := &sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				// Every tenth message is large enough to be split, so that fragments of messages written
+				// simultaneously would be interleaved if writes were not atomic.
+				size := 10
+				if i%10 == 0 {
+					size = 5000
+				}
+				msg := make([]byte, size)
+				msg[0] = 0xfe
+				msg[1] = byte(w)
+				binary.BigEndian.PutUint32(msg[2:], uint32(i))
+				for j := 6; j < size; j++ {
+					msg[j] = byte(w + i + j)
+				}
+				reliability := ReliableOrdered
+				if i%3 == 0 {
+					reliability = Reliable
+				}
+				if _, err := a.WriteReliability(msg, reliability); err != nil {
+					t.Errorf("error writing: %v", err)
+					return
+				}
+				if _, err := a.WriteReliability([]byte{0xfd, byte(w)}, UnreliableSequenced); err != nil {
+					t.Errorf("error writing: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	next := make([]uint32, writers)
+	received := 0
+	buf := make([]byte, 10000)
+	_ = b.SetReadDeadline(time.Now().Add(time.Second * 10))
+	for received < writers*messages {
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatalf("error reading after %v messages: %v", received, err)
+		}
+		if buf[0] == 0xfd {
+			continue
+		}
+		w, i := int(buf[1]), binary.BigEndian.Uint32(buf[2:])
+		for j := 6; j < n; j++ {
+			if buf[j] != byte(w+int(i)+j) {
+				t.Fatalf("message %v of writer %v was corrupted at byte %v", i, w, j)
+			}
+		}
+		if i%3 != 0 {
+			if i < next[w] {
+				t.Fatalf("ordered message %v of writer %v arrived after message %v", i, w, next[w])
+			}
+			next[w] = i
+		}
+		received++
+	}
+	wg.Wait()
+}
+
+func TestWriteReliabilitySequenced(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	a, b := p.A(), p.B()
+
+	// Sequenced messages that arrive after a later one must be dropped.
+	for i := 0; i < 3; i++ {
+		if _, err := a.WriteReliability([]byte{0xfe, byte(i)}, UnreliableSequenced); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	a.writeLock.Lock()
+	packets := a.sendDatagram.packets
+	a.sendDatagram.packets = []*packet{packets[0], packets[2], packets[1]}
+	a.writeLock.Unlock()
+	p.Step()
+
+	for _, expected := range []byte{0, 2} {
+		select {
+		case packet := <-b.packetChan:
+			if packet.Bytes()[1] != expected {
+				t.Fatalf("expected sequenced message %v, got %v", expected, packet.Bytes()[1])
+			}
+		default:
+			t.Fatalf("sequenced message %v not received", expected)
+		}
+	}
+	select {
+	case packet := <-b.packetChan:
+		t.Fatalf("expected outdated sequenced message to be dropped, got %x", packet.Bytes())
+	default:
+	}
+
+	if _, err := a.WriteReliability([]byte{0xfe}, Reliability(5)); err == nil {
+		t.Fatalf("expected invalid reliability to be rejected")
+	}
+	if name := UnreliableSequenced.String(); name != "UnreliableSequenced" {
+		t.Fatalf("unexpected name of reliability: %v", name)
+	}
+}
@@ -0,0 +1,95 @@
+package raknet
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReceiveWindow(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	conn := p.B()
+	receive := func(seq uint24) error {
+		b := bytes.NewBuffer(nil)
+		if err := (datagramHeader{flags: bitFlagValid, sequenceNumber: seq}).write(b); err != nil {
+			t.Fatalf("error writing datagram header: %v", err)
+		}
+		return conn.receive(b)
+	}
+	if err := receive(0); err != nil {
+		t.Fatalf("error receiving datagram: %v", err)
+	}
+	// Dropped datagrams are only counted: Returning an error would have them logged, which would let anyone
+	// able to spoof the address of the connection fill the log.
+	if err := receive(0); err != nil {
+		t.Fatalf("expected replayed datagram to be dropped without error, got %v", err)
+	}
+	if err := receive(receiveWindow + 1); err != nil {
+		t.Fatalf("expected datagram beyond the receive window to be dropped without error, got %v", err)
+	}
+	if n := conn.datagramRecvQueue.Len(); n != 0 {
+		t.Fatalf("expected no datagrams tracked, got %v", n)
+	}
+	if err := receive(receiveWindow); err != nil {
+		t.Fatalf("error receiving datagram at the end of the window: %v", err)
+	}
+	if dup := conn.Stats().DuplicateDatagrams; dup != 2 {
+		t.Fatalf("expected 2 datagrams dropped, got %v", dup)
+	}
+}
+
+// warnCounter is a Logger that counts the records logged at the warn level or higher.
+type warnCounter struct {
+	n int64
+}
+
+func (l *warnCounter) Debug(string, ...interface{}) {}
+func (l *warnCounter) Info(string, ...interface{})  {}
+func (l *warnCounter) Warn(string, ...interface{})  { atomic.AddInt64(&l.n, 1) }
+func (l *warnCounter) Error(string, ...interface{}) { atomic.AddInt64(&l.n, 1) }
+
+func TestReceiveWindowNotLogged(t *testing.T) {
+	logger := &warnCounter{}
+	l, err := ListenConfig{Logger: logger}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	server := c.(*Conn)
+
+	// Datagrams already received and datagrams far beyond the receive window are sent from the address of
+	// the client.
+	const n = 50
+	for i := 0; i < n; i++ {
+		b := bytes.NewBuffer(nil)
+		seq := uint24(0)
+		if i%2 == 1 {
+			seq = receiveWindow * 4
+		}
+		_ = (datagramHeader{flags: bitFlagValid, sequenceNumber: seq}).write(b)
+		if _, err := client.conn.WriteTo(b.Bytes(), client.addr); err != nil {
+			t.Fatalf("error writing datagram: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for server.Stats().DuplicateDatagrams < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v datagrams to be dropped, got %v", n, server.Stats().DuplicateDatagrams)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if warnings := atomic.LoadInt64(&logger.n); warnings != 0 {
+		t.Fatalf("expected dropped datagrams not to be logged, got %v records", warnings)
+	}
+}
@@ -0,0 +1,138 @@
+package raknet
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// resourceTracking is 1 if TrackResources was called to enable resource tracking, and 0 otherwise.
+var resourceTracking int32
+
+// trackedConns, trackedTimers and trackedBuffers hold the amount of resources created and released by
+// connections created while resource tracking was enabled.
+var trackedConns, trackedTimers, trackedBuffers resourceCounter
+
+// TrackResources enables or disables tracking of the internal resources created and released by
+// connections: The connections themselves, the timers they schedule to flush packets and the pooled buffers
+// they hold write batches and fragments of split packets in. Tracking is disabled by default, as it adds
+// atomic operations to the hot path. It is meant for long running soak tests, which may compare the
+// result of TrackedResources before and after a run to find resources that are never released:
+//
+//	raknet.TrackResources(true)
+//	baseline := raknet.TrackedResources()
+//	// Run the soak test and close all connections...
+//	if err := raknet.TrackedResources().Since(baseline).Err(); err != nil {
+//		panic(err)
+//	}
+//
+// Only the resources of connections created while tracking is enabled are counted, including those
+// released after tracking is disabled again. Connections created before tracking was enabled are not
+// counted at all.
+func TrackResources(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&resourceTracking, 1)
+		return
+	}
+	atomic.StoreInt32(&resourceTracking, 0)
+}
+
+// TrackedResources returns the amount of resources created and released by tracked connections since the
+// start of the process.
+func TrackedResources() Resources {
+	return Resources{Conns: trackedConns.load(), Timers: trackedTimers.load(), Buffers: trackedBuffers.load()}
+}
+
+// Resources holds the amount of internal resources created and released by connections, as returned by
+// TrackedResources.
+type Resources struct {
+	// Conns counts connections. A connection is released once it is closed, either by calling Conn.Close or
+	// by the connection timing out or being closed by the other end.
+	Conns ResourceCount
+	// Timers counts timers scheduled to flush pending packets or write batches of datagrams. A timer is
+	// released once it fires or is stopped when the connection is closed.
+	Timers ResourceCount
+	// Buffers counts pooled buffers held by connections for datagrams in write batches and for fragments of
+	// split packets being reassembled. Fragments of split packets that never complete remain outstanding,
+	// even after the connection is closed.
+	Buffers ResourceCount
+}
+
+// Since returns the resources created and released since the baseline passed was taken.
+func (r Resources) Since(baseline Resources) Resources {
+	return Resources{
+		Conns:   r.Conns.since(baseline.Conns),
+		Timers:  r.Timers.since(baseline.Timers),
+		Buffers: r.Buffers.since(baseline.Buffers),
+	}
+}
+
+// Err returns an error describing the resources that are outstanding, or nil if all resources created were
+// also released. It should only be called once all tracked connections were closed and their goroutines
+// finished, as resources of connections still open are reported as outstanding.
+func (r Resources) Err() error {
+	var imbalances []string
+	for _, res := range []struct {
+		name  string
+		count ResourceCount
+	}{{"conns", r.Conns}, {"timers", r.Timers}, {"buffers", r.Buffers}} {
+		if n := res.count.Outstanding(); n != 0 {
+			imbalances = append(imbalances, fmt.Sprintf("%v %v (%v created, %v released)", n, res.name, res.count.Created, res.count.Released))
+		}
+	}
+	if len(imbalances) == 0 {
+		return nil
+	}
+	return fmt.Errorf("resources outstanding: %v", strings.Join(imbalances, ", "))
+}
+
+// String returns a summary of the resources, such as 'conns: 10/10, timers: 50/50, buffers: 80/78', in which
+// each pair holds the amount created and released.
+func (r Resources) String() string {
+	return fmt.Sprintf("conns: %v/%v, timers: %v/%v, buffers: %v/%v", r.Conns.Created, r.Conns.Released, r.Timers.Created, r.Timers.Released, r.Buffers.Created, r.Buffers.Released)
+}
+
+// ResourceCount holds the amount of a single kind of resource that was created and released.
+type ResourceCount struct {
+	Created, Released uint64
+}
+
+// Outstanding returns the amount of resources that were created but not released.
+func (c ResourceCount) Outstanding() int64 {
+	return int64(c.Created - c.Released)
+}
+
+// since returns the amount of resources created and released since the baseline passed was taken.
+func (c ResourceCount) since(baseline ResourceCount) ResourceCount {
+	return ResourceCount{Created: c.Created - baseline.Created, Released: c.Released - baseline.Released}
+}
+
+// resourcesTracked checks if resource tracking is currently enabled.
+func resourcesTracked() bool {
+	return atomic.LoadInt32(&resourceTracking) == 1
+}
+
+// resourceCounter counts the creation and release of a single kind of resource by connections that are
+// tracked.
+type resourceCounter struct {
+	created, released uint64
+}
+
+// create records the creation of n resources if tracked is true.
+func (c *resourceCounter) create(tracked bool, n int) {
+	if tracked {
+		atomic.AddUint64(&c.created, uint64(n))
+	}
+}
+
+// release records the release of n resources if tracked is true.
+func (c *resourceCounter) release(tracked bool, n int) {
+	if tracked {
+		atomic.AddUint64(&c.released, uint64(n))
+	}
+}
+
+// load returns the amount of resources created and released so far.
+func (c *resourceCounter) load() ResourceCount {
+	return ResourceCount{Created: atomic.LoadUint64(&c.created), Released: atomic.LoadUint64(&c.released)}
+}
@@ -0,0 +1,56 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTrackResources(t *testing.T) {
+	TrackResources(true)
+	defer TrackResources(false)
+	baseline := TrackedResources()
+
+	a, b := Pipe()
+	for _, payload := range [][]byte{{0xfe, 1}, bytes.Repeat([]byte{0xfe}, 5000)} {
+		if _, err := a.Write(payload); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		_ = b.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := b.Read(make([]byte, 10000)); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+	}
+	_ = a.Close()
+	_ = b.Close()
+	a.goroutines.Wait()
+	b.goroutines.Wait()
+
+	r := TrackedResources().Since(baseline)
+	if r.Conns.Created != 2 || r.Timers.Created == 0 || r.Buffers.Created == 0 {
+		t.Fatalf("expected conns, timers and buffers to be tracked, got %v", r)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatalf("expected no outstanding resources after closing both ends: %v", err)
+	}
+}
+
+func TestTrackResourcesLeak(t *testing.T) {
+	TrackResources(true)
+	defer TrackResources(false)
+	baseline := TrackedResources()
+
+	bomb := newSplitBomb(t)
+	if err := bomb.send(2, 1, 0, []byte{0xfe}); err != nil {
+		t.Fatalf("error sending fragment: %v", err)
+	}
+	_ = bomb.conn.Close()
+
+	r := TrackedResources().Since(baseline)
+	if r.Buffers.Outstanding() != 1 {
+		t.Fatalf("expected the fragment of the split packet that never completed to be outstanding, got %v", r)
+	}
+	if r.Err() == nil {
+		t.Fatalf("expected an error reporting the outstanding fragment")
+	}
+}
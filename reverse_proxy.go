@@ -0,0 +1,148 @@
+package raknet
+
+import (
+	"net"
+	"sync"
+)
+
+// ProxyConfig may be used to pass additional configuration to a Proxy. The zero value of ProxyConfig is
+// valid and is used by the ListenProxy function.
+type ProxyConfig struct {
+	// ListenConfig is the configuration of the Listener that clients connect to.
+	ListenConfig ListenConfig
+	// Dialer is the Dialer used to connect to the upstream server for every client.
+	Dialer Dialer
+	// OnConnect is called once a client connected and the connection to the upstream server for it was
+	// established, before any packets are relayed between them. If it returns an error, both connections are
+	// closed.
+	// OnConnect is nil by default.
+	OnConnect func(client, server *Conn) error
+	// Inspect is called for every packet relayed, with the connection of the client that it is relayed for
+	// and the direction it is relayed in: Inbound for packets sent by the client, Outbound for packets sent
+	// to the client. It returns the packet to relay, which may be b itself or a modified packet, or nil to
+	// drop the packet. Inspect is called concurrently for different connections and directions.
+	// Inspect is nil by default, meaning all packets are relayed as they are.
+	Inspect func(client *Conn, direction Direction, b []byte) []byte
+}
+
+// Proxy is a RakNet reverse proxy. It accepts clients using a Listener, dials the upstream server for every
+// client and relays packets between both connections. The pong data of the upstream server is forwarded to
+// clients pinging the Proxy.
+type Proxy struct {
+	listener *Listener
+	upstream string
+	config   ProxyConfig
+
+	wg sync.WaitGroup
+}
+
+// ListenProxy listens for clients on the address passed and proxies them to the upstream server at the
+// address passed. If not successful, an error is returned.
+func ListenProxy(address, upstream string) (*Proxy, error) {
+	return ProxyConfig{}.Listen(address, upstream)
+}
+
+// Listen listens for clients on the address passed and proxies them to the upstream server at the address
+// passed. If not successful, an error is returned.
+func (config ProxyConfig) Listen(address, upstream string) (*Proxy, error) {
+	listener, err := config.ListenConfig.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	if err := listener.HijackPong(upstream); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	proxy := &Proxy{listener: listener, upstream: upstream, config: config}
+	proxy.wg.Add(1)
+	go proxy.accept()
+	return proxy, nil
+}
+
+// Addr returns the address that the Proxy listens on for clients.
+func (proxy *Proxy) Addr() net.Addr {
+	return proxy.listener.Addr()
+}
+
+// Listener returns the Listener that clients of the Proxy connect to, so that its statistics and events may
+// be inspected.
+func (proxy *Proxy) Listener() *Listener {
+	return proxy.listener
+}
+
+// Close closes the Proxy, all connections of its clients and the connections to the upstream server, and
+// waits for its goroutines to exit.
+func (proxy *Proxy) Close() error {
+	err := proxy.listener.Close()
+	proxy.wg.Wait()
+	return err
+}
+
+// accept accepts clients until the Listener of the Proxy is closed.
+func (proxy *Proxy) accept() {
+	defer proxy.wg.Done()
+	for {
+		c, err := proxy.listener.Accept()
+		if err != nil {
+			return
+		}
+		proxy.wg.Add(1)
+		go proxy.serve(c.(*Conn))
+	}
+}
+
+// serve dials the upstream server for the client passed and relays packets between both connections until
+// either of them is closed.
+func (proxy *Proxy) serve(client *Conn) {
+	defer proxy.wg.Done()
+	server, err := proxy.config.Dialer.Dial(proxy.upstream)
+	if err != nil {
+		proxy.listener.logger().Warn("error dialing upstream server", "remote_addr", client.RemoteAddr(), "error", err)
+		_ = client.Close()
+		return
+	}
+	if proxy.config.OnConnect != nil {
+		if err := proxy.config.OnConnect(client, server); err != nil {
+			_ = client.CloseWithReason([]byte(err.Error()))
+			_ = server.Close()
+			return
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		proxy.relay(client, server, client, Inbound)
+	}()
+	go func() {
+		defer wg.Done()
+		proxy.relay(client, client, server, Outbound)
+	}()
+	wg.Wait()
+}
+
+// relay relays packets read from src to dst with the reliability they were received with, like Splice, until
+// either connection is closed, after which both are closed.
+func (proxy *Proxy) relay(client, dst, src *Conn, direction Direction) {
+	b := make([]byte, spliceBufferSize)
+	for {
+		res, err := src.read(b)
+		if err != nil {
+			closeSpliced(dst, src)
+			if !ErrConnectionClosed(err) {
+				proxy.listener.logger().Warn("error reading packet to relay", "remote_addr", client.RemoteAddr(), "direction", direction.String(), "error", err)
+			}
+			return
+		}
+		data := b[:res.n]
+		if proxy.config.Inspect != nil {
+			if data = proxy.config.Inspect(client, direction, data); data == nil {
+				continue
+			}
+		}
+		if _, err := dst.WriteReliability(data, res.enc.Reliability.withoutReceipt()); err != nil {
+			closeSpliced(dst, src)
+			return
+		}
+	}
+}
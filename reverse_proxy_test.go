@@ -0,0 +1,61 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// Echo all packets back to the client.
+				b := make([]byte, 1500)
+				for {
+					n, err := c.Read(b)
+					if err != nil {
+						return
+					}
+					_, _ = c.Write(b[:n])
+				}
+			}()
+		}
+	}()
+
+	proxy, err := ProxyConfig{Inspect: func(client *Conn, direction Direction, b []byte) []byte {
+		if direction == Outbound {
+			return append(b, 0xff)
+		}
+		return b
+	}}.Listen("127.0.0.1:0", l.Addr().String())
+	if err != nil {
+		t.Fatalf("error listening for proxy: %v", err)
+	}
+	defer proxy.Close()
+
+	c, err := Dial(proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing proxy: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte{0xfe, 1, 2, 3}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	b := make([]byte, 1500)
+	n, err := c.Read(b)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if expected := []byte{0xfe, 1, 2, 3, 0xff}; !bytes.Equal(b[:n], expected) {
+		t.Fatalf("expected %x, got %x", expected, b[:n])
+	}
+}
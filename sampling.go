@@ -0,0 +1,132 @@
+package raknet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sampling specifies which records of a stream of diagnostics, such as traced datagrams or log records, are
+// kept, so that verbose diagnostics may stay enabled in production without overwhelming I/O. Both limits may
+// be combined, in which case a record must pass both to be kept.
+// The zero value of Sampling keeps every record.
+type Sampling struct {
+	// Every makes only one in every N records be kept. If 0 or 1, every record is kept.
+	Every int
+	// PerSecond is the maximum amount of records kept per second. Records beyond that amount are dropped
+	// until the next second starts. If 0, there is no maximum.
+	PerSecond int
+}
+
+// sampler decides which records are kept according to a Sampling. A nil *sampler keeps every record.
+type sampler struct {
+	// n is the amount of records offered to the sampler. It is accessed atomically and is placed first in the
+	// struct to guarantee 64-bit alignment.
+	n uint64
+
+	sampling Sampling
+	clock    Clock
+
+	// mu guards windowStart and windowCount, which hold the start of the current second and the amount of
+	// records kept since.
+	mu          sync.Mutex
+	windowStart time.Time
+	windowCount int
+}
+
+// newSampler returns a sampler for the Sampling passed that uses the clock passed to cap the rate of records.
+// If the Sampling keeps every record, nil is returned.
+func newSampler(sampling Sampling, clock Clock) *sampler {
+	if sampling.Every <= 1 && sampling.PerSecond <= 0 {
+		return nil
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &sampler{sampling: sampling, clock: clock}
+}
+
+// sample reports if the next record should be kept.
+func (s *sampler) sample() bool {
+	if s == nil {
+		return true
+	}
+	if s.sampling.Every > 1 && (atomic.AddUint64(&s.n, 1)-1)%uint64(s.sampling.Every) != 0 {
+		return false
+	}
+	if s.sampling.PerSecond <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.clock.Now(); now.Sub(s.windowStart) >= time.Second {
+		s.windowStart, s.windowCount = now, 0
+	}
+	if s.windowCount >= s.sampling.PerSecond {
+		return false
+	}
+	s.windowCount++
+	return true
+}
+
+// SampleTrace returns a PacketTraceFunc that only passes the datagrams kept by the Sampling passed on to the
+// trace function passed. If trace is nil or the Sampling keeps every datagram, trace is returned as is.
+func SampleTrace(trace PacketTraceFunc, sampling Sampling) PacketTraceFunc {
+	return sampleTrace(trace, newSampler(sampling, nil))
+}
+
+// sampleTrace returns a PacketTraceFunc that only passes the datagrams kept by the sampler passed on to trace.
+func sampleTrace(trace PacketTraceFunc, s *sampler) PacketTraceFunc {
+	if trace == nil || s == nil {
+		return trace
+	}
+	return func(direction Direction, addr net.Addr, b []byte) {
+		if s.sample() {
+			trace(direction, addr, b)
+		}
+	}
+}
+
+// sampledLogger is a Logger that only passes the records kept by a sampler on to the Logger it wraps.
+type sampledLogger struct {
+	l Logger
+	s *sampler
+}
+
+// sampleLogger returns a Logger that only passes the records kept by the sampler passed on to l. If s is nil,
+// l is returned as is.
+func sampleLogger(l Logger, s *sampler) Logger {
+	if s == nil {
+		return l
+	}
+	return sampledLogger{l: l, s: s}
+}
+
+// Debug ...
+func (l sampledLogger) Debug(msg string, args ...interface{}) {
+	if l.s.sample() {
+		l.l.Debug(msg, args...)
+	}
+}
+
+// Info ...
+func (l sampledLogger) Info(msg string, args ...interface{}) {
+	if l.s.sample() {
+		l.l.Info(msg, args...)
+	}
+}
+
+// Warn ...
+func (l sampledLogger) Warn(msg string, args ...interface{}) {
+	if l.s.sample() {
+		l.l.Warn(msg, args...)
+	}
+}
+
+// Error ...
+func (l sampledLogger) Error(msg string, args ...interface{}) {
+	if l.s.sample() {
+		l.l.Error(msg, args...)
+	}
+}
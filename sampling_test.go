@@ -0,0 +1,33 @@
+package raknet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	count := func(s *sampler, n int) (kept int) {
+		for i := 0; i < n; i++ {
+			if s.sample() {
+				kept++
+			}
+		}
+		return kept
+	}
+	if s := newSampler(Sampling{}, nil); s != nil {
+		t.Fatalf("expected nil sampler for zero Sampling")
+	}
+	if kept := count(newSampler(Sampling{Every: 10}, nil), 100); kept != 10 {
+		t.Errorf("Every: 10 kept %v of 100 records, expected 10", kept)
+	}
+
+	clock := NewManualClock(time.Now())
+	s := newSampler(Sampling{PerSecond: 5}, clock)
+	if kept := count(s, 100); kept != 5 {
+		t.Errorf("PerSecond: 5 kept %v records in one second, expected 5", kept)
+	}
+	clock.Advance(time.Second)
+	if kept := count(s, 100); kept != 5 {
+		t.Errorf("PerSecond: 5 kept %v records in the next second, expected 5", kept)
+	}
+}
@@ -0,0 +1,320 @@
+package raknet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// securityKeySize is the size of the X25519 public keys exchanged during the connection sequence of a
+	// secured connection.
+	securityKeySize = 32
+	// securityOverhead is the amount of bytes that the encryption of a secured connection adds to each
+	// datagram: A marker byte, the 8-byte counter the nonce is derived from and the 16-byte authentication
+	// tag.
+	securityOverhead = 1 + 8 + 16
+	// securityHeaderSize is the size of the part of an encrypted datagram preceding the ciphertext.
+	securityHeaderSize = 1 + 8
+	// securityInfo is the info string used to derive the keys of a secured connection.
+	securityInfo = "raknet connection security"
+	// replayWindow is the amount of counters, up to and including the highest counter received, of which a
+	// session remembers if a datagram holding it was received. Datagrams with counters below the window are
+	// rejected, as it cannot be told if they are replays.
+	replayWindow = 1024
+)
+
+// errReplayed is returned by securitySession.open for a datagram of which the counter was received before or
+// is below the replay window. Such datagrams are authentic, but were replayed, for example by an attacker on
+// the path, and are dropped.
+var errReplayed = errors.New("datagram replayed")
+
+// listenerSecurity holds the key material of a Listener that secures its connections.
+type listenerSecurity struct {
+	// key is the long-term key of the Listener. Its public key is sent to clients in the open connection
+	// reply 1.
+	key *ecdh.PrivateKey
+}
+
+// newListenerSecurity returns a listenerSecurity for the private key passed. An error is returned if the key
+// is not an X25519 key.
+func newListenerSecurity(key *ecdh.PrivateKey) (*listenerSecurity, error) {
+	if key.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("security key must be an X25519 key")
+	}
+	return &listenerSecurity{key: key}, nil
+}
+
+// accept performs the server side of the key exchange with the challenge, the ephemeral public key, of a
+// client. It returns the session of the connection and the answer to send to the client, which is the
+// ephemeral public key of the server.
+func (s *listenerSecurity) accept(challenge []byte) (*securitySession, []byte, error) {
+	clientKey, err := ecdh.X25519().NewPublicKey(challenge)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid challenge: %v", err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating ephemeral key: %v", err)
+	}
+	static, err := s.key.ECDH(clientKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := ephemeral.ECDH(clientKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	answer := ephemeral.PublicKey().Bytes()
+	session, err := newSecuritySession(append(static, shared...), append(append([]byte(nil), challenge...), answer...), false)
+	return session, answer, err
+}
+
+// clientSecurity holds the key material of a client connecting to a Listener that secures its connections.
+type clientSecurity struct {
+	// serverKey is the long-term public key of the server, sent in the open connection reply 1.
+	serverKey *ecdh.PublicKey
+	// cookie is the cookie sent by the server in the open connection reply 1.
+	cookie uint32
+	// key is the ephemeral key of the client. Its public key is sent as the challenge in the open connection
+	// request 2.
+	key *ecdh.PrivateKey
+}
+
+// newClientSecurity returns a clientSecurity for the public key and cookie sent by a server, after checking
+// the key against the security settings of the Dialer: secure and the public key expected, which may be nil.
+func newClientSecurity(secure bool, expected *ecdh.PublicKey, serverKey []byte, cookie uint32) (*clientSecurity, error) {
+	if !secure && expected == nil {
+		return nil, fmt.Errorf("server requires encryption, but the dialer does not encrypt")
+	}
+	key, err := ecdh.X25519().NewPublicKey(serverKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server public key: %v", err)
+	}
+	if expected != nil && !expected.Equal(key) {
+		return nil, fmt.Errorf("server public key does not match the expected key")
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating ephemeral key: %v", err)
+	}
+	return &clientSecurity{serverKey: key, cookie: cookie, key: ephemeral}, nil
+}
+
+// challenge returns the challenge sent to the server in the open connection request 2.
+func (s *clientSecurity) challenge() []byte {
+	return s.key.PublicKey().Bytes()
+}
+
+// finish performs the client side of the key exchange with the answer of the server found in the open
+// connection reply 2, returning the session of the connection.
+func (s *clientSecurity) finish(answer []byte) (*securitySession, error) {
+	serverEphemeral, err := ecdh.X25519().NewPublicKey(answer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid answer: %v", err)
+	}
+	static, err := s.key.ECDH(s.serverKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := s.key.ECDH(serverEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	return newSecuritySession(append(static, shared...), append(s.challenge(), answer...), true)
+}
+
+// securitySession encrypts and decrypts the datagrams of a secured connection. Each direction has its own
+// key, and each datagram sent holds the counter that its nonce is derived from.
+type securitySession struct {
+	send, recv cipher.AEAD
+	counter    uint64
+
+	// highest is the highest counter of a datagram received. Bit n of seen is set if the datagram with the
+	// counter highest-n was received. Both are only used by open, which is never called concurrently.
+	highest uint64
+	seen    [replayWindow / 64]uint64
+}
+
+// newSecuritySession derives the keys of a session from the secret and salt passed. client specifies if the
+// session is that of the client, which decides which key is used for which direction.
+func newSecuritySession(secret, salt []byte, client bool) (*securitySession, error) {
+	keys := deriveKeys(secret, salt, 64)
+	clientKey, serverKey := keys[:32], keys[32:]
+	if !client {
+		clientKey, serverKey = serverKey, clientKey
+	}
+	send, err := newAEAD(clientKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := newAEAD(serverKey)
+	if err != nil {
+		return nil, err
+	}
+	return &securitySession{send: send, recv: recv}, nil
+}
+
+// newAEAD returns an AES-256-GCM AEAD using the key passed.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKeys derives n bytes of key material from the secret and salt passed using HKDF with SHA-256.
+func deriveKeys(secret, salt []byte, n int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	_, _ = extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var out, block []byte
+	for i := byte(1); len(out) < n; i++ {
+		expand := hmac.New(sha256.New, prk)
+		_, _ = expand.Write(block)
+		_, _ = expand.Write([]byte(securityInfo))
+		_, _ = expand.Write([]byte{i})
+		block = expand.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:n]
+}
+
+// seal encrypts the datagram b and appends the result to dst.
+func (s *securitySession) seal(dst, b []byte) []byte {
+	counter := atomic.AddUint64(&s.counter, 1)
+	var header [securityHeaderSize]byte
+	header[0] = bitFlagValid
+	binary.BigEndian.PutUint64(header[1:], counter)
+	return s.send.Seal(append(dst, header[:]...), s.nonce(counter), b, header[:])
+}
+
+// open decrypts the encrypted datagram b in place and returns the datagram. An error is returned if the
+// datagram was not encrypted with the key of the other end or was altered, and errReplayed if a datagram with
+// the same counter was received before.
+func (s *securitySession) open(b []byte) ([]byte, error) {
+	if len(b) < securityOverhead || b[0] != bitFlagValid {
+		return nil, fmt.Errorf("datagram is not encrypted")
+	}
+	counter := binary.BigEndian.Uint64(b[1:securityHeaderSize])
+	if s.replayed(counter) {
+		return nil, errReplayed
+	}
+	data, err := s.recv.Open(b[securityHeaderSize:securityHeaderSize], s.nonce(counter), b[securityHeaderSize:], b[:securityHeaderSize])
+	if err != nil {
+		return nil, err
+	}
+	// The counter is only marked as received once the datagram was authenticated, so that forged datagrams
+	// cannot move the window.
+	s.receive(counter)
+	return data, nil
+}
+
+// replayed checks if the counter passed was received before or is below the replay window. Counters start at
+// 1, so 0 is never valid.
+func (s *securitySession) replayed(counter uint64) bool {
+	if counter == 0 {
+		return true
+	}
+	if counter > s.highest {
+		return false
+	}
+	n := s.highest - counter
+	return n >= replayWindow || s.seen[n/64]&(1<<(n%64)) != 0
+}
+
+// receive marks the counter passed as received, moving the replay window forward if it is the highest
+// counter received so far.
+func (s *securitySession) receive(counter uint64) {
+	if counter > s.highest {
+		shift := counter - s.highest
+		s.highest = counter
+		if shift >= replayWindow {
+			s.seen = [replayWindow / 64]uint64{}
+		} else {
+			words, bits := int(shift/64), shift%64
+			for i := len(s.seen) - 1; i >= 0; i-- {
+				var v uint64
+				if i >= words {
+					v = s.seen[i-words] << bits
+					if bits > 0 && i > words {
+						v |= s.seen[i-words-1] >> (64 - bits)
+					}
+				}
+				s.seen[i] = v
+			}
+		}
+	}
+	n := s.highest - counter
+	s.seen[n/64] |= 1 << (n % 64)
+}
+
+// nonce returns the nonce of the datagram with the counter passed.
+func (s *securitySession) nonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// secureConn is a net.PacketConn that encrypts all datagrams written to it using the session of a secured
+// connection.
+type secureConn struct {
+	net.PacketConn
+	session *securitySession
+
+	// mu guards buf, which the encrypted datagrams are written to.
+	mu  sync.Mutex
+	buf []byte
+}
+
+// WriteTo encrypts the datagram passed and writes it to the address passed.
+func (conn *secureConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.buf = conn.session.seal(conn.buf[:0], b)
+	if _, err := conn.PacketConn.WriteTo(conn.buf, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// decrypt decrypts the datagram in buffer b in place if the connection is secured. Offline messages, which
+// are never encrypted, are left as they are.
+func (conn *Conn) decrypt(b *bytes.Buffer) error {
+	if conn.session == nil || b.Len() == 0 || b.Bytes()[0]&bitFlagValid == 0 {
+		return nil
+	}
+	data, err := conn.session.open(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("error decrypting datagram: %w", err)
+	}
+	b.Reset()
+	_, _ = b.Write(data)
+	return nil
+}
+
+// securityOverhead returns the amount of bytes added to each datagram by the encryption of the connection.
+func (conn *Conn) securityOverhead() int {
+	if conn.session == nil {
+		return 0
+	}
+	return securityOverhead
+}
+
+// Encrypted checks if the connection is encrypted, meaning its datagrams are encrypted using the keys
+// exchanged during the connection sequence. See ListenConfig.EncryptionKey.
+func (conn *Conn) Encrypted() bool {
+	return conn.session != nil
+}
@@ -0,0 +1,397 @@
+package raknet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSecurity(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	payload := bytes.Repeat([]byte("secret payload "), 200)
+	var mu sync.Mutex
+	var leaked bool
+	l, err := ListenConfig{EncryptionKey: key, PacketTrace: func(_ Direction, _ net.Addr, b []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		leaked = leaked || bytes.Contains(b, []byte("secret payload"))
+	}}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	client, err := Dialer{ServerPublicKey: key.PublicKey()}.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+	if !client.Encrypted() || !c.(*Conn).Encrypted() {
+		t.Fatalf("expected both ends of the connection to be secure")
+	}
+	for _, ends := range [...]struct{ from, to net.Conn }{{client, c}, {c, client}} {
+		if _, err := ends.from.Write(payload); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		_ = ends.to.SetReadDeadline(time.Now().Add(time.Second * 5))
+		buf := make([]byte, 10000)
+		n, err := ends.to.Read(buf)
+		if err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(buf[:n], payload) {
+			t.Fatalf("read %v bytes, expected the %v bytes written", n, len(payload))
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if leaked {
+		t.Fatalf("payload found unencrypted in a datagram")
+	}
+}
+
+func TestSecurityMismatch(t *testing.T) {
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	secure, err := ListenConfig{EncryptionKey: key}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer secure.Close()
+	insecure, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer insecure.Close()
+
+	for _, test := range []struct {
+		name   string
+		dialer Dialer
+		addr   string
+	}{
+		{"insecure dialer", Dialer{}, secure.Addr().String()},
+		{"other server key", Dialer{ServerPublicKey: other.PublicKey()}, secure.Addr().String()},
+		{"insecure server", Dialer{Encrypt: true}, insecure.Addr().String()},
+	} {
+		if _, err := test.dialer.Dial(test.addr); err == nil || errors.Is(err, ErrTimeout) {
+			t.Errorf("%v: expected dial to fail immediately, got %v", test.name, err)
+		}
+	}
+}
+
+func TestRequireCookie(t *testing.T) {
+	l, err := ListenConfig{RequireCookie: true}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	_ = client.Close()
+	if client.Encrypted() {
+		t.Fatalf("expected connection verified using a cookie not to be secure")
+	}
+	if _, err := (Dialer{Encrypt: true}).Dial(l.Addr().String()); err == nil || errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected secure dialer to fail immediately, got %v", err)
+	}
+
+	// A client with a spoofed address never receives the cookie, so its open connection request 2 holds a
+	// wrong one and is not answered.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer conn.Close()
+	addr := rakAddr(*l.Addr().(*net.UDPAddr))
+	request, _ := (&openConnectionRequest2{Magic: magic, Secure: true, Cookie: 1, ServerAddress: &addr, MTUSize: 1400, ClientGUID: 1}).MarshalBinary()
+	if _, err := conn.WriteTo(append([]byte{idOpenConnectionRequest2}, request...), l.Addr()); err != nil {
+		t.Fatalf("error writing request: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if _, _, err := conn.ReadFrom(make([]byte, 1500)); err == nil {
+		t.Fatalf("expected no reply to open connection request 2 with wrong cookie")
+	}
+	if failed := l.Stats().HandshakeFailureReasons.SecurityFailed; failed != 1 {
+		t.Fatalf("expected 1 handshake failed on security, got %v", failed)
+	}
+}
+
+func TestStatelessHandshake(t *testing.T) {
+	l, err := ListenConfig{StatelessHandshake: true, Protocol: MinecraftProtocol, Protocols: []byte{10}}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	client, err := Dialer{Protocol: 10}.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	_ = client.Close()
+	if client.Protocol() != 10 {
+		t.Fatalf("expected protocol 10 to be taken from the cookie, got %v", client.Protocol())
+	}
+	l.mtuProbes.mu.Lock()
+	probes := len(l.mtuProbes.m)
+	l.mtuProbes.mu.Unlock()
+	l.pendingProtocols.mu.Lock()
+	protocols := len(l.pendingProtocols.m)
+	l.pendingProtocols.mu.Unlock()
+	if probes != 0 || protocols != 0 {
+		t.Fatalf("expected no state held for clients, got %v MTU probes and %v protocols", probes, protocols)
+	}
+
+	key, _ := newCookieKey()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}
+	now := time.Unix(1000, 0)
+	cookie := key.cookie(addr, 10, now)
+	if protocol, ok := key.verify(cookie, addr, []byte{MinecraftProtocol, 10}, now.Add(cookieLifetime)); !ok || protocol != 10 {
+		t.Fatalf("expected cookie to be valid for protocol 10 in the next epoch, got %v (%v)", protocol, ok)
+	}
+	if _, ok := key.verify(cookie, addr, []byte{MinecraftProtocol, 10}, now.Add(cookieLifetime*2)); ok {
+		t.Fatalf("expected cookie to expire after two epochs")
+	}
+	if _, ok := key.verify(cookie, addr, []byte{MinecraftProtocol}, now); ok {
+		t.Fatalf("expected cookie to be invalid for other protocol versions")
+	}
+}
+
+func TestSecuritySession(t *testing.T) {
+	client, server := securitySessions(t)
+	sealed := client.seal(nil, []byte{0x84, 1, 2, 3})
+	if len(sealed) != 4+securityOverhead {
+		t.Fatalf("expected %v bytes of overhead, got %v", securityOverhead, len(sealed)-4)
+	}
+	if _, err := client.open(append([]byte(nil), sealed...)); err == nil {
+		t.Fatalf("expected a datagram to be rejected by the session that sealed it")
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := server.open(tampered); err == nil {
+		t.Fatalf("expected a tampered datagram to be rejected")
+	}
+	data, err := server.open(sealed)
+	if err != nil || !bytes.Equal(data, []byte{0x84, 1, 2, 3}) {
+		t.Fatalf("expected datagram to be decrypted, got %x (%v)", data, err)
+	}
+}
+
+func TestSecuritySessionReplay(t *testing.T) {
+	client, server := securitySessions(t)
+	var sealed [][]byte
+	for i := 0; i < replayWindow+3; i++ {
+		sealed = append(sealed, client.seal(nil, []byte{0x84, byte(i)}))
+	}
+	open := func(i int) error {
+		_, err := server.open(append([]byte(nil), sealed[i]...))
+		return err
+	}
+	// Datagrams may arrive out of order, but each is only accepted once.
+	for _, i := range []int{1, 0, 3} {
+		if err := open(i); err != nil {
+			t.Fatalf("expected datagram %v to be accepted: %v", i, err)
+		}
+	}
+	for _, i := range []int{0, 1, 3} {
+		if err := open(i); !errors.Is(err, errReplayed) {
+			t.Fatalf("expected replay of datagram %v to be rejected, got %v", i, err)
+		}
+	}
+	// Datagram 2 was never received, but once the window moved past it, it cannot be told from a replay.
+	if err := open(replayWindow + 2); err != nil {
+		t.Fatalf("expected datagram to be accepted: %v", err)
+	}
+	if err := open(2); !errors.Is(err, errReplayed) {
+		t.Fatalf("expected datagram below the window to be rejected, got %v", err)
+	}
+	if err := open(replayWindow); err != nil {
+		t.Fatalf("expected datagram within the window to be accepted: %v", err)
+	}
+
+	// A forged datagram does not move the window, so the datagram it claims the counter of is still accepted.
+	forged := client.seal(nil, []byte{0x84})
+	real := append([]byte(nil), forged...)
+	forged[len(forged)-1] ^= 1
+	if _, err := server.open(forged); err == nil || errors.Is(err, errReplayed) {
+		t.Fatalf("expected forged datagram to fail authentication, got %v", err)
+	}
+	if _, err := server.open(real); err != nil {
+		t.Fatalf("expected datagram to be accepted after a forgery of it: %v", err)
+	}
+}
+
+func TestSecuredConnReplay(t *testing.T) {
+	p := NewSyncPipe(time.Now())
+	defer p.Close()
+	client, server := securitySessions(t)
+	a, b := p.A(), p.B()
+	a.conn, a.session = &secureConn{PacketConn: a.conn, session: client}, client
+	b.conn, b.session = &secureConn{PacketConn: b.conn, session: server}, server
+
+	// A NACK is captured and replayed by an attacker on the path: Only the first copy makes a resend.
+	if _, err := a.Write([]byte("hello")); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	a.writeLock.Lock()
+	_ = a.flush()
+	a.writeLock.Unlock()
+	p.pcs[1].in = make(chan []byte, pipeQueueSize)
+	var nack []byte
+	b.conn = &secureConn{PacketConn: &captureConn{PacketConn: b.conn.(*secureConn).PacketConn, captured: &nack}, session: server}
+	b.writeLock.Lock()
+	_ = b.sendNACK(0)
+	b.writeLock.Unlock()
+	for i := 0; i < 3; i++ {
+		_ = a.receive(bytes.NewBuffer(append([]byte(nil), nack...)))
+	}
+	if resent := a.Stats().DatagramsResent; resent != 1 {
+		t.Fatalf("expected a single resend for a replayed NACK, got %v", resent)
+	}
+}
+
+// captureConn is a net.PacketConn that stores the last datagram written to it.
+type captureConn struct {
+	net.PacketConn
+	captured *[]byte
+}
+
+// WriteTo stores the datagram passed and writes it to the underlying connection.
+func (conn *captureConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	*conn.captured = append((*conn.captured)[:0], b...)
+	return conn.PacketConn.WriteTo(b, addr)
+}
+
+// securitySessions performs a key exchange and returns the sessions of the client and the server.
+func securitySessions(t *testing.T) (client, server *securitySession) {
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	s, err := newListenerSecurity(key)
+	if err != nil {
+		t.Fatalf("error creating listener security: %v", err)
+	}
+	c, err := newClientSecurity(true, nil, key.PublicKey().Bytes(), 1)
+	if err != nil {
+		t.Fatalf("error creating client security: %v", err)
+	}
+	server, answer, err := s.accept(c.challenge())
+	if err != nil {
+		t.Fatalf("error accepting challenge: %v", err)
+	}
+	if client, err = c.finish(answer); err != nil {
+		t.Fatalf("error finishing key exchange: %v", err)
+	}
+	return client, server
+}
+
+func TestValidateSource(t *testing.T) {
+	l, err := ListenConfig{ValidateSource: true}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	// An open connection request 2 sent without a request 1 before it is not answered.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer conn.Close()
+	addr := rakAddr(*l.Addr().(*net.UDPAddr))
+	request, _ := (&openConnectionRequest2{Magic: magic, ServerAddress: &addr, MTUSize: 1400, ClientGUID: 1}).MarshalBinary()
+	if _, err := conn.WriteTo(append([]byte{idOpenConnectionRequest2}, request...), l.Addr()); err != nil {
+		t.Fatalf("error writing request: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if _, _, err := conn.ReadFrom(make([]byte, 1500)); err == nil {
+		t.Fatalf("expected no reply to open connection request 2 without request 1")
+	}
+	if _, ok := l.connections.Load(conn.LocalAddr().String()); ok {
+		t.Fatalf("expected no connection created for unvalidated source")
+	}
+	if failed := l.Stats().HandshakeFailureReasons.SecurityFailed; failed != 1 {
+		t.Fatalf("expected 1 handshake failed on security, got %v", failed)
+	}
+
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	_ = client.Close()
+}
+
+func TestSpoofedClose(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	// The loopback is trusted to send PROXY protocol headers, so that datagrams from a spoofed address may
+	// be sent.
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+
+	disconnect := bytes.NewBuffer(nil)
+	_ = (datagramHeader{flags: bitFlagValid, sequenceNumber: 100}).write(disconnect)
+	_ = (&packet{reliability: reliabilityUnreliable, content: []byte{idDisconnectNotification}}).write(disconnect)
+	request := append([]byte{idOpenConnectionRequest1}, magic[:]...)
+	for _, secured := range []bool{false, true} {
+		config, dialer, packets := ListenConfig{TrustedProxies: []*net.IPNet{loopback}}, Dialer{}, [][]byte{request}
+		if secured {
+			// Only datagrams of secured connections are authenticated, so only those are safe from a spoofed
+			// disconnect notification.
+			config.EncryptionKey, dialer.ServerPublicKey = key, key.PublicKey()
+			packets = append(packets, disconnect.Bytes())
+		}
+		l, err := config.Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		events, unsubscribe := l.Subscribe(16)
+
+		client, err := dialer.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatalf("error dialing: %v", err)
+		}
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatalf("error accepting: %v", err)
+		}
+
+		attacker, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error creating attacker: %v", err)
+		}
+		header := appendProxyHeader(nil, client.LocalAddr().(*net.UDPAddr), l.Addr().(*net.UDPAddr))
+		for _, b := range packets {
+			if _, err := attacker.WriteTo(append(header, b...), l.Addr()); err != nil {
+				t.Fatalf("error writing: %v", err)
+			}
+		}
+		select {
+		case <-c.(*Conn).closeCtx.Done():
+			t.Fatalf("expected connection (secured=%v) not to be closed by spoofed packets", secured)
+		case <-time.After(time.Millisecond * 200):
+		}
+		for len(events) > 0 {
+			if event := <-events; event.Type == EventResumed || event.Type == EventClosed {
+				t.Fatalf("unexpected event %v (secured=%v)", event.Type, secured)
+			}
+		}
+		unsubscribe()
+		_ = attacker.Close()
+		_ = client.Close()
+		_ = l.Close()
+	}
+}
@@ -0,0 +1,149 @@
+package raknet
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// NetworkConditions specifies the conditions of the network simulated by SimulateNetwork. The conditions are
+// applied to datagrams written, so that both ends of a connection should be wrapped to simulate conditions
+// in both directions.
+type NetworkConditions struct {
+	// Latency is the time by which every datagram written is delayed.
+	Latency time.Duration
+	// Jitter is the maximum random time added to the Latency of every datagram. Datagrams with different
+	// delays may arrive out of order.
+	Jitter time.Duration
+	// Loss is the chance from 0-1 that a datagram written is dropped.
+	Loss float64
+	// Duplication is the chance from 0-1 that a datagram written is sent twice.
+	Duplication float64
+	// Reordering is the chance from 0-1 that a datagram written is held back for an additional ReorderDelay,
+	// so that datagrams written after it overtake it.
+	Reordering float64
+	// ReorderDelay is the time a datagram is held back when it is reordered.
+	// ReorderDelay is 10 milliseconds by default.
+	ReorderDelay time.Duration
+	// Bandwidth is the maximum amount of bytes per second that may be written. Datagrams written faster than
+	// this are queued and sent once the bandwidth allows for it, like by the bottleneck link of a path.
+	// Bandwidth is 0 by default, meaning the bandwidth is unlimited.
+	Bandwidth int
+	// QueueSize is the maximum amount of bytes queued when datagrams are written faster than the Bandwidth
+	// allows for. Datagrams that do not fit in the queue are dropped, like by the router in front of a
+	// congested link.
+	// QueueSize is 0 by default, meaning the queue is unlimited.
+	QueueSize int
+
+	// Seed is the seed of the random source that decides which datagrams are affected. Passing the same seed
+	// makes the conditions affect the same datagrams for the same writes.
+	// Seed is 0 by default, meaning a random seed is used.
+	Seed int64
+	// Clock is the Clock used to delay datagrams.
+	// Clock is nil by default, meaning SystemClock is used.
+	Clock Clock
+}
+
+// SimulateNetwork wraps the net.PacketConn passed so that datagrams written to it are delayed, dropped,
+// duplicated, reordered and throttled according to the NetworkConditions passed. It may be passed to the WrapConn
+// field of a ListenConfig or Dialer to test the reliability layer and applications under WAN conditions:
+//
+//	conditions := raknet.NetworkConditions{Latency: time.Millisecond * 50, Jitter: time.Millisecond * 10, Loss: 0.02}
+//	dialer := raknet.Dialer{WrapConn: func(conn net.PacketConn) net.PacketConn {
+//		return raknet.SimulateNetwork(conn, conditions)
+//	}}
+func SimulateNetwork(conn net.PacketConn, conditions NetworkConditions) net.PacketConn {
+	if conditions.Clock == nil {
+		conditions.Clock = SystemClock
+	}
+	if conditions.ReorderDelay == 0 {
+		conditions.ReorderDelay = time.Millisecond * 10
+	}
+	if conditions.Seed == 0 {
+		conditions.Seed = conditions.Clock.Now().UnixNano()
+	}
+	return &simulatedConn{PacketConn: conn, conditions: conditions, rand: rand.New(rand.NewSource(conditions.Seed))}
+}
+
+// simulatedConn is a net.PacketConn that applies NetworkConditions to the datagrams written to it.
+type simulatedConn struct {
+	net.PacketConn
+	conditions NetworkConditions
+
+	// mu guards rand, which is not safe for concurrent use, and busyUntil.
+	mu   sync.Mutex
+	rand *rand.Rand
+	// busyUntil is the time at which all datagrams queued because of the Bandwidth are sent.
+	busyUntil time.Time
+}
+
+// WriteTo writes the datagram passed to the address passed according to the NetworkConditions of the conn.
+// Errors from writing delayed datagrams are discarded, as they would be by a network.
+func (conn *simulatedConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	conn.mu.Lock()
+	if conn.rand.Float64() < conn.conditions.Loss {
+		conn.mu.Unlock()
+		return len(b), nil
+	}
+	queueDelay, ok := conn.throttle(len(b))
+	if !ok {
+		conn.mu.Unlock()
+		return len(b), nil
+	}
+	copies := 1
+	if conn.rand.Float64() < conn.conditions.Duplication {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = queueDelay + conn.conditions.Latency
+		if conn.conditions.Jitter > 0 {
+			delays[i] += time.Duration(conn.rand.Int63n(int64(conn.conditions.Jitter)))
+		}
+		if conn.rand.Float64() < conn.conditions.Reordering {
+			delays[i] += conn.conditions.ReorderDelay
+		}
+	}
+	conn.mu.Unlock()
+
+	data, copied := b, false
+	for _, delay := range delays {
+		if delay <= 0 {
+			if _, err := conn.PacketConn.WriteTo(data, addr); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if !copied {
+			// The datagram is written after WriteTo returns, so it must be copied as b may be re-used.
+			data, copied = append([]byte(nil), b...), true
+		}
+		d := data
+		conn.conditions.Clock.AfterFunc(delay, func() {
+			_, _ = conn.PacketConn.WriteTo(d, addr)
+		})
+	}
+	return len(b), nil
+}
+
+// throttle queues a datagram of the size passed according to the Bandwidth of the conn. It returns the time
+// the datagram spends in the queue until it is fully sent, or false if it does not fit in the queue and must
+// be dropped.
+// throttle must be called while holding the lock of the conn.
+func (conn *simulatedConn) throttle(size int) (time.Duration, bool) {
+	if conn.conditions.Bandwidth <= 0 {
+		return 0, true
+	}
+	bandwidth := float64(conn.conditions.Bandwidth)
+	now := conn.conditions.Clock.Now()
+	if conn.busyUntil.Before(now) {
+		conn.busyUntil = now
+	}
+	queued := conn.busyUntil.Sub(now)
+	if conn.conditions.QueueSize > 0 && int(queued.Seconds()*bandwidth)+size > conn.conditions.QueueSize {
+		return 0, false
+	}
+	conn.busyUntil = conn.busyUntil.Add(time.Duration(float64(size) / bandwidth * float64(time.Second)))
+	return conn.busyUntil.Sub(now), true
+}
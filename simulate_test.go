@@ -0,0 +1,93 @@
+package raknet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSimulateNetwork(t *testing.T) {
+	wrap := func(conn net.PacketConn) net.PacketConn {
+		return SimulateNetwork(conn, NetworkConditions{
+			Latency:     time.Millisecond * 5,
+			Jitter:      time.Millisecond * 5,
+			Loss:        0.1,
+			Duplication: 0.1,
+			Reordering:  0.2,
+		})
+	}
+	l, err := ListenConfig{WrapConn: wrap}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan *Conn, 1)
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			accepted <- c.(*Conn)
+		}
+	}()
+	client, err := Dialer{WrapConn: wrap}.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+
+	const count = 100
+	for i := 0; i < count; i++ {
+		if _, err := client.Write([]byte{0xfe, byte(i)}); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	b := make([]byte, 1500)
+	_ = server.SetReadDeadline(time.Now().Add(time.Second * 10))
+	for i := 0; i < count; i++ {
+		n, err := server.Read(b)
+		if err != nil {
+			t.Fatalf("error reading packet %v: %v", i, err)
+		}
+		if n != 2 || b[1] != byte(i) {
+			t.Fatalf("packet %v arrived out of order: %x", i, b[:n])
+		}
+	}
+}
+
+func TestSimulateNetworkBandwidth(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn := SimulateNetwork(nil, NetworkConditions{Bandwidth: 1000, QueueSize: 1500, Clock: clock}).(*simulatedConn)
+
+	if delay, ok := conn.throttle(1000); !ok || delay != time.Second {
+		t.Fatalf("expected first datagram to be sent in 1s, got %v (queued: %v)", delay, ok)
+	}
+	if _, ok := conn.throttle(1000); ok {
+		t.Fatalf("expected datagram exceeding the queue size to be dropped")
+	}
+	clock.Advance(time.Millisecond * 600)
+	if delay, ok := conn.throttle(1000); !ok || delay != time.Millisecond*1400 {
+		t.Fatalf("expected datagram to be sent in 1.4s, got %v (queued: %v)", delay, ok)
+	}
+}
+
+func TestPipeBandwidth(t *testing.T) {
+	a, b := PipeConfig{Conditions: &NetworkConditions{Bandwidth: 100000}}.Pipe()
+	defer a.Close()
+
+	start := time.Now()
+	payload := make([]byte, 1000)
+	payload[0] = 0xfe
+	for i := 0; i < 50; i++ {
+		if _, err := a.Write(payload); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	_ = b.SetReadDeadline(time.Now().Add(time.Second * 5))
+	for i := 0; i < 50; i++ {
+		if _, err := b.Read(make([]byte, 1500)); err != nil {
+			t.Fatalf("error reading packet %v: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*400 {
+		t.Fatalf("50kB delivered in %v, faster than the bandwidth of 100kB/s allows", elapsed)
+	}
+}
@@ -0,0 +1,96 @@
+package raknet
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	// defaultReadQueueSize is the default amount of packets that may be waiting to be read from a Conn.
+	defaultReadQueueSize = 1024
+	// lowFootprintReadQueueSize is the amount of packets that may be waiting to be read from a Conn in low
+	// footprint mode.
+	lowFootprintReadQueueSize = 64
+)
+
+// SlowConsumerPolicy specifies what a connection does when the application does not call Read fast enough,
+// so that the packets received no longer fit in the read queue of the connection.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDisconnect drops unreliable packets that do not fit in the read queue, and closes the
+	// connection once a reliable packet does not fit. It is the default policy.
+	SlowConsumerDisconnect SlowConsumerPolicy = iota
+	// SlowConsumerBlock stops processing packets of the connection until there is room in the read queue
+	// again. Note that for connections of a Listener, this blocks the processing of packets of all of its
+	// connections.
+	SlowConsumerBlock
+)
+
+// errSlowConsumer is the error of the event emitted when the read queue of a connection is full.
+var errSlowConsumer = errors.New("read queue full: packets are not read fast enough")
+
+// receivedPacket is a packet in the read queue of a connection, along with the encapsulation that it was
+// received with.
+type receivedPacket struct {
+	*bytes.Buffer
+	enc Encapsulation
+}
+
+// deliver passes the content of a packet to a call to Conn.Read, either by copying it directly into the
+// buffer of a blocking call or by adding it to the read queue. If the read queue is full, the slow consumer
+// policy of the connection is applied. enc is the encapsulation that the packet was received with.
+func (conn *Conn) deliver(b []byte, enc Encapsulation) {
+	buffer := receivedPacket{Buffer: bytes.NewBuffer(b), enc: enc}
+	if len(conn.packetChan) == 0 {
+		// The application caught up with the packets received.
+		conn.slowConsumer = false
+	}
+	for {
+		var direct chan []byte
+		if len(conn.packetChan) == 0 {
+			// Packets may only be copied directly if none are queued, as they would otherwise be read out of
+			// order.
+			direct = conn.directReads
+		}
+		select {
+		case dst := <-direct:
+			if len(dst) < len(b) {
+				conn.directN <- readResult{n: -1}
+				continue
+			}
+			conn.meter.add(UserMessageBytesReceivedProcessed, len(b))
+			conn.directN <- readResult{n: copy(dst, b), enc: enc}
+			putBuffer(b)
+			return
+		case conn.packetChan <- buffer:
+			conn.addMemory(len(b))
+			notify(conn.readable)
+			return
+		case <-conn.closeCtx.Done():
+			return
+		default:
+		}
+		break
+	}
+	if !conn.slowConsumer {
+		conn.slowConsumer = true
+		conn.emit(EventSlowConsumer, errSlowConsumer)
+	}
+	if conn.slowConsumerPolicy == SlowConsumerBlock {
+		select {
+		case conn.packetChan <- buffer:
+			conn.addMemory(len(b))
+			notify(conn.readable)
+		case <-conn.closeCtx.Done():
+		}
+		return
+	}
+	conn.count(slowConsumerDrops)
+	conn.meter.add(UserMessageBytesReceivedIgnored, len(b))
+	putBuffer(b)
+	if enc.Reliability.reliable() {
+		// Dropping a reliable packet would break the guarantees of the connection, so we close it instead.
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,146 @@
+package raknet
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	l, err := ListenConfig{ReadQueueSize: 4}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	events, unsubscribe := l.Subscribe(16)
+	defer unsubscribe()
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c.(*Conn)
+		}
+	}()
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+
+	for i := 0; i < 16; i++ {
+		if _, err := client.Write([]byte{0xfe, byte(i)}); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-events:
+			if e.Type != EventSlowConsumer {
+				continue
+			}
+			select {
+			case <-server.closeCtx.Done():
+			case <-timeout:
+				t.Fatalf("connection not closed after its read queue filled up")
+			}
+			if n := server.Stats().SlowConsumerDrops; n == 0 {
+				t.Errorf("expected packets to be dropped")
+			}
+			return
+		case <-timeout:
+			t.Fatalf("no slow consumer event emitted")
+		}
+	}
+}
+
+// asyncRead is the result of a call to Conn.Read made by readAsync.
+type asyncRead struct {
+	n   int
+	err error
+}
+
+// readAsync calls Read on the connection passed in a new goroutine and returns a channel that the result is
+// sent over. It returns once Read had time to block.
+func readAsync(conn *Conn, b []byte) <-chan asyncRead {
+	results := make(chan asyncRead, 1)
+	go func() {
+		n, err := conn.Read(b)
+		results <- asyncRead{n: n, err: err}
+	}()
+	time.Sleep(time.Millisecond * 50)
+	return results
+}
+
+func TestReadDirect(t *testing.T) {
+	p := NewSyncPipe(time.Now())
+	defer p.Close()
+	a := p.A()
+	// Without a read queue, a packet can only reach Read by being copied directly into its buffer.
+	a.packetChan = nil
+
+	b := make([]byte, a.mtuSize)
+	results := readAsync(a, b)
+	payload := []byte{0xfe, 1, 2, 3}
+	a.deliver(append(getBuffer(0), payload...), Encapsulation{})
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatalf("error reading: %v", res.err)
+		}
+		if !bytes.Equal(b[:res.n], payload) {
+			t.Fatalf("expected %x to be read, got %x", payload, b[:res.n])
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("packet was not read directly, %v packets dropped", a.Stats().SlowConsumerDrops)
+	}
+}
+
+func TestReadDirectFallback(t *testing.T) {
+	p := NewSyncPipe(time.Now())
+	defer p.Close()
+	a := p.A()
+
+	// A buffer smaller than the MTU size is never offered directly, so the packet is read from the read
+	// queue.
+	small := make([]byte, 64)
+	results := readAsync(a, small)
+	payload := []byte{0xfe, 1, 2, 3}
+	a.deliver(append(getBuffer(0), payload...), Encapsulation{})
+	select {
+	case res := <-results:
+		if res.err != nil || !bytes.Equal(small[:res.n], payload) {
+			t.Fatalf("expected %x to be read, got %x (%v)", payload, small[:res.n], res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("packet was not read from the read queue")
+	}
+
+	// A buffer of the MTU size is offered directly, but a reassembled packet bigger than it does not fit.
+	// The packet is passed through the read queue instead, after which Read reports that it was too large.
+	b := make([]byte, a.mtuSize)
+	results = readAsync(a, b)
+	large := bytes.Repeat([]byte{0xfe}, len(b)+100)
+	a.deliver(append(getBuffer(0), large...), Encapsulation{})
+	select {
+	case res := <-results:
+		if !errors.Is(res.err, errMessageTooLarge) {
+			t.Fatalf("expected message too large error, got %v", res.err)
+		}
+		if res.n != len(b) || !bytes.Equal(b, large[:len(b)]) {
+			t.Fatalf("expected the first %v bytes of the packet to be read, got %v", len(b), res.n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("packet too large for the buffer was not read from the read queue")
+	}
+	if n := a.Stats().SlowConsumerDrops; n != 0 {
+		t.Fatalf("expected no packets to be dropped, got %v", n)
+	}
+	if m := atomic.LoadInt64(&a.memUsage); m != 0 {
+		t.Fatalf("expected the memory of the read queue to be released, got %v bytes", m)
+	}
+}
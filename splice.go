@@ -0,0 +1,71 @@
+package raknet
+
+import (
+	"sync"
+)
+
+// spliceBufferSize is the size of the buffers that spliced connections are read into. Messages bigger than
+// this end the splice.
+const spliceBufferSize = 1 << 18
+
+// Splice copies messages in both directions between the connections a and b until either of them is closed,
+// after which the other is closed too. Every message is written with the reliability that it was received
+// with, so that unreliable and sequenced messages keep their semantics on the other connection. Connections
+// of this package use a single ordering channel and priorities are not sent over the wire, so ordered
+// messages keep their order and there is no other metadata to preserve.
+// Splice returns the amount of bytes copied from a to b and from b to a, and the error that ended the splice.
+// If a connection was closed by its other end, the error is a *DisconnectError, the reason of which is
+// passed on to the other connection.
+func Splice(a, b *Conn) (aToB, bToA int64, err error) {
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+	)
+	end := func(e error) {
+		once.Do(func() {
+			err = e
+		})
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		aToB = splice(b, a, end)
+	}()
+	go func() {
+		defer wg.Done()
+		bToA = splice(a, b, end)
+	}()
+	wg.Wait()
+	return aToB, bToA, err
+}
+
+// splice copies messages from src to dst until either connection is closed, after which both are closed. The
+// error that ended the copying is passed to end. splice returns the amount of bytes copied.
+func splice(dst, src *Conn, end func(err error)) (n int64) {
+	b := make([]byte, spliceBufferSize)
+	for {
+		res, err := src.read(b)
+		if err != nil {
+			end(err)
+			closeSpliced(dst, src)
+			return n
+		}
+		if _, err := dst.WriteReliability(b[:res.n], res.enc.Reliability.withoutReceipt()); err != nil {
+			end(err)
+			closeSpliced(dst, src)
+			return n
+		}
+		n += int64(res.n)
+	}
+}
+
+// closeSpliced closes both connections passed. If src was closed by its other end, the reason of its
+// disconnect notification is passed on to dst.
+func closeSpliced(dst, src *Conn) {
+	if disconnect, ok := src.closeErr().(*DisconnectError); ok {
+		_ = dst.CloseWithReason(disconnect.Reason)
+	} else {
+		_ = dst.Close()
+	}
+	_ = src.Close()
+}
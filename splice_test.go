@@ -0,0 +1,61 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSplice(t *testing.T) {
+	client, a := Pipe()
+	b, server := Pipe()
+	type result struct {
+		aToB, bToA int64
+		err        error
+	}
+	done := make(chan result, 1)
+	go func() {
+		aToB, bToA, err := Splice(a, b)
+		done <- result{aToB, bToA, err}
+	}()
+
+	for _, reliability := range []Reliability{Reliable, ReliableOrdered, ReliableSequenced} {
+		payload := []byte{0xfe, byte(reliability)}
+		if _, err := client.WriteReliability(payload, reliability); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		_ = server.SetReadDeadline(time.Now().Add(time.Second * 5))
+		buf := make([]byte, 1500)
+		n, enc, err := server.ReadEncapsulated(buf)
+		if err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(buf[:n], payload) || enc.Reliability != reliability {
+			t.Fatalf("expected %x sent %v, got %x sent %v", payload, reliability, buf[:n], enc.Reliability)
+		}
+	}
+	if _, err := server.Write([]byte{0xfe, 1, 2, 3}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := client.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+
+	_ = client.Close()
+	select {
+	case r := <-done:
+		if r.aToB != 6 || r.bToA != 4 {
+			t.Fatalf("expected 6 bytes copied from a to b and 4 from b to a, got %v and %v", r.aToB, r.bToA)
+		}
+		if !ErrConnectionClosed(r.err) {
+			t.Fatalf("expected closed error, got %v", r.err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Splice did not return after closing a connection")
+	}
+	_ = server.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := server.Read(make([]byte, 1500)); !ErrConnectionClosed(err) {
+		t.Fatalf("expected closed error on other end of splice, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package raknet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// splitBomb is a scripted client that sends adversarial split packets to a connection, with split headers
+// that a well-behaved end of a connection never produces. The datagrams are handled synchronously, so that
+// the state of the connection may be inspected right after each of them.
+type splitBomb struct {
+	t    *testing.T
+	conn *Conn
+	seq  uint24
+	msg  uint24
+}
+
+// newSplitBomb returns a splitBomb that targets one end of a SyncPipe.
+func newSplitBomb(t *testing.T) *splitBomb {
+	p := NewSyncPipe(time.Unix(0, 0))
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return &splitBomb{t: t, conn: p.B()}
+}
+
+// send sends a single fragment with the split header and content passed in a datagram of its own. It
+// returns the error returned for handling the datagram, if any.
+func (bomb *splitBomb) send(count uint32, id uint16, index uint32, content []byte) error {
+	bomb.t.Helper()
+	b := bytes.NewBuffer(nil)
+	if err := (datagramHeader{flags: bitFlagValid, sequenceNumber: bomb.seq}).write(b); err != nil {
+		bomb.t.Fatalf("error writing datagram header: %v", err)
+	}
+	p := &packet{reliability: reliabilityReliable, messageIndex: bomb.msg, split: true, splitCount: count, splitID: id, splitIndex: index, content: content}
+	if err := p.write(b); err != nil {
+		bomb.t.Fatalf("error writing split packet: %v", err)
+	}
+	bomb.seq++
+	bomb.msg++
+	return bomb.conn.receive(b)
+}
+
+// pending returns the amount of split packets that are currently being reassembled.
+func (bomb *splitBomb) pending() int {
+	return len(bomb.conn.splits)
+}
+
+// read reads the next packet received by the connection, failing if there is none.
+func (bomb *splitBomb) read() []byte {
+	bomb.t.Helper()
+	b, ok := bomb.conn.Poll()
+	if !ok {
+		bomb.t.Fatalf("no reassembled packet received")
+	}
+	return b
+}
+
+func TestSplitBombHugeCount(t *testing.T) {
+	bomb := newSplitBomb(t)
+	for _, count := range []uint32{maxSplitCount + 1, 1 << 31, 0} {
+		if err := bomb.send(count, 1, 0, []byte{0xfe}); err == nil {
+			t.Fatalf("expected split count %v to be rejected", count)
+		}
+	}
+	if bomb.pending() != 0 || bomb.conn.MemoryUsage() != 0 {
+		t.Fatalf("expected nothing to be allocated for rejected split counts, got %v split packets and %v bytes", bomb.pending(), bomb.conn.MemoryUsage())
+	}
+	if err := bomb.send(maxSplitCount, 1, 0, []byte{0xfe}); err != nil {
+		t.Fatalf("expected the maximum split count to be accepted: %v", err)
+	}
+}
+
+func TestSplitBombOutOfRangeIndex(t *testing.T) {
+	bomb := newSplitBomb(t)
+	if err := bomb.send(2, 1, 2, []byte{0xfe}); err == nil {
+		t.Fatalf("expected index equal to the split count to be rejected")
+	}
+	// Once a split packet is being reassembled, its count is fixed by its first fragment: Fragments that
+	// claim a larger count for the same ID must not grow it.
+	if err := bomb.send(2, 1, 0, []byte{0xfe}); err != nil {
+		t.Fatalf("error sending fragment: %v", err)
+	}
+	if err := bomb.send(4, 1, 3, []byte{1}); err == nil {
+		t.Fatalf("expected index beyond the count of the first fragment to be rejected")
+	}
+	if n := len(bomb.conn.splits[1]); n != 2 {
+		t.Fatalf("expected split packet to keep 2 fragment slots, got %v", n)
+	}
+}
+
+func TestSplitBombOverlappingIndices(t *testing.T) {
+	bomb := newSplitBomb(t)
+	for i := 0; i < 10; i++ {
+		if err := bomb.send(2, 1, 0, bytes.Repeat([]byte{0xfe}, 100)); err != nil {
+			t.Fatalf("error sending fragment: %v", err)
+		}
+	}
+	if usage, expected := bomb.conn.MemoryUsage(), int64(100+2*splitSlotSize); usage != expected {
+		t.Fatalf("expected a fragment sent repeatedly to be held once (%v bytes), got %v bytes", expected, usage)
+	}
+	if err := bomb.send(2, 1, 1, []byte{1, 2}); err != nil {
+		t.Fatalf("error sending fragment: %v", err)
+	}
+	if b := bomb.read(); len(b) != 102 {
+		t.Fatalf("expected reassembled packet of 102 bytes, got %v", len(b))
+	}
+	if bomb.pending() != 0 || bomb.conn.MemoryUsage() != 0 {
+		t.Fatalf("expected all memory to be released after reassembly, got %v split packets and %v bytes", bomb.pending(), bomb.conn.MemoryUsage())
+	}
+}
+
+func TestSplitBombNeverCompleting(t *testing.T) {
+	bomb := newSplitBomb(t)
+	const groups = 100
+	for id := uint16(0); id < groups; id++ {
+		if err := bomb.send(maxSplitCount, id, 0, []byte{0xfe}); err != nil {
+			t.Fatalf("error sending fragment: %v", err)
+		}
+	}
+	if bomb.pending() != groups {
+		t.Fatalf("expected %v split packets to be pending, got %v", groups, bomb.pending())
+	}
+	// Each group holds on to a slot for every fragment it announced, which must be reflected by the memory
+	// usage, so that the memory limit of a Listener catches groups that never complete.
+	if usage, expected := bomb.conn.MemoryUsage(), int64(groups*(1+maxSplitCount*splitSlotSize)); usage != expected {
+		t.Fatalf("expected memory usage of %v bytes for groups that never complete, got %v", expected, usage)
+	}
+}
+
+func TestSplitBombCountLimit(t *testing.T) {
+	bomb := newSplitBomb(t)
+	bomb.conn.maxSplitCount = 16
+	if err := bomb.send(16, 1, 0, []byte{0xfe}); err != nil {
+		t.Fatalf("expected split count within the limit to be accepted: %v", err)
+	}
+	if err := bomb.send(17, 2, 0, []byte{0xfe}); !errors.Is(err, errSplitLimit) {
+		t.Fatalf("expected split count above the limit to exceed the split limit, got %v", err)
+	}
+	if !bomb.conn.closed() {
+		t.Fatalf("expected connection to be closed after exceeding the split limit")
+	}
+}
+
+func TestSplitBombMemoryLimit(t *testing.T) {
+	bomb := newSplitBomb(t)
+	bomb.conn.maxSplitMemory = 1000
+	for id := uint16(0); id < 2; id++ {
+		if err := bomb.send(2, id, 0, bytes.Repeat([]byte{0xfe}, 400)); err != nil {
+			t.Fatalf("error sending fragment: %v", err)
+		}
+	}
+	if bomb.conn.closed() {
+		t.Fatalf("expected connection within the memory limit to remain open")
+	}
+	if err := bomb.send(2, 2, 0, bytes.Repeat([]byte{0xfe}, 400)); !errors.Is(err, errSplitLimit) {
+		t.Fatalf("expected split packets above the memory limit to exceed the split limit, got %v", err)
+	}
+	if !bomb.conn.closed() {
+		t.Fatalf("expected connection to be closed after exceeding the split memory limit")
+	}
+}
@@ -0,0 +1,40 @@
+package raknet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// defaultMaxSplitMemory is the maximum amount of bytes a connection holds for split packets that are being
+// reassembled if none is set.
+const defaultMaxSplitMemory = 32 << 20
+
+// errSplitLimit is wrapped by the errors returned for split packets that exceed the split limits of a
+// connection. The connection is closed when they are returned.
+var errSplitLimit = errors.New("split limit exceeded")
+
+// splitLimits returns the maximum split count and reassembly memory of the connConfig passed, filling out
+// the defaults for those left empty. The split count can be no higher than maxSplitCount.
+func (config connConfig) splitLimits() (count, memory int) {
+	count, memory = config.maxSplitCount, config.maxSplitMemory
+	if count <= 0 || count > maxSplitCount {
+		count = maxSplitCount
+	}
+	if memory <= 0 {
+		memory = defaultMaxSplitMemory
+	}
+	return count, memory
+}
+
+// addSplitMemory adds n bytes to the memory held by the connection for split packets that are being
+// reassembled, and to its total memory usage. If the memory held exceeds the maximum, the connection is
+// closed and an error is returned.
+func (conn *Conn) addSplitMemory(n int) error {
+	conn.splitMemory += n
+	conn.addMemory(n)
+	if conn.splitMemory > conn.maxSplitMemory {
+		_ = conn.Close()
+		return fmt.Errorf("%w: %v bytes held for split packets (max %v)", errSplitLimit, conn.splitMemory, conn.maxSplitMemory)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package raknet
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats holds statistics of a Conn. All counters start at 0 when the connection is created and only ever
+// increase.
+type ConnStats struct {
+	// RemoteAddr is the address of the other end of the connection.
+	RemoteAddr net.Addr
+	// Latency is the last measured latency between both ends of the connection.
+	Latency time.Duration
+
+	// DatagramsSent is the amount of datagrams holding packets sent over the connection, including datagrams
+	// that were resent.
+	DatagramsSent uint64
+	// DatagramsReceived is the amount of datagrams holding packets received over the connection.
+	DatagramsReceived uint64
+	// DatagramsResent is the amount of datagrams that were resent, either because the other end of the
+	// connection reported them missing or because they were not acknowledged in time.
+	DatagramsResent uint64
+
+	// SpuriousResends is the amount of datagrams that were resent while the original datagram turned out to
+	// have arrived, which is detected when the original datagram is acknowledged after it was resent.
+	SpuriousResends uint64
+	// DuplicateDatagrams is the amount of datagrams received that had already been received before, or that
+	// were dropped because their sequence number was outside of the receive window.
+	DuplicateDatagrams uint64
+	// SlowConsumerDrops is the amount of packets received that were dropped because they did not fit in the
+	// read queue, as Read was not called fast enough.
+	SlowConsumerDrops uint64
+
+	// ACKsReceived and NACKsReceived are the amount of ACKs and NACKs received over the connection.
+	ACKsReceived  uint64
+	NACKsReceived uint64
+	// ConnectedPings is the amount of connected pings received over the connection.
+	ConnectedPings uint64
+
+	// RTT is a histogram of the round-trip times measured over the connection, which are measured every
+	// time a connected pong is received.
+	RTT Histogram
+
+	// OutboundLoss and InboundLoss are the estimated loss rates of datagrams sent and received over the last
+	// 10 seconds, between 0 and 1.
+	OutboundLoss float64
+	InboundLoss  float64
+}
+
+// ListenerStats holds statistics of a Listener and the connections it accepted. All counters start at 0 when
+// the Listener is created and only ever increase, also after connections are closed.
+type ListenerStats struct {
+	// Connections is the amount of connections that are currently open, including those that have not yet
+	// completed the connection sequence.
+	Connections int
+
+	// DatagramsSent is the amount of datagrams holding packets sent by all connections, including datagrams
+	// that were resent.
+	DatagramsSent uint64
+	// DatagramsReceived is the amount of datagrams holding packets received by all connections.
+	DatagramsReceived uint64
+	// DatagramsResent is the amount of datagrams that were resent by all connections.
+	DatagramsResent uint64
+	// SpuriousResends is the amount of datagrams resent by all connections while the original datagram
+	// turned out to have arrived.
+	SpuriousResends uint64
+	// DuplicateDatagrams is the amount of datagrams received by all connections that had already been
+	// received before, or that were outside of the receive window.
+	DuplicateDatagrams uint64
+	// SlowConsumerDrops is the amount of packets received by all connections that were dropped because they
+	// did not fit in the read queue of the connection.
+	SlowConsumerDrops uint64
+	// HandshakeFailures is the amount of connection attempts that failed, either because the client sent an
+	// invalid handshake packet, used an incompatible protocol or did not complete the connection sequence in
+	// time.
+	HandshakeFailures uint64
+	// HandshakeFailureReasons holds the amount of connection attempts that failed per reason.
+	HandshakeFailureReasons HandshakeFailureStats
+
+	// RateLimitDrops holds the amount of datagrams dropped by each of the RateLimits of the listener.
+	RateLimitDrops RateLimitStats
+	// BlackholeDrops is the amount of datagrams dropped because they were sent from a blackholed address.
+	BlackholeDrops uint64
+
+	// Packets holds the amount of packets received by the listener per type of packet.
+	Packets PacketStats
+	// RTT is a histogram of the round-trip times measured over all connections of the listener.
+	RTT Histogram
+}
+
+// PacketStats holds the amount of packets received per type of packet. Offline packets are those received
+// from addresses without a connection, and online packets those received over a connection.
+type PacketStats struct {
+	// UnconnectedPings is the amount of offline unconnected pings received.
+	UnconnectedPings uint64
+	// OpenConnectionRequests1 and OpenConnectionRequests2 are the amount of offline open connection
+	// requests 1 and 2 received.
+	OpenConnectionRequests1 uint64
+	OpenConnectionRequests2 uint64
+	// UnknownOffline is the amount of offline packets received with an unknown ID.
+	UnknownOffline uint64
+
+	// Datagrams is the amount of online datagrams holding packets received.
+	Datagrams uint64
+	// ACKs and NACKs are the amount of online ACKs and NACKs received.
+	ACKs  uint64
+	NACKs uint64
+	// ConnectedPings is the amount of online connected pings received.
+	ConnectedPings uint64
+}
+
+// counters holds the counters of a Conn or Listener. Its fields are updated atomically, so a counters value
+// must be 64-bit aligned.
+type counters struct {
+	datagramsSent      uint64
+	datagramsReceived  uint64
+	datagramsResent    uint64
+	spuriousResends    uint64
+	duplicateDatagrams uint64
+	slowConsumerDrops  uint64
+	handshakeFailures  uint64
+
+	handshakeInvalidPacket        uint64
+	handshakeIncompatibleProtocol uint64
+	handshakeTimeout              uint64
+	handshakeSecurityFailed       uint64
+
+	acksReceived   uint64
+	nacksReceived  uint64
+	connectedPings uint64
+
+	unconnectedPings        uint64
+	openConnectionRequests1 uint64
+	openConnectionRequests2 uint64
+	unknownOffline          uint64
+
+	rateLimitOffline            uint64
+	rateLimitOfflinePerSource   uint64
+	rateLimitConnected          uint64
+	rateLimitConnectedPerSource uint64
+	rateLimitAcks               uint64
+	blackholeDrops              uint64
+
+	// rtt holds the round-trip times measured using connected pings.
+	rtt histogram
+}
+
+// Stats returns the current statistics of the connection.
+func (conn *Conn) Stats() ConnStats {
+	stats := ConnStats{
+		RemoteAddr:         conn.addr,
+		Latency:            time.Duration(conn.Latency()) * time.Millisecond,
+		DatagramsSent:      atomic.LoadUint64(&conn.counters.datagramsSent),
+		DatagramsReceived:  atomic.LoadUint64(&conn.counters.datagramsReceived),
+		DatagramsResent:    atomic.LoadUint64(&conn.counters.datagramsResent),
+		SpuriousResends:    atomic.LoadUint64(&conn.counters.spuriousResends),
+		DuplicateDatagrams: atomic.LoadUint64(&conn.counters.duplicateDatagrams),
+		SlowConsumerDrops:  atomic.LoadUint64(&conn.counters.slowConsumerDrops),
+		ACKsReceived:       atomic.LoadUint64(&conn.counters.acksReceived),
+		NACKsReceived:      atomic.LoadUint64(&conn.counters.nacksReceived),
+		ConnectedPings:     atomic.LoadUint64(&conn.counters.connectedPings),
+		RTT:                conn.counters.rtt.snapshot(),
+	}
+	stats.OutboundLoss, stats.InboundLoss = conn.loss.rates()
+	return stats
+}
+
+// Stats returns the current statistics of the listener, combined with those of all connections it accepted.
+func (listener *Listener) Stats() ListenerStats {
+	stats := ListenerStats{
+		DatagramsSent:      atomic.LoadUint64(&listener.counters.datagramsSent),
+		DatagramsReceived:  atomic.LoadUint64(&listener.counters.datagramsReceived),
+		DatagramsResent:    atomic.LoadUint64(&listener.counters.datagramsResent),
+		SpuriousResends:    atomic.LoadUint64(&listener.counters.spuriousResends),
+		DuplicateDatagrams: atomic.LoadUint64(&listener.counters.duplicateDatagrams),
+		SlowConsumerDrops:  atomic.LoadUint64(&listener.counters.slowConsumerDrops),
+		HandshakeFailures:  atomic.LoadUint64(&listener.counters.handshakeFailures),
+		HandshakeFailureReasons: HandshakeFailureStats{
+			InvalidPacket:        atomic.LoadUint64(&listener.counters.handshakeInvalidPacket),
+			IncompatibleProtocol: atomic.LoadUint64(&listener.counters.handshakeIncompatibleProtocol),
+			Timeout:              atomic.LoadUint64(&listener.counters.handshakeTimeout),
+			SecurityFailed:       atomic.LoadUint64(&listener.counters.handshakeSecurityFailed),
+		},
+		RateLimitDrops: RateLimitStats{
+			Offline:            atomic.LoadUint64(&listener.counters.rateLimitOffline),
+			OfflinePerSource:   atomic.LoadUint64(&listener.counters.rateLimitOfflinePerSource),
+			Connected:          atomic.LoadUint64(&listener.counters.rateLimitConnected),
+			ConnectedPerSource: atomic.LoadUint64(&listener.counters.rateLimitConnectedPerSource),
+			Acks:               atomic.LoadUint64(&listener.counters.rateLimitAcks),
+		},
+		BlackholeDrops: atomic.LoadUint64(&listener.counters.blackholeDrops),
+		Packets: PacketStats{
+			UnconnectedPings:        atomic.LoadUint64(&listener.counters.unconnectedPings),
+			OpenConnectionRequests1: atomic.LoadUint64(&listener.counters.openConnectionRequests1),
+			OpenConnectionRequests2: atomic.LoadUint64(&listener.counters.openConnectionRequests2),
+			UnknownOffline:          atomic.LoadUint64(&listener.counters.unknownOffline),
+			Datagrams:               atomic.LoadUint64(&listener.counters.datagramsReceived),
+			ACKs:                    atomic.LoadUint64(&listener.counters.acksReceived),
+			NACKs:                   atomic.LoadUint64(&listener.counters.nacksReceived),
+			ConnectedPings:          atomic.LoadUint64(&listener.counters.connectedPings),
+		},
+		RTT: listener.counters.rtt.snapshot(),
+	}
+	listener.connections.Range(func(key, value interface{}) bool {
+		stats.Connections++
+		return true
+	})
+	return stats
+}
+
+// ConnStats returns the current statistics of all connections of the listener that are currently open.
+func (listener *Listener) ConnStats() []ConnStats {
+	var stats []ConnStats
+	listener.connections.Range(func(key, value interface{}) bool {
+		stats = append(stats, value.(*Conn).Stats())
+		return true
+	})
+	return stats
+}
+
+// count atomically increments the counter passed of the connection, and that of the listener of the
+// connection, if it has one.
+func (conn *Conn) count(counter func(c *counters) *uint64) {
+	atomic.AddUint64(counter(&conn.counters), 1)
+	if conn.listenerCounters != nil {
+		atomic.AddUint64(counter(conn.listenerCounters), 1)
+	}
+}
+
+// The functions below may be passed to Conn.count to select a counter.
+func datagramsSent(c *counters) *uint64      { return &c.datagramsSent }
+func datagramsReceived(c *counters) *uint64  { return &c.datagramsReceived }
+func datagramsResent(c *counters) *uint64    { return &c.datagramsResent }
+func spuriousResends(c *counters) *uint64    { return &c.spuriousResends }
+func duplicateDatagrams(c *counters) *uint64 { return &c.duplicateDatagrams }
+func slowConsumerDrops(c *counters) *uint64  { return &c.slowConsumerDrops }
+func acksReceived(c *counters) *uint64       { return &c.acksReceived }
+func nacksReceived(c *counters) *uint64      { return &c.nacksReceived }
+func connectedPings(c *counters) *uint64     { return &c.connectedPings }
+func ackRateLimitDrops(c *counters) *uint64  { return &c.rateLimitAcks }
+
+// resendHistorySize is the amount of sequence numbers of resent datagrams remembered to detect spurious
+// resends.
+const resendHistorySize = 256
+
+// resendHistory remembers the sequence numbers that datagrams had before they were most recently resent. If
+// one of these sequence numbers is acknowledged later, the resend was spurious.
+type resendHistory struct {
+	sequenceNumbers [resendHistorySize]uint24
+	valid           [resendHistorySize]bool
+	next            int
+}
+
+// add remembers the sequence number of a datagram that was resent, overwriting the oldest one remembered if
+// the history is full.
+func (h *resendHistory) add(sequenceNumber uint24) {
+	h.sequenceNumbers[h.next], h.valid[h.next] = sequenceNumber, true
+	h.next = (h.next + 1) % resendHistorySize
+}
+
+// remove forgets the sequence number passed and returns true if it was remembered.
+func (h *resendHistory) remove(sequenceNumber uint24) bool {
+	for i, seq := range h.sequenceNumbers {
+		if h.valid[i] && seq == sequenceNumber {
+			h.valid[i] = false
+			return true
+		}
+	}
+	return false
+}
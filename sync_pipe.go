@@ -0,0 +1,148 @@
+package raknet
+
+import (
+	"bytes"
+	"time"
+)
+
+// SyncPipe is a pair of connected *Conns, like those returned by Pipe, of which all processing happens
+// synchronously in calls to Step and Advance rather than in background goroutines. Datagrams written to one
+// end are only flushed and delivered to the other end when the SyncPipe is stepped, and time only passes
+// when it is advanced, so that tests asserting the state of connections are race-free and reproducible
+// regardless of the timing of the machine they run on.
+// Methods of a SyncPipe and of its Conns must not be called from multiple goroutines simultaneously. Reads
+// on the Conns should only be made for data that was delivered by a previous Step, as nothing will deliver
+// more data while a Read blocks.
+type SyncPipe struct {
+	clock    *ManualClock
+	conns    [2]*Conn
+	pcs      [2]*pipeConn
+	nextPing time.Time
+}
+
+// NewSyncPipe returns a SyncPipe of which the clock starts at the time passed.
+func NewSyncPipe(start time.Time) *SyncPipe {
+	a, b := newPipeConn("pipe-a"), newPipeConn("pipe-b")
+	a.peer, b.peer = b, a
+
+	clock := NewManualClock(start)
+	config := connConfig{clock: clock, synchronous: true}
+	idA, _ := randInt63(nil)
+	idB, _ := randInt63(nil)
+	p := &SyncPipe{clock: clock, pcs: [2]*pipeConn{a, b}, nextPing: start.Add(pingInterval)}
+	p.conns[0] = newConn(a, b.local, defaultMaxDatagramSize, idB, config)
+	p.conns[1] = newConn(b, a.local, defaultMaxDatagramSize, idA, config)
+	for _, conn := range p.conns {
+		conn.finishSequence()
+	}
+	return p
+}
+
+// A returns the first end of the SyncPipe.
+func (p *SyncPipe) A() *Conn {
+	return p.conns[0]
+}
+
+// B returns the second end of the SyncPipe.
+func (p *SyncPipe) B() *Conn {
+	return p.conns[1]
+}
+
+// Now returns the current time of the clock of the SyncPipe.
+func (p *SyncPipe) Now() time.Time {
+	return p.clock.Now()
+}
+
+// Step flushes the datagrams queued by both ends of the SyncPipe and delivers them to the other end. This is
+// repeated until no more datagrams are in transit, so that responses, such as pongs, are delivered too.
+// Time does not pass during a Step: Acknowledgements and resends only happen when the SyncPipe is advanced.
+// If one end of the SyncPipe was closed, Step closes the other end too.
+func (p *SyncPipe) Step() {
+	for {
+		for _, conn := range p.conns {
+			conn.writeLock.Lock()
+			_ = conn.flush()
+			_ = conn.writeBatch()
+			conn.writeLock.Unlock()
+		}
+		delivered := false
+		for i, pc := range p.pcs {
+			for {
+				select {
+				case b := <-pc.in:
+					// Errors are ignored, as the other end of a SyncPipe only ever sends valid datagrams.
+					_ = p.conns[i].receive(bytes.NewBuffer(b))
+					delivered = true
+					continue
+				default:
+				}
+				break
+			}
+		}
+		if !delivered {
+			break
+		}
+	}
+	p.propagateClose()
+}
+
+// Advance moves the time of the SyncPipe forward by d in steps of the tick interval of connections. Every
+// step, both ends perform their tick, in which they time out, acknowledge datagrams and resend lost
+// datagrams, and ping the other end if the ping interval passed, after which the SyncPipe is stepped.
+func (p *SyncPipe) Advance(d time.Duration) {
+	target := p.clock.Now().Add(d)
+	for {
+		now := p.clock.Now()
+		if !now.Before(target) {
+			break
+		}
+		step := tickInterval
+		if remaining := target.Sub(now); remaining < step {
+			step = remaining
+		}
+		p.clock.Advance(step)
+		now = p.clock.Now()
+
+		ping := !now.Before(p.nextPing)
+		if ping {
+			p.nextPing = p.nextPing.Add(pingInterval)
+		}
+		for _, conn := range p.conns {
+			if ping {
+				conn.Ping()
+			}
+			select {
+			case <-conn.closeCtx.Done():
+				continue
+			default:
+			}
+			conn.tick(now)
+		}
+		p.Step()
+	}
+}
+
+// Close closes both ends of the SyncPipe.
+func (p *SyncPipe) Close() error {
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.Step()
+	return nil
+}
+
+// propagateClose closes both ends of the SyncPipe and its transport if either of its ends was closed.
+func (p *SyncPipe) propagateClose() {
+	for _, conn := range p.conns {
+		select {
+		case <-conn.closeCtx.Done():
+		default:
+			continue
+		}
+		for i := range p.conns {
+			_ = p.pcs[i].Close()
+			_ = p.conns[i].Close()
+		}
+		return
+	}
+}
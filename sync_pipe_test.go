@@ -0,0 +1,158 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSyncPipe(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	a, b := p.A(), p.B()
+
+	large := bytes.Repeat([]byte{0xfe, 1, 2, 3}, 2000)
+	for _, payload := range [][]byte{{0xfe, 1}, large} {
+		if _, err := a.Write(payload); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		p.Step()
+		buf := make([]byte, 10000)
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(buf[:n], payload) {
+			t.Fatalf("read %v bytes, expected the %v bytes written", n, len(payload))
+		}
+	}
+	if a.recoveryQueue.Len() == 0 {
+		t.Fatalf("expected datagrams to be unacknowledged before advancing")
+	}
+	p.Advance(tickInterval)
+	if n := a.recoveryQueue.Len(); n != 0 {
+		t.Fatalf("expected all datagrams to be acknowledged after a tick, %v remain", n)
+	}
+
+	p.Advance(pingInterval)
+	if a.Latency() != 0 {
+		t.Fatalf("expected zero latency without time passing during steps, got %v", a.Latency())
+	}
+	if _, err := a.Write([]byte{0xfe}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+
+	_ = b.Close()
+	p.Step()
+	if _, err := a.Read(make([]byte, 10)); !ErrConnectionClosed(err) {
+		t.Fatalf("expected closed error after closing other end, got %v", err)
+	}
+}
+
+func TestDetectLostConnections(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	a := p.A()
+
+	p.Advance(connTimeout/2 + tickInterval*2)
+	if a.sendMessageIndex != 1 {
+		t.Fatalf("expected a detect lost connections packet to be sent after half of the timeout, %v reliable packets were sent", a.sendMessageIndex)
+	}
+	if a.lastDetectTime.IsZero() {
+		t.Fatalf("expected the time of the detect lost connections packet to be recorded")
+	}
+	if !a.lastPacketTime.Load().(time.Time).After(time.Unix(0, 0).Add(connTimeout / 2)) {
+		t.Fatalf("expected the acknowledgement of the detect lost connections packet to keep the connection alive")
+	}
+	if n := len(p.B().packetChan); n != 0 {
+		t.Fatalf("expected detect lost connections packet not to be forwarded to Read, %v packets are queued", n)
+	}
+}
+
+func TestResendOverduePacing(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	a := p.A()
+
+	// The datagrams awaiting acknowledgement were sent right before and after the sequence number wrapped
+	// around.
+	sent := []uint24{0xfffffc, 0xfffffd, 0xfffffe, 0xffffff, 0, 1, 2, 3}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	for _, seq := range sent {
+		d := &datagram{packets: []*packet{{reliability: reliabilityReliable, content: []byte{0xfe}}}}
+		_ = a.recoveryQueue.put(seq, d)
+	}
+	a.sendSequenceNumber = 4
+	// With a latency of 20ms, the datagrams overdue are spread out over the 4 ticks of a round-trip time,
+	// so each tick resends a quarter of those left, rounded up.
+	a.latency.Store(20)
+	p.clock.Advance(time.Second * 4)
+
+	resent := 0
+	for tick, n := range []int{2, 2, 1, 1, 1, 1} {
+		if err := a.resendOverdue(); err != nil {
+			t.Fatalf("error resending: %v", err)
+		}
+		resent += n
+		// The datagrams sent first are resent first, also across the wraparound.
+		for i, seq := range sent {
+			if _, ok := a.recoveryQueue.queue[seq]; ok != (i >= resent) {
+				t.Fatalf("tick %v: expected %v datagrams sent first to be resent, but datagram %v resent: %v", tick, resent, seq, !ok)
+			}
+		}
+	}
+	if n := a.Stats().DatagramsResent; n != uint64(len(sent)) {
+		t.Fatalf("expected %v datagrams to be resent, got %v", len(sent), n)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	p := NewSyncPipe(time.Unix(0, 0))
+	defer p.Close()
+	a := p.A()
+
+	// The interval is a quarter of the round-trip time, bounded by a millisecond and the tick interval.
+	for latency, expected := range map[int]time.Duration{0: time.Millisecond, 8: time.Millisecond * 2, 20: time.Millisecond * 5, 200: tickInterval} {
+		a.latency.Store(latency)
+		if interval := a.flushInterval(); interval != expected {
+			t.Fatalf("latency %vms: expected flush interval %v, got %v", latency, expected, interval)
+		}
+	}
+
+	a.writeLock.Lock()
+	a.synchronous = false
+	maxSize := a.maxDatagramSize(int(a.pathMTU))
+	a.writeLock.Unlock()
+	a.latency.Store(20)
+	sent := a.Stats().DatagramsSent
+	waitSent := func(n uint64) {
+		deadline := time.Now().Add(time.Second)
+		for a.Stats().DatagramsSent != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %v datagrams to be sent, got %v", n, a.Stats().DatagramsSent)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A small message is held until the flush interval passed, so that more may be added to its datagram.
+	if _, err := a.Write([]byte{0xfe, 1}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	p.clock.Advance(time.Millisecond * 4)
+	if n := a.Stats().DatagramsSent; n != sent {
+		t.Fatalf("expected datagram to be held before the flush interval passed, got %v sent", n-sent)
+	}
+	p.clock.Advance(time.Millisecond)
+	waitSent(sent + 1)
+
+	// Once a full MTU's worth of packets is queued, the datagram is flushed without waiting. The header of a
+	// reliable ordered packet is 10 bytes.
+	if _, err := a.Write(bytes.Repeat([]byte{0xfe}, maxSize-10)); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if n := a.Stats().DatagramsSent; n != sent+2 {
+		t.Fatalf("expected full datagram to be sent immediately, got %v sent", n-sent)
+	}
+}
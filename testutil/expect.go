@@ -0,0 +1,99 @@
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// maxPacketSize is the size of the buffer that packets are read into by the Expect functions.
+const maxPacketSize = 1024 * 1024
+
+// ExpectEventuallyReceived reads packets from the connection passed until one equal to the payload passed
+// is read, failing the test if this does not happen within the timeout passed. Packets read before it are
+// discarded. As packets lost in transit are only resent once the other end notices, the timeout should
+// leave room for several resends when the connection runs over a lossy transport, such as one wrapped
+// using raknet.SimulateNetwork.
+// ExpectEventuallyReceived sets the read deadline of the connection, which is removed again once it
+// returns.
+func ExpectEventuallyReceived(tb testing.TB, conn net.Conn, payload []byte, timeout time.Duration) {
+	tb.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	b := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			tb.Fatalf("packet of %v bytes starting with %x not received within %v: %v", len(payload), prefix(payload), timeout, err)
+		}
+		if bytes.Equal(b[:n], payload) {
+			return
+		}
+	}
+}
+
+// ExpectReceivedInOrder reads packets from the connection passed and fails the test unless they are equal
+// to the payloads passed, in the same order, and are all received within the timeout passed. Like with
+// ExpectEventuallyReceived, the timeout should leave room for resends over lossy transports.
+// ExpectReceivedInOrder sets the read deadline of the connection, which is removed again once it returns.
+func ExpectReceivedInOrder(tb testing.TB, conn net.Conn, payloads [][]byte, timeout time.Duration) {
+	tb.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	b := make([]byte, maxPacketSize)
+	for i, payload := range payloads {
+		n, err := conn.Read(b)
+		if err != nil {
+			tb.Fatalf("packet %v of %v not received within %v: %v", i, len(payloads), timeout, err)
+		}
+		if !bytes.Equal(b[:n], payload) {
+			tb.Fatalf("packet %v was received as %v bytes starting with %x, expected %v bytes starting with %x", i, n, prefix(b[:n]), len(payload), prefix(payload))
+		}
+	}
+}
+
+// ExpectEventuallyClosed reads packets from the connection passed until reading fails, failing the test if
+// this does not happen within the timeout passed. It may be used to wait for a connection to time out
+// or to be closed by the other end.
+func ExpectEventuallyClosed(tb testing.TB, conn net.Conn, timeout time.Duration) {
+	tb.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	b := make([]byte, maxPacketSize)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				tb.Fatalf("connection not closed within %v", timeout)
+			}
+			return
+		}
+	}
+}
+
+// Eventually calls the condition passed repeatedly until it returns true, failing the test if this does not
+// happen within the timeout passed. It may be used to wait for state that changes once resends or
+// acknowledgements arrive, such as the statistics of a connection, without hand-rolled polling loops.
+func Eventually(tb testing.TB, condition func() bool, timeout time.Duration) {
+	tb.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if !time.Now().Before(deadline) {
+			tb.Fatalf("condition not met within %v", timeout)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// prefix returns at most the first 8 bytes of the packet passed, for use in failure messages.
+func prefix(b []byte) []byte {
+	if len(b) > 8 {
+		return b[:8]
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+package testutil
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+func TestExpect(t *testing.T) {
+	// Datagrams are only lost once the connection is established, as the connection sequence is not
+	// resilient to loss.
+	lossy := &atomic.Value{}
+	lossy.Store(false)
+	wrap := func(conn net.PacketConn) net.PacketConn {
+		return &toggledConn{PacketConn: conn, lossy: lossy, simulated: raknet.SimulateNetwork(conn, raknet.NetworkConditions{Loss: 0.2, Seed: 1})}
+	}
+	l, addr, _ := StartTestListener(t, raknet.ListenConfig{WrapConn: wrap})
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	client, err := raknet.Dialer{WrapConn: wrap}.Dial(addr)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	server := <-accepted
+	lossy.Store(true)
+
+	payloads := make([][]byte, 50)
+	for i := range payloads {
+		payloads[i] = make([]byte, 1000)
+		payloads[i][0], payloads[i][1] = 0xfe, byte(i)
+		if _, err := client.Write(payloads[i]); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	ExpectReceivedInOrder(t, server, payloads[:25], time.Second*5)
+	ExpectEventuallyReceived(t, server, payloads[49], time.Second*5)
+	Eventually(t, func() bool {
+		return client.Stats().DatagramsResent > 0
+	}, time.Second*5)
+
+	_ = client.Close()
+	_ = server.Close()
+	ExpectEventuallyClosed(t, server, time.Second)
+}
+
+// toggledConn is a net.PacketConn that writes datagrams through a simulated network only while lossy is
+// true.
+type toggledConn struct {
+	net.PacketConn
+	lossy     *atomic.Value
+	simulated net.PacketConn
+}
+
+// WriteTo ...
+func (conn *toggledConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if conn.lossy.Load().(bool) {
+		return conn.simulated.WriteTo(b, addr)
+	}
+	return conn.PacketConn.WriteTo(b, addr)
+}
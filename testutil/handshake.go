@@ -0,0 +1,123 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+const (
+	// idOpenConnectionRequest1 and idOpenConnectionRequest2 are the IDs of the offline messages that a client
+	// sends to start the connection sequence.
+	idOpenConnectionRequest1 = 0x05
+	idOpenConnectionRequest2 = 0x07
+)
+
+// Handshake holds the open connection requests sent by a client at the start of its connection sequence,
+// as found in a capture.
+type Handshake struct {
+	// Addr is the address the client sent the requests from.
+	Addr *net.UDPAddr
+	// Request1 and Request2 are the open connection request 1 and 2 sent by the client, including their
+	// packet IDs. Either may be nil if the capture did not hold it.
+	Request1, Request2 []byte
+}
+
+// ReadHandshakes reads the open connection requests sent to the address local from the capture passed and
+// returns them grouped by the address of the client that sent them, in the order in which the clients
+// first appear in the capture. Of requests sent multiple times, such as those repeated during MTU
+// discovery, only the last is kept, as it is the one that the server answered. If the IP of local is
+// unspecified, requests sent to any IP with the port of local are read.
+func ReadHandshakes(r *raknet.PcapReader, local *net.UDPAddr) ([]Handshake, error) {
+	var handshakes []Handshake
+	index := make(map[string]int)
+	for {
+		packet, err := r.ReadPacket()
+		if err == io.EOF {
+			return handshakes, nil
+		}
+		if err != nil {
+			return handshakes, err
+		}
+		if packet.Dst.Port != local.Port || (len(local.IP) != 0 && !local.IP.IsUnspecified() && !packet.Dst.IP.Equal(local.IP)) {
+			continue
+		}
+		if len(packet.Data) == 0 || (packet.Data[0] != idOpenConnectionRequest1 && packet.Data[0] != idOpenConnectionRequest2) {
+			continue
+		}
+		i, ok := index[packet.Src.String()]
+		if !ok {
+			i = len(handshakes)
+			index[packet.Src.String()] = i
+			handshakes = append(handshakes, Handshake{Addr: packet.Src})
+		}
+		if packet.Data[0] == idOpenConnectionRequest1 {
+			handshakes[i].Request1 = packet.Data
+		} else {
+			handshakes[i].Request2 = packet.Data
+		}
+	}
+}
+
+// ReplayHandshake injects the open connection requests of the Handshake passed into the PacketConn passed
+// as if they were sent from the address from, which is usually spoofed, and returns the replies that the
+// Listener using the PacketConn wrote back to that address. Each request is injected once the reply to the
+// previous one was written, or once the timeout passed without one, so that the replies show at which step
+// a Listener stopped a spoofed handshake:
+//
+//	replies, _ := testutil.ReplayHandshake(conn, handshakes[0], testutil.SpoofedAddr(1), time.Second)
+//	if len(replies) == 2 {
+//		t.Fatal("handshake replayed from a spoofed address was answered")
+//	}
+//
+// Datagrams that the Listener wrote to other addresses in the meantime are skipped.
+func ReplayHandshake(conn *PacketConn, h Handshake, from *net.UDPAddr, timeout time.Duration) (replies []Datagram, err error) {
+	for _, request := range [][]byte{h.Request1, h.Request2} {
+		if request == nil {
+			continue
+		}
+		conn.Inject(from, request)
+		reply, ok, err := nextTo(conn, from, timeout)
+		if err != nil {
+			return replies, err
+		}
+		if !ok {
+			return replies, nil
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// SpoofedAddr returns the i-th address of a range of addresses reserved for documentation (198.51.100.0/24),
+// which are never used by real clients, for use as spoofed source addresses.
+func SpoofedAddr(i int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(198, 51, 100, byte(i%254+1)), Port: 10000 + i}
+}
+
+// nextTo returns the next datagram written by the PacketConn to the address passed, skipping datagrams
+// written to other addresses. It returns false if no such datagram is written within the timeout passed.
+func nextTo(conn *PacketConn, addr *net.UDPAddr, timeout time.Duration) (Datagram, bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return Datagram{}, false, nil
+		}
+		d, err := conn.Next(remaining)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return Datagram{}, false, nil
+			}
+			return Datagram{}, false, fmt.Errorf("error waiting for reply: %v", err)
+		}
+		if d.Addr.String() == addr.String() {
+			return d, true, nil
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package testutil
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+func TestReplayHandshake(t *testing.T) {
+	// Record the connection sequence of a real client.
+	capture := &bytes.Buffer{}
+	l, addr, cleanup := StartTestListener(t, raknet.ListenConfig{})
+	client, err := raknet.Dialer{PcapWriter: capture}.Dial(addr)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	_ = client.Close()
+	cleanup()
+
+	r, err := raknet.NewPcapReader(capture)
+	if err != nil {
+		t.Fatalf("error reading capture: %v", err)
+	}
+	local := l.Addr().(*net.UDPAddr)
+	handshakes, err := ReadHandshakes(r, local)
+	if err != nil {
+		t.Fatalf("error reading handshakes: %v", err)
+	}
+	if len(handshakes) != 1 || handshakes[0].Request1 == nil || handshakes[0].Request2 == nil {
+		t.Fatalf("expected a single complete handshake in the capture, got %v", handshakes)
+	}
+
+	// Replay the handshake from spoofed addresses into a new listener. Without protection against spoofing,
+	// every one of them is answered: The first with an open connection reply 2, the others with an
+	// ID_ALREADY_CONNECTED, as they hold the GUID of the first.
+	conn := NewPacketConn(local, nil)
+	replayed, err := raknet.ListenConfig{}.ListenConn(conn)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer replayed.Close()
+	for i := 0; i < 3; i++ {
+		from := SpoofedAddr(i)
+		replies, err := ReplayHandshake(conn, handshakes[0], from, time.Second*5)
+		if err != nil {
+			t.Fatalf("error replaying handshake: %v", err)
+		}
+		expected := byte(0x08)
+		if i > 0 {
+			expected = 0x12
+		}
+		if len(replies) != 2 || replies[0].Data[0] != 0x06 || replies[1].Data[0] != expected {
+			t.Fatalf("expected open connection reply 1 and %#x to be sent to %v, got %v", expected, from, replies)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package testutil
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// StartTestListener starts a raknet.Listener with the ListenConfig passed on a random port of the loopback
+// interface, like httptest.NewServer does for HTTP servers. It returns the Listener, its address and a
+// function that closes it. The function is also registered using tb.Cleanup, so calling it is only needed
+// to close the Listener before the end of the test. If the Listener cannot be started, the test fails
+// immediately.
+func StartTestListener(tb testing.TB, config raknet.ListenConfig) (l *raknet.Listener, addr string, cleanup func()) {
+	tb.Helper()
+	l, err := config.Listen("127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("error starting test listener: %v", err)
+	}
+	once := &sync.Once{}
+	cleanup = func() {
+		once.Do(func() {
+			_ = l.Close()
+		})
+	}
+	tb.Cleanup(cleanup)
+	return l, l.Addr().String(), cleanup
+}
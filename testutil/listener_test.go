@@ -0,0 +1,27 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/sandertv/go-raknet"
+)
+
+func TestStartTestListener(t *testing.T) {
+	l, addr, cleanup := StartTestListener(t, raknet.ListenConfig{})
+	if addr != l.Addr().String() {
+		t.Fatalf("expected address %v, got %v", l.Addr(), addr)
+	}
+	conn, err := raknet.Dial(addr)
+	if err != nil {
+		t.Fatalf("error dialing test listener: %v", err)
+	}
+	_ = conn.Close()
+	if _, err := l.Accept(); err != nil {
+		t.Fatalf("error accepting: %v", err)
+	}
+
+	cleanup()
+	if _, err := l.Accept(); err == nil {
+		t.Fatalf("expected accepting to fail after cleanup")
+	}
+}
@@ -0,0 +1,155 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// MTUScenario describes the MTU conditions under which a connection is established and used by
+// RunMTUScenario.
+type MTUScenario struct {
+	// Name is the name of the scenario, such as "pppoe".
+	Name string
+	// ClientMaxDatagramSize and ServerMaxDatagramSize are the MaxDatagramSize of the raknet.Dialer and the
+	// raknet.ListenConfig respectively.
+	ClientMaxDatagramSize, ServerMaxDatagramSize int
+	// PathMTU is the MTU of the simulated path between client and server. Datagrams that would exceed it
+	// once UDP/IP headers are added are dropped, like by a link that does not fragment. If 0, the path does
+	// not limit the size of datagrams.
+	PathMTU int
+	// ExpectedMTU is the MTU size that both ends are expected to negotiate. If 0, the MTU size is only
+	// checked against the maximum datagram sizes and the path MTU.
+	ExpectedMTU int
+}
+
+// MTUScenarios holds scenarios for the MTU sizes that most often break interoperability: The minimum MTU
+// size of IPv4, the MTU of PPPoE links, mismatched maximum sizes of both ends and paths smaller than what
+// both ends allow.
+var MTUScenarios = []MTUScenario{
+	{Name: "minimum", ClientMaxDatagramSize: 576, ServerMaxDatagramSize: 576, ExpectedMTU: 576},
+	{Name: "pppoe", ClientMaxDatagramSize: 1500, ServerMaxDatagramSize: 1500, PathMTU: 1492, ExpectedMTU: 1492},
+	{Name: "server smaller than client", ClientMaxDatagramSize: 1500, ServerMaxDatagramSize: 1200, ExpectedMTU: 1200},
+	{Name: "client smaller than server", ClientMaxDatagramSize: 1200, ServerMaxDatagramSize: 1500, ExpectedMTU: 1200},
+	{Name: "path smaller than both ends", ClientMaxDatagramSize: 1500, ServerMaxDatagramSize: 1500, PathMTU: 1400},
+	{Name: "jumbo", ClientMaxDatagramSize: 8192, ServerMaxDatagramSize: 8192, ExpectedMTU: 8192},
+}
+
+const (
+	// udpHeaderSize is the size of the UDP/IP headers that are part of the MTU size.
+	udpHeaderSize = 28
+	// reliableOrderedOverhead is the size that the datagram header and the header of a single reliable
+	// ordered packet add to a datagram.
+	reliableOrderedOverhead = 14
+	// splitOverhead is the size that the split information adds to the header of a split packet.
+	splitOverhead = 10
+)
+
+// BoundarySizes returns the sizes of packets that sit exactly at the limits of the MTU size passed: Those
+// that just fit in a single datagram, those that are just too big for one, and those that fill a whole
+// number of split fragments, or exceed it by a single byte.
+func BoundarySizes(mtuSize int) []int {
+	unsplit := mtuSize - udpHeaderSize - reliableOrderedOverhead
+	fragment := unsplit - splitOverhead
+	return []int{1, unsplit - 1, unsplit, unsplit + 1, fragment * 2, fragment*2 + 1, fragment * 10, fragment*10 + 1}
+}
+
+// RunMTUScenario establishes a connection between a raknet.Listener and a raknet.Dialer on the loopback
+// interface under the MTU conditions of the scenario passed, checks the MTU size negotiated and echoes
+// packets of all BoundarySizes of that MTU size. An error is returned if the connection sequence failed,
+// the MTU size was unexpected or a packet was not echoed intact within the timeout passed.
+func RunMTUScenario(s MTUScenario, timeout time.Duration) error {
+	wrap := func(conn net.PacketConn) net.PacketConn {
+		return &pathConn{PacketConn: conn, mtu: s.PathMTU}
+	}
+	discard := log.New(io.Discard, "", 0)
+	l, err := raknet.ListenConfig{MaxDatagramSize: s.ServerMaxDatagramSize, WrapConn: wrap, ErrorLog: discard}.Listen("127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("error listening: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan *raknet.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c.(*raknet.Conn)
+		b := make([]byte, 1024*1024)
+		for {
+			n, err := c.Read(b)
+			if err != nil {
+				return
+			}
+			if _, err := c.Write(b[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := raknet.Dialer{MaxDatagramSize: s.ClientMaxDatagramSize, WrapConn: wrap, ErrorLog: discard}.Dial(l.Addr().String())
+	if err != nil {
+		return fmt.Errorf("connection sequence failed: %v", err)
+	}
+	defer conn.Close()
+	var server *raknet.Conn
+	select {
+	case server = <-accepted:
+	case <-time.After(timeout):
+		return fmt.Errorf("connection was not accepted")
+	}
+
+	mtu := conn.MTUSize()
+	if server.MTUSize() != mtu {
+		return fmt.Errorf("client negotiated MTU size %v, but server negotiated %v", mtu, server.MTUSize())
+	}
+	if s.ExpectedMTU != 0 && mtu != s.ExpectedMTU {
+		return fmt.Errorf("negotiated MTU size %v, expected %v", mtu, s.ExpectedMTU)
+	}
+	for _, limit := range []int{s.ClientMaxDatagramSize, s.ServerMaxDatagramSize, s.PathMTU} {
+		if limit != 0 && mtu > limit {
+			return fmt.Errorf("negotiated MTU size %v exceeds limit %v", mtu, limit)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	b := make([]byte, 1024*1024)
+	for _, size := range BoundarySizes(mtu) {
+		packet := make([]byte, size)
+		packet[0] = 0xfe
+		for i := 1; i < size; i++ {
+			packet[i] = byte(i)
+		}
+		if _, err := conn.Write(packet); err != nil {
+			return fmt.Errorf("error writing packet of %v bytes: %v", size, err)
+		}
+		n, err := conn.Read(b)
+		if err != nil {
+			return fmt.Errorf("packet of %v bytes was not echoed at MTU size %v: %v", size, mtu, err)
+		}
+		if !bytes.Equal(b[:n], packet) {
+			return fmt.Errorf("packet of %v bytes was echoed as %v bytes at MTU size %v", size, n, mtu)
+		}
+	}
+	return nil
+}
+
+// pathConn is a net.PacketConn that drops datagrams written to it that exceed the MTU of a path, once the
+// UDP/IP headers are added.
+type pathConn struct {
+	net.PacketConn
+	mtu int
+}
+
+// WriteTo writes the datagram passed if it does not exceed the MTU of the path, and drops it otherwise.
+func (conn *pathConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	if conn.mtu != 0 && len(b)+udpHeaderSize > conn.mtu {
+		return len(b), nil
+	}
+	return conn.PacketConn.WriteTo(b, addr)
+}
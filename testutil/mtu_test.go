@@ -0,0 +1,18 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMTUScenarios(t *testing.T) {
+	for _, s := range MTUScenarios {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := RunMTUScenario(s, time.Second*10); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Package testutil provides fakes for testing code built on top of the raknet package deterministically,
+// without opening sockets, and helpers that exercise the raknet package under edge-case conditions.
+package testutil
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// Datagram is a datagram read from or written to a PacketConn.
+type Datagram struct {
+	// Addr is the address that the datagram was sent from if it was read, or sent to if it was written.
+	Addr net.Addr
+	// Data is the content of the datagram.
+	Data []byte
+	// Time is the time of the Clock of the PacketConn at which the datagram was injected or written.
+	Time time.Time
+}
+
+// PacketConn is a fake net.PacketConn of which the inbound datagrams are scripted using Inject, and of which
+// the outbound datagrams are captured so that they may be inspected using Next and Written. It may be passed
+// to raknet.ListenConfig.ListenConn to test the behaviour of a Listener:
+//
+//	conn := testutil.NewPacketConn(addr, clock)
+//	listener, _ := raknet.ListenConfig{Clock: clock}.ListenConn(conn)
+//	conn.Inject(client, ping)
+//	pong, err := conn.Next(time.Second)
+//
+// Read deadlines are evaluated using the Clock of the PacketConn, so that a ManualClock shared with the
+// Listener drives them as well.
+// Methods may be called on PacketConn from multiple goroutines simultaneously.
+type PacketConn struct {
+	addr  *net.UDPAddr
+	clock raknet.Clock
+
+	mu sync.Mutex
+	// changed is closed and replaced whenever a datagram is injected or written, or the PacketConn is closed,
+	// to wake up goroutines waiting for either of these.
+	changed      chan struct{}
+	closed       bool
+	readDeadline time.Time
+
+	inbound []Datagram
+	written []Datagram
+	// next is the index in written of the datagram returned by the next call to Next.
+	next int
+}
+
+// NewPacketConn returns a PacketConn with the local address passed. Its deadlines and the times of its
+// datagrams are taken from the Clock passed, or from raknet.SystemClock if it is nil.
+func NewPacketConn(addr *net.UDPAddr, clock raknet.Clock) *PacketConn {
+	if clock == nil {
+		clock = raknet.SystemClock
+	}
+	return &PacketConn{addr: addr, clock: clock, changed: make(chan struct{})}
+}
+
+// Inject makes the datagram passed be read by the PacketConn as if it was sent from the address passed.
+// Datagrams are read in the order in which they are injected. b may be re-used after Inject returns.
+func (conn *PacketConn) Inject(from *net.UDPAddr, b []byte) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.inbound = append(conn.inbound, Datagram{Addr: from, Data: append([]byte(nil), b...), Time: conn.clock.Now()})
+	conn.notify()
+}
+
+// Next returns the next datagram written to the PacketConn that was not yet returned by Next. It waits up to
+// the timeout passed, measured in real time, for a datagram to be written, after which it returns an error.
+func (conn *PacketConn) Next(timeout time.Duration) (Datagram, error) {
+	deadline := time.After(timeout)
+	for {
+		conn.mu.Lock()
+		if conn.next < len(conn.written) {
+			d := conn.written[conn.next]
+			conn.next++
+			conn.mu.Unlock()
+			return d, nil
+		}
+		changed := conn.changed
+		conn.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return Datagram{}, &net.OpError{Op: "next", Net: "testutil", Addr: conn.addr, Err: os.ErrDeadlineExceeded}
+		}
+	}
+}
+
+// Written returns all datagrams written to the PacketConn so far, including those returned by Next.
+func (conn *PacketConn) Written() []Datagram {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return append([]Datagram(nil), conn.written...)
+}
+
+// ReadFrom reads the next datagram injected. It blocks until a datagram is injected, the read deadline
+// passes or the PacketConn is closed.
+func (conn *PacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	for {
+		conn.mu.Lock()
+		if conn.closed {
+			conn.mu.Unlock()
+			return 0, nil, conn.opError("read", net.ErrClosed)
+		}
+		if len(conn.inbound) > 0 {
+			d := conn.inbound[0]
+			conn.inbound = conn.inbound[1:]
+			conn.mu.Unlock()
+			return copy(b, d.Data), d.Addr, nil
+		}
+		var timeout <-chan time.Time
+		if !conn.readDeadline.IsZero() {
+			remaining := conn.readDeadline.Sub(conn.clock.Now())
+			if remaining <= 0 {
+				conn.mu.Unlock()
+				return 0, nil, conn.opError("read", os.ErrDeadlineExceeded)
+			}
+			timeout = conn.clock.After(remaining)
+		}
+		changed := conn.changed
+		conn.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+		}
+	}
+}
+
+// WriteTo captures the datagram passed, so that it may be returned by Next and Written.
+func (conn *PacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closed {
+		return 0, conn.opError("write", net.ErrClosed)
+	}
+	conn.written = append(conn.written, Datagram{Addr: addr, Data: append([]byte(nil), b...), Time: conn.clock.Now()})
+	conn.notify()
+	return len(b), nil
+}
+
+// Close closes the PacketConn. Blocking calls to ReadFrom return an error.
+func (conn *PacketConn) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closed {
+		return conn.opError("close", net.ErrClosed)
+	}
+	conn.closed = true
+	conn.notify()
+	return nil
+}
+
+// LocalAddr returns the address passed to NewPacketConn.
+func (conn *PacketConn) LocalAddr() net.Addr {
+	return conn.addr
+}
+
+// SetDeadline sets the read deadline of the PacketConn. Writes never block, so there is no write deadline.
+func (conn *PacketConn) SetDeadline(t time.Time) error {
+	return conn.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the time of the Clock of the PacketConn after which ReadFrom returns an error.
+func (conn *PacketConn) SetReadDeadline(t time.Time) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.readDeadline = t
+	conn.notify()
+	return nil
+}
+
+// SetWriteDeadline is a no-op, as writes never block.
+func (conn *PacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// notify wakes up all goroutines waiting for the state of the PacketConn to change.
+// notify must be called while holding the lock of the PacketConn.
+func (conn *PacketConn) notify() {
+	close(conn.changed)
+	conn.changed = make(chan struct{})
+}
+
+// opError returns a *net.OpError for the operation op wrapping err.
+func (conn *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "testutil", Addr: conn.addr, Err: err}
+}
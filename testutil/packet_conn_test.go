@@ -0,0 +1,70 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+func TestListenerUnconnectedPing(t *testing.T) {
+	clock := raknet.NewManualClock(time.Unix(1000, 0))
+	conn := NewPacketConn(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, clock)
+	l, err := raknet.ListenConfig{Clock: clock}.ListenConn(conn)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	l.PongData([]byte("pong data"))
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 50000}
+	ping := &bytes.Buffer{}
+	ping.WriteByte(0x01)
+	_ = binary.Write(ping, binary.BigEndian, int64(1234))
+	ping.Write([]byte{0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78})
+	_ = binary.Write(ping, binary.BigEndian, int64(1))
+	conn.Inject(client, ping.Bytes())
+
+	pong, err := conn.Next(time.Second)
+	if err != nil {
+		t.Fatalf("no pong written: %v", err)
+	}
+	if pong.Addr.String() != client.String() {
+		t.Errorf("pong written to %v, expected %v", pong.Addr, client)
+	}
+	if pong.Data[0] != 0x1c || !bytes.HasSuffix(pong.Data, []byte("pong data")) {
+		t.Errorf("unexpected pong %x", pong.Data)
+	}
+	if !pong.Time.Equal(clock.Now()) {
+		t.Errorf("pong written at %v, expected %v", pong.Time, clock.Now())
+	}
+}
+
+func TestPacketConnReadDeadline(t *testing.T) {
+	clock := raknet.NewManualClock(time.Unix(1000, 0))
+	conn := NewPacketConn(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, clock)
+	_ = conn.SetReadDeadline(clock.Now().Add(time.Second))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, 10))
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("read returned before the deadline passed: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	clock.Advance(time.Second)
+	select {
+	case err := <-errs:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Fatalf("expected timeout error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("read did not return after the deadline passed")
+	}
+}
@@ -0,0 +1,37 @@
+package testutil
+
+import (
+	"io"
+	"net"
+
+	"github.com/sandertv/go-raknet"
+)
+
+// Replay injects the datagrams captured in a pcapng file, such as one written by a raknet.PcapWriter, into
+// the PacketConn passed, so that a session of a real client that once triggered a bug may be replayed into
+// a Listener as a regression test. Only datagrams sent to the address local are injected. If the IP of local
+// is unspecified, datagrams sent to any IP with the port of local are injected.
+// If clock is not nil, it is advanced by the time that passed between two datagrams in the capture before
+// the second is injected, so that the timing of the session is reproduced.
+// Replay returns the amount of datagrams injected.
+func Replay(conn *PacketConn, r *raknet.PcapReader, local *net.UDPAddr, clock *raknet.ManualClock) (n int, err error) {
+	var last raknet.CapturedPacket
+	for {
+		packet, err := r.ReadPacket()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if packet.Dst.Port != local.Port || (len(local.IP) != 0 && !local.IP.IsUnspecified() && !packet.Dst.IP.Equal(local.IP)) {
+			continue
+		}
+		if clock != nil && n > 0 && packet.Time.After(last.Time) {
+			clock.Advance(packet.Time.Sub(last.Time))
+		}
+		conn.Inject(packet.Src, packet.Data)
+		last = packet
+		n++
+	}
+}
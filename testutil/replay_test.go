@@ -0,0 +1,65 @@
+package testutil
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sandertv/go-raknet"
+)
+
+func TestReplay(t *testing.T) {
+	// Record the connection sequence of a real connection.
+	capture := &bytes.Buffer{}
+	l, err := raknet.ListenConfig{PcapWriter: capture}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	recorded := make(chan struct{})
+	go func() {
+		_, _ = l.Accept()
+		close(recorded)
+	}()
+	client, err := raknet.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	<-recorded
+	_ = client.Close()
+	_ = l.Close()
+
+	// Replay the datagrams of the client into a new listener, which should accept the connection again.
+	addr := l.Addr().(*net.UDPAddr)
+	conn := NewPacketConn(addr, nil)
+	replayed, err := raknet.ListenConfig{}.ListenConn(conn)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer replayed.Close()
+	r, err := raknet.NewPcapReader(capture)
+	if err != nil {
+		t.Fatalf("error reading capture: %v", err)
+	}
+	n, err := Replay(conn, r, addr, nil)
+	if err != nil {
+		t.Fatalf("error replaying capture: %v", err)
+	}
+	if n == 0 {
+		t.Fatalf("no datagrams replayed")
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := replayed.Accept()
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("error accepting replayed connection: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("replayed connection was not accepted")
+	}
+}
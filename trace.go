@@ -0,0 +1,53 @@
+package raknet
+
+import (
+	"context"
+)
+
+// Tracer starts spans that trace the connection sequence and the lifecycle of connections, so that handshake
+// latency shows up in tracing backends. go-raknet does not depend on any tracing library: A Tracer may be
+// implemented by a small adapter around an OpenTelemetry trace.Tracer, for example.
+type Tracer interface {
+	// Start starts a new span with the name and attributes passed. If ctx holds a span, the new span is a
+	// child of it. Start returns a context holding the new span.
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttributes sets the attributes passed on the span.
+	SetAttributes(attributes ...Attribute)
+	// RecordError records an error that occurred during the span.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// Attribute is a key-value pair set on a Span. Value is a string, bool, int or int64.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// nopTracer is a Tracer that starts spans that do nothing. It is used if no Tracer is set.
+type nopTracer struct{}
+
+// Start returns ctx and a span that does nothing.
+func (nopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+// nopSpan is a Span that does nothing.
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) RecordError(error)          {}
+func (nopSpan) End()                       {}
+
+// endSpan records the error passed on the span if it is not nil, and ends the span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
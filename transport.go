@@ -0,0 +1,159 @@
+package raknet
+
+import (
+	"net"
+	"time"
+)
+
+// Transport is a datagram transport that RakNet connections may run over instead of a UDP socket, such as a
+// DTLS session, a WireGuard tunnel, QUIC datagrams or an in-memory link. A Listener is created on top of a
+// Transport using ListenConfig.ListenTransport and a connection is dialed over one using
+// Dialer.DialTransport. The handshake, reliability and ordering layers of RakNet are the same regardless of
+// the Transport used.
+// The addresses that datagrams are read from and written to must be *net.UDPAddrs, as they are encoded in
+// the messages of the connection sequence. Transports that have no notion of addresses may use made up
+// addresses.
+type Transport interface {
+	// ReadDatagram reads a single datagram into b, returning the amount of bytes read and the address that
+	// the datagram was sent from. ReadDatagram blocks until a datagram is available, the read deadline passes
+	// or the Transport is closed.
+	ReadDatagram(b []byte) (n int, addr net.Addr, err error)
+	// WriteDatagram writes b as a single datagram to the address passed.
+	WriteDatagram(b []byte, addr net.Addr) error
+	// SetReadDeadline sets the deadline for calls to ReadDatagram. A zero time means ReadDatagram does not
+	// time out. It is used to time out the connection sequence of a Dialer.
+	SetReadDeadline(t time.Time) error
+	// LocalAddr returns the local address of the Transport.
+	LocalAddr() net.Addr
+	// Close closes the Transport. Blocked calls to ReadDatagram return an error.
+	Close() error
+}
+
+// NewUDPTransport returns a Transport that reads and writes datagrams using the UDP connection passed. It is
+// the Transport that a Listener and Dialer use by default.
+func NewUDPTransport(conn *net.UDPConn) Transport {
+	return packetConnTransport{conn: conn}
+}
+
+// PacketConnTransport returns a Transport that reads and writes datagrams using the net.PacketConn passed,
+// so that any net.PacketConn implementation may be used as the underlay of RakNet connections. The
+// net.PacketConn must preserve the boundaries of datagrams.
+func PacketConnTransport(conn net.PacketConn) Transport {
+	return packetConnTransport{conn: conn}
+}
+
+// packetConnTransport implements Transport using a net.PacketConn.
+type packetConnTransport struct {
+	conn net.PacketConn
+}
+
+// ReadDatagram ...
+func (t packetConnTransport) ReadDatagram(b []byte) (n int, addr net.Addr, err error) {
+	return t.conn.ReadFrom(b)
+}
+
+// WriteDatagram ...
+func (t packetConnTransport) WriteDatagram(b []byte, addr net.Addr) error {
+	_, err := t.conn.WriteTo(b, addr)
+	return err
+}
+
+// SetReadDeadline ...
+func (t packetConnTransport) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+// LocalAddr ...
+func (t packetConnTransport) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+// Close ...
+func (t packetConnTransport) Close() error {
+	return t.conn.Close()
+}
+
+// transportConn turns a Transport into a net.PacketConn, which is what Listeners and connections use
+// internally.
+type transportConn struct {
+	t Transport
+}
+
+// newTransportConn returns a net.PacketConn reading and writing datagrams using the Transport passed. If the
+// Transport wraps a net.PacketConn, the net.PacketConn is returned directly, so that optimisations such as
+// batched writes on UDP connections remain available.
+func newTransportConn(t Transport) net.PacketConn {
+	if pc, ok := t.(packetConnTransport); ok {
+		return pc.conn
+	}
+	return &transportConn{t: t}
+}
+
+// TransportPacketConn returns a net.PacketConn that reads and writes datagrams using the Transport passed, so
+// that a Transport may be used where a net.PacketConn is expected, such as by ListenConfig.ListenConn or by a
+// ListenConfig.WrapConn function.
+func TransportPacketConn(t Transport) net.PacketConn {
+	return newTransportConn(t)
+}
+
+// ReadFrom ...
+func (conn *transportConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	return conn.t.ReadDatagram(b)
+}
+
+// WriteTo ...
+func (conn *transportConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	if err := conn.t.WriteDatagram(b, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// LocalAddr ...
+func (conn *transportConn) LocalAddr() net.Addr {
+	return conn.t.LocalAddr()
+}
+
+// Close ...
+func (conn *transportConn) Close() error {
+	return conn.t.Close()
+}
+
+// SetDeadline sets the read deadline of the Transport. Writes to a Transport never time out.
+func (conn *transportConn) SetDeadline(t time.Time) error {
+	return conn.t.SetReadDeadline(t)
+}
+
+// SetReadDeadline ...
+func (conn *transportConn) SetReadDeadline(t time.Time) error {
+	return conn.t.SetReadDeadline(t)
+}
+
+// SetWriteDeadline is a no-op, as writes to a Transport never time out.
+func (conn *transportConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// ListenTransport returns a Listener that accepts connections over the Transport passed, rather than over a
+// UDP connection created by the Listener. The Listener takes ownership of the Transport: It is closed when
+// the Listener is closed or if ListenTransport returns an error.
+// ListenTransport fills out any values of the ListenConfig left as their empty values with the default
+// values of those fields.
+func (config ListenConfig) ListenTransport(t Transport) (*Listener, error) {
+	return config.ListenConn(newTransportConn(t))
+}
+
+// DialTransport dials a RakNet connection to the address passed over the Transport passed, rather than over
+// a UDP connection created by the Dialer. The connection takes ownership of the Transport: It is closed when
+// the connection is closed or if DialTransport returns an error.
+func (dialer Dialer) DialTransport(t Transport, addr net.Addr) (*Conn, error) {
+	return dialer.dial(addr.String(), func() (net.Conn, error) {
+		if pc, ok := t.(packetConnTransport); ok {
+			if udp, ok := pc.conn.(*net.UDPConn); ok && udp.RemoteAddr() != nil {
+				// The UDP connection is already connected, so it may be used as it is.
+				return udp, nil
+			}
+		}
+		return &connectedConn{PacketConn: newTransportConn(t), remoteAddr: addr}, nil
+	})
+}
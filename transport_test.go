@@ -0,0 +1,101 @@
+package raknet
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memTransport is a Transport linked to another memTransport in memory.
+type memTransport struct {
+	local *net.UDPAddr
+	peer  *memTransport
+	in    chan []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// memTransports returns two memTransports linked to each other.
+func memTransports() (*memTransport, *memTransport) {
+	a := &memTransport{local: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132}, in: make(chan []byte, 256), changed: make(chan struct{}), closed: make(chan struct{})}
+	b := &memTransport{local: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 19133}, in: make(chan []byte, 256), changed: make(chan struct{}), closed: make(chan struct{})}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (t *memTransport) ReadDatagram(b []byte) (int, net.Addr, error) {
+	for {
+		t.mu.Lock()
+		deadline, changed := t.deadline, t.changed
+		t.mu.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case data := <-t.in:
+			return copy(b, data), t.peer.local, nil
+		case <-t.closed:
+			return 0, nil, net.ErrClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-changed:
+		}
+	}
+}
+
+func (t *memTransport) WriteDatagram(b []byte, _ net.Addr) error {
+	select {
+	case t.peer.in <- append([]byte(nil), b...):
+	default:
+	}
+	return nil
+}
+
+func (t *memTransport) SetReadDeadline(deadline time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadline = deadline
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return nil
+}
+
+func (t *memTransport) LocalAddr() net.Addr { return t.local }
+
+func (t *memTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	serverTransport, clientTransport := memTransports()
+	l, err := ListenConfig{}.ListenTransport(serverTransport)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			_, _ = c.Write([]byte{0xfe, 1, 2, 3})
+		}
+	}()
+
+	c, err := Dialer{}.DialTransport(clientTransport, serverTransport.LocalAddr())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c.Read(make([]byte, 1500)); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+}